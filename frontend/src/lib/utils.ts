@@ -22,3 +22,30 @@ export function formatBytesPerSec(bytes: number): string {
 export function formatHash(hash: number[]): string {
     return hash.map((b) => b.toString(16).padStart(2, '0')).join('')
 }
+
+const errorMessages: Record<string, string> = {
+    torrentNotFound: 'That torrent is no longer in the list.',
+    invalidInfoHash: 'That info hash is not valid.',
+    invalidTorrent: 'The file is not a valid .torrent file.',
+    duplicateTorrent: 'This torrent has already been added.',
+    invalidMagnet: 'The magnet link is malformed or not supported.',
+    diskFull: 'Not enough disk space. Free some space or pick another download folder.',
+    trackerUnreachable: 'No tracker could be reached. Check your connection.',
+    quotaExceeded: 'The download folder is over its quota. Raise the quota or free up space.',
+    unknownCategory: 'That category does not exist.',
+    invalidCategory: 'The category name is empty.',
+    invalidPath: 'Pick a full folder path.',
+    invalidTracker: 'The list contains a line that is not a tracker URL.',
+}
+
+/**
+ * Turn an error from a backend call into a message for the user, going by
+ * its code when the backend sent one
+ */
+export function errorMessage(error: unknown): string {
+    if (error && typeof error === 'object' && 'code' in error) {
+        const { code, message } = error as { code: string; message: string }
+        return errorMessages[code] ?? message
+    }
+    return String(error)
+}
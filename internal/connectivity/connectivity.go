@@ -0,0 +1,65 @@
+// Package connectivity is the client's one record of the port peers can
+// reach us on. Tracker announces and the extension handshake all read it
+// from here, so they never disagree with each other or with the listener.
+package connectivity
+
+import "sync"
+
+// Manager holds the port the listener bound and, when a NAT mapping
+// forwards a different one to it, the mapped port. A nil Manager knows
+// no port.
+type Manager struct {
+	mut      sync.Mutex
+	listen   uint16
+	external uint16
+}
+
+func New(listenPort uint16) *Manager {
+	return &Manager{listen: listenPort}
+}
+
+// Port returns the port to advertise: the mapped port if there is one,
+// the listen port otherwise.
+func (m *Manager) Port() uint16 {
+	if m == nil {
+		return 0
+	}
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	return m.portLocked()
+}
+
+// ListenPort returns the port the listener bound.
+func (m *Manager) ListenPort() uint16 {
+	if m == nil {
+		return 0
+	}
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	return m.listen
+}
+
+// SetExternalPort records the port a NAT mapping forwards to the
+// listener, or 0 once the mapping is gone. Announces pick it up from
+// the next one on.
+func (m *Manager) SetExternalPort(port uint16) {
+	if m == nil {
+		return
+	}
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	m.external = port
+}
+
+func (m *Manager) portLocked() uint16 {
+	if m.external != 0 {
+		return m.external
+	}
+	return m.listen
+}
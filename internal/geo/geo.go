@@ -0,0 +1,249 @@
+// Package geo annotates peer addresses with country and ASN data from
+// local MaxMind databases. Nothing leaves the machine; the databases are
+// only opened on first use.
+package geo
+
+import (
+	"cmp"
+	"errors"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prxssh/rabbit/pkg/mmdb"
+)
+
+var ErrDisabled = errors.New("geo: lookups disabled")
+
+// maxCacheEntries bounds the lookup cache; it is simply dropped when full.
+const maxCacheEntries = 4096
+
+type Config struct {
+	// Enabled turns lookups on. Off by default since peer locations are
+	// sensitive to show on screen.
+	Enabled bool
+	// CountryDB is a GeoLite2-Country or GeoLite2-City database path.
+	CountryDB string
+	// ASNDB is a GeoLite2-ASN database path.
+	ASNDB string
+}
+
+func WithDefaultConfig() *Config {
+	return &Config{
+		Enabled:   false,
+		CountryDB: "",
+		ASNDB:     "",
+	}
+}
+
+type Location struct {
+	Country     string `json:"country"`
+	CountryName string `json:"countryName"`
+	ASN         uint32 `json:"asn"`
+	Org         string `json:"org"`
+}
+
+type Resolver struct {
+	cfg     *Config
+	logger  *slog.Logger
+	enabled atomic.Bool
+
+	countryOnce sync.Once
+	country     *mmdb.Reader
+	asnOnce     sync.Once
+	asn         *mmdb.Reader
+
+	cacheMut sync.Mutex
+	cache    map[netip.Addr]Location
+}
+
+func NewResolver(cfg *Config, logger *slog.Logger) *Resolver {
+	if cfg == nil {
+		cfg = WithDefaultConfig()
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	r := &Resolver{
+		cfg:    cfg,
+		logger: logger.With("component", "geo"),
+		cache:  make(map[netip.Addr]Location),
+	}
+	r.enabled.Store(cfg.Enabled)
+
+	return r
+}
+
+func (r *Resolver) Enabled() bool {
+	return r.enabled.Load()
+}
+
+// SetEnabled toggles lookups. Disabling also forgets cached locations.
+func (r *Resolver) SetEnabled(enabled bool) {
+	r.enabled.Store(enabled)
+	if !enabled {
+		r.cacheMut.Lock()
+		clear(r.cache)
+		r.cacheMut.Unlock()
+	}
+}
+
+func (r *Resolver) Lookup(addr netip.Addr) (Location, error) {
+	if !r.Enabled() {
+		return Location{}, ErrDisabled
+	}
+	addr = addr.Unmap()
+
+	r.cacheMut.Lock()
+	loc, ok := r.cache[addr]
+	r.cacheMut.Unlock()
+	if ok {
+		return loc, nil
+	}
+
+	if db := r.countryDB(); db != nil {
+		if rec, err := db.Lookup(addr); err == nil {
+			loc.Country, loc.CountryName = countryFrom(rec)
+		}
+	}
+	if db := r.asnDB(); db != nil {
+		if rec, err := db.Lookup(addr); err == nil {
+			loc.ASN, loc.Org = asnFrom(rec)
+		}
+	}
+
+	r.cacheMut.Lock()
+	if len(r.cache) >= maxCacheEntries {
+		clear(r.cache)
+	}
+	r.cache[addr] = loc
+	r.cacheMut.Unlock()
+
+	return loc, nil
+}
+
+func (r *Resolver) countryDB() *mmdb.Reader {
+	r.countryOnce.Do(func() { r.country = r.open(r.cfg.CountryDB) })
+	return r.country
+}
+
+func (r *Resolver) asnDB() *mmdb.Reader {
+	r.asnOnce.Do(func() { r.asn = r.open(r.cfg.ASNDB) })
+	return r.asn
+}
+
+func (r *Resolver) open(path string) *mmdb.Reader {
+	if path == "" {
+		return nil
+	}
+
+	db, err := mmdb.Open(path)
+	if err != nil {
+		r.logger.Warn("open geoip database failed", "path", path, "error", err)
+		return nil
+	}
+
+	r.logger.Debug("geoip database loaded", "path", path, "type", db.Metadata().DatabaseType)
+	return db
+}
+
+func countryFrom(rec any) (string, string) {
+	m, _ := rec.(map[string]any)
+	country, _ := m["country"].(map[string]any)
+	if country == nil {
+		country, _ = m["registered_country"].(map[string]any)
+	}
+
+	code, _ := country["iso_code"].(string)
+	names, _ := country["names"].(map[string]any)
+	name, _ := names["en"].(string)
+
+	return code, name
+}
+
+func asnFrom(rec any) (uint32, string) {
+	m, _ := rec.(map[string]any)
+	asn, _ := m["autonomous_system_number"].(uint64)
+	org, _ := m["autonomous_system_organization"].(string)
+
+	return uint32(asn), org
+}
+
+// Peer is one connected peer with its location and current rates.
+type Peer struct {
+	Addr         string `json:"addr"`
+	DownloadRate uint64 `json:"downloadRate"`
+	UploadRate   uint64 `json:"uploadRate"`
+	Location
+}
+
+// Group aggregates peers sharing a country or an ASN.
+type Group struct {
+	Key          string `json:"key"`
+	Name         string `json:"name"`
+	Peers        int    `json:"peers"`
+	DownloadRate uint64 `json:"downloadRate"`
+	UploadRate   uint64 `json:"uploadRate"`
+}
+
+type Summary struct {
+	Peers     []Peer  `json:"peers"`
+	Countries []Group `json:"countries"`
+	ASNs      []Group `json:"asns"`
+}
+
+// Summarize groups peers by country and by ASN, busiest groups first.
+// Peers with no data land in a group with an empty key.
+func Summarize(peers []Peer) *Summary {
+	countries := make(map[string]*Group)
+	asns := make(map[string]*Group)
+
+	add := func(groups map[string]*Group, key, name string, p Peer) {
+		g, ok := groups[key]
+		if !ok {
+			g = &Group{Key: key, Name: name}
+			groups[key] = g
+		}
+		g.Peers++
+		g.DownloadRate += p.DownloadRate
+		g.UploadRate += p.UploadRate
+	}
+
+	for _, p := range peers {
+		add(countries, p.Country, p.CountryName, p)
+
+		var asnKey string
+		if p.ASN != 0 {
+			asnKey = "AS" + strconv.FormatUint(uint64(p.ASN), 10)
+		}
+		add(asns, asnKey, p.Org, p)
+	}
+
+	return &Summary{
+		Peers:     peers,
+		Countries: sortedGroups(countries),
+		ASNs:      sortedGroups(asns),
+	}
+}
+
+func sortedGroups(groups map[string]*Group) []Group {
+	out := make([]Group, 0, len(groups))
+	for _, g := range groups {
+		out = append(out, *g)
+	}
+	slices.SortFunc(out, func(a, b Group) int {
+		if a.Peers != b.Peers {
+			return b.Peers - a.Peers
+		}
+		if ra, rb := a.DownloadRate+a.UploadRate, b.DownloadRate+b.UploadRate; ra != rb {
+			return cmp.Compare(rb, ra)
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+	return out
+}
@@ -0,0 +1,265 @@
+// Package index keeps a small persistent catalogue of torrents the client
+// has seen, searchable while offline.
+package index
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prxssh/rabbit/pkg/atomicfile"
+)
+
+// formatVersion is the on-disk schema version written by Save. Version 0
+// is the original bare JSON array of entries.
+const formatVersion = 1
+
+var ErrUnsupportedVersion = errors.New("index: file written by a newer version")
+
+type file struct {
+	Version int      `json:"version"`
+	Entries []*Entry `json:"entries"`
+}
+
+type Source string
+
+const (
+	SourceAdded      Source = "added"
+	SourceDiscovered Source = "discovered"
+)
+
+type Entry struct {
+	InfoHash string    `json:"infoHash"`
+	Name     string    `json:"name"`
+	Size     uint64    `json:"size"`
+	Files    []string  `json:"files"`
+	Seeders  int64     `json:"seeders"`
+	Leechers int64     `json:"leechers"`
+	Source   Source    `json:"source"`
+	AddedAt  time.Time `json:"addedAt"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+type Index struct {
+	path string
+
+	mut     sync.RWMutex
+	entries map[string]*Entry
+	dirty   bool
+}
+
+// New returns an empty index that will be saved to path.
+func New(path string) *Index {
+	return &Index{path: path, entries: make(map[string]*Entry)}
+}
+
+// Open loads the index stored at path, starting empty if it doesn't exist.
+func Open(path string) (*Index, error) {
+	idx := New(path)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("index: read: %w", err)
+	}
+
+	f, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range f.Entries {
+		idx.entries[e.InfoHash] = e
+	}
+	// Rewrite older formats on the next save.
+	idx.dirty = f.Version < formatVersion
+
+	return idx, nil
+}
+
+func decode(data []byte) (*file, error) {
+	var f file
+
+	// Version 0 files are a bare array.
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(data, &f.Entries); err != nil {
+			return nil, fmt.Errorf("index: decode: %w", err)
+		}
+		return &f, nil
+	}
+
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("index: decode: %w", err)
+	}
+	if f.Version > formatVersion {
+		return nil, fmt.Errorf("%w (version %d)", ErrUnsupportedVersion, f.Version)
+	}
+	return &f, nil
+}
+
+// Put adds an entry or refreshes an existing one, keeping its original
+// AddedAt.
+func (idx *Index) Put(e Entry) {
+	idx.mut.Lock()
+	defer idx.mut.Unlock()
+
+	now := time.Now()
+	if old, ok := idx.entries[e.InfoHash]; ok && !old.AddedAt.IsZero() {
+		e.AddedAt = old.AddedAt
+	} else if e.AddedAt.IsZero() {
+		e.AddedAt = now
+	}
+	e.LastSeen = now
+
+	idx.entries[e.InfoHash] = &e
+	idx.dirty = true
+}
+
+// UpdateSwarm records a fresh seeders/leechers snapshot.
+func (idx *Index) UpdateSwarm(infoHash string, seeders, leechers int64) {
+	idx.mut.Lock()
+	defer idx.mut.Unlock()
+
+	e, ok := idx.entries[infoHash]
+	if !ok {
+		return
+	}
+	e.Seeders = seeders
+	e.Leechers = leechers
+	e.LastSeen = time.Now()
+	idx.dirty = true
+}
+
+// Search returns entries whose name or file paths contain every term of
+// query, case-insensitively. Name matches rank above file matches, then
+// entries with more seeders. limit <= 0 returns every match.
+func (idx *Index) Search(query string, limit int) []Entry {
+	terms := strings.Fields(strings.ToLower(query))
+
+	idx.mut.RLock()
+	defer idx.mut.RUnlock()
+
+	type hit struct {
+		entry Entry
+		score int
+	}
+	var hits []hit
+
+	for _, e := range idx.entries {
+		score, ok := match(e, terms)
+		if ok {
+			hits = append(hits, hit{entry: *e, score: score})
+		}
+	}
+
+	slices.SortFunc(hits, func(a, b hit) int {
+		if a.score != b.score {
+			return b.score - a.score
+		}
+		if a.entry.Seeders != b.entry.Seeders {
+			return int(b.entry.Seeders - a.entry.Seeders)
+		}
+		return strings.Compare(a.entry.Name, b.entry.Name)
+	})
+
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	out := make([]Entry, len(hits))
+	for i, h := range hits {
+		out[i] = h.entry
+	}
+	return out
+}
+
+func match(e *Entry, terms []string) (int, bool) {
+	name := strings.ToLower(e.Name)
+	score := 0
+
+	for _, term := range terms {
+		if strings.Contains(name, term) {
+			score += 2
+			continue
+		}
+
+		found := false
+		for _, f := range e.Files {
+			if strings.Contains(strings.ToLower(f), term) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, false
+		}
+		score++
+	}
+
+	return score, true
+}
+
+// Prune drops entries not seen within maxAge and returns how many were
+// removed.
+func (idx *Index) Prune(maxAge time.Duration) int {
+	cutoff := time.Now().Add(-maxAge)
+
+	idx.mut.Lock()
+	defer idx.mut.Unlock()
+
+	n := 0
+	for hash, e := range idx.entries {
+		if e.LastSeen.Before(cutoff) {
+			delete(idx.entries, hash)
+			n++
+		}
+	}
+	if n > 0 {
+		idx.dirty = true
+	}
+	return n
+}
+
+func (idx *Index) Len() int {
+	idx.mut.RLock()
+	defer idx.mut.RUnlock()
+
+	return len(idx.entries)
+}
+
+// Save writes the index to disk if it changed since the last save. The
+// file is replaced atomically.
+func (idx *Index) Save() error {
+	idx.mut.Lock()
+	defer idx.mut.Unlock()
+
+	if !idx.dirty {
+		return nil
+	}
+
+	entries := make([]*Entry, 0, len(idx.entries))
+	for _, e := range idx.entries {
+		entries = append(entries, e)
+	}
+	slices.SortFunc(entries, func(a, b *Entry) int {
+		return strings.Compare(a.InfoHash, b.InfoHash)
+	})
+
+	data, err := json.Marshal(file{Version: formatVersion, Entries: entries})
+	if err != nil {
+		return fmt.Errorf("index: encode: %w", err)
+	}
+	if err := atomicfile.WriteFile(idx.path, data, 0o644); err != nil {
+		return fmt.Errorf("index: write: %w", err)
+	}
+
+	idx.dirty = false
+	return nil
+}
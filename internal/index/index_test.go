@@ -0,0 +1,122 @@
+package index
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIndex_Search(t *testing.T) {
+	idx, err := Open(filepath.Join(t.TempDir(), "index.json"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	idx.Put(Entry{InfoHash: "a", Name: "Ubuntu 24.04 Desktop", Seeders: 10})
+	idx.Put(Entry{InfoHash: "b", Name: "Debian ISO", Files: []string{"debian/ubuntu-compat.txt"}, Seeders: 50})
+	idx.Put(Entry{InfoHash: "c", Name: "Ubuntu 22.04 Server", Seeders: 30})
+
+	got := idx.Search("ubuntu", 0)
+	want := []string{"c", "a", "b"}
+	if len(got) != len(want) {
+		t.Fatalf("Search() returned %d entries, want %d", len(got), len(want))
+	}
+	for i, h := range want {
+		if got[i].InfoHash != h {
+			t.Errorf("Search()[%d] = %s, want %s", i, got[i].InfoHash, h)
+		}
+	}
+
+	if got := idx.Search("ubuntu server", 0); len(got) != 1 || got[0].InfoHash != "c" {
+		t.Errorf("Search(multi-term) = %+v, want only c", got)
+	}
+	if got := idx.Search("ubuntu", 1); len(got) != 1 {
+		t.Errorf("Search() with limit returned %d entries", len(got))
+	}
+}
+
+func TestIndex_SaveAndOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "index.json")
+
+	idx, _ := Open(path)
+	idx.Put(Entry{InfoHash: "a", Name: "one", Size: 42, Source: SourceAdded})
+	if err := idx.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	got := reopened.Search("one", 0)
+	if len(got) != 1 || got[0].Size != 42 || got[0].Source != SourceAdded {
+		t.Errorf("reopened index = %+v", got)
+	}
+}
+
+func TestIndex_Prune(t *testing.T) {
+	idx, _ := Open(filepath.Join(t.TempDir(), "index.json"))
+	idx.Put(Entry{InfoHash: "old", Name: "old"})
+	idx.Put(Entry{InfoHash: "new", Name: "new"})
+	idx.entries["old"].LastSeen = time.Now().Add(-48 * time.Hour)
+
+	if n := idx.Prune(24 * time.Hour); n != 1 {
+		t.Errorf("Prune() = %d, want 1", n)
+	}
+	if idx.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", idx.Len())
+	}
+}
+
+func TestIndex_PutKeepsAddedAt(t *testing.T) {
+	idx, _ := Open(filepath.Join(t.TempDir(), "index.json"))
+	first := time.Now().Add(-time.Hour)
+	idx.Put(Entry{InfoHash: "a", Name: "a", AddedAt: first})
+	idx.Put(Entry{InfoHash: "a", Name: "a renamed"})
+
+	e := idx.entries["a"]
+	if !e.AddedAt.Equal(first) {
+		t.Errorf("AddedAt = %v, want %v", e.AddedAt, first)
+	}
+	if e.Name != "a renamed" {
+		t.Errorf("Name = %q, want updated name", e.Name)
+	}
+}
+
+func TestIndex_OpenLegacyFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+	legacy := `[{"infoHash":"a","name":"legacy","size":7}]`
+	if err := os.WriteFile(path, []byte(legacy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if got := idx.Search("legacy", 0); len(got) != 1 || got[0].Size != 7 {
+		t.Fatalf("legacy entries = %+v", got)
+	}
+
+	if err := idx.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.HasPrefix(string(data), `{"version":1,`) {
+		t.Errorf("saved file = %s, want the versioned format", data)
+	}
+}
+
+func TestIndex_OpenNewerVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+	if err := os.WriteFile(path, []byte(`{"version":99,"entries":[]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(path); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Open() error = %v, want ErrUnsupportedVersion", err)
+	}
+}
@@ -2,18 +2,46 @@ package meta
 
 import (
 	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
 	"encoding/hex"
 	"fmt"
+	"net/netip"
 	"net/url"
+	"slices"
+	"sort"
 	"strings"
 )
 
+// multihashSHA256 prefixes a SHA-256 digest in a btmh URN: function code
+// 0x12, length 0x20.
+const multihashSHA256 = "1220"
+
 type Magnet struct {
+	// InfoHash is the v1 info hash, zero when the link only has a v2 one.
 	InfoHash [sha1.Size]byte
-	Name     string
-	Trackers []string
+	// InfoHashV2 is the v2 (SHA-256) info hash of a v2 or hybrid link.
+	InfoHashV2 *[sha256.Size]byte
+	Name       string
+	Trackers   []string
+	// Peers are the x.pe hints: peers to connect to directly. They are
+	// parsed and written back out, but nothing dials them yet.
+	Peers []netip.AddrPort
+	// WebSeeds are the ws URLs the content can be fetched from over HTTP.
+	// Like Peers, they are only carried; the client has no web seed
+	// support.
+	WebSeeds []string
+}
+
+// HasV1 reports whether the link carries a v1 info hash.
+func (m *Magnet) HasV1() bool {
+	return m.InfoHash != [sha1.Size]byte{}
 }
 
+// ParseMagnet parses a magnet URI. Exact topics (xt, also numbered as
+// xt.1, xt.2, ...) may be btih hashes, in hex or base32, and btmh SHA-256
+// multihashes; at least one is required. x.pe hints that aren't literal
+// ip:port pairs and ws values that aren't http(s) URLs are dropped.
 func ParseMagnet(magnetURL string) (*Magnet, error) {
 	u, err := url.Parse(magnetURL)
 	if err != nil {
@@ -30,32 +58,193 @@ func ParseMagnet(magnetURL string) (*Magnet, error) {
 
 	magnet := &Magnet{}
 
-	xt, ok := params["xt"]
-	if !ok || len(xt) == 0 {
+	topics := numbered(params, "xt")
+	if len(topics) == 0 {
 		return nil, fmt.Errorf("magnet url missing 'xt'")
 	}
-	xtVal := xt[0]
-	if !strings.HasPrefix(xtVal, "urn:btih:") {
-		return nil, fmt.Errorf("invalid 'xt' value: must be in 'urn:btih:<hash>' format")
-	}
 
-	hashString := strings.TrimPrefix(xtVal, "urn:btih:")
-	if len(hashString) != sha1.Size*2 { // 20 bytes = 40 hex chars
-		return nil, fmt.Errorf("invalid infohash length")
+	var found bool
+	for _, xt := range topics {
+		switch {
+		case strings.HasPrefix(xt, "urn:btih:"):
+			hash, err := parseBTIH(strings.TrimPrefix(xt, "urn:btih:"))
+			if err != nil {
+				return nil, err
+			}
+			magnet.InfoHash = hash
+			found = true
+
+		case strings.HasPrefix(xt, "urn:btmh:"):
+			hash, err := parseBTMH(strings.TrimPrefix(xt, "urn:btmh:"))
+			if err != nil {
+				return nil, err
+			}
+			magnet.InfoHashV2 = &hash
+			found = true
+		}
 	}
-	hashBytes, err := hex.DecodeString(hashString)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode infohash: %w", err)
+	if !found {
+		return nil, fmt.Errorf(
+			"invalid 'xt' value: must be in 'urn:btih:<hash>' or 'urn:btmh:<multihash>' format",
+		)
 	}
-	copy(magnet.InfoHash[:], hashBytes)
 
 	if dn, ok := params["dn"]; ok && len(dn) > 0 {
 		magnet.Name = dn[0]
 	}
 
-	if tr, ok := params["tr"]; ok {
-		magnet.Trackers = tr
+	for _, tr := range numbered(params, "tr") {
+		if tr != "" && !slices.Contains(magnet.Trackers, tr) {
+			magnet.Trackers = append(magnet.Trackers, tr)
+		}
+	}
+
+	for _, pe := range params["x.pe"] {
+		if addr, err := netip.ParseAddrPort(pe); err == nil {
+			magnet.Peers = append(magnet.Peers, addr)
+		}
+	}
+
+	for _, ws := range params["ws"] {
+		if wu, err := url.Parse(ws); err == nil &&
+			(wu.Scheme == "http" || wu.Scheme == "https") && wu.Host != "" {
+			magnet.WebSeeds = append(magnet.WebSeeds, ws)
+		}
 	}
 
 	return magnet, nil
 }
+
+// numbered returns the values of key followed by those of key.1, key.2,
+// ... in order of their number.
+func numbered(params url.Values, key string) []string {
+	out := append([]string(nil), params[key]...)
+
+	var keys []string
+	for k := range params {
+		if strings.HasPrefix(k, key+".") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		if len(keys[a]) != len(keys[b]) {
+			return len(keys[a]) < len(keys[b])
+		}
+		return keys[a] < keys[b]
+	})
+	for _, k := range keys {
+		out = append(out, params[k]...)
+	}
+	return out
+}
+
+func parseBTIH(s string) ([sha1.Size]byte, error) {
+	var hash [sha1.Size]byte
+
+	var (
+		b   []byte
+		err error
+	)
+	switch len(s) {
+	case sha1.Size * 2: // 20 bytes = 40 hex chars
+		b, err = hex.DecodeString(s)
+	case 32: // 20 bytes = 32 base32 chars
+		b, err = base32.StdEncoding.DecodeString(strings.ToUpper(s))
+	default:
+		return hash, fmt.Errorf("invalid infohash length")
+	}
+	if err != nil {
+		return hash, fmt.Errorf("failed to decode infohash: %w", err)
+	}
+
+	copy(hash[:], b)
+	return hash, nil
+}
+
+func parseBTMH(s string) ([sha256.Size]byte, error) {
+	var hash [sha256.Size]byte
+
+	if !strings.HasPrefix(s, multihashSHA256) {
+		return hash, fmt.Errorf("unsupported multihash: only sha2-256 (1220) is supported")
+	}
+	digest := strings.TrimPrefix(s, multihashSHA256)
+	if len(digest) != sha256.Size*2 {
+		return hash, fmt.Errorf("invalid infohash length")
+	}
+	b, err := hex.DecodeString(digest)
+	if err != nil {
+		return hash, fmt.Errorf("failed to decode infohash: %w", err)
+	}
+
+	copy(hash[:], b)
+	return hash, nil
+}
+
+// String formats the magnet as a URI: the hex info hashes first, v1 then
+// v2, followed by the display name, trackers, peer hints and web seeds in
+// order, each value query-escaped.
+func (m *Magnet) String() string {
+	var b strings.Builder
+
+	b.WriteString("magnet:?")
+	if m.HasV1() || m.InfoHashV2 == nil {
+		b.WriteString("xt=urn:btih:")
+		b.WriteString(hex.EncodeToString(m.InfoHash[:]))
+	}
+	if m.InfoHashV2 != nil {
+		if m.HasV1() {
+			b.WriteString("&")
+		}
+		b.WriteString("xt=urn:btmh:" + multihashSHA256)
+		b.WriteString(hex.EncodeToString(m.InfoHashV2[:]))
+	}
+	if m.Name != "" {
+		b.WriteString("&dn=")
+		b.WriteString(url.QueryEscape(m.Name))
+	}
+	for _, tr := range m.Trackers {
+		b.WriteString("&tr=")
+		b.WriteString(url.QueryEscape(tr))
+	}
+	for _, pe := range m.Peers {
+		b.WriteString("&x.pe=")
+		b.WriteString(url.QueryEscape(pe.String()))
+	}
+	for _, ws := range m.WebSeeds {
+		b.WriteString("&ws=")
+		b.WriteString(url.QueryEscape(ws))
+	}
+
+	return b.String()
+}
+
+// Magnet returns the magnet link for the torrent, listing the announce URL
+// and every announce-list tier without duplicates.
+func (m *Metainfo) Magnet() *Magnet {
+	seen := make(map[string]struct{})
+	var trackers []string
+
+	add := func(tr string) {
+		if tr == "" {
+			return
+		}
+		if _, ok := seen[tr]; ok {
+			return
+		}
+		seen[tr] = struct{}{}
+		trackers = append(trackers, tr)
+	}
+
+	add(m.Announce)
+	for _, tier := range m.AnnounceList {
+		for _, tr := range tier {
+			add(tr)
+		}
+	}
+
+	return &Magnet{
+		InfoHash: m.InfoHash,
+		Name:     m.Info.Name,
+		Trackers: trackers,
+	}
+}
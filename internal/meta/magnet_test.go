@@ -2,8 +2,10 @@ package meta
 
 import (
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"net/netip"
 	"reflect"
 	"strings"
 	"testing"
@@ -19,6 +21,16 @@ func mustDecodeInfoHash(s string) [sha1.Size]byte {
 	return arr
 }
 
+func mustDecodeInfoHashV2(s string) *[sha256.Size]byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(fmt.Sprintf("test setup failed: bad hex string '%s': %v", s, err))
+	}
+	var arr [sha256.Size]byte
+	copy(arr[:], b)
+	return &arr
+}
+
 func TestParseMagnet(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -79,6 +91,69 @@ func TestParseMagnet(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:  "Base32 btih",
+			input: "magnet:?xt=urn:btih:YEX6DQDLXISUVHOJ6UM3GNNKPQJWPKEK",
+			want: &Magnet{
+				InfoHash: mustDecodeInfoHash(
+					"c12fe1c06bba254a9dc9f519b335aa7c1367a88a",
+				),
+			},
+		},
+		{
+			name:  "Lowercase base32 btih",
+			input: "magnet:?xt=urn:btih:yex6dqdlxisuvhoj6um3gnnkpqjwpkek",
+			want: &Magnet{
+				InfoHash: mustDecodeInfoHash(
+					"c12fe1c06bba254a9dc9f519b335aa7c1367a88a",
+				),
+			},
+		},
+		{
+			name:  "v2 only",
+			input: "magnet:?xt=urn:btmh:1220" + strings.Repeat("ab", 32),
+			want: &Magnet{
+				InfoHashV2: mustDecodeInfoHashV2(strings.Repeat("ab", 32)),
+			},
+		},
+		{
+			name: "Hybrid with numbered topics, peers and web seeds",
+			input: "magnet:?xt.1=urn:btih:c12fe1c06bba254a9dc9f519b335aa7c1367a88a" +
+				"&xt.2=urn:btmh:1220" + strings.Repeat("cd", 32) +
+				"&tr=udp%3A%2F%2Fa.example%3A1&tr=udp%3A%2F%2Fa.example%3A1&tr.1=http%3A%2F%2Fb.example" +
+				"&x.pe=10.0.0.1%3A6881&x.pe=%5B2001%3Adb8%3A%3A1%5D%3A51413&x.pe=peer.example%3A1" +
+				"&ws=https%3A%2F%2Fmirror.example%2Ffile&ws=ftp%3A%2F%2Fmirror.example%2Ffile",
+			want: &Magnet{
+				InfoHash: mustDecodeInfoHash(
+					"c12fe1c06bba254a9dc9f519b335aa7c1367a88a",
+				),
+				InfoHashV2: mustDecodeInfoHashV2(strings.Repeat("cd", 32)),
+				Trackers:   []string{"udp://a.example:1", "http://b.example"},
+				Peers: []netip.AddrPort{
+					netip.MustParseAddrPort("10.0.0.1:6881"),
+					netip.MustParseAddrPort("[2001:db8::1]:51413"),
+				},
+				WebSeeds: []string{"https://mirror.example/file"},
+			},
+		},
+		{
+			name:      "Unsupported multihash",
+			input:     "magnet:?xt=urn:btmh:1114" + strings.Repeat("00", 20),
+			wantErr:   true,
+			errSubstr: "unsupported multihash",
+		},
+		{
+			name:      "v2 hash too short",
+			input:     "magnet:?xt=urn:btmh:1220abcd",
+			wantErr:   true,
+			errSubstr: "invalid infohash length",
+		},
+		{
+			name:      "Base32 not decodable",
+			input:     "magnet:?xt=urn:btih:11111111111111111111111111111111",
+			wantErr:   true,
+			errSubstr: "failed to decode infohash",
+		},
 
 		// --- Error Cases ---
 		{
@@ -163,3 +238,84 @@ func TestParseMagnet(t *testing.T) {
 		})
 	}
 }
+
+func TestMagnet_String_RoundTrip(t *testing.T) {
+	m := &Magnet{
+		InfoHash: mustDecodeInfoHash("c12fe1c06bba254a9dc9f519b335aa7c1367a88a"),
+		Name:     "My File & Co.zip",
+		Trackers: []string{
+			"udp://tracker.example.org:80/announce",
+			"https://t.example.com/a?passkey=abc&x=1",
+		},
+	}
+
+	want := "magnet:?xt=urn:btih:c12fe1c06bba254a9dc9f519b335aa7c1367a88a" +
+		"&dn=My+File+%26+Co.zip" +
+		"&tr=udp%3A%2F%2Ftracker.example.org%3A80%2Fannounce" +
+		"&tr=https%3A%2F%2Ft.example.com%2Fa%3Fpasskey%3Dabc%26x%3D1"
+	if got := m.String(); got != want {
+		t.Fatalf("String() = %q, want %q", got, want)
+	}
+
+	got, err := ParseMagnet(m.String())
+	if err != nil {
+		t.Fatalf("ParseMagnet() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("round trip mismatch:\ngot  = %+v\nwant = %+v", got, m)
+	}
+}
+
+func TestMagnet_String_RoundTripHybrid(t *testing.T) {
+	m := &Magnet{
+		InfoHash:   mustDecodeInfoHash("c12fe1c06bba254a9dc9f519b335aa7c1367a88a"),
+		InfoHashV2: mustDecodeInfoHashV2(strings.Repeat("ef", 32)),
+		Name:       "hybrid",
+		Trackers:   []string{"udp://a.example:1"},
+		Peers:      []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:6881")},
+		WebSeeds:   []string{"https://mirror.example/hybrid"},
+	}
+
+	got, err := ParseMagnet(m.String())
+	if err != nil {
+		t.Fatalf("ParseMagnet() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("round trip mismatch:\ngot  = %+v\nwant = %+v", got, m)
+	}
+
+	m.InfoHash = [sha1.Size]byte{}
+	got, err = ParseMagnet(m.String())
+	if err != nil {
+		t.Fatalf("ParseMagnet(v2 only) error = %v", err)
+	}
+	if got.HasV1() || !reflect.DeepEqual(got.InfoHashV2, m.InfoHashV2) {
+		t.Errorf("v2-only round trip: got %+v", got)
+	}
+}
+
+func TestMetainfo_Magnet(t *testing.T) {
+	mi := &Metainfo{
+		Info:     &Info{Name: "ubuntu.iso"},
+		Announce: "udp://a.example:1",
+		AnnounceList: [][]string{
+			{"udp://a.example:1", "udp://b.example:2"},
+			{"", "http://c.example/announce"},
+		},
+		InfoHash: mustDecodeInfoHash("0000000000000000000000000000000000000001"),
+	}
+
+	got := mi.Magnet()
+	want := &Magnet{
+		InfoHash: mi.InfoHash,
+		Name:     "ubuntu.iso",
+		Trackers: []string{
+			"udp://a.example:1",
+			"udp://b.example:2",
+			"http://c.example/announce",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Magnet() mismatch:\ngot  = %+v\nwant = %+v", got, want)
+	}
+}
@@ -39,7 +39,6 @@ type File struct {
 
 var (
 	ErrTopLevelNotDict     = errors.New("metainfo: top-level is not a dict")
-	ErrAnnounceMissing     = errors.New("metainfo: both announce and announce-list missing")
 	ErrInfoMissing         = errors.New("metainfo: 'info' missing")
 	ErrInfoNotDict         = errors.New("metainfo: 'info' is not a dict")
 	ErrNameMissing         = errors.New("metainfo: 'info' name missing")
@@ -82,9 +81,6 @@ func ParseMetainfo(data []byte) (*Metainfo, error) {
 	if err != nil {
 		return nil, err
 	}
-	if announce == "" && len(announceList) == 0 {
-		return nil, ErrAnnounceMissing
-	}
 
 	var creationDate time.Time
 	if v, ok := root["creation date"]; ok {
@@ -168,7 +168,7 @@ func TestParseMetainfo_TopLevelAndRequiredErrors(t *testing.T) {
 		t.Fatalf("want ErrTopLevelNotDict, got %v", err)
 	}
 
-	// Missing both announce and announce-list
+	// Missing both announce and announce-list is a trackerless torrent
 	info := map[string]any{
 		"name":         "f",
 		"piece length": int64(1),
@@ -177,9 +177,10 @@ func TestParseMetainfo_TopLevelAndRequiredErrors(t *testing.T) {
 	}
 	root := map[string]any{"info": info}
 	data, _ = bencode.Marshal(root)
-	if _, err := ParseMetainfo(data); err == nil ||
-		err != ErrAnnounceMissing {
-		t.Fatalf("want ErrAnnounceMissing, got %v", err)
+	if m, err := ParseMetainfo(data); err != nil {
+		t.Fatalf("trackerless torrent: unexpected error %v", err)
+	} else if m.Announce != "" || len(m.AnnounceList) != 0 {
+		t.Fatalf("trackerless torrent: got announce %q, list %v", m.Announce, m.AnnounceList)
 	}
 
 	// Info missing
@@ -0,0 +1,197 @@
+// Package migrate reads the saved session of other BitTorrent clients so
+// their torrents can be recreated in rabbit without downloading or
+// hash-checking everything again.
+//
+// qBittorrent and Deluge both sit on libtorrent and keep its resume data,
+// which is all we need: the save path and which pieces are on disk.
+package migrate
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/prxssh/rabbit/internal/bencode"
+	"github.com/prxssh/rabbit/internal/meta"
+	"github.com/prxssh/rabbit/pkg/bitfield"
+)
+
+// Torrent is one torrent recovered from another client's session.
+type Torrent struct {
+	InfoHash string
+	Name     string
+	// Data is the .torrent file.
+	Data     []byte
+	SavePath string
+	Category string
+	// Have marks the pieces the other client had verified. Nil when the
+	// resume data had no usable piece map.
+	Have bitfield.Bitfield
+}
+
+// Result lists what was recovered and, keyed by info hash or file name,
+// why the rest was skipped.
+type Result struct {
+	Torrents []Torrent
+	Skipped  map[string]string
+}
+
+// QBittorrent reads a qBittorrent BT_backup directory, which holds a
+// <hash>.torrent and <hash>.fastresume pair per torrent.
+func QBittorrent(dir string) (*Result, error) {
+	torrents, err := filepath.Glob(filepath.Join(dir, "*.torrent"))
+	if err != nil {
+		return nil, err
+	}
+	if len(torrents) == 0 {
+		return nil, fmt.Errorf("migrate: no .torrent files in %s", dir)
+	}
+	sort.Strings(torrents)
+
+	res := &Result{Skipped: make(map[string]string)}
+	for _, path := range torrents {
+		base := strings.TrimSuffix(filepath.Base(path), ".torrent")
+
+		resume, err := readResume(filepath.Join(dir, base+".fastresume"))
+		if err != nil {
+			res.Skipped[base] = err.Error()
+			continue
+		}
+
+		// Older qBittorrent versions keep their own save path, which wins
+		// over libtorrent's.
+		if saved, _ := resume["qBt-savePath"].(string); saved != "" {
+			resume["save_path"] = saved
+		}
+
+		t, err := load(path, resume)
+		if err != nil {
+			res.Skipped[base] = err.Error()
+			continue
+		}
+		t.Category, _ = resume["qBt-category"].(string)
+
+		res.Torrents = append(res.Torrents, *t)
+	}
+
+	return res, nil
+}
+
+// Deluge reads a Deluge state directory: <hash>.torrent files plus one
+// torrents.fastresume holding every torrent's resume data by info hash.
+// Labels live in Deluge's Python pickles and are not carried over.
+func Deluge(dir string) (*Result, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "torrents.fastresume"))
+	if err != nil {
+		return nil, fmt.Errorf("migrate: read deluge resume data: %w", err)
+	}
+	v, err := bencode.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: parse deluge resume data: %w", err)
+	}
+	all, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("migrate: deluge resume data is not a dictionary")
+	}
+
+	hashes := make([]string, 0, len(all))
+	for h := range all {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+
+	res := &Result{Skipped: make(map[string]string)}
+	for _, h := range hashes {
+		resume, err := resumeDict(all[h])
+		if err != nil {
+			res.Skipped[h] = err.Error()
+			continue
+		}
+
+		t, err := load(filepath.Join(dir, h+".torrent"), resume)
+		if err != nil {
+			res.Skipped[h] = err.Error()
+			continue
+		}
+		res.Torrents = append(res.Torrents, *t)
+	}
+
+	return res, nil
+}
+
+func readResume(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read resume data: %w", err)
+	}
+	v, err := bencode.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse resume data: %w", err)
+	}
+	return resumeDict(v)
+}
+
+// resumeDict accepts resume data either decoded or, as Deluge stores it,
+// still bencoded inside a string.
+func resumeDict(v any) (map[string]any, error) {
+	if s, ok := v.(string); ok {
+		var err error
+		if v, err = bencode.Unmarshal([]byte(s)); err != nil {
+			return nil, fmt.Errorf("parse resume data: %w", err)
+		}
+	}
+
+	d, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("resume data is not a dictionary")
+	}
+	return d, nil
+}
+
+// load reads the .torrent at path and fills in what libtorrent's
+// resume data says about it.
+func load(path string, resume map[string]any) (*Torrent, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read torrent: %w", err)
+	}
+	metainfo, err := meta.ParseMetainfo(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse torrent: %w", err)
+	}
+
+	savePath, _ := resume["save_path"].(string)
+	if savePath == "" {
+		return nil, errors.New("resume data has no save path")
+	}
+
+	return &Torrent{
+		InfoHash: hex.EncodeToString(metainfo.InfoHash[:]),
+		Name:     metainfo.Info.Name,
+		Data:     data,
+		SavePath: savePath,
+		Have:     havePieces(resume, len(metainfo.Info.Pieces)),
+	}, nil
+}
+
+// havePieces decodes libtorrent's "pieces" entry, one byte per piece with
+// the low bit set for pieces on disk. A map of the wrong length is
+// ignored rather than trusted.
+func havePieces(resume map[string]any, count int) bitfield.Bitfield {
+	s, ok := resume["pieces"].(string)
+	if !ok || len(s) != count {
+		return nil
+	}
+
+	bf := bitfield.New(count)
+	for i := 0; i < count; i++ {
+		if s[i]&1 == 1 {
+			bf.Set(i)
+		}
+	}
+	return bf
+}
@@ -0,0 +1,133 @@
+package migrate
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prxssh/rabbit/internal/bencode"
+	"github.com/prxssh/rabbit/internal/meta"
+)
+
+func mkTorrent(t *testing.T, name string, pieces int) ([]byte, string) {
+	t.Helper()
+
+	info := map[string]any{
+		"name":         name,
+		"piece length": int64(16384),
+		"pieces":       bytes.Repeat([]byte{'x'}, pieces*sha1.Size),
+		"length":       int64(16384 * pieces),
+	}
+	data, err := bencode.Marshal(map[string]any{
+		"announce": "http://tracker/announce",
+		"info":     info,
+	})
+	if err != nil {
+		t.Fatalf("marshal torrent: %v", err)
+	}
+
+	mi, err := meta.ParseMetainfo(data)
+	if err != nil {
+		t.Fatalf("ParseMetainfo: %v", err)
+	}
+	return data, hex.EncodeToString(mi.InfoHash[:])
+}
+
+func writeFile(t *testing.T, path string, v any) {
+	t.Helper()
+
+	data, ok := v.([]byte)
+	if !ok {
+		var err error
+		if data, err = bencode.Marshal(v); err != nil {
+			t.Fatalf("marshal %s: %v", path, err)
+		}
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQBittorrent(t *testing.T) {
+	dir := t.TempDir()
+
+	data, hash := mkTorrent(t, "linux.iso", 3)
+	writeFile(t, filepath.Join(dir, hash+".torrent"), data)
+	writeFile(t, filepath.Join(dir, hash+".fastresume"), map[string]any{
+		"save_path":    "/old/path",
+		"qBt-savePath": "/data/linux",
+		"qBt-category": "iso",
+		"pieces":       "\x01\x00\x01",
+	})
+
+	other, otherHash := mkTorrent(t, "no-resume", 1)
+	writeFile(t, filepath.Join(dir, otherHash+".torrent"), other)
+
+	res, err := QBittorrent(dir)
+	if err != nil {
+		t.Fatalf("QBittorrent() error = %v", err)
+	}
+
+	if len(res.Torrents) != 1 {
+		t.Fatalf("got %d torrents, want 1", len(res.Torrents))
+	}
+	got := res.Torrents[0]
+	if got.InfoHash != hash || got.Name != "linux.iso" {
+		t.Errorf("torrent = %s %q, want %s %q", got.InfoHash, got.Name, hash, "linux.iso")
+	}
+	if got.SavePath != "/data/linux" || got.Category != "iso" {
+		t.Errorf("save path, category = %q, %q", got.SavePath, got.Category)
+	}
+	if !got.Have.Has(0) || got.Have.Has(1) || !got.Have.Has(2) {
+		t.Errorf("have = %s, want pieces 0 and 2", got.Have)
+	}
+	if !bytes.Equal(got.Data, data) {
+		t.Error("torrent data not preserved")
+	}
+
+	if _, ok := res.Skipped[otherHash]; !ok {
+		t.Errorf("torrent without resume data not skipped: %v", res.Skipped)
+	}
+}
+
+func TestDeluge(t *testing.T) {
+	dir := t.TempDir()
+
+	data, hash := mkTorrent(t, "album", 2)
+	writeFile(t, filepath.Join(dir, hash+".torrent"), data)
+
+	resume, err := bencode.Marshal(map[string]any{
+		"save_path": "/music",
+		// Wrong length: must be ignored, not trusted.
+		"pieces": "\x01",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "torrents.fastresume"), map[string]any{
+		hash:                   string(resume),
+		"0000000000000000dead": map[string]any{"save_path": "/gone"},
+	})
+
+	res, err := Deluge(dir)
+	if err != nil {
+		t.Fatalf("Deluge() error = %v", err)
+	}
+
+	if len(res.Torrents) != 1 {
+		t.Fatalf("got %d torrents, want 1 (skipped: %v)", len(res.Torrents), res.Skipped)
+	}
+	got := res.Torrents[0]
+	if got.SavePath != "/music" {
+		t.Errorf("save path = %q, want /music", got.SavePath)
+	}
+	if got.Have != nil {
+		t.Errorf("have = %s, want nil for a mis-sized piece map", got.Have)
+	}
+	if _, ok := res.Skipped["0000000000000000dead"]; !ok {
+		t.Errorf("entry without .torrent not skipped: %v", res.Skipped)
+	}
+}
@@ -0,0 +1,139 @@
+package peer
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// dialBackoff remembers addresses we failed to connect to, so the dialers
+// don't spend their slots on dead peers every time a tracker hands them
+// back. Each consecutive failure doubles the wait before the next attempt,
+// and after maxAttempts the address is given up on for the session.
+type dialBackoff struct {
+	mut      sync.Mutex
+	failures map[netip.AddrPort]*dialFailure
+}
+
+type dialFailure struct {
+	attempts int
+	retryAt  time.Time
+}
+
+func newDialBackoff() *dialBackoff {
+	return &dialBackoff{failures: make(map[netip.AddrPort]*dialFailure)}
+}
+
+// allow reports whether addr may be dialed at now.
+func (b *dialBackoff) allow(addr netip.AddrPort, now time.Time, maxAttempts int) bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	f, ok := b.failures[addr]
+	if !ok {
+		return true
+	}
+	if maxAttempts > 0 && f.attempts >= maxAttempts {
+		return false
+	}
+	return !now.Before(f.retryAt)
+}
+
+// failed records a failed attempt and schedules the next one base doubled
+// once per earlier failure, capped at limit.
+func (b *dialBackoff) failed(addr netip.AddrPort, now time.Time, base, limit time.Duration) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	f, ok := b.failures[addr]
+	if !ok {
+		f = &dialFailure{}
+		b.failures[addr] = f
+	}
+
+	wait := base
+	for i := 0; i < f.attempts && wait < limit; i++ {
+		wait *= 2
+	}
+	if limit > 0 {
+		wait = min(wait, limit)
+	}
+
+	f.attempts++
+	f.retryAt = now.Add(wait)
+}
+
+// succeeded forgets addr's failures.
+func (b *dialBackoff) succeeded(addr netip.AddrPort) {
+	b.mut.Lock()
+	delete(b.failures, addr)
+	b.mut.Unlock()
+}
+
+// reset forgets every address's failures.
+func (b *dialBackoff) reset() {
+	b.mut.Lock()
+	clear(b.failures)
+	b.mut.Unlock()
+}
+
+// waiting returns how many addresses are backed off at now, including
+// those given up on.
+func (b *dialBackoff) waiting(now time.Time, maxAttempts int) int {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	var n int
+	for _, f := range b.failures {
+		if now.Before(f.retryAt) || (maxAttempts > 0 && f.attempts >= maxAttempts) {
+			n++
+		}
+	}
+	return n
+}
+
+// dialBudget limits how fast new dials start, so a tracker handing an
+// empty swarm 200 peers at once doesn't open 200 sockets in the same
+// moment. Up to burst dials go out straight away; the rest are spread
+// evenly over interval per burst.
+type dialBudget struct {
+	mut    sync.Mutex
+	burst  float64
+	every  time.Duration
+	tokens float64
+	last   time.Time
+}
+
+// newDialBudget returns nil, no limit, when burst or interval is 0.
+func newDialBudget(burst int, interval time.Duration) *dialBudget {
+	if burst <= 0 || interval <= 0 {
+		return nil
+	}
+	return &dialBudget{
+		burst:  float64(burst),
+		every:  interval / time.Duration(burst),
+		tokens: float64(burst),
+	}
+}
+
+// reserve takes a dial from the budget at now and returns how long to
+// wait before making it.
+func (b *dialBudget) reserve(now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = min(b.burst, b.tokens+float64(now.Sub(b.last))/float64(b.every))
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens * float64(b.every))
+}
@@ -0,0 +1,84 @@
+package peer
+
+import (
+	"context"
+
+	"github.com/prxssh/rabbit/pkg/ratelimit"
+)
+
+// Bandwidth holds the client-wide rate limiters shared by every peer
+// connection of every torrent.
+type Bandwidth struct {
+	Download *ratelimit.Limiter
+	Upload   *ratelimit.Limiter
+
+	// IncludeOverhead makes the limiters count protocol overhead
+	// (message headers, handshakes, keep-alives) in addition to piece
+	// data.
+	IncludeOverhead bool
+
+	// downloadShare and uploadShare are one torrent's weighted claims on
+	// the limiters, set on the copies Shared hands out.
+	downloadShare *ratelimit.Share
+	uploadShare   *ratelimit.Share
+}
+
+// Shared returns a copy of b for one torrent, whose peers split the
+// limiters' rates with other torrents' by weight. Release it when the
+// torrent goes away. It is nil if b is.
+func (b *Bandwidth) Shared(weight int) *Bandwidth {
+	if b == nil {
+		return nil
+	}
+
+	shared := *b
+	shared.downloadShare = b.Download.NewShare(weight)
+	shared.uploadShare = b.Upload.NewShare(weight)
+	return &shared
+}
+
+// SetWeight changes a shared copy's weight.
+func (b *Bandwidth) SetWeight(weight int) {
+	if b == nil {
+		return
+	}
+	b.downloadShare.SetWeight(weight)
+	b.uploadShare.SetWeight(weight)
+}
+
+// Release gives up a shared copy's claims on the limiters.
+func (b *Bandwidth) Release() {
+	if b == nil {
+		return
+	}
+	b.downloadShare.Release()
+	b.uploadShare.Release()
+}
+
+// limited returns how many of the frame's bytes are charged to a limiter.
+func (b *Bandwidth) limited(payload, overhead int) int {
+	if b.IncludeOverhead {
+		return payload + overhead
+	}
+	return payload
+}
+
+// uploadLimited reports whether an upload rate is set. A share only
+// divides its limiter's rate, so the limiter decides.
+func (b *Bandwidth) uploadLimited() bool {
+	return b.Upload.Rate() > 0
+}
+
+func (b *Bandwidth) waitDownload(ctx context.Context, n int) error {
+	if b.downloadShare != nil {
+		return b.downloadShare.WaitN(ctx, n)
+	}
+	return b.Download.WaitN(ctx, n)
+}
+
+func (b *Bandwidth) waitUpload(ctx context.Context, n int) error {
+	if b.uploadShare != nil {
+		return b.uploadShare.WaitN(ctx, n)
+	}
+	return b.Upload.WaitN(ctx, n)
+}
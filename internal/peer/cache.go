@@ -0,0 +1,130 @@
+package peer
+
+import (
+	"crypto/sha1"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheTTL      = 6 * time.Hour
+	defaultCacheCapacity = 200
+)
+
+// CachedPeer is a peer we previously completed a handshake with.
+type CachedPeer struct {
+	Addr       netip.AddrPort
+	Score      float64
+	Downloaded uint64
+	Uploaded   uint64
+	LastSeen   time.Time
+}
+
+// Cache remembers known-good peers per info hash across torrent restarts, so
+// a paused or re-added torrent can reconnect to its swarm immediately instead
+// of waiting for the next tracker interval.
+//
+// It is shared by every torrent of a client.
+type Cache struct {
+	mut      sync.Mutex
+	ttl      time.Duration
+	capacity int
+	peers    map[[sha1.Size]byte]map[netip.AddrPort]*CachedPeer
+}
+
+func NewCache(ttl time.Duration, capacity int) *Cache {
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+	if capacity <= 0 {
+		capacity = defaultCacheCapacity
+	}
+
+	return &Cache{
+		ttl:      ttl,
+		capacity: capacity,
+		peers:    make(map[[sha1.Size]byte]map[netip.AddrPort]*CachedPeer),
+	}
+}
+
+// Record stores or refreshes a peer of infoHash with the traffic exchanged
+// during its last session.
+func (c *Cache) Record(infoHash [sha1.Size]byte, addr netip.AddrPort, downloaded, uploaded uint64) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	byAddr, ok := c.peers[infoHash]
+	if !ok {
+		byAddr = make(map[netip.AddrPort]*CachedPeer)
+		c.peers[infoHash] = byAddr
+	}
+
+	cp, ok := byAddr[addr]
+	if !ok {
+		cp = &CachedPeer{Addr: addr}
+		byAddr[addr] = cp
+	}
+	cp.Downloaded += downloaded
+	cp.Uploaded += uploaded
+	cp.LastSeen = time.Now()
+	cp.Score = peerScore(cp)
+
+	if len(byAddr) > c.capacity {
+		c.evictWorst(byAddr)
+	}
+}
+
+// Peers returns the unexpired cached peers of infoHash, best first.
+func (c *Cache) Peers(infoHash [sha1.Size]byte) []CachedPeer {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	byAddr := c.peers[infoHash]
+	cutoff := time.Now().Add(-c.ttl)
+
+	out := make([]CachedPeer, 0, len(byAddr))
+	for addr, cp := range byAddr {
+		if cp.LastSeen.Before(cutoff) {
+			delete(byAddr, addr)
+			continue
+		}
+		out = append(out, *cp)
+	}
+	if len(byAddr) == 0 {
+		delete(c.peers, infoHash)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+	return out
+}
+
+// Forget drops every cached peer of infoHash.
+func (c *Cache) Forget(infoHash [sha1.Size]byte) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	delete(c.peers, infoHash)
+}
+
+func (c *Cache) evictWorst(byAddr map[netip.AddrPort]*CachedPeer) {
+	var (
+		worst *CachedPeer
+		addr  netip.AddrPort
+	)
+
+	for a, cp := range byAddr {
+		if worst == nil || cp.Score < worst.Score {
+			worst, addr = cp, a
+		}
+	}
+
+	delete(byAddr, addr)
+}
+
+// peerScore favours peers that gave us data, then peers that took data; a
+// peer we merely handshook with still scores above zero so it is retried.
+func peerScore(cp *CachedPeer) float64 {
+	return 1 + float64(cp.Downloaded)/(1<<20) + float64(cp.Uploaded)/(2<<20)
+}
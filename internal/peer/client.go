@@ -0,0 +1,79 @@
+package peer
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"strings"
+)
+
+// azureusClients maps Azureus-style peer ID codes ("-qB4250-") to client
+// names. Only clients common enough to be worth naming are listed.
+var azureusClients = map[string]string{
+	"AZ": "Vuze",
+	"BC": "BitComet",
+	"BI": "BiglyBT",
+	"BT": "BitTorrent",
+	"DE": "Deluge",
+	"FD": "Free Download Manager",
+	"KT": "KTorrent",
+	"LT": "libtorrent",
+	"lt": "rTorrent",
+	"PI": "PicoTorrent",
+	"qB": "qBittorrent",
+	"RB": "rabbit",
+	"TL": "Tribler",
+	"TR": "Transmission",
+	"UT": "µTorrent",
+	"UW": "µTorrent Web",
+	"WW": "WebTorrent",
+}
+
+// ClientName guesses the client software from a peer ID, e.g.
+// "qBittorrent 4.2.5". Unknown IDs return their printable prefix.
+func ClientName(id [sha1.Size]byte) string {
+	if id == ([sha1.Size]byte{}) {
+		return ""
+	}
+
+	if id[0] == '-' && id[7] == '-' {
+		code, ver := string(id[1:3]), id[3:7]
+		if name, ok := azureusClients[code]; ok {
+			return name + " " + azureusVersion(ver)
+		}
+		return fmt.Sprintf("%s %s", printable(id[1:3]), azureusVersion(ver))
+	}
+
+	// Mainline style, e.g. "M7-2-2--".
+	if id[0] == 'M' {
+		if v, _, ok := strings.Cut(string(id[1:8]), "--"); ok {
+			return "BitTorrent " + strings.ReplaceAll(v, "-", ".")
+		}
+	}
+
+	return printable(id[:8])
+}
+
+// azureusVersion turns the four version characters into dotted form,
+// dropping trailing zeros past the minor version: "4250" is "4.2.5".
+func azureusVersion(v []byte) string {
+	parts := make([]string, 0, len(v))
+	for _, c := range v {
+		parts = append(parts, printable([]byte{c}))
+	}
+	for len(parts) > 2 && parts[len(parts)-1] == "0" {
+		parts = parts[:len(parts)-1]
+	}
+	return strings.Join(parts, ".")
+}
+
+func printable(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		if c >= 0x20 && c < 0x7f {
+			sb.WriteByte(c)
+		} else {
+			sb.WriteByte('?')
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,183 @@
+package peer
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prxssh/rabbit/internal/protocol"
+)
+
+// ErrProtocol wraps messages from a peer that break the wire protocol.
+var ErrProtocol = errors.New("peer: protocol violation")
+
+// DisconnectReason says why a connection ended.
+type DisconnectReason uint8
+
+const (
+	// DisconnectError is anything not covered below.
+	DisconnectError DisconnectReason = iota
+	// DisconnectRemote means the peer closed or reset the connection.
+	DisconnectRemote
+	DisconnectTimeout
+	DisconnectProtocol
+	// DisconnectIdle means neither side moved data for
+	// PeerInactivityDuration.
+	DisconnectIdle
+	// DisconnectKicked means the scheduler dropped the peer for sending
+	// bad blocks.
+	DisconnectKicked
+	// DisconnectSeed means the peer was a seed while we seed too.
+	DisconnectSeed
+	// DisconnectShutdown means the torrent stopped.
+	DisconnectShutdown
+
+	disconnectReasons
+)
+
+func (r DisconnectReason) String() string {
+	switch r {
+	case DisconnectRemote:
+		return "remote"
+	case DisconnectTimeout:
+		return "timeout"
+	case DisconnectProtocol:
+		return "protocol"
+	case DisconnectIdle:
+		return "idle"
+	case DisconnectKicked:
+		return "kicked"
+	case DisconnectSeed:
+		return "seed"
+	case DisconnectShutdown:
+		return "shutdown"
+	default:
+		return "error"
+	}
+}
+
+// disconnectReason classifies the error a peer's Run returned.
+func disconnectReason(err error) DisconnectReason {
+	var netErr net.Error
+
+	switch {
+	case err == nil, errors.Is(err, net.ErrClosed):
+		return DisconnectShutdown
+	case errors.Is(err, ErrDisconnected):
+		return DisconnectKicked
+	case errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET):
+		return DisconnectRemote
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return DisconnectTimeout
+	case errors.Is(err, ErrProtocol),
+		errors.Is(err, protocol.ErrShortMessage),
+		errors.Is(err, protocol.ErrBadLengthPrefix),
+		errors.Is(err, protocol.ErrBadPayloadSize),
+		errors.Is(err, protocol.ErrMessageTooLarge):
+		return DisconnectProtocol
+	default:
+		return DisconnectError
+	}
+}
+
+// DialLatencyBuckets are the upper bounds of the dial latency histogram.
+// The last bucket counts everything slower.
+var DialLatencyBuckets = [...]time.Duration{
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// connHealth counts how a swarm's connections fare, from dial to
+// disconnect.
+type connHealth struct {
+	dials             atomic.Uint64
+	dialFailures      atomic.Uint64
+	handshakes        atomic.Uint64
+	handshakeFailures atomic.Uint64
+	dialLatency       [len(DialLatencyBuckets) + 1]atomic.Uint64
+
+	closed      atomic.Uint64
+	lifetimeNs  atomic.Int64
+	disconnects [disconnectReasons]atomic.Uint64
+}
+
+func (h *connHealth) dialed(took time.Duration, err error) {
+	h.dials.Add(1)
+	if err != nil {
+		h.dialFailures.Add(1)
+		return
+	}
+
+	i := 0
+	for i < len(DialLatencyBuckets) && took > DialLatencyBuckets[i] {
+		i++
+	}
+	h.dialLatency[i].Add(1)
+}
+
+func (h *connHealth) handshook(err error) {
+	if err != nil {
+		h.handshakeFailures.Add(1)
+		return
+	}
+	h.handshakes.Add(1)
+}
+
+func (h *connHealth) disconnected(reason DisconnectReason, lifetime time.Duration) {
+	h.closed.Add(1)
+	h.lifetimeNs.Add(int64(lifetime))
+	h.disconnects[reason].Add(1)
+}
+
+// ConnectionHealth is a snapshot of connHealth.
+type ConnectionHealth struct {
+	Dials             uint64 `json:"dials"`
+	DialFailures      uint64 `json:"dialFailures"`
+	Handshakes        uint64 `json:"handshakes"`
+	HandshakeFailures uint64 `json:"handshakeFailures"`
+	// HandshakeSuccess is the share of established connections whose
+	// handshake succeeded, from 0 to 1.
+	HandshakeSuccess float64 `json:"handshakeSuccess"`
+	// DialLatency counts successful dials per DialLatencyBuckets bucket.
+	DialLatency []uint64 `json:"dialLatency"`
+	// AvgLifetimeNs is the mean duration of connections that have ended.
+	AvgLifetimeNs int64 `json:"avgLifetimeNs"`
+	// Disconnects counts ended connections by DisconnectReason name.
+	Disconnects map[string]uint64 `json:"disconnects"`
+}
+
+func (h *connHealth) snapshot() ConnectionHealth {
+	out := ConnectionHealth{
+		Dials:             h.dials.Load(),
+		DialFailures:      h.dialFailures.Load(),
+		Handshakes:        h.handshakes.Load(),
+		HandshakeFailures: h.handshakeFailures.Load(),
+		DialLatency:       make([]uint64, len(h.dialLatency)),
+		Disconnects:       make(map[string]uint64, disconnectReasons),
+	}
+
+	if total := out.Handshakes + out.HandshakeFailures; total > 0 {
+		out.HandshakeSuccess = float64(out.Handshakes) / float64(total)
+	}
+	for i := range h.dialLatency {
+		out.DialLatency[i] = h.dialLatency[i].Load()
+	}
+	if closed := h.closed.Load(); closed > 0 {
+		out.AvgLifetimeNs = h.lifetimeNs.Load() / int64(closed)
+	}
+	for r := DisconnectReason(0); r < disconnectReasons; r++ {
+		out.Disconnects[r.String()] = h.disconnects[r].Load()
+	}
+	return out
+}
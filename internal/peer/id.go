@@ -0,0 +1,33 @@
+package peer
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"sync"
+
+	"github.com/prxssh/rabbit/internal/version"
+)
+
+// sessionID is generated on first use and kept for the life of the
+// process.
+var sessionID = sync.OnceValues(NewID)
+
+// SessionID returns the peer ID we present to trackers and peers for the
+// whole session, however many clients and torrents are created.
+func SessionID() ([sha1.Size]byte, error) {
+	return sessionID()
+}
+
+// NewID returns a fresh random peer ID with our client prefix, for
+// torrents that shouldn't share the session's identity.
+func NewID() ([sha1.Size]byte, error) {
+	var id [sha1.Size]byte
+
+	prefix := []byte(version.PeerIDPrefix())
+	copy(id[:], prefix)
+
+	if _, err := rand.Read(id[len(prefix):]); err != nil {
+		return [sha1.Size]byte{}, err
+	}
+	return id, nil
+}
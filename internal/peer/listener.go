@@ -0,0 +1,208 @@
+package peer
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/prxssh/rabbit/internal/protocol"
+)
+
+// maxPortAttempts bounds how many ports from the range are tried before
+// giving up.
+const maxPortAttempts = 32
+
+type ListenConfig struct {
+	// Port is the preferred TCP port for incoming peer connections. It is
+	// ignored when RandomizePort is set. 0 picks a port from the range.
+	Port uint16
+
+	// PortRangeMin and PortRangeMax bound the ports tried when Port is 0,
+	// busy, or RandomizePort is set.
+	PortRangeMin uint16
+	PortRangeMax uint16
+
+	// RandomizePort picks a random port from the range on each start.
+	RandomizePort bool
+
+	// Socket tunes accepted connections.
+	Socket SocketConfig
+
+	// HandshakeTimeout is how long an incoming connection has to name
+	// its torrent before it is dropped.
+	HandshakeTimeout time.Duration
+}
+
+func WithDefaultListenConfig() *ListenConfig {
+	return &ListenConfig{
+		Port:             6969,
+		PortRangeMin:     49160,
+		PortRangeMax:     65534,
+		RandomizePort:    false,
+		HandshakeTimeout: 10 * time.Second,
+	}
+}
+
+// Listener accepts incoming peer connections on the client-wide listen
+// port and hands each to the swarm its handshake names.
+type Listener struct {
+	logger           *slog.Logger
+	ln               net.Listener
+	port             uint16
+	socket           SocketConfig
+	handshakeTimeout time.Duration
+	registry         *Registry
+}
+
+// Listen binds the first usable port according to cfg.
+func Listen(cfg *ListenConfig, logger *slog.Logger) (*Listener, error) {
+	if cfg == nil {
+		cfg = WithDefaultListenConfig()
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	var lastErr error
+	for _, port := range candidatePorts(cfg) {
+		ln, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(int(port))))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		bound := ln.Addr().(*net.TCPAddr).Port
+		l := &Listener{
+			logger:           logger.With("source", "listener", "port", bound),
+			ln:               ln,
+			port:             uint16(bound),
+			socket:           cfg.Socket,
+			handshakeTimeout: cfg.HandshakeTimeout,
+			registry:         NewRegistry(),
+		}
+		l.logger.Info("listening for incoming peers")
+
+		return l, nil
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("no candidate ports")
+	}
+	return nil, fmt.Errorf("peer: listen: %w", lastErr)
+}
+
+// Port returns the bound TCP port, which is what must be announced to
+// trackers.
+func (l *Listener) Port() uint16 { return l.port }
+
+// Registry returns the torrents incoming connections are routed to; pass
+// it to each swarm as SwarmOpts.Registry.
+func (l *Listener) Registry() *Registry { return l.registry }
+
+func (l *Listener) Run(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		_ = l.ln.Close()
+	}()
+
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		if err := l.socket.apply(conn); err != nil {
+			l.logger.Debug("tuning incoming connection failed", "error", err)
+		}
+
+		go l.route(conn)
+	}
+}
+
+// route reads the head of an incoming handshake and passes the connection
+// to the swarm for its info hash. Unknown torrents are dropped without
+// a reply.
+func (l *Listener) route(conn net.Conn) {
+	addr, ok := remoteAddrPort(conn)
+	if !ok {
+		_ = conn.Close()
+		return
+	}
+
+	if l.handshakeTimeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(l.handshakeTimeout))
+	}
+	head, err := protocol.ReadHandshakeHead(conn)
+	if err != nil {
+		l.logger.Debug("bad incoming handshake", "remote", addr, "error", err)
+		_ = conn.Close()
+		return
+	}
+
+	swarm, ok := l.registry.Lookup(head.InfoHash)
+	if !ok {
+		l.logger.Debug("rejecting incoming connection for unknown torrent",
+			"remote", addr,
+			"infoHash", hex.EncodeToString(head.InfoHash[:]),
+		)
+		_ = conn.Close()
+		return
+	}
+	if !swarm.deliver(conn, addr, head.SupportsExtensions()) {
+		l.logger.Debug("rejecting incoming connection, swarm busy", "remote", addr)
+		_ = conn.Close()
+	}
+}
+
+func remoteAddrPort(conn net.Conn) (netip.AddrPort, bool) {
+	tcp, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return netip.AddrPort{}, false
+	}
+	ap := tcp.AddrPort()
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()), true
+}
+
+func candidatePorts(cfg *ListenConfig) []uint16 {
+	var ports []uint16
+
+	if cfg.Port != 0 && !cfg.RandomizePort {
+		ports = append(ports, cfg.Port)
+	}
+
+	lo, hi := cfg.PortRangeMin, cfg.PortRangeMax
+	if lo == 0 || hi < lo {
+		return ports
+	}
+
+	span := int(hi-lo) + 1
+	n := min(span, maxPortAttempts)
+	start := 0
+	if cfg.RandomizePort {
+		start = rand.Intn(span)
+	}
+
+	for i := 0; i < n; i++ {
+		port := lo + uint16((start+i)%span)
+		if port != cfg.Port || cfg.RandomizePort {
+			ports = append(ports, port)
+		}
+	}
+
+	return ports
+}
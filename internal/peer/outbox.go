@@ -0,0 +1,237 @@
+package peer
+
+import (
+	"sync"
+
+	"github.com/prxssh/rabbit/internal/protocol"
+)
+
+// outbox is a peer's queue of messages waiting to be written. Pushing
+// never blocks: when the queue is at its limit, queued HAVEs are dropped
+// first to make room, since the peer can do without them. State messages
+// (choke, interest, keep-alive, extension handshake) are tiny and always
+// accepted.
+//
+// Queued Piece messages are indexed by block, so a Cancel from the peer
+// or a Choke from us drops the data before it costs upload bandwidth.
+//
+// Pushing after close is a no-op, so senders racing the connection
+// teardown can't panic.
+type outbox struct {
+	mut    sync.Mutex
+	queue  []*protocol.Message
+	haves  map[uint32]struct{}
+	pieces map[uint64]struct{}
+	limit  int
+	closed bool
+
+	// notify has room for one pending wake-up.
+	notify chan struct{}
+}
+
+func newOutbox(limit int) *outbox {
+	return &outbox{
+		haves:  make(map[uint32]struct{}),
+		pieces: make(map[uint64]struct{}),
+		limit:  max(1, limit),
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// push queues m and reports whether it was accepted. A HAVE for a piece
+// already queued is coalesced into the existing one.
+func (o *outbox) push(m *protocol.Message) bool {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+
+	if o.closed || m == nil {
+		return false
+	}
+
+	have, isHave := parseHave(m)
+	if isHave {
+		if _, dup := o.haves[have]; dup {
+			return true
+		}
+	}
+
+	// Choking tells the peer its outstanding requests are discarded, so
+	// data still queued for it is wasted.
+	if m.ID == protocol.Choke && !protocol.IsKeepAlive(m) {
+		o.dropPieces(func(uint64) bool { return true })
+	}
+
+	if len(o.queue) >= o.limit && !isStateMessage(m) {
+		if isHave || !o.dropHave() {
+			return false
+		}
+	}
+
+	o.queue = append(o.queue, m)
+	if isHave {
+		o.haves[have] = struct{}{}
+	}
+	if key, ok := pieceKey(m); ok {
+		o.pieces[key] = struct{}{}
+	}
+
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// pushHaves queues a HAVE for each piece not already queued and returns
+// how many were added. A batch is bounded by the piece count, so it is
+// taken whole even past the limit; later pushes still make room by
+// dropping the oldest HAVEs.
+func (o *outbox) pushHaves(pieces []uint32) int {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+
+	if o.closed {
+		return 0
+	}
+
+	var added int
+	for _, piece := range pieces {
+		if _, dup := o.haves[piece]; dup {
+			continue
+		}
+		o.queue = append(o.queue, protocol.MessageHave(piece))
+		o.haves[piece] = struct{}{}
+		added++
+	}
+
+	if added > 0 {
+		select {
+		case o.notify <- struct{}{}:
+		default:
+		}
+	}
+	return added
+}
+
+// pop removes the oldest message. ok is false when the queue is empty.
+func (o *outbox) pop() (m *protocol.Message, ok bool) {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+
+	if len(o.queue) == 0 {
+		return nil, false
+	}
+
+	m = o.queue[0]
+	o.queue[0] = nil
+	o.queue = o.queue[1:]
+	if have, isHave := parseHave(m); isHave {
+		delete(o.haves, have)
+	}
+	if key, ok := pieceKey(m); ok {
+		delete(o.pieces, key)
+	}
+	return m, true
+}
+
+// cancelPiece drops a queued Piece message for the block, returning how
+// many were removed.
+func (o *outbox) cancelPiece(index, begin uint32) int {
+	key := blockKey(index, begin)
+
+	o.mut.Lock()
+	defer o.mut.Unlock()
+
+	if _, ok := o.pieces[key]; !ok {
+		return 0
+	}
+	return o.dropPieces(func(k uint64) bool { return k == key })
+}
+
+// dropPieces removes queued Piece messages whose block key matches.
+// Called with o.mut held.
+func (o *outbox) dropPieces(match func(key uint64) bool) int {
+	if len(o.pieces) == 0 {
+		return 0
+	}
+
+	var dropped int
+	kept := o.queue[:0]
+	for _, m := range o.queue {
+		if key, ok := pieceKey(m); ok && match(key) {
+			delete(o.pieces, key)
+			dropped++
+			continue
+		}
+		kept = append(kept, m)
+	}
+	clear(o.queue[len(kept):])
+	o.queue = kept
+	return dropped
+}
+
+func (o *outbox) len() int {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+
+	return len(o.queue)
+}
+
+// ready is signalled after a push; drain with pop until it reports empty.
+func (o *outbox) ready() <-chan struct{} {
+	return o.notify
+}
+
+func (o *outbox) close() {
+	o.mut.Lock()
+	o.closed = true
+	o.queue = nil
+	o.haves = nil
+	o.pieces = nil
+	o.mut.Unlock()
+}
+
+// dropHave removes the oldest queued HAVE. Called with o.mut held.
+func (o *outbox) dropHave() bool {
+	for i, m := range o.queue {
+		have, ok := parseHave(m)
+		if !ok {
+			continue
+		}
+
+		o.queue = append(o.queue[:i], o.queue[i+1:]...)
+		delete(o.haves, have)
+		return true
+	}
+	return false
+}
+
+func parseHave(m *protocol.Message) (uint32, bool) {
+	return m.ParseHave()
+}
+
+func pieceKey(m *protocol.Message) (uint64, bool) {
+	index, begin, _, ok := m.ParsePiece()
+	if !ok {
+		return 0, false
+	}
+	return blockKey(index, begin), true
+}
+
+func blockKey(index, begin uint32) uint64 {
+	return uint64(index)<<32 | uint64(begin)
+}
+
+func isStateMessage(m *protocol.Message) bool {
+	if protocol.IsKeepAlive(m) {
+		return true
+	}
+
+	switch m.ID {
+	case protocol.Choke, protocol.Unchoke, protocol.Interested, protocol.NotInterested,
+		protocol.Extended:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,119 @@
+package peer
+
+import (
+	"testing"
+
+	"github.com/prxssh/rabbit/internal/protocol"
+)
+
+func drain(o *outbox) []*protocol.Message {
+	var out []*protocol.Message
+	for {
+		m, ok := o.pop()
+		if !ok {
+			return out
+		}
+		out = append(out, m)
+	}
+}
+
+func TestOutbox_FullDropsHavesFirst(t *testing.T) {
+	o := newOutbox(2)
+	o.push(protocol.MessageHave(1))
+	o.push(protocol.MessageRequest(0, 0, 16384))
+
+	if !o.push(protocol.MessageRequest(0, 16384, 16384)) {
+		t.Fatal("push() rejected a request while a HAVE could make room")
+	}
+
+	got := drain(o)
+	if len(got) != 2 || got[0].ID != protocol.Request || got[1].ID != protocol.Request {
+		t.Errorf("queue = %v, want the two requests", got)
+	}
+}
+
+func TestOutbox_FullRejectsData(t *testing.T) {
+	o := newOutbox(1)
+	o.push(protocol.MessageRequest(0, 0, 16384))
+
+	if o.push(protocol.MessageRequest(0, 16384, 16384)) {
+		t.Error("push(request) accepted past the limit")
+	}
+	if o.push(protocol.MessagePiece(0, 0, make([]byte, 16))) {
+		t.Error("push(piece) accepted past the limit")
+	}
+	if o.push(protocol.MessageHave(3)) {
+		t.Error("push(have) accepted past the limit")
+	}
+	if !o.push(protocol.MessageInterested()) {
+		t.Error("push(interested) rejected; state messages are always accepted")
+	}
+	if n := o.len(); n != 2 {
+		t.Errorf("len() = %d, want 2", n)
+	}
+}
+
+func TestOutbox_CoalescesHaves(t *testing.T) {
+	o := newOutbox(8)
+	o.push(protocol.MessageHave(5))
+	o.push(protocol.MessageHave(5))
+	if added := o.pushHaves([]uint32{5, 6, 7}); added != 2 {
+		t.Errorf("pushHaves() = %d, want 2", added)
+	}
+	if n := o.len(); n != 3 {
+		t.Errorf("len() = %d, want 3", n)
+	}
+
+	drain(o)
+	if !o.push(protocol.MessageHave(5)) || o.len() != 1 {
+		t.Error("HAVE not queued again after it was sent")
+	}
+}
+
+func TestOutbox_CancelAndChokeDropPieces(t *testing.T) {
+	o := newOutbox(8)
+	block := make([]byte, 16)
+	o.push(protocol.MessagePiece(1, 0, block))
+	o.push(protocol.MessagePiece(1, 16, block))
+	o.push(protocol.MessageHave(2))
+
+	if n := o.cancelPiece(1, 0); n != 1 {
+		t.Errorf("cancelPiece() = %d, want 1", n)
+	}
+	if n := o.cancelPiece(1, 0); n != 0 {
+		t.Errorf("cancelPiece() again = %d, want 0", n)
+	}
+
+	o.push(protocol.MessageChoke())
+	got := drain(o)
+	if len(got) != 2 || got[0].ID != protocol.Have || got[1].ID != protocol.Choke {
+		t.Errorf("queue after choke = %v, want HAVE then CHOKE", got)
+	}
+}
+
+func TestOutbox_KeepAliveKeepsPieces(t *testing.T) {
+	o := newOutbox(8)
+	o.push(protocol.MessagePiece(1, 0, make([]byte, 16)))
+
+	// A keep-alive has the zero id, like a choke, but must not drop data.
+	o.push(protocol.MessageKeepAlive())
+	got := drain(o)
+	if len(got) != 2 || got[0].ID != protocol.Piece || !protocol.IsKeepAlive(got[1]) {
+		t.Errorf("queue after keep-alive = %v, want PIECE then keep-alive", got)
+	}
+}
+
+func TestOutbox_PushAfterClose(t *testing.T) {
+	o := newOutbox(8)
+	o.close()
+
+	if o.push(protocol.MessageKeepAlive()) {
+		t.Error("push() accepted after close")
+	}
+	if o.pushHaves([]uint32{1}) != 0 {
+		t.Error("pushHaves() accepted after close")
+	}
+	if _, ok := o.pop(); ok {
+		t.Error("pop() returned a message after close")
+	}
+}
@@ -1,8 +1,10 @@
 package peer
 
 import (
+	"bufio"
 	"context"
 	"crypto/sha1"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -11,9 +13,13 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/prxssh/rabbit/internal/connectivity"
 	"github.com/prxssh/rabbit/internal/protocol"
 	"github.com/prxssh/rabbit/internal/scheduler"
+	"github.com/prxssh/rabbit/internal/version"
 	"github.com/prxssh/rabbit/pkg/bitfield"
+	"github.com/prxssh/rabbit/pkg/clock"
+	"github.com/prxssh/rabbit/pkg/supervise"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -24,23 +30,76 @@ const (
 	statePeerInterested = 1 << 3
 )
 
+// ErrDisconnected is returned by Run when the scheduler drops the peer,
+// e.g. for sending blocks we never asked for.
+var ErrDisconnected = errors.New("peer: disconnected by scheduler")
+
 type Peer struct {
 	cfg            *Config
 	logger         *slog.Logger
+	clock          clock.Clock
 	conn           net.Conn
 	addr           netip.AddrPort
 	stats          *peerStats
 	messageHistory *messageHistoryBuffer
-	messageOutbox  chan *protocol.Message
+	outbox         *outbox
 	state          uint32
 	lastActivityNs atomic.Int64
 	work           <-chan scheduler.Event
 	event          chan<- scheduler.Event
+	bandwidth      *Bandwidth
+	peerID         [sha1.Size]byte
+	source         Source
+	supervisor     *supervise.Supervisor
+	connectivity   *connectivity.Manager
+
+	// extensions is set when the remote speaks the extension protocol;
+	// uploadOnly is its BEP 21 flag from the last extension handshake.
+	extensions bool
+	uploadOnly atomic.Bool
+
+	// The remote's bitfield is kept by the scheduler alone; remotePieces
+	// asks it how many pieces the remote has.
+	pieceCount   int
+	remotePieces func() int
+
+	// reader buffers the connection for the read loop, which also owns
+	// pendingHaves: HAVEs held back while more messages are already
+	// buffered, so a burst reaches the scheduler as one event.
+	reader       *bufio.Reader
+	pendingHaves []uint32
+
+	// requests are ours the remote hasn't answered; writeFailed is set
+	// once a write fails, possibly part way through a message.
+	requests    sentRequests
+	writeFailed atomic.Bool
 }
 
+const (
+	readBufferSize = 32 * 1024
+	// maxHaveBatch bounds how many HAVEs are held before the scheduler
+	// hears of them.
+	maxHaveBatch = 512
+)
+
+// Source is how we learned about a peer.
+type Source string
+
+const (
+	SourceTracker Source = "tracker"
+	SourceCache   Source = "cache"
+	// SourceIncoming peers connected to us.
+	SourceIncoming Source = "incoming"
+)
+
 type peerStats struct {
-	Downloaded        atomic.Uint64
-	Uploaded          atomic.Uint64
+	// Downloaded and Uploaded count piece data only; everything else on
+	// the wire is counted in ProtocolDownloaded and ProtocolUploaded.
+	Downloaded         atomic.Uint64
+	Uploaded           atomic.Uint64
+	ProtocolDownloaded atomic.Uint64
+	ProtocolUploaded   atomic.Uint64
+
 	DownloadRate      atomic.Uint64
 	UploadRate        atomic.Uint64
 	MessagesReceived  atomic.Uint64
@@ -52,24 +111,45 @@ type peerStats struct {
 	PiecesReceived    atomic.Uint64
 	PiecesSent        atomic.Uint64
 	Errors            atomic.Uint64
+	MessagesDropped   atomic.Uint64
 	ConnectedAt       time.Time
 	DisconnectedAt    time.Time
+
+	// downWindow and upWindow hold per-second byte counts used by the
+	// choker; lastPieceReceivedNs and lastPieceSentNs break its ties.
+	downWindow          *rateWindow
+	upWindow            *rateWindow
+	lastPieceReceivedNs atomic.Int64
+	lastPieceSentNs     atomic.Int64
+}
+
+func newPeerStats() *peerStats {
+	return &peerStats{
+		downWindow: newRateWindow(chokeRateWindow),
+		upWindow:   newRateWindow(chokeRateWindow),
+	}
 }
 
 type PeerMetrics struct {
-	Addr           netip.AddrPort
-	Downloaded     uint64
-	Uploaded       uint64
-	RequestsSent   uint64
-	BlocksReceived uint64
-	BlocksFailed   uint64
-	LastActive     time.Time
-	ConnectedAt    time.Time
-	ConnectedForNs int64
-	DownloadRate   uint64
-	UploadRate     uint64
-	IsChoked       bool
-	IsInterested   bool
+	Addr               netip.AddrPort
+	Downloaded         uint64
+	Uploaded           uint64
+	ProtocolDownloaded uint64
+	ProtocolUploaded   uint64
+	RequestsSent       uint64
+	BlocksReceived     uint64
+	BlocksFailed       uint64
+	LastActive         time.Time
+	ConnectedAt        time.Time
+	ConnectedForNs     int64
+	DownloadRate       uint64
+	UploadRate         uint64
+	IsChoked           bool
+	IsInterested       bool
+	// MessagesDropped counts messages the outbox had no room for.
+	MessagesDropped uint64
+	// Progress is how much of the torrent the peer has, in percent.
+	Progress float64
 }
 
 type peerOpts struct {
@@ -80,40 +160,161 @@ type peerOpts struct {
 	workQueue  <-chan scheduler.Event
 	eventQueue chan<- scheduler.Event
 	config     *Config
+	bandwidth  *Bandwidth
+	dial       Dialer
+	clock      clock.Clock
+	health     *connHealth
+	source     Source
+	supervisor *supervise.Supervisor
+	// remotePieces counts the pieces the remote has announced.
+	remotePieces func() int
+	// connectivity knows the port we advertise in extension handshakes.
+	connectivity *connectivity.Manager
+}
+
+// exchangeHandshake runs the outbound handshake on conn, giving up once
+// HandshakeTimeout passes or ctx is done, so a pause or removal doesn't
+// wait on peers that accepted the connection and then went quiet.
+func exchangeHandshake(
+	ctx context.Context,
+	conn net.Conn,
+	opts *peerOpts,
+) (protocol.Handshake, error) {
+	if timeout := opts.config.HandshakeTimeout; timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Unix(1, 0)) })
+
+	remote, err := ourHandshake(opts).Exchange(conn, true)
+	if !stop() {
+		return protocol.Handshake{}, ctx.Err()
+	}
+	if err != nil {
+		return protocol.Handshake{}, err
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	return remote, nil
 }
 
 func newPeer(ctx context.Context, addr netip.AddrPort, opts *peerOpts) (*Peer, error) {
-	logger := opts.logger.With("source", "peer", "addr", addr)
+	dial := opts.dial
+	if dial == nil {
+		dial = tcpDialer(opts.config.Socket)
+	}
+
+	health := opts.health
+	if health == nil {
+		health = &connHealth{}
+	}
+	clk := clock.Or(opts.clock)
 
-	conn, err := net.DialTimeout("tcp", addr.String(), opts.config.DialTimeout)
+	dialStart := clk.Now()
+	conn, err := dial(ctx, addr, opts.config.DialTimeout)
+	// Dials cut short by a pause or removal say nothing about the peer.
+	if ctx.Err() == nil {
+		health.dialed(clk.Since(dialStart), err)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	handshake := protocol.NewHandshake(opts.infoHash, opts.clientID)
-	if _, err := handshake.Exchange(conn, true); err != nil {
+	remote, err := exchangeHandshake(ctx, conn, opts)
+	if ctx.Err() == nil {
+		health.handshook(err)
+	}
+	if err != nil {
 		_ = conn.Close()
 		return nil, err
 	}
 
+	return connectedPeer(conn, addr, remote.PeerID, remote.SupportsExtensions(), opts), nil
+}
+
+// ourHandshake is the handshake we open every connection with.
+func ourHandshake(opts *peerOpts) *protocol.Handshake {
+	h := protocol.NewHandshake(opts.infoHash, opts.clientID)
+	h.EnableExtensions()
+	return h
+}
+
+// acceptPeer answers an incoming handshake whose head the listener has
+// already read, then reads the remote's peer ID. conn is closed on error.
+func acceptPeer(
+	ctx context.Context,
+	conn net.Conn,
+	addr netip.AddrPort,
+	extensions bool,
+	opts *peerOpts,
+) (*Peer, error) {
+	if timeout := opts.config.HandshakeTimeout; timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Unix(1, 0)) })
+
+	err := protocol.WriteHandshake(conn, *ourHandshake(opts))
+	var peerID [sha1.Size]byte
+	if err == nil {
+		peerID, err = protocol.ReadPeerID(conn)
+	}
+	if !stop() {
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	return connectedPeer(conn, addr, peerID, extensions, opts), nil
+}
+
+// connectedPeer sets up a peer over a connection that has finished the
+// handshake.
+func connectedPeer(
+	conn net.Conn,
+	addr netip.AddrPort,
+	peerID [sha1.Size]byte,
+	extensions bool,
+	opts *peerOpts,
+) *Peer {
+	logger := opts.logger.With("source", "peer", "addr", addr)
+	clk := clock.Or(opts.clock)
+
 	p := &Peer{
 		cfg:            opts.config,
 		logger:         logger,
+		clock:          clk,
 		conn:           conn,
 		addr:           addr,
-		stats:          &peerStats{},
+		stats:          newPeerStats(),
 		work:           opts.workQueue,
 		event:          opts.eventQueue,
 		messageHistory: newMessageHistoryBuffer(500),
-		messageOutbox:  make(chan *protocol.Message, opts.config.PeerOutboxBacklog),
+		outbox:         newOutbox(int(opts.config.PeerOutboxBacklog)),
+		bandwidth:      opts.bandwidth,
+		peerID:         peerID,
+		source:         opts.source,
+		supervisor:     opts.supervisor,
+		connectivity:   opts.connectivity,
+		extensions:     extensions,
+		pieceCount:     opts.pieceCount,
+		remotePieces:   opts.remotePieces,
+		reader:         bufio.NewReaderSize(conn, readBufferSize),
+	}
+	if p.bandwidth == nil {
+		p.bandwidth = &Bandwidth{}
 	}
 
+	p.stats.ProtocolUploaded.Add(uint64(protocol.HandshakeLen))
+	p.stats.ProtocolDownloaded.Add(uint64(protocol.HandshakeLen))
+
 	p.setState(stateAmChoking|statePeerChoking, true)
-	p.lastActivityNs.Store(time.Now().UnixNano())
-	p.stats.ConnectedAt = time.Now()
+	p.lastActivityNs.Store(p.clock.Now().UnixNano())
+	p.stats.ConnectedAt = p.clock.Now()
 	p.event <- scheduler.NewHandshakeEvent(p.addr)
 
-	return p, nil
+	return p
 }
 
 // TODO: errgroup
@@ -122,12 +323,31 @@ func (p *Peer) Run(ctx context.Context) error {
 
 	g, gctx := errgroup.WithContext(ctx)
 
-	g.Go(func() error { return p.readMessagesLoop(gctx) })
-	g.Go(func() error { return p.writeMessagesLoop(gctx) })
-	g.Go(func() error { return p.requestWorkerLoop(gctx) })
-	g.Go(func() error { return p.downloadUploadRatesLoop(gctx) })
+	// Expiring the deadlines unblocks a read or write in progress, so the
+	// loops notice cancellation without waiting out their timeouts.
+	stop := context.AfterFunc(gctx, p.expireDeadlines)
+	defer stop()
+
+	g.Go(p.guarded(gctx, p.readMessagesLoop))
+	g.Go(p.guarded(gctx, p.writeMessagesLoop))
+	g.Go(p.guarded(gctx, p.requestWorkerLoop))
+	g.Go(p.guarded(gctx, p.downloadUploadRatesLoop))
 
-	return g.Wait()
+	err := g.Wait()
+	// Stopped, paused or removed rather than failed.
+	if ctx.Err() != nil {
+		p.farewell()
+	}
+	return err
+}
+
+// guarded wraps one of the peer's loops so a panic in it disconnects this
+// peer alone, like any other error, instead of crashing the client. A
+// connection can't be resumed mid-stream; the swarm redials it later.
+func (p *Peer) guarded(ctx context.Context, loop func(context.Context) error) func() error {
+	return func() error {
+		return p.supervisor.Recover("peer loop", func() error { return loop(ctx) })
+	}
 }
 
 func (p *Peer) GetMessageHistory(limit int) ([]*Event, error) {
@@ -141,17 +361,15 @@ func (p *Peer) PeerInterested() bool { return p.getState(statePeerInterested) }
 
 func (p *Peer) Idleness() time.Duration {
 	ns := time.Unix(0, p.lastActivityNs.Load())
-	return time.Since(ns)
+	return p.clock.Since(ns)
 }
 
 func (p *Peer) Unchoke() {
-	// TODO: make it non blocking
-	p.messageOutbox <- protocol.MessageUnchoke()
+	p.sendMessage(protocol.MessageUnchoke())
 }
 
 func (p *Peer) Choke() {
-	// TODO: make it non blocking
-	p.messageOutbox <- protocol.MessageChoke()
+	p.sendMessage(protocol.MessageChoke())
 }
 
 func (p *Peer) Stats() PeerMetrics {
@@ -160,25 +378,74 @@ func (p *Peer) Stats() PeerMetrics {
 	connectedAt := p.stats.ConnectedAt
 
 	return PeerMetrics{
+		Addr:               p.addr,
+		Downloaded:         p.stats.Downloaded.Load(),
+		Uploaded:           p.stats.Uploaded.Load(),
+		ProtocolDownloaded: p.stats.ProtocolDownloaded.Load(),
+		ProtocolUploaded:   p.stats.ProtocolUploaded.Load(),
+		RequestsSent:       p.stats.RequestsSent.Load(),
+		BlocksReceived:     p.stats.PiecesReceived.Load(),
+		BlocksFailed:       p.stats.RequestsTimeout.Load(),
+		LastActive:         lastActive,
+		ConnectedAt:        connectedAt,
+		DownloadRate:       p.stats.DownloadRate.Load(),
+		UploadRate:         p.stats.UploadRate.Load(),
+		IsChoked:           p.PeerChoking(),
+		IsInterested:       p.AmInterested(),
+		MessagesDropped:    p.stats.MessagesDropped.Load(),
+		Progress:           p.Progress(),
+	}
+}
+
+// Progress is the share of the torrent the remote has, in percent.
+func (p *Peer) Progress() float64 {
+	if p.pieceCount == 0 {
+		return 0
+	}
+	return float64(p.havePieceCount()) / float64(p.pieceCount) * 100.0
+}
+
+// IsSeed reports whether the remote has every piece.
+func (p *Peer) IsSeed() bool {
+	return p.pieceCount > 0 && p.havePieceCount() == p.pieceCount
+}
+
+func (p *Peer) havePieceCount() int {
+	if p.remotePieces == nil {
+		return 0
+	}
+	return p.remotePieces()
+}
+
+// Close drops the connection; Run returns shortly after.
+func (p *Peer) Close() error {
+	return p.conn.Close()
+}
+
+func (p *Peer) Snapshot() PeerSnapshot {
+	return PeerSnapshot{
 		Addr:           p.addr,
-		Downloaded:     p.stats.Downloaded.Load(),
-		Uploaded:       p.stats.Uploaded.Load(),
-		RequestsSent:   p.stats.RequestsSent.Load(),
-		BlocksReceived: p.stats.PiecesReceived.Load(),
-		BlocksFailed:   p.stats.RequestsTimeout.Load(),
-		LastActive:     lastActive,
-		ConnectedAt:    connectedAt,
+		Client:         ClientName(p.peerID),
+		Source:         p.source,
+		AmChoking:      p.AmChoking(),
+		AmInterested:   p.AmInterested(),
+		PeerChoking:    p.PeerChoking(),
+		PeerInterested: p.PeerInterested(),
 		DownloadRate:   p.stats.DownloadRate.Load(),
 		UploadRate:     p.stats.UploadRate.Load(),
-		IsChoked:       p.PeerChoking(),
-		IsInterested:   p.AmInterested(),
+		Downloaded:     p.stats.Downloaded.Load(),
+		Uploaded:       p.stats.Uploaded.Load(),
+		ConnectedAt:    p.stats.ConnectedAt,
+		Progress:       p.Progress(),
+		IsSeed:         p.IsSeed(),
+		UploadOnly:     p.uploadOnly.Load(),
 	}
 }
 
 func (p *Peer) cleanup() {
-	close(p.messageOutbox)
+	p.outbox.close()
 
-	p.stats.DisconnectedAt = time.Now()
+	p.stats.DisconnectedAt = p.clock.Now()
 	p.event <- scheduler.NewGoneEvent(p.addr)
 }
 
@@ -193,8 +460,11 @@ func (p *Peer) readMessagesLoop(ctx context.Context) error {
 		default:
 		}
 
-		message, err := p.readMessage()
+		message, err := p.readMessage(ctx)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 				continue
 			}
@@ -207,6 +477,9 @@ func (p *Peer) readMessagesLoop(ctx context.Context) error {
 			l.Warn("handle message failed", "error", err.Error())
 			return err
 		}
+		if len(p.pendingHaves) >= maxHaveBatch || !p.messageBuffered() {
+			p.flushHaves()
+		}
 	}
 }
 
@@ -214,7 +487,7 @@ func (p *Peer) writeMessagesLoop(ctx context.Context) error {
 	l := p.logger.With("component", "write messages loop")
 	l.Debug("started")
 
-	heartbeatTicker := time.NewTicker(p.cfg.PeerHeartbeatInterval)
+	heartbeatTicker := p.clock.NewTicker(p.cfg.PeerHeartbeatInterval)
 	defer heartbeatTicker.Stop()
 
 	for {
@@ -222,13 +495,11 @@ func (p *Peer) writeMessagesLoop(ctx context.Context) error {
 		case <-ctx.Done():
 			return nil
 
-		case message, ok := <-p.messageOutbox:
-			if !ok {
-				l.Warn("exiting; outbox is closed")
-				return nil
-			}
-
-			if err := p.writeMessage(message); err != nil {
+		case <-p.outbox.ready():
+			if err := p.flushOutbox(ctx); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
 				l.Warn(
 					"failed to write message, exiting loop",
 					"error", err.Error(),
@@ -236,16 +507,66 @@ func (p *Peer) writeMessagesLoop(ctx context.Context) error {
 				return err
 			}
 
-		case <-heartbeatTicker.C:
+		case <-heartbeatTicker.C():
 			lastActivityAt := time.Unix(0, p.lastActivityNs.Load())
 
-			if time.Since(lastActivityAt) >= p.cfg.PeerHeartbeatInterval {
-				p.sendMessage(ctx, nil)
+			if p.clock.Since(lastActivityAt) >= p.cfg.PeerHeartbeatInterval {
+				p.sendMessage(protocol.MessageKeepAlive())
 			}
 		}
 	}
 }
 
+const (
+	// maxWriteBatch and maxWriteBatchBytes cap how much of the outbox is
+	// written with one writev.
+	maxWriteBatch      = 64
+	maxWriteBatchBytes = 256 * 1024
+)
+
+// flushOutbox writes queued messages until the outbox is empty, batching
+// them so a burst of pipelined requests or HAVEs costs one syscall rather
+// than two per message. A message that must wait for upload bandwidth
+// first sends the batch gathered so far, so small messages aren't held up
+// behind it. Without an upload limit nothing waits and blocks are batched
+// like everything else.
+func (p *Peer) flushOutbox(ctx context.Context) error {
+	var (
+		batch []*protocol.Message
+		size  int
+	)
+	limited := p.bandwidth.uploadLimited()
+	for {
+		message, ok := p.outbox.pop()
+		if !ok {
+			break
+		}
+
+		data := message.DataLen()
+		if n := p.bandwidth.limited(data, message.WireLen()-data); limited && n > 0 {
+			if err := p.writeMessages(ctx, batch); err != nil {
+				return err
+			}
+			batch, size = batch[:0], 0
+
+			if err := p.bandwidth.waitUpload(ctx, n); err != nil {
+				return err
+			}
+		}
+
+		batch = append(batch, message)
+		size += message.WireLen()
+		if len(batch) >= maxWriteBatch || size >= maxWriteBatchBytes {
+			if err := p.writeMessages(ctx, batch); err != nil {
+				return err
+			}
+			batch, size = batch[:0], 0
+		}
+	}
+
+	return p.writeMessages(ctx, batch)
+}
+
 // Rate calculation (UploadRate / DownloadRate)
 //
 // We maintain two monotonic byte counters per peer: Uploaded and Downloaded.
@@ -266,12 +587,12 @@ func (p *Peer) downloadUploadRatesLoop(ctx context.Context) error {
 	l := p.logger.With("component", "download upload rate loop")
 	l.Debug("started")
 
-	t := time.NewTicker(time.Second)
+	t := p.clock.NewTicker(time.Second)
 	defer t.Stop()
 
 	lastUp := p.stats.Uploaded.Load()
 	lastDown := p.stats.Downloaded.Load()
-	lastTick := time.Now()
+	lastTick := p.clock.Now()
 
 	var (
 		upEMA   uint64
@@ -284,7 +605,7 @@ func (p *Peer) downloadUploadRatesLoop(ctx context.Context) error {
 		case <-ctx.Done():
 			return nil
 
-		case now := <-t.C:
+		case now := <-t.C():
 			elapsed := now.Sub(lastTick).Seconds()
 			curUp := p.stats.Uploaded.Load()
 			curDown := p.stats.Downloaded.Load()
@@ -292,6 +613,9 @@ func (p *Peer) downloadUploadRatesLoop(ctx context.Context) error {
 			instUpRate := uint64(float64(curUp-lastUp) / elapsed)
 			instDownRate := uint64(float64(curDown-lastDown) / elapsed)
 
+			p.stats.upWindow.add(instUpRate)
+			p.stats.downWindow.add(instDownRate)
+
 			if !inited {
 				upEMA = instUpRate
 				downEMA = instDownRate
@@ -341,46 +665,165 @@ func (p *Peer) requestWorkerLoop(ctx context.Context) error {
 				message = protocol.MessageRequest(w.Data.PieceIdx, w.Data.Begin, w.Data.Length)
 			case scheduler.PeerHaveEvent:
 				message = protocol.MessageHave(w.Data.Piece)
+			case scheduler.PeerHavesEvent:
+				p.outbox.pushHaves(w.Data.Pieces)
+				continue
+			case scheduler.PeerUploadOnlyEvent:
+				if !p.extensions {
+					continue
+				}
+				var err error
+				message, err = protocol.MessageExtendedHandshake(protocol.ExtendedHandshake{
+					Client:     version.Name + " " + version.String(),
+					UploadOnly: w.Data.UploadOnly,
+					Port:       p.connectivity.Port(),
+				})
+				if err != nil {
+					l.Warn("encode extension handshake failed", "error", err)
+					continue
+				}
 			case scheduler.PeerPieceEvent:
+				// Choked since the block was requested; the peer has
+				// dropped the request.
+				if p.AmChoking() {
+					continue
+				}
 				message = protocol.MessagePiece(w.Data.PieceIdx, w.Data.Begin, w.Data.Block)
+			case scheduler.PeerGoneEvent:
+				l.Warn("disconnecting on scheduler request")
+				return ErrDisconnected
 			default:
 				l.Warn("unhandled work message", "message", work)
+				continue
 			}
 
-			p.sendMessage(ctx, message)
+			p.sendMessage(message)
 		}
 	}
 }
 
-func (p *Peer) readMessage() (*protocol.Message, error) {
+func (p *Peer) readMessage(ctx context.Context) (*protocol.Message, error) {
 	_ = p.conn.SetReadDeadline(time.Now().Add(p.cfg.ReadTimeout))
 	defer p.conn.SetReadDeadline(time.Time{})
+	// Checked after setting the deadline, so a cancellation landing
+	// between the two can't be overwritten.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	message, err := protocol.ReadMessage(p.conn)
+	if err := p.awaitDownload(ctx); err != nil {
+		return nil, err
+	}
+
+	message, err := protocol.ReadMessage(p.reader)
 	if err != nil {
 		p.stats.Errors.Add(1)
 		return nil, err
 	}
 
 	p.stats.MessagesReceived.Add(1)
-	p.lastActivityNs.Store(time.Now().UnixNano())
+	p.stats.ProtocolDownloaded.Add(uint64(message.WireLen() - message.DataLen()))
+	p.lastActivityNs.Store(p.clock.Now().UnixNano())
 
 	return message, nil
 }
 
-func (p *Peer) writeMessage(message *protocol.Message) error {
+// awaitDownload peeks at the next frame's header and waits for download
+// bandwidth before its body is read, so a throttled connection leaves the
+// data in the socket and TCP flow control slows the sender.
+func (p *Peer) awaitDownload(ctx context.Context) error {
+	prefix, err := p.reader.Peek(4)
+	if err != nil {
+		return err
+	}
+
+	length := int(binary.BigEndian.Uint32(prefix))
+	if length > protocol.MaxMessageLength {
+		return nil // ReadMessage rejects it
+	}
+
+	data := 0
+	if length > 9 {
+		hdr, err := p.reader.Peek(5)
+		if err != nil {
+			return err
+		}
+		if protocol.MessageID(hdr[4]) == protocol.Piece {
+			data = length - 9
+		}
+	}
+
+	n := p.bandwidth.limited(data, 4+length-data)
+	if n == 0 {
+		return nil
+	}
+	if err := p.bandwidth.waitDownload(ctx, n); err != nil {
+		return err
+	}
+
+	// The wait may have outlasted the read deadline. As in readMessage,
+	// ctx is checked after re-arming it, so a cancellation that expired
+	// the deadline during the wait isn't pushed back.
+	_ = p.conn.SetReadDeadline(time.Now().Add(p.cfg.ReadTimeout))
+	return ctx.Err()
+}
+
+// messageBuffered reports whether a whole message is already buffered, so
+// reading it won't block.
+func (p *Peer) messageBuffered() bool {
+	n := p.reader.Buffered()
+	if n < 4 {
+		return false
+	}
+	prefix, err := p.reader.Peek(4)
+	if err != nil {
+		return false
+	}
+	return uint64(n) >= 4+uint64(binary.BigEndian.Uint32(prefix))
+}
+
+// flushHaves hands the held HAVEs to the scheduler.
+func (p *Peer) flushHaves() {
+	switch len(p.pendingHaves) {
+	case 0:
+		return
+	case 1:
+		p.event <- scheduler.NewHaveEvent(p.addr, p.pendingHaves[0])
+	default:
+		p.event <- scheduler.NewHavesEvent(p.addr, p.pendingHaves)
+	}
+	p.pendingHaves = nil
+}
+
+func (p *Peer) writeMessages(ctx context.Context, messages []*protocol.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
 	_ = p.conn.SetWriteDeadline(time.Now().Add(p.cfg.WriteTimeout))
 	defer p.conn.SetWriteDeadline(time.Time{})
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	if err := protocol.WriteMessage(p.conn, message); err != nil {
+	if _, err := protocol.WriteMessages(p.conn, messages); err != nil {
 		p.stats.Errors.Add(1)
+		p.writeFailed.Store(true)
 		return err
 	}
 
-	p.handleSentMessage(message)
+	for _, message := range messages {
+		p.stats.ProtocolUploaded.Add(uint64(message.WireLen() - message.DataLen()))
+		p.handleSentMessage(message)
+	}
 	return nil
 }
 
+// expireDeadlines fails any read or write blocked on the connection.
+func (p *Peer) expireDeadlines() {
+	_ = p.conn.SetDeadline(time.Unix(1, 0))
+}
+
 func (p *Peer) getState(state uint32) bool { return atomic.LoadUint32(&p.state)&state != 0 }
 
 func (p *Peer) setState(state uint32, on bool) {
@@ -401,13 +844,12 @@ func (p *Peer) setState(state uint32, on bool) {
 
 func (p *Peer) handleMessage(message *protocol.Message) error {
 	event := &Event{
-		Timestamp: time.Now(),
+		Timestamp: p.clock.Now(),
 		Direction: EventReceived,
 	}
 
 	if protocol.IsKeepAlive(message) {
 		event.MessageType = "Keep Alive"
-		event.PayloadSize = 0
 		p.messageHistory.Add(event)
 		return nil
 	}
@@ -415,9 +857,16 @@ func (p *Peer) handleMessage(message *protocol.Message) error {
 	event.MessageType = message.ID.String()
 	event.PayloadSize = len(message.Payload)
 
+	// Held HAVEs go first so the scheduler sees messages in order.
+	if message.ID != protocol.Have {
+		p.flushHaves()
+	}
+
 	switch message.ID {
 	case protocol.Choke:
 		p.setState(statePeerChoking, true)
+		// Requests are dropped on choke.
+		p.requests.clear()
 		p.event <- scheduler.NewChokedEvent(p.addr)
 
 	case protocol.Unchoke:
@@ -431,36 +880,37 @@ func (p *Peer) handleMessage(message *protocol.Message) error {
 		p.setState(statePeerInterested, false)
 
 	case protocol.Bitfield:
-		bf := bitfield.FromBytes(message.Payload)
-		p.event <- scheduler.NewBitfieldEvent(p.addr, bf)
+		p.event <- scheduler.NewBitfieldEvent(p.addr, bitfield.Bitfield(message.Payload))
 
 	case protocol.Have:
 		piece, ok := message.ParseHave()
 		if !ok {
-			return errors.New("malformed have message")
+			return fmt.Errorf("%w: malformed have message", ErrProtocol)
 		}
 
 		event.PieceIndex = &piece
-		p.event <- scheduler.NewHaveEvent(p.addr, piece)
+		p.pendingHaves = append(p.pendingHaves, piece)
 
 	case protocol.Piece:
 		piece, begin, block, ok := message.ParsePiece()
 		if !ok {
-			return errors.New("malformed piece message")
+			return fmt.Errorf("%w: malformed piece message", ErrProtocol)
 		}
 
 		event.PieceIndex = &piece
 		event.BlockOffset = &begin
 
+		p.requests.remove(piece, begin)
 		p.event <- scheduler.NewPieceEvent(p.addr, piece, begin, block)
 
 		p.stats.PiecesReceived.Add(1)
 		p.stats.Downloaded.Add(uint64(len(block)))
+		p.stats.lastPieceReceivedNs.Store(event.Timestamp.UnixNano())
 
 	case protocol.Request:
-		piece, begin, _, ok := message.ParseRequest()
+		piece, begin, length, ok := message.ParseRequest()
 		if !ok {
-			return errors.New("malformed request message")
+			return fmt.Errorf("%w: malformed request message", ErrProtocol)
 		}
 
 		event.PieceIndex = &piece
@@ -468,11 +918,47 @@ func (p *Peer) handleMessage(message *protocol.Message) error {
 
 		p.stats.RequestsReceived.Add(1)
 
+		// Requests made while we choke the peer are discarded, as the
+		// choke told it they would be.
+		if !p.AmChoking() {
+			p.event <- scheduler.NewRequestEvent(p.addr, piece, begin, length)
+		}
+
 	case protocol.Cancel:
+		piece, begin, _, ok := message.ParseCancel()
+		if !ok {
+			return fmt.Errorf("%w: malformed cancel message", ErrProtocol)
+		}
+
+		event.PieceIndex = &piece
+		event.BlockOffset = &begin
+
+		if n := p.outbox.cancelPiece(piece, begin); n > 0 {
+			p.logger.Debug("dropped cancelled block from outbox",
+				"piece", piece,
+				"begin", begin,
+			)
+		}
 		p.stats.RequestsCancelled.Add(1)
 
+	case protocol.Extended:
+		id, payload, ok := message.ParseExtended()
+		if !ok {
+			return fmt.Errorf("%w: malformed extended message", ErrProtocol)
+		}
+		// We advertise no extension messages, so only the handshake
+		// means anything to us.
+		if id != protocol.ExtHandshakeID {
+			break
+		}
+		h, err := protocol.ParseExtendedHandshake(payload)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrProtocol, err)
+		}
+		p.uploadOnly.Store(h.UploadOnly)
+
 	default:
-		return fmt.Errorf("invalid message id '%d'", message.ID)
+		return fmt.Errorf("%w: invalid message id '%d'", ErrProtocol, message.ID)
 	}
 
 	p.messageHistory.Add(event)
@@ -482,14 +968,14 @@ func (p *Peer) handleMessage(message *protocol.Message) error {
 
 func (p *Peer) handleSentMessage(message *protocol.Message) {
 	event := &Event{
-		Timestamp: time.Now(),
+		Timestamp: p.clock.Now(),
 		Direction: EventSent,
 	}
 
 	p.stats.MessagesSent.Add(1)
 	p.lastActivityNs.Store(event.Timestamp.UnixNano())
 
-	if message == nil {
+	if protocol.IsKeepAlive(message) {
 		event.MessageType = "Keep Alive"
 		p.messageHistory.Add(event)
 		return
@@ -519,9 +1005,10 @@ func (p *Peer) handleSentMessage(message *protocol.Message) {
 		// nothing to do
 
 	case protocol.Request:
-		if piece, begin, _, ok := message.ParseRequest(); ok {
+		if piece, begin, length, ok := message.ParseRequest(); ok {
 			event.PieceIndex = &piece
 			event.BlockOffset = &begin
+			p.requests.add(piece, begin, length)
 		}
 		p.stats.RequestsSent.Add(1)
 
@@ -532,9 +1019,13 @@ func (p *Peer) handleSentMessage(message *protocol.Message) {
 
 			p.stats.PiecesSent.Add(1)
 			p.stats.Uploaded.Add(uint64(len(block)))
+			p.stats.lastPieceSentNs.Store(event.Timestamp.UnixNano())
 		}
 
 	case protocol.Cancel:
+		if piece, begin, _, ok := message.ParseCancel(); ok {
+			p.requests.remove(piece, begin)
+		}
 		p.stats.RequestsCancelled.Add(1)
 
 	default:
@@ -544,11 +1035,25 @@ func (p *Peer) handleSentMessage(message *protocol.Message) {
 	p.messageHistory.Add(event)
 }
 
-func (p *Peer) sendMessage(ctx context.Context, message *protocol.Message) {
-	select {
-	case p.messageOutbox <- message:
-
-	case <-ctx.Done():
+// sendMessage queues message for the write loop without blocking. Messages
+// the outbox has no room for are dropped and counted. A dropped request is
+// handed back to the scheduler to assign again; a dropped block is left
+// for the remote to request again once its request times out.
+func (p *Peer) sendMessage(message *protocol.Message) {
+	if p.outbox.push(message) {
 		return
 	}
+	p.stats.MessagesDropped.Add(1)
+
+	switch message.ID {
+	case protocol.Request:
+		piece, begin, _, _ := message.ParseRequest()
+		p.event <- scheduler.NewUnsentEvent(p.addr, piece, begin)
+		p.logger.Debug("outbox full; returned request", "piece", piece, "begin", begin)
+	case protocol.Piece:
+		piece, begin, _, _ := message.ParsePiece()
+		p.logger.Debug("outbox full; dropped block", "piece", piece, "begin", begin)
+	default:
+		p.logger.Debug("outbox full; dropping message", "message", message.ID.String())
+	}
 }
@@ -0,0 +1,55 @@
+package peer
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prxssh/rabbit/internal/protocol"
+	"github.com/prxssh/rabbit/pkg/clock"
+)
+
+// batchConn counts the batches writeMessages sends: it arms a write
+// deadline once per call.
+type batchConn struct {
+	net.Conn
+	batches int
+}
+
+func (c *batchConn) Write(p []byte) (int, error) { return len(p), nil }
+
+func (c *batchConn) SetWriteDeadline(t time.Time) error {
+	if !t.IsZero() {
+		c.batches++
+	}
+	return nil
+}
+
+func TestPeer_FlushOutboxBatchesPiecesWithoutLimit(t *testing.T) {
+	conn := &batchConn{}
+	p := &Peer{
+		cfg:            WithDefaultConfig(),
+		clock:          clock.Real,
+		conn:           conn,
+		stats:          newPeerStats(),
+		messageHistory: newMessageHistoryBuffer(16),
+		outbox:         newOutbox(16),
+		bandwidth:      &Bandwidth{IncludeOverhead: true},
+	}
+
+	block := make([]byte, 16384)
+	for i := range 4 {
+		p.sendMessage(protocol.MessagePiece(0, uint32(i)*16384, block))
+	}
+	if err := p.flushOutbox(context.Background()); err != nil {
+		t.Fatalf("flushOutbox() error = %v", err)
+	}
+
+	if conn.batches != 1 {
+		t.Errorf("pieces written in %d batches, want 1", conn.batches)
+	}
+	if got := p.stats.PiecesSent.Load(); got != 4 {
+		t.Errorf("PiecesSent = %d, want 4", got)
+	}
+}
@@ -0,0 +1,57 @@
+package peer
+
+import "sync"
+
+const (
+	// chokeRateWindow is the window, in one second samples, over which the
+	// choker compares peers.
+	chokeRateWindow = 20
+
+	// chokeShortRateWindow breaks ties between peers with an equal
+	// chokeRateWindow average.
+	chokeShortRateWindow = 10
+)
+
+// rateWindow keeps the bytes transferred in each of the last len(samples)
+// seconds so rates can be averaged over a fixed window instead of reacting to
+// a single noisy tick.
+type rateWindow struct {
+	mut     sync.Mutex
+	samples []uint64
+	pos     int
+	filled  int
+}
+
+func newRateWindow(seconds int) *rateWindow {
+	return &rateWindow{samples: make([]uint64, seconds)}
+}
+
+// add records the bytes transferred during the latest one second tick.
+func (w *rateWindow) add(n uint64) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	w.samples[w.pos] = n
+	w.pos = (w.pos + 1) % len(w.samples)
+	w.filled = min(w.filled+1, len(w.samples))
+}
+
+// average returns the mean bytes/sec over the most recent seconds samples.
+// Peers connected for less than the window are averaged over what exists.
+func (w *rateWindow) average(seconds int) uint64 {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	n := min(seconds, w.filled)
+	if n == 0 {
+		return 0
+	}
+
+	var sum uint64
+	for i := 1; i <= n; i++ {
+		idx := (w.pos - i + len(w.samples)) % len(w.samples)
+		sum += w.samples[idx]
+	}
+
+	return sum / uint64(n)
+}
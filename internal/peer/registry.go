@@ -0,0 +1,46 @@
+package peer
+
+import (
+	"crypto/sha1"
+	"sync"
+)
+
+// Registry maps info hashes to running swarms, so the one listen port
+// serves every torrent: the listener reads an incoming handshake's info
+// hash, looks the swarm up here and hands it the connection.
+type Registry struct {
+	mut    sync.RWMutex
+	byHash map[[sha1.Size]byte]*Swarm
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		byHash: make(map[[sha1.Size]byte]*Swarm),
+	}
+}
+
+// register makes s reachable under its info hash.
+func (r *Registry) register(s *Swarm) {
+	r.mut.Lock()
+	r.byHash[s.infoHash] = s
+	r.mut.Unlock()
+}
+
+// unregister removes s, unless another swarm for the same torrent has
+// taken its place.
+func (r *Registry) unregister(s *Swarm) {
+	r.mut.Lock()
+	if r.byHash[s.infoHash] == s {
+		delete(r.byHash, s.infoHash)
+	}
+	r.mut.Unlock()
+}
+
+// Lookup returns the swarm serving infoHash.
+func (r *Registry) Lookup(infoHash [sha1.Size]byte) (*Swarm, bool) {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+
+	s, ok := r.byHash[infoHash]
+	return s, ok
+}
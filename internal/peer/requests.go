@@ -0,0 +1,86 @@
+package peer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prxssh/rabbit/internal/protocol"
+)
+
+// farewellTimeout bounds how long a peer shutting down spends telling the
+// remote to stop sending.
+const farewellTimeout = 2 * time.Second
+
+// sentRequests is the block requests written to the remote and not yet
+// answered, cancelled, or dropped by a choke.
+type sentRequests struct {
+	mut      sync.Mutex
+	requests map[uint64]uint32
+}
+
+func requestKey(piece, begin uint32) uint64 {
+	return uint64(piece)<<32 | uint64(begin)
+}
+
+func (r *sentRequests) add(piece, begin, length uint32) {
+	r.mut.Lock()
+	if r.requests == nil {
+		r.requests = make(map[uint64]uint32)
+	}
+	r.requests[requestKey(piece, begin)] = length
+	r.mut.Unlock()
+}
+
+func (r *sentRequests) remove(piece, begin uint32) {
+	r.mut.Lock()
+	delete(r.requests, requestKey(piece, begin))
+	r.mut.Unlock()
+}
+
+func (r *sentRequests) clear() {
+	r.mut.Lock()
+	clear(r.requests)
+	r.mut.Unlock()
+}
+
+// cancels returns a CANCEL for every outstanding request.
+func (r *sentRequests) cancels() []*protocol.Message {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	out := make([]*protocol.Message, 0, len(r.requests))
+	for key, length := range r.requests {
+		out = append(out, protocol.MessageCancel(uint32(key>>32), uint32(key), length))
+	}
+	return out
+}
+
+// farewell is sent when we leave of our own accord: CHOKE, so the remote
+// stops asking us for blocks, and a CANCEL for each of our requests, so a
+// well-behaved peer doesn't spend upload on data we'd discard. Messages go
+// out in batches straight to the connection; the write loop has stopped.
+func (p *Peer) farewell() {
+	// A write cut off part way leaves the stream unframed.
+	if p.writeFailed.Load() {
+		return
+	}
+
+	var messages []*protocol.Message
+	if !p.AmChoking() {
+		messages = append(messages, protocol.MessageChoke())
+	}
+	messages = append(messages, p.requests.cancels()...)
+	if len(messages) == 0 {
+		return
+	}
+
+	_ = p.conn.SetWriteDeadline(time.Now().Add(farewellTimeout))
+	for start := 0; start < len(messages); start += maxWriteBatch {
+		batch := messages[start:min(start+maxWriteBatch, len(messages))]
+		if _, err := protocol.WriteMessages(p.conn, batch); err != nil {
+			p.logger.Debug("farewell failed", "error", err)
+			return
+		}
+	}
+	p.logger.Debug("sent farewell", "messages", len(messages))
+}
@@ -0,0 +1,95 @@
+package peer
+
+import (
+	"slices"
+	"sync"
+)
+
+// SlotPool caps the regular unchoke slots of every torrent of the client
+// combined. Each swarm reports how many slots it could use on every
+// rechoke and is granted a share: torrents wanting less than an even
+// split keep what they ask for and the rest is divided among the others,
+// so one busy torrent can't take the whole upload while others starve.
+type SlotPool struct {
+	mut    sync.Mutex
+	limit  int
+	demand map[*Swarm]int
+}
+
+// NewSlotPool returns a pool of limit slots. A limit <= 0 is unlimited.
+func NewSlotPool(limit int) *SlotPool {
+	return &SlotPool{limit: limit, demand: make(map[*Swarm]int)}
+}
+
+func (p *SlotPool) SetLimit(limit int) {
+	p.mut.Lock()
+	p.limit = limit
+	p.mut.Unlock()
+}
+
+func (p *SlotPool) Limit() int {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	return p.limit
+}
+
+// grant records that s could use want slots and returns how many it may
+// unchoke until its next rechoke. A ceiling > 0 lowers the pool's limit for
+// this grant, e.g. while the upload limiter is saturated.
+func (p *SlotPool) grant(s *Swarm, want, ceiling int) int {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	p.demand[s] = want
+	limit := p.limit
+	if ceiling > 0 && (limit <= 0 || ceiling < limit) {
+		limit = ceiling
+	}
+	if limit <= 0 {
+		return want
+	}
+
+	return p.shares(limit)[s]
+}
+
+// release forgets s, returning its slots to the other swarms.
+func (p *SlotPool) release(s *Swarm) {
+	if p == nil {
+		return
+	}
+
+	p.mut.Lock()
+	delete(p.demand, s)
+	p.mut.Unlock()
+}
+
+// shares splits limit over the recorded demands by water-filling. Called
+// with p.mut held.
+func (p *SlotPool) shares(limit int) map[*Swarm]int {
+	type claim struct {
+		swarm *Swarm
+		want  int
+	}
+
+	claims := make([]claim, 0, len(p.demand))
+	for s, want := range p.demand {
+		claims = append(claims, claim{s, want})
+	}
+	// Map order is random, so ties (and the last slots when there are
+	// more torrents than slots) don't always go to the same torrents.
+	slices.SortStableFunc(claims, func(a, b claim) int { return a.want - b.want })
+
+	out := make(map[*Swarm]int, len(claims))
+	remaining := limit
+	for i, c := range claims {
+		share := remaining / (len(claims) - i)
+		if share == 0 && remaining > 0 {
+			share = 1
+		}
+		got := min(c.want, share)
+		out[c.swarm] = got
+		remaining -= got
+	}
+	return out
+}
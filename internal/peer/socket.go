@@ -0,0 +1,82 @@
+package peer
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SocketConfig tunes the TCP sockets of peer connections. It is applied
+// before connecting on dial, so buffer sizes count toward the window
+// scale negotiated in the handshake, and right after accepting. The zero
+// value keeps the OS defaults.
+type SocketConfig struct {
+	// Nagle turns Nagle's algorithm back on (TCP_NODELAY off). It is off
+	// by default so small messages like requests and HAVEs leave without
+	// waiting to be coalesced; the write loop batches them already.
+	Nagle bool
+
+	// SendBuffer and ReceiveBuffer set the kernel socket buffers in
+	// bytes. 0 keeps the OS default, which may autotune. Large buffers
+	// pay off on fast links with high round-trip times.
+	SendBuffer    int
+	ReceiveBuffer int
+
+	// DSCP marks outgoing packets with a differentiated services code
+	// point (0-63) so routers can classify peer traffic, CS1 (8) being
+	// the usual "lower effort" class. 0 leaves packets unmarked.
+	DSCP uint8
+}
+
+// tos is the IP TOS / traffic class byte carrying DSCP.
+func (c SocketConfig) tos() int { return int(c.DSCP&0x3f) << 2 }
+
+func (c SocketConfig) control(network, _ string, rc syscall.RawConn) error {
+	var err error
+	if cerr := rc.Control(func(fd uintptr) {
+		err = setSocketOptions(fd, strings.HasSuffix(network, "6"), c)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// apply tunes an accepted connection.
+func (c SocketConfig) apply(conn net.Conn) error {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if err := tc.SetNoDelay(!c.Nagle); err != nil {
+		return err
+	}
+
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	local, _ := netip.ParseAddrPort(tc.LocalAddr().String())
+	network := "tcp4"
+	if !local.Addr().Unmap().Is4() {
+		network = "tcp6"
+	}
+	return c.control(network, "", rc)
+}
+
+// tcpDialer returns a Dialer opening TCP connections tuned by c.
+func tcpDialer(c SocketConfig) Dialer {
+	return func(ctx context.Context, addr netip.AddrPort, timeout time.Duration) (net.Conn, error) {
+		d := net.Dialer{Timeout: timeout, Control: c.control}
+		conn, err := d.DialContext(ctx, "tcp", addr.String())
+		if err != nil {
+			return nil, err
+		}
+		if tc, ok := conn.(*net.TCPConn); ok {
+			_ = tc.SetNoDelay(!c.Nagle)
+		}
+		return conn, nil
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly || windows)
+
+package peer
+
+// setSocketOptions is a no-op where the socket options aren't wired up;
+// connections keep the OS defaults.
+func setSocketOptions(uintptr, bool, SocketConfig) error { return nil }
@@ -0,0 +1,26 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package peer
+
+import "syscall"
+
+func setSocketOptions(fd uintptr, ipv6 bool, c SocketConfig) error {
+	s := int(fd)
+	if c.SendBuffer > 0 {
+		if err := syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_SNDBUF, c.SendBuffer); err != nil {
+			return err
+		}
+	}
+	if c.ReceiveBuffer > 0 {
+		if err := syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_RCVBUF, c.ReceiveBuffer); err != nil {
+			return err
+		}
+	}
+	if c.DSCP == 0 {
+		return nil
+	}
+	if ipv6 {
+		return syscall.SetsockoptInt(s, syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, c.tos())
+	}
+	return syscall.SetsockoptInt(s, syscall.IPPROTO_IP, syscall.IP_TOS, c.tos())
+}
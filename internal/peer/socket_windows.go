@@ -0,0 +1,25 @@
+//go:build windows
+
+package peer
+
+import "syscall"
+
+// setSocketOptions sets buffers and, for IPv4 only, the TOS byte. Windows
+// mostly ignores IP_TOS unless QoS policy allows it.
+func setSocketOptions(fd uintptr, ipv6 bool, c SocketConfig) error {
+	s := syscall.Handle(fd)
+	if c.SendBuffer > 0 {
+		if err := syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_SNDBUF, c.SendBuffer); err != nil {
+			return err
+		}
+	}
+	if c.ReceiveBuffer > 0 {
+		if err := syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_RCVBUF, c.ReceiveBuffer); err != nil {
+			return err
+		}
+	}
+	if c.DSCP == 0 || ipv6 {
+		return nil
+	}
+	return syscall.SetsockoptInt(s, syscall.IPPROTO_IP, syscall.IP_TOS, c.tos())
+}
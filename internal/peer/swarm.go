@@ -4,42 +4,95 @@ import (
 	"context"
 	"crypto/sha1"
 	"log/slog"
+	"math"
 	"math/rand"
+	"net"
 	"net/netip"
 	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/prxssh/rabbit/internal/connectivity"
 	"github.com/prxssh/rabbit/internal/scheduler"
+	"github.com/prxssh/rabbit/pkg/clock"
+	"github.com/prxssh/rabbit/pkg/supervise"
 	"golang.org/x/sync/errgroup"
 )
 
 type Config struct {
-	MaxPeers                  uint8
-	UploadSlots               uint8
+	MaxPeers    uint8
+	UploadSlots uint8
+
+	// AutoUploadSlots sizes the regular unchoke slots from upload bandwidth
+	// instead of using the fixed UploadSlots.
+	AutoUploadSlots bool
+
+	// UploadBandwidth is the available upload bandwidth in bytes/sec used
+	// by AutoUploadSlots. 0 uses the measured peak upload rate instead.
+	UploadBandwidth uint64
+
 	PeerOutboxBacklog         uint8
 	ReadTimeout               time.Duration
 	WriteTimeout              time.Duration
 	DialTimeout               time.Duration
+	HandshakeTimeout          time.Duration
+	Socket                    SocketConfig
 	RechokeInterval           time.Duration
 	OptimisticUnchokeInterval time.Duration
 	PeerHeartbeatInterval     time.Duration
 	PeerInactivityDuration    time.Duration
+
+	// MinUploadSlotRate is the upload rate, in bytes/sec, each regular
+	// unchoke should get while the client-wide upload limit is saturated.
+	// The unchoke slots are then cut to limit / MinUploadSlotRate, so a
+	// few peers get useful rates instead of every unchoked peer trickling.
+	// 0 leaves the slots alone.
+	MinUploadSlotRate uint64
+
+	// DropSeedsWhenSeeding disconnects peers that have the whole torrent
+	// once we do too, and stops redialing them, to free slots for
+	// leechers.
+	DropSeedsWhenSeeding bool
+
+	// DialBackoff is how long an address that failed to connect waits
+	// before it is dialed again; each further failure doubles the wait, up
+	// to MaxDialBackoff. After MaxDialAttempts consecutive failures the
+	// address isn't dialed again this session; 0 retries forever.
+	DialBackoff     time.Duration
+	MaxDialBackoff  time.Duration
+	MaxDialAttempts int
+
+	// DialBurst is how many new dials may start per DialBurstInterval,
+	// however many peers a tracker response or cache refill hands over
+	// at once; beyond it they're spread over the interval. 0 is
+	// unlimited.
+	DialBurst         int
+	DialBurstInterval time.Duration
 }
 
 func WithDefaultConfig() *Config {
 	return &Config{
 		UploadSlots:               4,
+		AutoUploadSlots:           false,
+		UploadBandwidth:           0,
+		MinUploadSlotRate:         16 * 1024,
 		MaxPeers:                  50,
 		ReadTimeout:               45 * time.Second,
 		WriteTimeout:              30 * time.Second,
 		DialTimeout:               45 * time.Second,
+		HandshakeTimeout:          20 * time.Second,
 		RechokeInterval:           10 * time.Second,
 		OptimisticUnchokeInterval: 30 * time.Second,
 		PeerHeartbeatInterval:     45 * time.Second,
 		PeerInactivityDuration:    2 * time.Minute,
 		PeerOutboxBacklog:         50,
+		DropSeedsWhenSeeding:      true,
+		DialBackoff:               30 * time.Second,
+		MaxDialBackoff:            30 * time.Minute,
+		MaxDialAttempts:           5,
+		DialBurst:                 20,
+		DialBurstInterval:         2 * time.Second,
 	}
 }
 
@@ -56,8 +109,50 @@ type Swarm struct {
 	scheduler                  *scheduler.Scheduler
 	optimisticUnchokedPeerAddr netip.AddrPort
 	peerConnectCh              chan netip.AddrPort
+	peerCache                  *Cache
+	bandwidth                  *Bandwidth
+	// cachedAddrs are peer cache entries queued for dialing, so the dialer
+	// can tell them from tracker peers. Guarded by peerMut.
+	cachedAddrs map[netip.AddrPort]struct{}
+	// knownSeeds are peers dropped for being seeds while we seed, and
+	// when, so tracker responses don't keep reconnecting them. Entries
+	// age out after knownSeedTTL. Guarded by peerMut.
+	knownSeeds map[netip.AddrPort]time.Time
+	dial       Dialer
+	clock      clock.Clock
+	slots      *SlotPool
+	health     connHealth
+	backoff    *dialBackoff
+	budget     *dialBudget
+	supervisor *supervise.Supervisor
+	// connectivity knows the port we advertise in extension handshakes.
+	connectivity *connectivity.Manager
+
+	// registry routes incoming connections here while the swarm runs;
+	// incomingCh queues them for the accept loop.
+	registry   *Registry
+	incomingCh chan inboundConn
+}
+
+// inboundConn is an incoming connection whose handshake has been read up
+// to the info hash.
+type inboundConn struct {
+	conn net.Conn
+	addr netip.AddrPort
+	// extensions is set when the handshake advertised the extension
+	// protocol.
+	extensions bool
 }
 
+// incomingQueueSize bounds incoming connections waiting for the accept
+// loop; more are refused.
+const incomingQueueSize = 16
+
+// knownSeedTTL is how long a seed dropped while we seed stays undialed.
+// It may have lost data or be a new client behind the same address by
+// then.
+const knownSeedTTL = 30 * time.Minute
+
 type SwarmStats struct {
 	TotalPeers       atomic.Uint32
 	ConnectingPeers  atomic.Uint32
@@ -68,8 +163,12 @@ type SwarmStats struct {
 	DownloadingFrom  atomic.Uint32
 	TotalDownloaded  atomic.Uint64
 	TotalUploaded    atomic.Uint64
+	ProtocolDownload atomic.Uint64
+	ProtocolUpload   atomic.Uint64
 	DownloadRate     atomic.Uint64
 	UploadRate       atomic.Uint64
+	PeakUploadRate   atomic.Uint64
+	UploadSlots      atomic.Uint32
 }
 
 type SwarmOpts struct {
@@ -79,8 +178,43 @@ type SwarmOpts struct {
 	ClientID  [sha1.Size]byte
 	Scheduler *scheduler.Scheduler
 	IsSeeder  bool
+
+	// PeerCache, when set, seeds the swarm with peers remembered from
+	// earlier sessions and records peers as they disconnect.
+	PeerCache *Cache
+
+	// Bandwidth holds the client-wide rate limiters. Optional.
+	Bandwidth *Bandwidth
+
+	// Dial opens connections to peers. Defaults to TCP, tuned by
+	// Config.Socket.
+	Dial Dialer
+
+	// UploadSlots is the client-wide unchoke slot pool shared with other
+	// torrents. Optional; without it only Config.UploadSlots applies.
+	UploadSlots *SlotPool
+
+	// Clock drives the choke, stats and maintenance loops and the peers'
+	// timers. Defaults to the wall clock.
+	Clock clock.Clock
+
+	// Registry, when set, is told of the swarm while it runs so the
+	// client's listener can hand it incoming connections.
+	Registry *Registry
+
+	// Supervisor is told of peers whose loops panic. Optional; without it
+	// a panic crashes the client.
+	Supervisor *supervise.Supervisor
+
+	// Connectivity is the client-wide record of our listen port, sent to
+	// peers in the extension handshake. Optional.
+	Connectivity *connectivity.Manager
 }
 
+// Dialer opens a connection to a peer. It lets tests and simulations
+// swap the network for in-process connections.
+type Dialer func(ctx context.Context, addr netip.AddrPort, timeout time.Duration) (net.Conn, error)
+
 type SwarmMetrics struct {
 	TotalPeers       uint32 `json:"totalPeers"`
 	ConnectingPeers  uint32 `json:"connectingPeers"`
@@ -91,33 +225,68 @@ type SwarmMetrics struct {
 	DownloadingFrom  uint32 `json:"downloadingFrom"`
 	TotalDownloaded  uint64 `json:"totalDownloaded"`
 	TotalUploaded    uint64 `json:"totalUploaded"`
+	ProtocolDownload uint64 `json:"protocolDownload"`
+	ProtocolUpload   uint64 `json:"protocolUpload"`
 	DownloadRate     uint64 `json:"downloadRate"`
 	UploadRate       uint64 `json:"uploadRate"`
+	UploadSlots      uint32 `json:"uploadSlots"`
+	// BackedOffPeers counts addresses not being dialed for now, or at
+	// all, after failing to connect.
+	BackedOffPeers int `json:"backedOffPeers"`
+	// DistributedCopies is how many full copies of the torrent the
+	// connected peers hold between them.
+	DistributedCopies float64 `json:"distributedCopies"`
+
+	Health ConnectionHealth `json:"health"`
 }
 
 func NewSwarm(opts *SwarmOpts) (*Swarm, error) {
-	return &Swarm{
+	dial := opts.Dial
+	if dial == nil {
+		dial = tcpDialer(opts.Config.Socket)
+	}
+
+	s := &Swarm{
+		dial:          dial,
+		clock:         clock.Or(opts.Clock),
+		slots:         opts.UploadSlots,
 		cfg:           opts.Config,
 		infoHash:      opts.InfoHash,
 		clientID:      opts.ClientID,
 		stats:         &SwarmStats{},
 		scheduler:     opts.Scheduler,
 		peers:         make(map[netip.AddrPort]*Peer),
+		cachedAddrs:   make(map[netip.AddrPort]struct{}),
+		knownSeeds:    make(map[netip.AddrPort]time.Time),
 		peerConnectCh: make(chan netip.AddrPort, opts.Config.MaxPeers),
 		logger:        opts.Logger.With("source", "peer_swarm"),
 		isSeeder:      opts.IsSeeder,
-	}, nil
+		peerCache:     opts.PeerCache,
+		supervisor:    opts.Supervisor,
+		connectivity:  opts.Connectivity,
+		bandwidth:     opts.Bandwidth,
+		backoff:       newDialBackoff(),
+		budget:        newDialBudget(opts.Config.DialBurst, opts.Config.DialBurstInterval),
+		registry:      opts.Registry,
+		incomingCh:    make(chan inboundConn, incomingQueueSize),
+	}
+	s.scheduler.OnPeerSeed(s.seedDetected)
+	return s, nil
 }
 
 func (s *Swarm) Run(ctx context.Context) error {
+	defer s.slots.release(s)
+	s.admitCachedPeers()
+
 	g, gctx := errgroup.WithContext(ctx)
 
-	g.Go(func() error { return s.maintenanceLoop(gctx) })
-	g.Go(func() error { return s.statsLoop(ctx) })
-	g.Go(func() error { return s.chokeLoop(ctx) })
+	g.Go(func() error { return s.supervisor.Run(gctx, "maintenance loop", s.maintenanceLoop) })
+	g.Go(func() error { return s.supervisor.Run(ctx, "stats loop", s.statsLoop) })
+	g.Go(func() error { return s.supervisor.Run(ctx, "choke loop", s.chokeLoop) })
+	g.Go(func() error { return s.supervisor.Run(gctx, "accept loop", s.acceptLoop) })
 
 	for dialWorker := 0; dialWorker < 10; dialWorker++ {
-		g.Go(func() error { return s.peerDialerLoop(ctx) })
+		g.Go(func() error { return s.supervisor.Run(ctx, "dial loop", s.peerDialerLoop) })
 	}
 
 	return g.Wait()
@@ -139,8 +308,15 @@ func (s *Swarm) Stats() SwarmMetrics {
 		DownloadingFrom:  ps.DownloadingFrom.Load(),
 		TotalDownloaded:  ps.TotalDownloaded.Load(),
 		TotalUploaded:    ps.TotalUploaded.Load(),
+		ProtocolDownload: ps.ProtocolDownload.Load(),
+		ProtocolUpload:   ps.ProtocolUpload.Load(),
 		DownloadRate:     ps.DownloadRate.Load(),
 		UploadRate:       ps.UploadRate.Load(),
+		UploadSlots:      ps.UploadSlots.Load(),
+		BackedOffPeers:   s.backoff.waiting(s.clock.Now(), s.cfg.MaxDialAttempts),
+
+		DistributedCopies: s.scheduler.DistributedCopies(),
+		Health:            s.health.snapshot(),
 	}
 }
 
@@ -156,6 +332,62 @@ func (s *Swarm) PeerMetrics() []PeerMetrics {
 	return metrics
 }
 
+// PeerSnapshot is a point-in-time view of one connected peer.
+type PeerSnapshot struct {
+	Addr   netip.AddrPort `json:"addr"`
+	Client string         `json:"client"`
+	Source Source         `json:"source"`
+	// Encrypted is always false until connection encryption exists.
+	Encrypted      bool      `json:"encrypted"`
+	AmChoking      bool      `json:"amChoking"`
+	AmInterested   bool      `json:"amInterested"`
+	PeerChoking    bool      `json:"peerChoking"`
+	PeerInterested bool      `json:"peerInterested"`
+	DownloadRate   uint64    `json:"downloadRate"`
+	UploadRate     uint64    `json:"uploadRate"`
+	Downloaded     uint64    `json:"downloaded"`
+	Uploaded       uint64    `json:"uploaded"`
+	ConnectedAt    time.Time `json:"connectedAt"`
+	// Progress is the share of the torrent the peer has, in percent.
+	Progress float64 `json:"progress"`
+	IsSeed   bool    `json:"isSeed"`
+	// UploadOnly is set for peers that want no more pieces, such as
+	// partial seeds.
+	UploadOnly bool `json:"uploadOnly"`
+}
+
+// PeerSnapshots describes every connected peer. The swarm lock is held
+// only to copy the peer list; each peer's state is read from atomics.
+func (s *Swarm) PeerSnapshots() []PeerSnapshot {
+	s.peerMut.RLock()
+	peers := make([]*Peer, 0, len(s.peers))
+	for _, p := range s.peers {
+		peers = append(peers, p)
+	}
+	s.peerMut.RUnlock()
+
+	out := make([]PeerSnapshot, 0, len(peers))
+	for _, p := range peers {
+		out = append(out, p.Snapshot())
+	}
+	return out
+}
+
+// QueueDepths reports how many addresses wait to be dialed, how many
+// messages sit in connected peers' outboxes and how many in the fullest
+// one.
+func (s *Swarm) QueueDepths() (connect, outbox, fullest int) {
+	s.peerMut.RLock()
+	defer s.peerMut.RUnlock()
+
+	for _, p := range s.peers {
+		n := p.outbox.len()
+		outbox += n
+		fullest = max(fullest, n)
+	}
+	return len(s.peerConnectCh), outbox, fullest
+}
+
 func (s *Swarm) AdmitPeers(addrs []netip.AddrPort) {
 	for _, addr := range addrs {
 		select {
@@ -166,58 +398,334 @@ func (s *Swarm) AdmitPeers(addrs []netip.AddrPort) {
 	}
 }
 
+// Reconnect forgets failed dials and dials the cached peers again, for
+// when the network comes back and addresses that looked dead may not be.
+func (s *Swarm) Reconnect() {
+	s.backoff.reset()
+	s.admitCachedPeers()
+}
+
+func (s *Swarm) admitCachedPeers() {
+	if s.peerCache == nil {
+		return
+	}
+
+	cached := s.peerCache.Peers(s.infoHash)
+	if len(cached) == 0 {
+		return
+	}
+
+	addrs := make([]netip.AddrPort, 0, len(cached))
+	for _, cp := range cached {
+		addrs = append(addrs, cp.Addr)
+	}
+
+	s.peerMut.Lock()
+	for _, addr := range addrs {
+		s.cachedAddrs[addr] = struct{}{}
+	}
+	s.peerMut.Unlock()
+
+	s.logger.Debug("admitting cached peers", "count", len(addrs))
+	s.AdmitPeers(addrs)
+}
+
 func (s *Swarm) addPeer(ctx context.Context, addr netip.AddrPort) (*Peer, error) {
-	s.peerMut.RLock()
+	s.peerMut.Lock()
 	_, dup := s.peers[addr]
+	seed := s.knownSeedLocked(addr)
 	totalPeers := len(s.peers)
-	s.peerMut.RUnlock()
+	source := SourceTracker
+	if _, ok := s.cachedAddrs[addr]; ok {
+		source = SourceCache
+		delete(s.cachedAddrs, addr)
+	}
+	s.peerMut.Unlock()
 
 	if dup {
 		return nil, nil
 	}
+	if seed && s.dropSeedsEnabled() {
+		return nil, nil
+	}
 
 	if totalPeers >= int(s.cfg.MaxPeers) {
 		return nil, nil
 	}
 
+	if !s.backoff.allow(addr, s.clock.Now(), s.cfg.MaxDialAttempts) {
+		return nil, nil
+	}
+	if err := s.waitDialBudget(ctx); err != nil {
+		return nil, err
+	}
+
 	s.stats.ConnectingPeers.Add(1)
 
-	peer, err := newPeer(ctx, addr, &peerOpts{
+	peer, err := newPeer(ctx, addr, s.peerOpts(addr, source))
+	s.stats.ConnectingPeers.Add(^uint32(0))
+
+	if err != nil {
+		s.stats.FailedConnection.Add(1)
+		if ctx.Err() == nil {
+			s.backoff.failed(addr, s.clock.Now(), s.cfg.DialBackoff, s.cfg.MaxDialBackoff)
+		}
+		return nil, err
+	}
+	s.backoff.succeeded(addr)
+
+	if !s.admit(peer) {
+		return nil, nil
+	}
+	return peer, nil
+}
+
+// waitDialBudget holds a dial back until the dial budget allows it.
+func (s *Swarm) waitDialBudget(ctx context.Context) error {
+	wait := s.budget.reserve(s.clock.Now())
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := s.clock.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
+
+func (s *Swarm) peerOpts(addr netip.AddrPort, source Source) *peerOpts {
+	return &peerOpts{
 		infoHash:   s.infoHash,
 		clientID:   s.clientID,
 		config:     s.cfg,
 		logger:     s.logger,
 		eventQueue: s.scheduler.GetPeerEventQueue(),
 		workQueue:  s.scheduler.GetPeerWorkQueue(addr),
-	})
-	s.stats.ConnectingPeers.Add(^uint32(0))
+		bandwidth:  s.bandwidth,
+		dial:       s.dial,
+		clock:      s.clock,
+		health:     &s.health,
+		source:     source,
+		supervisor: s.supervisor,
+		pieceCount: s.scheduler.PieceCount(),
+		remotePieces: func() int {
+			return s.scheduler.PeerPieceCount(addr)
+		},
+		connectivity: s.connectivity,
+	}
+}
 
+// deliver queues an incoming connection for the swarm, reporting false if
+// the queue is full.
+func (s *Swarm) deliver(conn net.Conn, addr netip.AddrPort, extensions bool) bool {
+	select {
+	case s.incomingCh <- inboundConn{conn: conn, addr: addr, extensions: extensions}:
+		return true
+	default:
+		return false
+	}
+}
+
+// acceptLoop registers the swarm for incoming connections, finishes the
+// handshake of each and runs the peer alongside the dialed ones.
+func (s *Swarm) acceptLoop(ctx context.Context) error {
+	if s.registry == nil {
+		return nil
+	}
+	s.registry.register(s)
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.registry.unregister(s)
+			// Refuse whatever was queued before the registry let go.
+			for {
+				select {
+				case in := <-s.incomingCh:
+					_ = in.conn.Close()
+				default:
+					return nil
+				}
+			}
+
+		case in := <-s.incomingCh:
+			go func() {
+				peer, err := s.acceptPeer(ctx, in)
+				if err != nil {
+					s.logger.Debug("incoming peer failed", "addr", in.addr, "error", err)
+					return
+				}
+				if peer == nil {
+					return
+				}
+
+				err = peer.Run(ctx)
+				s.removePeer(peer.addr, disconnectReason(err))
+			}()
+		}
+	}
+}
+
+// acceptPeer admits an incoming connection under the same limits as the
+// dialer. It returns nil, closing conn, when the peer isn't wanted.
+func (s *Swarm) acceptPeer(ctx context.Context, in inboundConn) (*Peer, error) {
+	s.peerMut.Lock()
+	_, dup := s.peers[in.addr]
+	seed := s.knownSeedLocked(in.addr)
+	totalPeers := len(s.peers)
+	s.peerMut.Unlock()
+
+	if dup || totalPeers >= int(s.cfg.MaxPeers) || (seed && s.dropSeedsEnabled()) {
+		_ = in.conn.Close()
+		return nil, nil
+	}
+
+	s.stats.ConnectingPeers.Add(1)
+	peer, err := acceptPeer(ctx, in.conn, in.addr, in.extensions, s.peerOpts(in.addr, SourceIncoming))
+	s.stats.ConnectingPeers.Add(^uint32(0))
 	if err != nil {
-		s.stats.FailedConnection.Add(1)
 		return nil, err
 	}
 
+	if !s.admit(peer) {
+		return nil, nil
+	}
+	return peer, nil
+}
+
+// admit adds a peer that finished its handshake. The checks made before
+// connecting are repeated under the lock, since other connections may
+// have been admitted meanwhile, and a peer ID already connected is
+// refused too: an incoming peer is keyed by its ephemeral port, so the
+// address alone doesn't catch a peer we also dialed. A refused peer is
+// closed.
+func (s *Swarm) admit(peer *Peer) bool {
 	s.peerMut.Lock()
-	s.peers[peer.addr] = peer
+	_, dupAddr := s.peers[peer.addr]
+	ok := !dupAddr && len(s.peers) < int(s.cfg.MaxPeers) && !s.connectedLocked(peer.peerID)
+	if ok {
+		s.peers[peer.addr] = peer
+	}
 	s.peerMut.Unlock()
 
+	if !ok {
+		_ = peer.Close()
+		// The scheduler state of a duplicate address is the other
+		// connection's.
+		if !dupAddr {
+			peer.cleanup()
+		}
+		return false
+	}
+
 	s.stats.TotalPeers.Add(1)
+	return true
+}
 
-	return peer, nil
+// connectedLocked reports whether a peer with id is connected. Called
+// with s.peerMut held.
+func (s *Swarm) connectedLocked(id [sha1.Size]byte) bool {
+	for _, p := range s.peers {
+		if p.peerID == id {
+			return true
+		}
+	}
+	return false
 }
 
-func (s *Swarm) removePeer(addr netip.AddrPort) {
+// removePeer disconnects a peer. Only the first call for a connection
+// counts it, with its reason, in the swarm's health metrics.
+func (s *Swarm) removePeer(addr netip.AddrPort, reason DisconnectReason) {
 	s.peerMut.Lock()
-	if _, exists := s.peers[addr]; !exists {
+	peer, exists := s.peers[addr]
+	if !exists {
 		s.peerMut.Unlock()
 		return
 	}
 	delete(s.peers, addr)
 	s.peerMut.Unlock()
 
+	_ = peer.Close()
+	s.health.disconnected(reason, s.clock.Since(peer.stats.ConnectedAt))
+
+	if s.peerCache != nil {
+		s.peerCache.Record(
+			s.infoHash,
+			addr,
+			peer.stats.Downloaded.Load(),
+			peer.stats.Uploaded.Load(),
+		)
+	}
+
 	s.stats.TotalPeers.Add(^uint32(0))
 }
 
+func (s *Swarm) dropSeedsEnabled() bool {
+	return s.cfg.DropSeedsWhenSeeding && s.scheduler.Complete()
+}
+
+// seedDetected is called by the scheduler the moment a peer completes.
+func (s *Swarm) seedDetected(addr netip.AddrPort) {
+	if !s.dropSeedsEnabled() {
+		return
+	}
+
+	s.logger.Debug("disconnecting seed while seeding", "addr", addr)
+	s.removeSeed(addr)
+}
+
+// dropSeeds disconnects peers that have the whole torrent once we do too:
+// neither side can give the other anything, and the slot is better spent
+// on a leecher. It catches seeds that completed before we did.
+func (s *Swarm) dropSeeds() int {
+	now := s.clock.Now()
+	s.peerMut.Lock()
+	for addr, at := range s.knownSeeds {
+		if now.Sub(at) >= knownSeedTTL {
+			delete(s.knownSeeds, addr)
+		}
+	}
+	s.peerMut.Unlock()
+
+	if !s.dropSeedsEnabled() {
+		return 0
+	}
+
+	var seeds []netip.AddrPort
+	s.peerMut.RLock()
+	for addr, peer := range s.peers {
+		if peer.IsSeed() {
+			seeds = append(seeds, addr)
+		}
+	}
+	s.peerMut.RUnlock()
+
+	for _, addr := range seeds {
+		s.removeSeed(addr)
+	}
+	return len(seeds)
+}
+
+func (s *Swarm) removeSeed(addr netip.AddrPort) {
+	s.peerMut.Lock()
+	s.knownSeeds[addr] = s.clock.Now()
+	s.peerMut.Unlock()
+
+	s.removePeer(addr, DisconnectSeed)
+}
+
+// knownSeedLocked reports whether addr was dropped as a seed within
+// knownSeedTTL. Called with peerMut held.
+func (s *Swarm) knownSeedLocked(addr netip.AddrPort) bool {
+	at, ok := s.knownSeeds[addr]
+	return ok && s.clock.Since(at) < knownSeedTTL
+}
+
 func (s *Swarm) GetPeer(addr netip.AddrPort) (*Peer, bool) {
 	s.peerMut.RLock()
 	defer s.peerMut.RUnlock()
@@ -230,7 +738,7 @@ func (s *Swarm) maintenanceLoop(ctx context.Context) error {
 	l := s.logger.With("component", "maintenance loop")
 	l.Debug("started")
 
-	ticker := time.NewTicker(5 * time.Second)
+	ticker := s.clock.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
 	for {
@@ -238,7 +746,7 @@ func (s *Swarm) maintenanceLoop(ctx context.Context) error {
 		case <-ctx.Done():
 			return nil
 
-		case <-ticker.C:
+		case <-ticker.C():
 			maxIdle := s.cfg.PeerInactivityDuration
 			var inactivePeerAddrs []netip.AddrPort
 
@@ -251,13 +759,17 @@ func (s *Swarm) maintenanceLoop(ctx context.Context) error {
 			s.peerMut.RUnlock()
 
 			for _, addr := range inactivePeerAddrs {
-				s.removePeer(addr)
+				s.removePeer(addr, DisconnectIdle)
 			}
 
 			n := len(inactivePeerAddrs)
 			if n > 0 {
 				l.Info("removed inactive peers", "count", n)
 			}
+
+			if n := s.dropSeeds(); n > 0 {
+				l.Info("disconnected seeds while seeding", "count", n)
+			}
 		}
 	}
 }
@@ -285,13 +797,13 @@ func (s *Swarm) peerDialerLoop(ctx context.Context) error {
 				)
 				continue
 			}
-			if peer == nil { // duplicate
+			if peer == nil { // duplicate, full or backed off
 				continue
 			}
 
 			go func(p *Peer) {
-				defer s.removePeer(p.addr)
-				p.Run(ctx)
+				err := p.Run(ctx)
+				s.removePeer(p.addr, disconnectReason(err))
 			}(peer)
 		}
 	}
@@ -301,7 +813,7 @@ func (s *Swarm) statsLoop(ctx context.Context) error {
 	l := s.logger.With("component", "stats loop")
 	l.Debug("started")
 
-	ticker := time.NewTicker(time.Second)
+	ticker := s.clock.NewTicker(time.Second)
 	defer ticker.Stop()
 
 	for {
@@ -310,14 +822,16 @@ func (s *Swarm) statsLoop(ctx context.Context) error {
 			l.Warn("context done, exiting", "error", ctx.Err())
 			return nil
 
-		case <-ticker.C:
-			var totUp, totDown, upRate, downRate uint64
+		case <-ticker.C():
+			var totUp, totDown, protoUp, protoDown, upRate, downRate uint64
 			var unchoked, interested, uploadingTo, downloadingFrom uint32
 
 			s.peerMut.RLock()
 			for _, peer := range s.peers {
 				totUp += peer.stats.Uploaded.Load()
 				totDown += peer.stats.Downloaded.Load()
+				protoUp += peer.stats.ProtocolUploaded.Load()
+				protoDown += peer.stats.ProtocolDownloaded.Load()
 				ru := peer.stats.UploadRate.Load()
 				rd := peer.stats.DownloadRate.Load()
 				upRate += ru
@@ -340,7 +854,13 @@ func (s *Swarm) statsLoop(ctx context.Context) error {
 
 			s.stats.TotalUploaded.Store(totUp)
 			s.stats.TotalDownloaded.Store(totDown)
+			s.stats.ProtocolUpload.Store(protoUp)
+			s.stats.ProtocolDownload.Store(protoDown)
 			s.stats.UploadRate.Store(upRate)
+			// Decaying peak so the measured capacity follows a
+			// changing uplink instead of its all-time best.
+			peak := s.stats.PeakUploadRate.Load()
+			s.stats.PeakUploadRate.Store(max(upRate, peak-peak/100))
 			s.stats.DownloadRate.Store(downRate)
 			s.stats.UnchokedPeers.Store(unchoked)
 			s.stats.InterestedPeers.Store(interested)
@@ -354,10 +874,10 @@ func (s *Swarm) chokeLoop(ctx context.Context) error {
 	l := s.logger.With("source", "leecher choke loop")
 	l.Debug("started")
 
-	normalChokeTicker := time.NewTicker(s.cfg.RechokeInterval)
+	normalChokeTicker := s.clock.NewTicker(s.cfg.RechokeInterval)
 	defer normalChokeTicker.Stop()
 
-	optimisticChokeTicker := time.NewTicker(s.cfg.OptimisticUnchokeInterval)
+	optimisticChokeTicker := s.clock.NewTicker(s.cfg.OptimisticUnchokeInterval)
 	defer optimisticChokeTicker.Stop()
 
 	for {
@@ -365,10 +885,10 @@ func (s *Swarm) chokeLoop(ctx context.Context) error {
 		case <-ctx.Done():
 			return nil
 
-		case <-normalChokeTicker.C:
+		case <-normalChokeTicker.C():
 			s.recalculateRegularUnchokes(ctx)
 
-		case <-optimisticChokeTicker.C:
+		case <-optimisticChokeTicker.C():
 			s.recalculateOptimisticUnchoke(ctx)
 		}
 	}
@@ -386,15 +906,20 @@ func (s *Swarm) recalculateRegularUnchokes(ctx context.Context) {
 	s.peerMut.RUnlock()
 
 	sort.Slice(candidates, func(i, j int) bool {
-		if s.isSeeder {
-			return candidates[i].stats.UploadRate.Load() > candidates[j].stats.UploadRate.Load()
-		}
-
-		return candidates[i].stats.DownloadRate.Load() > candidates[j].stats.DownloadRate.Load()
+		return s.unchokeLess(candidates[i], candidates[j])
 	})
 
+	slots := s.uploadSlots()
+	limit := s.saturatedSlots()
+	if s.slots != nil {
+		slots = s.slots.grant(s, min(len(candidates), slots), limit)
+	} else if limit > 0 {
+		slots = min(slots, limit)
+	}
+	s.stats.UploadSlots.Store(uint32(slots))
+
 	newUnchokes := make(map[netip.AddrPort]struct{})
-	for i := 0; i < len(candidates) && i < int(s.cfg.UploadSlots); i++ {
+	for i := 0; i < len(candidates) && i < slots; i++ {
 		newUnchokes[candidates[i].addr] = struct{}{}
 	}
 
@@ -416,6 +941,73 @@ func (s *Swarm) recalculateRegularUnchokes(ctx context.Context) {
 	s.peerMut.Unlock()
 }
 
+// unchokeLess orders regular unchoke candidates best first.
+//
+// Peers are compared on their rate averaged over the last 20 seconds (what
+// they give us while leeching, what they take while seeding), then over the
+// last 10 seconds, and finally on who moved data most recently. Averaging
+// keeps a single jittery second from swapping slots every rechoke.
+func (s *Swarm) unchokeLess(a, b *Peer) bool {
+	windowA, windowB := a.stats.downWindow, b.stats.downWindow
+	lastA, lastB := a.stats.lastPieceReceivedNs.Load(), b.stats.lastPieceReceivedNs.Load()
+	if s.isSeeder {
+		windowA, windowB = a.stats.upWindow, b.stats.upWindow
+		lastA, lastB = a.stats.lastPieceSentNs.Load(), b.stats.lastPieceSentNs.Load()
+	}
+
+	if ra, rb := windowA.average(chokeRateWindow), windowB.average(chokeRateWindow); ra != rb {
+		return ra > rb
+	}
+
+	ra, rb := windowA.average(chokeShortRateWindow), windowB.average(chokeShortRateWindow)
+	if ra != rb {
+		return ra > rb
+	}
+
+	return lastA > lastB
+}
+
+const (
+	minAutoUploadSlots = 2
+	maxAutoUploadSlots = 50
+)
+
+// uploadSlots returns how many peers the regular unchoke may pick.
+//
+// In auto mode the count follows the sqrt rule used by most clients,
+// sqrt(0.6 * KiB/s), so a fast uplink is split among more peers while a slow
+// one still gives each unchoked peer a useful share. It is recomputed on every
+// rechoke from the configured bandwidth or, if unset, the measured peak.
+func (s *Swarm) uploadSlots() int {
+	if !s.cfg.AutoUploadSlots {
+		return int(s.cfg.UploadSlots)
+	}
+
+	bandwidth := s.cfg.UploadBandwidth
+	if bandwidth == 0 {
+		bandwidth = s.stats.PeakUploadRate.Load()
+	}
+
+	slots := int(math.Round(math.Sqrt(0.6 * float64(bandwidth) / 1024)))
+	return min(maxAutoUploadSlots, max(minAutoUploadSlots, slots))
+}
+
+// saturatedSlots returns how many regular unchokes the upload limit can
+// serve at MinUploadSlotRate each, or 0 unless the limit has held uploads
+// back since the last rechoke. With a slot pool the count is shared by
+// every torrent, as the limiter is.
+func (s *Swarm) saturatedSlots() int {
+	if s.bandwidth == nil || s.cfg.MinUploadSlotRate == 0 {
+		return 0
+	}
+
+	up := s.bandwidth.Upload
+	if !up.Saturated(s.cfg.RechokeInterval) {
+		return 0
+	}
+	return max(1, int(up.Rate()/s.cfg.MinUploadSlotRate))
+}
+
 func (s *Swarm) recalculateOptimisticUnchoke(ctx context.Context) {
 	var candidates []*Peer
 
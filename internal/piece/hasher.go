@@ -0,0 +1,165 @@
+package piece
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"hash"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Hasher computes the digest pieces are verified against. SHA1 covers v1
+// torrents; SHA256 is the per-piece-layer hash of BitTorrent v2. Anything
+// else, e.g. a hardware-accelerated implementation, can be swapped in
+// without touching the code that verifies pieces.
+type Hasher interface {
+	// Name identifies the algorithm in logs.
+	Name() string
+	// Size is the digest length in bytes.
+	Size() int
+	// Sum returns the digest of data.
+	Sum(data []byte) []byte
+	// New starts an incremental digest, for hashing a piece block by
+	// block as it arrives.
+	New() hash.Hash
+}
+
+// SHA1 and SHA256 use the standard library, which already picks the
+// fastest implementation the CPU supports (SHA-NI, AVX2, ARMv8 crypto
+// extensions) at startup.
+var (
+	SHA1   Hasher = sha1Hasher{}
+	SHA256 Hasher = sha256Hasher{}
+)
+
+// Verify reports whether data hashes to want under h.
+func Verify(h Hasher, data, want []byte) bool {
+	return len(want) == h.Size() && bytes.Equal(h.Sum(data), want)
+}
+
+// Digests converts the SHA-1 piece hashes of a v1 metainfo to the
+// variable-length digests pieces are verified against.
+func Digests(hashes [][sha1.Size]byte) [][]byte {
+	out := make([][]byte, len(hashes))
+	for i := range hashes {
+		out[i] = hashes[i][:]
+	}
+	return out
+}
+
+// SumBatch hashes pieces on up to workers goroutines and returns their
+// digests in order.
+func SumBatch(h Hasher, pieces [][]byte, workers int) [][]byte {
+	sums := make([][]byte, len(pieces))
+	if len(pieces) == 0 {
+		return sums
+	}
+
+	workers = max(1, min(workers, len(pieces)))
+	var (
+		next atomic.Int64
+		wg   sync.WaitGroup
+	)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= len(pieces) {
+					return
+				}
+				sums[i] = h.Sum(pieces[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	return sums
+}
+
+// HashMeter accumulates how much data was hashed and the time spent doing
+// it, summed across goroutines.
+type HashMeter struct {
+	bytes atomic.Uint64
+	busy  atomic.Int64
+}
+
+// HashStats is a HashMeter reading.
+type HashStats struct {
+	Bytes uint64 `json:"bytes"`
+	// Busy is the hashing time in nanoseconds.
+	Busy int64 `json:"busy"`
+	// Rate is MB/s per hashing goroutine, i.e. Bytes over Busy.
+	Rate float64 `json:"rate"`
+}
+
+func (m *HashMeter) Stats() HashStats {
+	st := HashStats{Bytes: m.bytes.Load(), Busy: m.busy.Load()}
+	if st.Busy > 0 {
+		st.Rate = float64(st.Bytes) / 1e6 / time.Duration(st.Busy).Seconds()
+	}
+	return st
+}
+
+// Metered returns h recording every Sum in m.
+func Metered(h Hasher, m *HashMeter) Hasher {
+	return meteredHasher{Hasher: h, meter: m}
+}
+
+type meteredHasher struct {
+	Hasher
+	meter *HashMeter
+}
+
+func (h meteredHasher) Sum(data []byte) []byte {
+	start := time.Now()
+	sum := h.Hasher.Sum(data)
+	h.meter.add(len(data), time.Since(start))
+	return sum
+}
+
+func (h meteredHasher) New() hash.Hash {
+	return meteredHash{Hash: h.Hasher.New(), meter: h.meter}
+}
+
+type meteredHash struct {
+	hash.Hash
+	meter *HashMeter
+}
+
+func (h meteredHash) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := h.Hash.Write(p)
+	h.meter.add(n, time.Since(start))
+	return n, err
+}
+
+func (m *HashMeter) add(n int, took time.Duration) {
+	m.busy.Add(int64(took))
+	m.bytes.Add(uint64(n))
+}
+
+type sha1Hasher struct{}
+
+func (sha1Hasher) Name() string   { return "sha1" }
+func (sha1Hasher) Size() int      { return sha1.Size }
+func (sha1Hasher) New() hash.Hash { return sha1.New() }
+
+func (sha1Hasher) Sum(data []byte) []byte {
+	sum := sha1.Sum(data)
+	return sum[:]
+}
+
+type sha256Hasher struct{}
+
+func (sha256Hasher) Name() string   { return "sha256" }
+func (sha256Hasher) Size() int      { return sha256.Size }
+func (sha256Hasher) New() hash.Hash { return sha256.New() }
+
+func (sha256Hasher) Sum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
@@ -0,0 +1,111 @@
+package piece
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"hash"
+	"testing"
+)
+
+// multihash prefixes a SHA-256 digest with its multihash code and length,
+// standing in for a hasher whose digests aren't plain SHA sums.
+type multihash struct{}
+
+func (multihash) Name() string { return "multihash-sha256" }
+func (multihash) Size() int    { return 2 + sha256.Size }
+
+func (multihash) Sum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return append([]byte{0x12, sha256.Size}, sum[:]...)
+}
+
+func (multihash) New() hash.Hash { return multihashState{sha256.New()} }
+
+type multihashState struct{ hash.Hash }
+
+func (m multihashState) Size() int { return 2 + sha256.Size }
+
+func (m multihashState) Sum(b []byte) []byte {
+	return m.Hash.Sum(append(b, 0x12, sha256.Size))
+}
+
+func TestVerify(t *testing.T) {
+	data := []byte("piece data")
+	v1 := sha1.Sum(data)
+	v2 := sha256.Sum256(data)
+
+	tests := []struct {
+		name   string
+		hasher Hasher
+		want   []byte
+		ok     bool
+	}{
+		{"sha1 match", SHA1, v1[:], true},
+		{"sha1 mismatch", SHA1, make([]byte, sha1.Size), false},
+		{"sha256 match", SHA256, v2[:], true},
+		{"sha256 against sha1 digest", SHA256, v1[:], false},
+		{"multihash match", multihash{}, append([]byte{0x12, 0x20}, v2[:]...), true},
+		{"multihash bare digest", multihash{}, v2[:], false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Verify(tt.hasher, data, tt.want); got != tt.ok {
+				t.Errorf("Verify(%s) = %v, want %v", tt.hasher.Name(), got, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSumBatch(t *testing.T) {
+	pieces := make([][]byte, 37)
+	for i := range pieces {
+		pieces[i] = []byte{byte(i), byte(i * 7)}
+	}
+
+	for _, workers := range []int{0, 1, 4, 100} {
+		sums := SumBatch(SHA1, pieces, workers)
+		if len(sums) != len(pieces) {
+			t.Fatalf("workers=%d: got %d sums, want %d", workers, len(sums), len(pieces))
+		}
+		for i, p := range pieces {
+			if !Verify(SHA1, p, sums[i]) {
+				t.Fatalf("workers=%d: sum %d doesn't match its piece", workers, i)
+			}
+		}
+	}
+}
+
+func TestMetered(t *testing.T) {
+	var m HashMeter
+	h := Metered(SHA1, &m)
+
+	data := make([]byte, 1<<20)
+	want := sha1.Sum(data)
+	if !Verify(h, data, want[:]) {
+		t.Fatal("metered hasher changed the digest")
+	}
+	h.Sum(data)
+
+	st := m.Stats()
+	if st.Bytes != 2<<20 {
+		t.Errorf("Bytes = %d, want %d", st.Bytes, 2<<20)
+	}
+	if st.Busy <= 0 || st.Rate <= 0 {
+		t.Errorf("Busy = %d, Rate = %f; want both positive", st.Busy, st.Rate)
+	}
+}
+
+func TestHasher_NewMatchesSum(t *testing.T) {
+	data := []byte("a piece hashed one block at a time")
+
+	for _, h := range []Hasher{SHA1, SHA256, multihash{}, Metered(SHA1, &HashMeter{})} {
+		d := h.New()
+		for i := 0; i < len(data); i += 5 {
+			d.Write(data[i:min(i+5, len(data))])
+		}
+		if !Verify(h, data, d.Sum(nil)) {
+			t.Errorf("%s: incremental digest differs from Sum", h.Name())
+		}
+	}
+}
@@ -1,11 +1,12 @@
 package piece
 
 import (
-	"crypto/sha1"
 	"errors"
 	"log/slog"
 	"net/netip"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prxssh/rabbit/pkg/bitfield"
@@ -40,31 +41,54 @@ type block struct {
 
 type piece struct {
 	index         uint32
-	status        Status
 	length        uint32
 	blockCount    uint32
 	lastBlockSize uint32
-	doneBlocks    uint32
-	verified      bool
-	blocks        []*block
-	hash          [sha1.Size]byte
+	hash          []byte
+
+	// Guarded by the piece's shard.
+	status Status
+	blocks []*block
+	// doneBlocks and verified are only written under the shard, but scans
+	// read them without it to skip pieces that don't qualify.
+	doneBlocks atomic.Uint32
+	verified   atomic.Bool
 }
 
+// shardCount is how many locks the pieces are spread over. Piece i is
+// guarded by shard i % shardCount, so peers working on different pieces
+// rarely wait on each other.
+const shardCount = 64
+
+type shard struct {
+	sync.Mutex
+	// Keeps neighbouring locks off the same cache line.
+	_ [56]byte
+}
+
+// Manager tracks the state of every piece and block. Geometry and hashes
+// never change and are read without locking; per-piece state is guarded
+// by shards, and the sequential cursor by seqMut, which is always taken
+// before any shard.
 type Manager struct {
 	logger          *slog.Logger
-	mut             sync.RWMutex
 	pieces          []*piece
 	pieceCount      uint32
-	nextPiece       uint32
-	nextBlock       uint32
-	remainingBlocks uint32
 	lastPieceLength uint32
-	blockCount      uint32
+	remainingBlocks atomic.Uint32
+	// duplicates counts owners beyond the first, over all blocks.
+	duplicates atomic.Int32
+
+	shards [shardCount]shard
+
+	seqMut    sync.Mutex
+	nextPiece uint32
+	nextBlock uint32
 }
 
 // TODO: check timeouts and free blocks
 func NewManager(
-	pieceHashes [][sha1.Size]byte,
+	pieceHashes [][]byte,
 	pieceLen uint32,
 	size uint64,
 	logger *slog.Logger,
@@ -95,10 +119,8 @@ func NewManager(
 
 		pieces[i] = &piece{
 			index:         uint32(i),
-			doneBlocks:    0,
 			status:        StatusWant,
 			length:        currPieceLen,
-			verified:      false,
 			blocks:        blocks,
 			blockCount:    blockCount,
 			hash:          pieceHashes[i],
@@ -106,73 +128,90 @@ func NewManager(
 		}
 	}
 
-	return &Manager{
+	m := &Manager{
 		logger:          logger,
 		pieces:          pieces,
 		nextPiece:       0,
 		nextBlock:       0,
 		pieceCount:      uint32(n),
-		remainingBlocks: totalBlocks,
 		lastPieceLength: lastPieceLen,
-	}, nil
+	}
+	m.remainingBlocks.Store(totalBlocks)
+	return m, nil
 }
 
-func (m *Manager) PieceCount() uint32 {
-	m.mut.RLock()
-	defer m.mut.RUnlock()
+// shard returns the lock guarding a piece.
+func (m *Manager) shard(pieceIdx uint32) *shard {
+	return &m.shards[pieceIdx%shardCount]
+}
 
+func (m *Manager) PieceCount() uint32 {
 	return m.pieceCount
 }
 
 func (m *Manager) ResetSequentialState() {
-	m.mut.Lock()
-	defer m.mut.Unlock()
+	m.seqMut.Lock()
+	defer m.seqMut.Unlock()
 
 	m.nextPiece = 0
 	m.nextBlock = 0
 
-	for m.nextPiece < m.pieceCount && m.pieces[m.nextPiece].verified {
+	for m.nextPiece < m.pieceCount && m.pieces[m.nextPiece].verified.Load() {
 		m.nextPiece++
 	}
 }
 
 func (m *Manager) PieceLength(pieceIdx uint32) uint32 {
-	m.mut.RLock()
-	defer m.mut.RUnlock()
-
 	return m.pieces[pieceIdx].length
 }
 
-func (m *Manager) PieceHash(pieceIdx uint32) [sha1.Size]byte {
-	m.mut.RLock()
-	defer m.mut.RUnlock()
-
+func (m *Manager) PieceHash(pieceIdx uint32) []byte {
 	return m.pieces[pieceIdx].hash
 }
 
 func (m *Manager) PieceComplete(pieceIdx uint32) bool {
-	m.mut.Lock()
-	defer m.mut.Unlock()
+	sh := m.shard(pieceIdx)
+	sh.Lock()
+	defer sh.Unlock()
 
 	piece := m.pieces[pieceIdx]
-	return piece.doneBlocks == piece.blockCount
+	return piece.doneBlocks.Load() == piece.blockCount
 }
 
-func (m *Manager) PieceStatus() []Status {
-	m.mut.RLock()
-	defer m.mut.RUnlock()
+// BlockDone reports whether the block at begin has already been received.
+func (m *Manager) BlockDone(pieceIdx, begin uint32) bool {
+	if pieceIdx >= m.pieceCount {
+		return false
+	}
 
+	sh := m.shard(pieceIdx)
+	sh.Lock()
+	defer sh.Unlock()
+
+	piece := m.pieces[pieceIdx]
+	blockIdx, ok := BlockIndexForBegin(begin, piece.length)
+	if !ok {
+		return false
+	}
+	return piece.verified.Load() || piece.blocks[blockIdx].status == StatusDone
+}
+
+func (m *Manager) PieceStatus() []Status {
 	states := make([]Status, m.pieceCount)
 	for i, piece := range m.pieces {
+		sh := m.shard(uint32(i))
+		sh.Lock()
 		states[i] = piece.status
+		sh.Unlock()
 	}
 
 	return states
 }
 
 func (m *Manager) MarkBlockComplete(peer netip.AddrPort, pieceIdx, begin uint32) []netip.AddrPort {
-	m.mut.Lock()
-	defer m.mut.Unlock()
+	sh := m.shard(pieceIdx)
+	sh.Lock()
+	defer sh.Unlock()
 
 	piece := m.pieces[pieceIdx]
 	blockIdx, _ := BlockIndexForBegin(begin, piece.length)
@@ -181,7 +220,7 @@ func (m *Manager) MarkBlockComplete(peer netip.AddrPort, pieceIdx, begin uint32)
 		return nil
 	}
 	block.status = StatusDone
-	piece.doneBlocks++
+	piece.doneBlocks.Add(1)
 
 	var redundantPeers []netip.AddrPort
 	for i := range block.owners {
@@ -189,7 +228,7 @@ func (m *Manager) MarkBlockComplete(peer netip.AddrPort, pieceIdx, begin uint32)
 			redundantPeers = append(redundantPeers, block.owners[i].peer)
 		}
 	}
-	block.owners = nil
+	m.dropOwners(block)
 
 	return redundantPeers
 }
@@ -197,55 +236,138 @@ func (m *Manager) MarkBlockComplete(peer netip.AddrPort, pieceIdx, begin uint32)
 func (m *Manager) MarkPieceVerified(pieceIdx uint32, ok bool) {
 	m.logger.Debug("mark piece verified called", "piece", pieceIdx)
 
-	m.mut.Lock()
-	defer m.mut.Unlock()
+	sh := m.shard(pieceIdx)
+	sh.Lock()
 
 	piece := m.pieces[pieceIdx]
-	if piece.verified {
+	if piece.verified.Load() {
+		sh.Unlock()
 		return
 	}
 
 	if ok {
-		piece.verified = true
+		piece.verified.Store(true)
 		piece.status = StatusDone
+		sh.Unlock()
 
+		m.seqMut.Lock()
 		if m.nextPiece == pieceIdx {
 			m.nextPiece++
 			m.nextBlock = 0
 		}
+		m.seqMut.Unlock()
 
 		return
 	}
+	defer sh.Unlock()
 
 	for b := 0; b < int(piece.blockCount); b++ {
 		if piece.blocks[b].status == StatusDone {
-			m.remainingBlocks++
+			m.remainingBlocks.Add(1)
 		}
 
 		piece.blocks[b].status = StatusWant
-		piece.blocks[b].owners = nil
+		m.dropOwners(piece.blocks[b])
 	}
 
-	piece.doneBlocks = 0
+	piece.doneBlocks.Store(0)
 	piece.status = StatusWant
 }
 
+// MarkPieceHave marks a piece found intact on disk as verified without it
+// ever having been downloaded.
+func (m *Manager) MarkPieceHave(pieceIdx uint32) {
+	if pieceIdx >= m.pieceCount {
+		return
+	}
+
+	sh := m.shard(pieceIdx)
+	sh.Lock()
+
+	piece := m.pieces[pieceIdx]
+	if piece.verified.Load() {
+		sh.Unlock()
+		return
+	}
+
+	for _, block := range piece.blocks {
+		if block.status == StatusWant && len(block.owners) == 0 {
+			m.remainingBlocks.Add(^uint32(0))
+		}
+		block.status = StatusDone
+		m.dropOwners(block)
+	}
+
+	piece.doneBlocks.Store(piece.blockCount)
+	piece.verified.Store(true)
+	piece.status = StatusDone
+	sh.Unlock()
+
+	m.seqMut.Lock()
+	defer m.seqMut.Unlock()
+
+	for m.nextPiece < m.pieceCount && m.pieces[m.nextPiece].verified.Load() {
+		m.nextPiece++
+		m.nextBlock = 0
+	}
+}
+
+// ResetPiece marks a verified piece as wanted again, as when its data on
+// disk turned out corrupt, so it gets downloaded anew.
+func (m *Manager) ResetPiece(pieceIdx uint32) {
+	if pieceIdx >= m.pieceCount {
+		return
+	}
+
+	sh := m.shard(pieceIdx)
+	sh.Lock()
+
+	piece := m.pieces[pieceIdx]
+	if !piece.verified.Load() {
+		sh.Unlock()
+		return
+	}
+
+	for _, block := range piece.blocks {
+		if block.status == StatusDone {
+			m.remainingBlocks.Add(1)
+		}
+		block.status = StatusWant
+		m.dropOwners(block)
+	}
+
+	piece.doneBlocks.Store(0)
+	piece.verified.Store(false)
+	piece.status = StatusWant
+	sh.Unlock()
+
+	m.seqMut.Lock()
+	defer m.seqMut.Unlock()
+
+	if pieceIdx < m.nextPiece {
+		m.nextPiece = pieceIdx
+		m.nextBlock = 0
+	}
+}
+
 func (m *Manager) AssignBlock(peer netip.AddrPort, pieceIdx, blockIdx uint32) bool {
-	m.mut.Lock()
-	defer m.mut.Unlock()
+	sh := m.shard(pieceIdx)
+	sh.Lock()
+	defer sh.Unlock()
 
 	_, ok := m.safeAssignBlock(peer, pieceIdx, blockIdx, 1)
 	return ok
 }
 
 func (m *Manager) UnassignBlock(peer netip.AddrPort, pieceIdx, begin uint32) {
-	m.mut.Lock()
-	defer m.mut.Unlock()
-
 	if pieceIdx >= m.pieceCount {
 		return
 	}
 
+	sh := m.shard(pieceIdx)
+	sh.Lock()
+	defer sh.Unlock()
+
 	piece := m.pieces[pieceIdx]
 	blockIdx, ok := BlockIndexForBegin(begin, piece.length)
 	if !ok {
@@ -258,8 +380,11 @@ func (m *Manager) UnassignBlock(peer netip.AddrPort, pieceIdx, begin uint32) {
 		if block.owners[i].peer == peer {
 			block.owners[i] = block.owners[n-1]
 			block.owners = block.owners[:n-1]
+			if n > 1 {
+				m.duplicates.Add(-1)
+			}
 
-			m.remainingBlocks++
+			m.remainingBlocks.Add(1)
 			break
 		}
 	}
@@ -274,17 +399,16 @@ func (m *Manager) AssignInProgressBlocks(
 	peerBF bitfield.Bitfield,
 	capacity uint32,
 ) ([]*BlockInfo, uint32) {
-	m.mut.Lock()
-	defer m.mut.Unlock()
-
 	assigned := make([]*BlockInfo, 0, capacity)
 
 	for i := uint32(0); i < m.pieceCount && capacity > 0; i++ {
 		piece := m.pieces[i]
-		if piece.verified || piece.doneBlocks == 0 || !peerBF.Has(int(piece.index)) {
+		if piece.verified.Load() || piece.doneBlocks.Load() == 0 || !peerBF.Has(int(piece.index)) {
 			continue
 		}
 
+		sh := m.shard(i)
+		sh.Lock()
 		for j := uint32(0); j < piece.blockCount && capacity > 0; j++ {
 			if piece.blocks[j].status != StatusWant {
 				continue
@@ -297,6 +421,7 @@ func (m *Manager) AssignInProgressBlocks(
 
 			break
 		}
+		sh.Unlock()
 	}
 
 	return assigned, capacity
@@ -307,17 +432,16 @@ func (m *Manager) AssignEndgameBlocks(
 	peerBF bitfield.Bitfield,
 	capacity, duplicateLimit uint32,
 ) ([]*BlockInfo, uint32) {
-	m.mut.Lock()
-	defer m.mut.Unlock()
-
 	assigned := make([]*BlockInfo, 0, capacity)
 
 	for i := 0; i < int(m.pieceCount) && capacity > 0; i++ {
 		piece := m.pieces[i]
-		if piece.verified || !peerBF.Has(i) {
+		if piece.verified.Load() || !peerBF.Has(i) {
 			continue
 		}
 
+		sh := m.shard(uint32(i))
+		sh.Lock()
 		for j := 0; j < int(piece.blockCount) && capacity > 0; j++ {
 			if piece.blocks[j].status == StatusDone {
 				continue
@@ -328,6 +452,7 @@ func (m *Manager) AssignEndgameBlocks(
 				capacity--
 			}
 		}
+		sh.Unlock()
 	}
 
 	return assigned, capacity
@@ -338,14 +463,14 @@ func (m *Manager) AssignSequentialBlocks(
 	peerBF bitfield.Bitfield,
 	capacity uint32,
 ) ([]*BlockInfo, uint32) {
-	m.mut.Lock()
-	defer m.mut.Unlock()
+	m.seqMut.Lock()
+	defer m.seqMut.Unlock()
 
 	assigned := make([]*BlockInfo, 0, capacity)
 
 	for m.nextPiece < m.pieceCount && capacity > 0 {
 		// Skip verified pieces
-		for m.nextPiece < m.pieceCount && m.pieces[m.nextPiece].verified {
+		for m.nextPiece < m.pieceCount && m.pieces[m.nextPiece].verified.Load() {
 			m.nextPiece++
 			m.nextBlock = 0
 		}
@@ -361,6 +486,8 @@ func (m *Manager) AssignSequentialBlocks(
 		}
 
 		piece := m.pieces[m.nextPiece]
+		sh := m.shard(piece.index)
+		sh.Lock()
 		for bi := m.nextBlock; bi < piece.blockCount && capacity > 0; bi++ {
 			block, ok := m.safeAssignBlock(peer, piece.index, bi, 1)
 			if ok {
@@ -369,6 +496,7 @@ func (m *Manager) AssignSequentialBlocks(
 				m.nextBlock = bi + 1
 			}
 		}
+		sh.Unlock()
 
 		if m.nextBlock >= piece.blockCount {
 			m.nextPiece++
@@ -386,9 +514,6 @@ func (m *Manager) AssignBlocksFromList(
 	pieceIndices []uint32,
 	capacity uint32,
 ) ([]*BlockInfo, uint32) {
-	m.mut.Lock()
-	defer m.mut.Unlock()
-
 	assigned := make([]*BlockInfo, 0, capacity)
 
 	for _, pieceIdx := range pieceIndices {
@@ -396,12 +521,14 @@ func (m *Manager) AssignBlocksFromList(
 			break
 		}
 
-		if pieceIdx >= m.pieceCount || m.pieces[pieceIdx].verified {
+		if pieceIdx >= m.pieceCount || m.pieces[pieceIdx].verified.Load() {
 			continue
 		}
 
 		piece := m.pieces[pieceIdx]
 
+		sh := m.shard(pieceIdx)
+		sh.Lock()
 		for blockIdx := uint32(0); blockIdx < piece.blockCount; blockIdx++ {
 			block, ok := m.safeAssignBlock(peer, piece.index, blockIdx, 1)
 			if ok {
@@ -410,11 +537,138 @@ func (m *Manager) AssignBlocksFromList(
 				break
 			}
 		}
+		sh.Unlock()
 	}
 
 	return assigned, capacity
 }
 
+// AssignPieceBlocks assigns every unclaimed block of each piece, in order,
+// until capacity runs out. Used for pieces with a deadline, where finishing
+// one piece quickly matters more than spreading requests.
+func (m *Manager) AssignPieceBlocks(
+	peer netip.AddrPort,
+	pieceIndices []uint32,
+	capacity uint32,
+) ([]*BlockInfo, uint32) {
+	assigned := make([]*BlockInfo, 0, capacity)
+
+	for _, pieceIdx := range pieceIndices {
+		if pieceIdx >= m.pieceCount || m.pieces[pieceIdx].verified.Load() {
+			continue
+		}
+
+		piece := m.pieces[pieceIdx]
+
+		sh := m.shard(pieceIdx)
+		sh.Lock()
+		for blockIdx := uint32(0); blockIdx < piece.blockCount && capacity > 0; blockIdx++ {
+			if piece.blocks[blockIdx].status != StatusWant {
+				continue
+			}
+
+			block, ok := m.safeAssignBlock(peer, piece.index, blockIdx, 1)
+			if ok {
+				assigned = append(assigned, block)
+				capacity--
+			}
+		}
+		sh.Unlock()
+	}
+
+	return assigned, capacity
+}
+
+// StealBlocks duplicates onto peer blocks of pieces in peerBF that have
+// been in flight to a single other owner for at least minAge, when slow
+// says that owner is worth stealing from. Nothing is stolen while peerBF
+// still has unclaimed blocks, and fewer than budget blocks are duplicated
+// at any time, counting endgame duplicates.
+func (m *Manager) StealBlocks(
+	peer netip.AddrPort,
+	peerBF bitfield.Bitfield,
+	capacity, budget uint32,
+	minAge time.Duration,
+	slow func(netip.AddrPort) bool,
+) ([]*BlockInfo, uint32) {
+	if m.duplicates.Load() >= int32(budget) || m.hasWantBlocks(peerBF) {
+		return nil, capacity
+	}
+
+	var assigned []*BlockInfo
+
+	for i := uint32(0); i < m.pieceCount && capacity > 0; i++ {
+		if m.duplicates.Load() >= int32(budget) {
+			break
+		}
+
+		piece := m.pieces[i]
+		if piece.verified.Load() || !peerBF.Has(int(i)) {
+			continue
+		}
+
+		sh := m.shard(i)
+		sh.Lock()
+		for j := uint32(0); j < piece.blockCount && capacity > 0; j++ {
+			block := piece.blocks[j]
+			if block.status != StatusInflight || len(block.owners) != 1 {
+				continue
+			}
+			owner := block.owners[0]
+			if owner.peer == peer || time.Since(owner.requestedAt) < minAge || !slow(owner.peer) {
+				continue
+			}
+			if m.duplicates.Load() >= int32(budget) {
+				break
+			}
+
+			if info, ok := m.safeAssignBlock(peer, i, j, 2); ok {
+				assigned = append(assigned, info)
+				capacity--
+			}
+		}
+		sh.Unlock()
+	}
+
+	return assigned, capacity
+}
+
+// hasWantBlocks reports whether any piece in peerBF has a block nobody was
+// asked for.
+func (m *Manager) hasWantBlocks(peerBF bitfield.Bitfield) bool {
+	for i, piece := range m.pieces {
+		if piece.verified.Load() || piece.doneBlocks.Load() == piece.blockCount || !peerBF.Has(i) {
+			continue
+		}
+
+		sh := m.shard(uint32(i))
+		sh.Lock()
+		want := piece.status == StatusWant || slices.ContainsFunc(piece.blocks, func(b *block) bool {
+			return b.status == StatusWant
+		})
+		sh.Unlock()
+		if want {
+			return true
+		}
+	}
+	return false
+}
+
+// Duplicates returns how many block requests are outstanding beyond the
+// first for their block.
+func (m *Manager) Duplicates() int {
+	return int(m.duplicates.Load())
+}
+
+// dropOwners clears a block's owners. Called with the piece's shard held.
+func (m *Manager) dropOwners(block *block) {
+	if n := len(block.owners); n > 1 {
+		m.duplicates.Add(-int32(n - 1))
+	}
+	block.owners = nil
+}
+
+// safeAssignBlock must be called with the piece's shard held.
 func (m *Manager) safeAssignBlock(
 	peer netip.AddrPort,
 	pieceIdx, blockIdx uint32,
@@ -432,13 +686,16 @@ func (m *Manager) safeAssignBlock(
 		return nil, false
 	}
 
+	if len(block.owners) > 0 {
+		m.duplicates.Add(1)
+	}
 	piece.status = StatusInflight
 	block.status = StatusInflight
 	block.owners = append(block.owners, &blockOwner{
 		peer:        peer,
 		requestedAt: time.Now(),
 	})
-	m.remainingBlocks--
+	m.remainingBlocks.Add(^uint32(0))
 
 	return &BlockInfo{
 		PieceIdx: pieceIdx,
@@ -0,0 +1,118 @@
+package piece
+
+import (
+	"log/slog"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prxssh/rabbit/pkg/bitfield"
+)
+
+const (
+	benchPieces   = 10_000
+	benchPieceLen = 256 * 1024
+)
+
+func benchManager(b *testing.B) *Manager {
+	b.Helper()
+
+	mgr, err := NewManager(
+		make([][]byte, benchPieces),
+		benchPieceLen,
+		uint64(benchPieces)*benchPieceLen,
+		slog.Default(),
+	)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return mgr
+}
+
+func benchSeed() bitfield.Bitfield {
+	bf := bitfield.New(benchPieces)
+	for i := range benchPieces {
+		bf.Set(i)
+	}
+	return bf
+}
+
+// BenchmarkManager_AssignUnassign is request bookkeeping from many peers at
+// once, each working on its own pieces.
+func BenchmarkManager_AssignUnassign(b *testing.B) {
+	mgr := benchManager(b)
+	var peers atomic.Uint32
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		id := peers.Add(1)
+		peer := netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 0, byte(id >> 8), byte(id)}), 6881)
+		pieceIdx := id * 97 % benchPieces
+
+		for pb.Next() {
+			mgr.AssignBlock(peer, pieceIdx, 0)
+			mgr.UnassignBlock(peer, pieceIdx, 0)
+			pieceIdx = (pieceIdx + 1) % benchPieces
+		}
+	})
+}
+
+// BenchmarkManager_MarkBlockComplete is block arrivals from many peers.
+func BenchmarkManager_MarkBlockComplete(b *testing.B) {
+	mgr := benchManager(b)
+	blocks := uint32(benchPieceLen / MaxBlockLength)
+	var next atomic.Uint64
+	peer := netip.MustParseAddrPort("10.0.0.1:6881")
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := next.Add(1) % (benchPieces * uint64(blocks))
+			pieceIdx, blockIdx := uint32(i)/blocks, uint32(i)%blocks
+			mgr.MarkBlockComplete(peer, pieceIdx, blockIdx*MaxBlockLength)
+		}
+	})
+}
+
+// BenchmarkManager_AssignInProgressBlocks scans a large torrent with a few
+// pieces underway, as every dispatch to a seed does.
+func BenchmarkManager_AssignInProgressBlocks(b *testing.B) {
+	mgr := benchManager(b)
+	seed := benchSeed()
+	peer := netip.MustParseAddrPort("10.0.0.1:6881")
+	for i := uint32(0); i < benchPieces; i += benchPieces / 8 {
+		mgr.MarkBlockComplete(peer, i, 0)
+	}
+
+	b.ResetTimer()
+	for b.Loop() {
+		assigned, _ := mgr.AssignInProgressBlocks(peer, seed, 8)
+		for _, block := range assigned {
+			mgr.UnassignBlock(peer, block.PieceIdx, block.Begin)
+		}
+	}
+}
+
+// BenchmarkManager_BlockDone is the duplicate check run on every block
+// received, alongside assignment from other peers.
+func BenchmarkManager_BlockDone(b *testing.B) {
+	mgr := benchManager(b)
+	var peers atomic.Uint32
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		id := peers.Add(1)
+		peer := netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 1, byte(id >> 8), byte(id)}), 6881)
+		pieceIdx := id * 131 % benchPieces
+
+		for i := 0; pb.Next(); i++ {
+			if i%4 == 0 {
+				mgr.AssignBlock(peer, pieceIdx, 0)
+				mgr.UnassignBlock(peer, pieceIdx, 0)
+			} else {
+				mgr.BlockDone(pieceIdx, 0)
+			}
+			pieceIdx = (pieceIdx + 1) % benchPieces
+		}
+	})
+}
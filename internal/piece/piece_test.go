@@ -1,10 +1,12 @@
 package piece
 
 import (
-	"crypto/sha1"
+	"bytes"
+	"log/slog"
 	"net/netip"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/prxssh/rabbit/pkg/bitfield"
 )
@@ -12,7 +14,7 @@ import (
 func TestNewManager(t *testing.T) {
 	tests := []struct {
 		name          string
-		pieceHashes   [][sha1.Size]byte
+		pieceHashes   [][]byte
 		pieceLen      uint32
 		size          uint64
 		expectedErr   bool
@@ -20,7 +22,7 @@ func TestNewManager(t *testing.T) {
 	}{
 		{
 			name: "valid arguments",
-			pieceHashes: [][sha1.Size]byte{
+			pieceHashes: [][]byte{
 				{},
 				{},
 			},
@@ -31,7 +33,7 @@ func TestNewManager(t *testing.T) {
 		},
 		{
 			name:          "invalid size",
-			pieceHashes:   [][sha1.Size]byte{},
+			pieceHashes:   [][]byte{},
 			pieceLen:      16384,
 			size:          0,
 			expectedErr:   true,
@@ -41,7 +43,7 @@ func TestNewManager(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			mgr, err := NewManager(tt.pieceHashes, tt.pieceLen, tt.size)
+			mgr, err := NewManager(tt.pieceHashes, tt.pieceLen, tt.size, slog.Default())
 			if (err != nil) != tt.expectedErr {
 				t.Errorf("NewManager() error = %v, wantErr %v", err, tt.expectedErr)
 				return
@@ -58,10 +60,10 @@ func TestNewManager(t *testing.T) {
 }
 
 func TestPieceManager_PieceLength(t *testing.T) {
-	pieceHashes := [][sha1.Size]byte{{0x1}}
+	pieceHashes := [][]byte{{0x1}}
 	pieceLen := uint32(16384)
 	size := uint64(16384)
-	mgr, _ := NewManager(pieceHashes, pieceLen, size)
+	mgr, _ := NewManager(pieceHashes, pieceLen, size, slog.Default())
 
 	if length := mgr.PieceLength(0); length != pieceLen {
 		t.Errorf("PieceLength(0) = %v, want %v", length, pieceLen)
@@ -69,21 +71,21 @@ func TestPieceManager_PieceLength(t *testing.T) {
 }
 
 func TestPieceManager_PieceHash(t *testing.T) {
-	pieceHashes := [][sha1.Size]byte{{0x1}, {0x2}}
+	pieceHashes := [][]byte{{0x1}, {0x2}}
 	pieceLen := uint32(16384)
 	size := uint64(32768)
-	mgr, _ := NewManager(pieceHashes, pieceLen, size)
+	mgr, _ := NewManager(pieceHashes, pieceLen, size, slog.Default())
 
-	if hash := mgr.PieceHash(1); hash != pieceHashes[1] {
+	if hash := mgr.PieceHash(1); !bytes.Equal(hash, pieceHashes[1]) {
 		t.Errorf("PieceHash(1) = %v, want %v", hash, pieceHashes[1])
 	}
 }
 
 func TestPieceManager_PieceComplete(t *testing.T) {
-	pieceHashes := [][sha1.Size]byte{{0x1}}
+	pieceHashes := [][]byte{{0x1}}
 	pieceLen := uint32(16384)
 	size := uint64(16384)
-	mgr, _ := NewManager(pieceHashes, pieceLen, size)
+	mgr, _ := NewManager(pieceHashes, pieceLen, size, slog.Default())
 
 	if complete := mgr.PieceComplete(0); complete {
 		t.Errorf("PieceComplete(0) should be false initially")
@@ -91,10 +93,10 @@ func TestPieceManager_PieceComplete(t *testing.T) {
 }
 
 func TestPieceManager_MarkBlockComplete(t *testing.T) {
-	pieceHashes := [][sha1.Size]byte{{0x1}}
+	pieceHashes := [][]byte{{0x1}}
 	pieceLen := uint32(16384)
 	size := uint64(16384)
-	mgr, _ := NewManager(pieceHashes, pieceLen, size)
+	mgr, _ := NewManager(pieceHashes, pieceLen, size, slog.Default())
 	peer := netip.MustParseAddrPort("1.2.3.4:5678")
 
 	redundantPeers := mgr.MarkBlockComplete(peer, 0, 0)
@@ -106,20 +108,20 @@ func TestPieceManager_MarkBlockComplete(t *testing.T) {
 	if piece.blocks[0].status != StatusDone {
 		t.Errorf("Block status should be StatusDone")
 	}
-	if piece.doneBlocks != 1 {
+	if piece.doneBlocks.Load() != 1 {
 		t.Errorf("doneBlocks should be 1")
 	}
 }
 
 func TestPieceManager_MarkPieceVerified(t *testing.T) {
-	pieceHashes := [][sha1.Size]byte{{0x1}}
+	pieceHashes := [][]byte{{0x1}}
 	pieceLen := uint32(16384)
 	size := uint64(16384)
-	mgr, _ := NewManager(pieceHashes, pieceLen, size)
+	mgr, _ := NewManager(pieceHashes, pieceLen, size, slog.Default())
 
 	mgr.MarkPieceVerified(0, true)
 	piece := mgr.pieces[0]
-	if !piece.verified {
+	if !piece.verified.Load() {
 		t.Errorf("Piece should be verified")
 	}
 	if piece.status != StatusDone {
@@ -128,16 +130,16 @@ func TestPieceManager_MarkPieceVerified(t *testing.T) {
 
 	// Test re-verification
 	mgr.MarkPieceVerified(0, false)
-	if !piece.verified {
+	if !piece.verified.Load() {
 		t.Errorf("Piece should remain verified")
 	}
 }
 
 func TestPieceManager_AssignAndUnassignBlock(t *testing.T) {
-	pieceHashes := [][sha1.Size]byte{{0x1}}
+	pieceHashes := [][]byte{{0x1}}
 	pieceLen := uint32(16384)
 	size := uint64(16384)
-	mgr, _ := NewManager(pieceHashes, pieceLen, size)
+	mgr, _ := NewManager(pieceHashes, pieceLen, size, slog.Default())
 	peer := netip.MustParseAddrPort("5.6.7.8:1234")
 
 	assigned := mgr.AssignBlock(peer, 0, 0)
@@ -164,11 +166,11 @@ func TestPieceManager_AssignAndUnassignBlock(t *testing.T) {
 }
 
 func TestPieceStatus(t *testing.T) {
-	pieceHashes := [][sha1.Size]byte{{}, {}, {}}
+	pieceHashes := [][]byte{{}, {}, {}}
 	pieceLen := uint32(16384)
 	size := uint64(49152)
 
-	mgr, _ := NewManager(pieceHashes, pieceLen, size)
+	mgr, _ := NewManager(pieceHashes, pieceLen, size, slog.Default())
 	mgr.pieces[0].status = StatusDone
 	mgr.pieces[1].status = StatusInflight
 
@@ -179,7 +181,7 @@ func TestPieceStatus(t *testing.T) {
 }
 
 func TestAssignSequentialBlocks(t *testing.T) {
-	pieceHashes := [][sha1.Size]byte{{0x1}, {0x2}, {0x3}}
+	pieceHashes := [][]byte{{0x1}, {0x2}, {0x3}}
 	pieceLen := uint32(16384)
 	size := uint64(49152)
 	peer := netip.MustParseAddrPort("1.2.3.4:5678")
@@ -187,7 +189,7 @@ func TestAssignSequentialBlocks(t *testing.T) {
 	bf.Set(0)
 	bf.Set(1)
 	bf.Set(2)
-	mgr, _ := NewManager(pieceHashes, pieceLen, size)
+	mgr, _ := NewManager(pieceHashes, pieceLen, size, slog.Default())
 
 	blocks, capacity := mgr.AssignSequentialBlocks(peer, bf, 5)
 	if capacity != 4 {
@@ -205,7 +207,7 @@ func TestAssignSequentialBlocks(t *testing.T) {
 }
 
 func TestAssignInProgressBlocks(t *testing.T) {
-	pieceHashes := [][sha1.Size]byte{{0x1}, {0x2}, {0x3}}
+	pieceHashes := [][]byte{{0x1}, {0x2}, {0x3}}
 	pieceLen := uint32(16384)
 	size := uint64(49152)
 	peer := netip.MustParseAddrPort("1.2.3.4:5678")
@@ -213,8 +215,8 @@ func TestAssignInProgressBlocks(t *testing.T) {
 	bf.Set(0)
 	bf.Set(1)
 	bf.Set(2)
-	mgr, _ := NewManager(pieceHashes, pieceLen, size)
-	mgr.pieces[0].doneBlocks = 1 // Mark one block as done to make the piece "in progress"
+	mgr, _ := NewManager(pieceHashes, pieceLen, size, slog.Default())
+	mgr.pieces[0].doneBlocks.Store(1) // Mark one block as done to make the piece "in progress"
 
 	blocks, capacity := mgr.AssignInProgressBlocks(peer, bf, 5)
 	if capacity != 4 {
@@ -232,7 +234,7 @@ func TestAssignInProgressBlocks(t *testing.T) {
 }
 
 func TestAssignEndgameBlocks(t *testing.T) {
-	pieceHashes := [][sha1.Size]byte{{0x1}, {0x2}, {0x3}}
+	pieceHashes := [][]byte{{0x1}, {0x2}, {0x3}}
 	pieceLen := uint32(16384)
 	size := uint64(49152)
 	peer1 := netip.MustParseAddrPort("1.2.3.4:5678")
@@ -241,7 +243,7 @@ func TestAssignEndgameBlocks(t *testing.T) {
 	bf.Set(0)
 	bf.Set(1)
 	bf.Set(2)
-	mgr, _ := NewManager(pieceHashes, pieceLen, size)
+	mgr, _ := NewManager(pieceHashes, pieceLen, size, slog.Default())
 
 	blocks, capacity := mgr.AssignEndgameBlocks(peer1, bf, 5, 2)
 	if capacity != 2 {
@@ -275,11 +277,11 @@ func TestAssignEndgameBlocks(t *testing.T) {
 }
 
 func TestAssignBlocksFromList(t *testing.T) {
-	pieceHashes := [][sha1.Size]byte{{0x1}, {0x2}, {0x3}}
+	pieceHashes := [][]byte{{0x1}, {0x2}, {0x3}}
 	pieceLen := uint32(16384)
 	size := uint64(49152)
 	peer := netip.MustParseAddrPort("1.2.3.4:5678")
-	mgr, _ := NewManager(pieceHashes, pieceLen, size)
+	mgr, _ := NewManager(pieceHashes, pieceLen, size, slog.Default())
 
 	blocks, capacity := mgr.AssignBlocksFromList(peer, []uint32{1, 2}, 5)
 	if capacity != 3 {
@@ -298,3 +300,164 @@ func TestAssignBlocksFromList(t *testing.T) {
 		t.Errorf("Expected owner to be %v, got %v", peer, owner)
 	}
 }
+
+func TestPieceManager_BlockDone(t *testing.T) {
+	pieceHashes := [][]byte{{0x1}}
+	mgr, _ := NewManager(pieceHashes, 32768, 32768, slog.Default())
+	peer := netip.MustParseAddrPort("1.2.3.4:5678")
+
+	if mgr.BlockDone(0, 0) {
+		t.Errorf("BlockDone should be false before the block is received")
+	}
+
+	mgr.MarkBlockComplete(peer, 0, 0)
+	if !mgr.BlockDone(0, 0) {
+		t.Errorf("BlockDone should be true after MarkBlockComplete")
+	}
+	if mgr.BlockDone(0, MaxBlockLength) {
+		t.Errorf("BlockDone should be false for a different block")
+	}
+	if mgr.BlockDone(1, 0) {
+		t.Errorf("BlockDone should be false for an out-of-range piece")
+	}
+}
+
+func TestPieceManager_MarkPieceHave(t *testing.T) {
+	pieceHashes := [][]byte{{0x1}, {0x2}}
+	mgr, _ := NewManager(pieceHashes, 32768, 65536, slog.Default())
+	before := mgr.remainingBlocks.Load()
+
+	mgr.MarkPieceHave(0)
+
+	piece := mgr.pieces[0]
+	if !piece.verified.Load() || piece.status != StatusDone {
+		t.Errorf("piece should be verified and done")
+	}
+	if piece.doneBlocks.Load() != piece.blockCount {
+		t.Errorf("doneBlocks = %d, want %d", piece.doneBlocks.Load(), piece.blockCount)
+	}
+	if got, want := mgr.remainingBlocks.Load(), before-piece.blockCount; got != want {
+		t.Errorf("remainingBlocks = %d, want %d", got, want)
+	}
+	if mgr.nextPiece != 1 {
+		t.Errorf("nextPiece = %d, want 1", mgr.nextPiece)
+	}
+
+	mgr.MarkPieceHave(0)
+	if got, want := mgr.remainingBlocks.Load(), before-piece.blockCount; got != want {
+		t.Errorf("marking twice changed remainingBlocks to %d", got)
+	}
+}
+
+func TestPieceManager_ResetPiece(t *testing.T) {
+	pieceHashes := [][]byte{{0x1}, {0x2}}
+	mgr, _ := NewManager(pieceHashes, 32768, 65536, slog.Default())
+	before := mgr.remainingBlocks.Load()
+
+	mgr.MarkPieceHave(0)
+	mgr.MarkPieceHave(1)
+	mgr.ResetPiece(0)
+
+	piece := mgr.pieces[0]
+	if piece.verified.Load() || piece.status != StatusWant {
+		t.Errorf("piece should be wanted again")
+	}
+	if piece.doneBlocks.Load() != 0 {
+		t.Errorf("doneBlocks = %d, want 0", piece.doneBlocks.Load())
+	}
+	for i, block := range piece.blocks {
+		if block.status != StatusWant {
+			t.Errorf("block %d status = %d, want StatusWant", i, block.status)
+		}
+	}
+	if got, want := mgr.remainingBlocks.Load(), before-mgr.pieces[1].blockCount; got != want {
+		t.Errorf("remainingBlocks = %d, want %d", got, want)
+	}
+	if mgr.nextPiece != 0 {
+		t.Errorf("nextPiece = %d, want 0", mgr.nextPiece)
+	}
+
+	mgr.ResetPiece(0)
+	if got, want := mgr.remainingBlocks.Load(), before-mgr.pieces[1].blockCount; got != want {
+		t.Errorf("resetting twice changed remainingBlocks to %d", got)
+	}
+}
+
+func TestPieceManager_AssignPieceBlocks(t *testing.T) {
+	pieceHashes := [][]byte{{0x1}, {0x2}}
+	mgr, _ := NewManager(pieceHashes, 3*MaxBlockLength, 6*MaxBlockLength, slog.Default())
+	peer := netip.MustParseAddrPort("1.2.3.4:5678")
+
+	mgr.MarkBlockComplete(peer, 1, MaxBlockLength)
+
+	blocks, rem := mgr.AssignPieceBlocks(peer, []uint32{1, 0}, 4)
+	if rem != 0 {
+		t.Fatalf("remaining capacity = %d, want 0", rem)
+	}
+
+	want := []BlockInfo{
+		{PieceIdx: 1, Begin: 0, Length: MaxBlockLength},
+		{PieceIdx: 1, Begin: 2 * MaxBlockLength, Length: MaxBlockLength},
+		{PieceIdx: 0, Begin: 0, Length: MaxBlockLength},
+		{PieceIdx: 0, Begin: MaxBlockLength, Length: MaxBlockLength},
+	}
+	if len(blocks) != len(want) {
+		t.Fatalf("assigned %d blocks, want %d", len(blocks), len(want))
+	}
+	for i := range want {
+		if *blocks[i] != want[i] {
+			t.Errorf("block %d = %+v, want %+v", i, *blocks[i], want[i])
+		}
+	}
+}
+
+func TestStealBlocks(t *testing.T) {
+	pieceHashes := [][]byte{{0x1}, {0x2}, {0x3}}
+	pieceLen := uint32(16384)
+	size := uint64(49152)
+	slowPeer := netip.MustParseAddrPort("1.2.3.4:5678")
+	fastPeer := netip.MustParseAddrPort("1.2.3.4:5679")
+	bf := bitfield.New(3)
+	bf.Set(0)
+	bf.Set(1)
+	bf.Set(2)
+	mgr, _ := NewManager(pieceHashes, pieceLen, size, slog.Default())
+
+	isSlow := func(p netip.AddrPort) bool { return p == slowPeer }
+	mgr.AssignBlocksFromList(slowPeer, []uint32{0, 1}, 2)
+	blocks, _ := mgr.StealBlocks(fastPeer, bf, 5, 2, 0, isSlow)
+	if len(blocks) != 0 {
+		t.Errorf("Expected nothing stolen while piece 2 is unclaimed, stole %d", len(blocks))
+	}
+
+	mgr.AssignBlocksFromList(slowPeer, []uint32{2}, 1)
+	blocks, _ = mgr.StealBlocks(fastPeer, bf, 5, 2, time.Hour, isSlow)
+	if len(blocks) != 0 {
+		t.Errorf("Expected young requests to be left alone, stole %d", len(blocks))
+	}
+
+	blocks, capacity := mgr.StealBlocks(fastPeer, bf, 5, 2, 0, isSlow)
+	if len(blocks) != 2 {
+		t.Errorf("Expected the budget to allow 2 steals, got %d", len(blocks))
+	}
+	if capacity != 3 {
+		t.Errorf("Expected capacity to be 3, got %d", capacity)
+	}
+	if mgr.Duplicates() != 2 {
+		t.Errorf("Expected 2 duplicates, got %d", mgr.Duplicates())
+	}
+
+	redundant := mgr.MarkBlockComplete(fastPeer, 0, 0)
+	if len(redundant) != 1 || redundant[0] != slowPeer {
+		t.Errorf("Expected %v to be redundant, got %v", slowPeer, redundant)
+	}
+	mgr.UnassignBlock(fastPeer, 1, 0)
+	if mgr.Duplicates() != 0 {
+		t.Errorf("Expected no duplicates left, got %d", mgr.Duplicates())
+	}
+
+	blocks, _ = mgr.StealBlocks(fastPeer, bf, 5, 2, 0, func(netip.AddrPort) bool { return false })
+	if len(blocks) != 0 {
+		t.Errorf("Expected nothing stolen from fast owners, stole %d", len(blocks))
+	}
+}
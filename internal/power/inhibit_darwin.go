@@ -0,0 +1,27 @@
+//go:build darwin
+
+package power
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+)
+
+// inhibit holds a caffeinate assertion against idle sleep. It watches our
+// pid, so the assertion goes with us if we die without releasing it.
+func inhibit(string) (func(), error) {
+	cmd := exec.Command("caffeinate", "-i", "-w", strconv.Itoa(os.Getpid()))
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+		})
+	}, nil
+}
@@ -0,0 +1,7 @@
+//go:build !darwin && !windows
+
+package power
+
+func inhibit(string) (func(), error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,56 @@
+//go:build windows
+
+package power
+
+import (
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procPowerCreateRequest = syscall.NewLazyDLL("kernel32.dll").NewProc("PowerCreateRequest")
+	procPowerSetRequest    = syscall.NewLazyDLL("kernel32.dll").NewProc("PowerSetRequest")
+	procPowerClearRequest  = syscall.NewLazyDLL("kernel32.dll").NewProc("PowerClearRequest")
+)
+
+const (
+	powerRequestContextSimpleString = 0x1
+	powerRequestSystemRequired      = 1
+)
+
+// reasonContext mirrors REASON_CONTEXT with a simple reason string. The
+// padding covers the larger member of its union.
+type reasonContext struct {
+	Version uint32
+	Flags   uint32
+	Reason  *uint16
+	_       [3]uintptr
+}
+
+// inhibit holds a system-required power request. Unlike
+// SetThreadExecutionState it isn't tied to the calling OS thread.
+func inhibit(reason string) (func(), error) {
+	text, err := syscall.UTF16PtrFromString(reason)
+	if err != nil {
+		return nil, err
+	}
+	ctx := reasonContext{Flags: powerRequestContextSimpleString, Reason: text}
+
+	h, _, err := procPowerCreateRequest.Call(uintptr(unsafe.Pointer(&ctx)))
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		return nil, err
+	}
+	if ok, _, err := procPowerSetRequest.Call(h, powerRequestSystemRequired); ok == 0 {
+		syscall.CloseHandle(syscall.Handle(h))
+		return nil, err
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			procPowerClearRequest.Call(h, powerRequestSystemRequired)
+			syscall.CloseHandle(syscall.Handle(h))
+		})
+	}, nil
+}
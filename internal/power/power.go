@@ -0,0 +1,156 @@
+// Package power reports whether the machine runs on battery or sits on a
+// metered network, as far as the OS lets us tell, and decides what the
+// client should do about it.
+package power
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrUnsupported = errors.New("power: status not available on this platform")
+
+// Status is the machine's power and network situation. A condition that
+// can't be detected reads as false.
+type Status struct {
+	OnBattery bool `json:"onBattery"`
+	Metered   bool `json:"metered"`
+}
+
+// Read returns the current status.
+func Read() (Status, error) {
+	return read()
+}
+
+// Inhibit keeps the system from sleeping until release is called. The
+// display may still turn off. Reason is shown where the OS lists what
+// keeps it awake.
+func Inhibit(reason string) (release func(), err error) {
+	return inhibit(reason)
+}
+
+// Policy is what to do while a condition holds.
+type Policy string
+
+const (
+	PolicyNone  Policy = ""
+	PolicyPause Policy = "pause"
+	PolicyLimit Policy = "limit"
+)
+
+// Reason names the condition a Decision was made for; it ends up in the
+// pause reason of the torrents it paused.
+type Reason string
+
+const (
+	ReasonBattery Reason = "battery"
+	ReasonMetered Reason = "metered"
+)
+
+type Config struct {
+	// OnBattery and OnMetered choose the policy while the machine runs on
+	// battery or the network connection is metered. Pause wins over limit
+	// when both conditions hold.
+	OnBattery Policy
+	OnMetered Policy
+
+	// DownloadRateLimit and UploadRateLimit are the caps PolicyLimit
+	// applies, in bytes/sec. They only ever lower the configured limits.
+	DownloadRateLimit uint64
+	UploadRateLimit   uint64
+
+	// PollInterval is how often the status is read.
+	PollInterval time.Duration
+
+	// PreventSleep keeps the machine awake while torrents are
+	// downloading or seeding, or only while downloading with
+	// SleepWhileSeeding set.
+	PreventSleep      bool
+	SleepWhileSeeding bool
+}
+
+func WithDefaultConfig() *Config {
+	return &Config{
+		OnBattery:         PolicyNone,
+		OnMetered:         PolicyNone,
+		DownloadRateLimit: 256 << 10,
+		UploadRateLimit:   32 << 10,
+		PollInterval:      30 * time.Second,
+	}
+}
+
+// Enabled reports whether any condition has a policy, i.e. whether the
+// status is worth polling at all.
+func (c *Config) Enabled() bool {
+	return c.OnBattery != PolicyNone || c.OnMetered != PolicyNone
+}
+
+// KeepAwake reports whether sleep should be prevented given whether some
+// torrent is downloading and some is seeding.
+func (c *Config) KeepAwake(downloading, seeding bool) bool {
+	if !c.PreventSleep {
+		return false
+	}
+	return downloading || (seeding && !c.SleepWhileSeeding)
+}
+
+// Decision is the policy in force for a status and the condition that
+// caused it.
+type Decision struct {
+	Policy Policy `json:"policy"`
+	Reason Reason `json:"reason,omitempty"`
+}
+
+// Decide picks the policy for s.
+func (c *Config) Decide(s Status) Decision {
+	var d Decision
+	consider := func(holds bool, p Policy, r Reason) {
+		if !holds || p == PolicyNone || d.Policy == PolicyPause {
+			return
+		}
+		if p == PolicyPause || d.Policy == PolicyNone {
+			d = Decision{Policy: p, Reason: r}
+		}
+	}
+	consider(s.OnBattery, c.OnBattery, ReasonBattery)
+	consider(s.Metered, c.OnMetered, ReasonMetered)
+	return d
+}
+
+// LimitRate applies a PolicyLimit cap to a configured rate, where 0 is
+// unlimited on either side.
+func LimitRate(configured, limit uint64) uint64 {
+	if limit == 0 {
+		return configured
+	}
+	if configured == 0 {
+		return limit
+	}
+	return min(configured, limit)
+}
+
+// parseMetered reads NetworkManager's Metered property as printed by
+// busctl, e.g. "u 1". 1 and 3 are yes and guess-yes.
+func parseMetered(out string) (bool, error) {
+	f := strings.Fields(out)
+	if len(f) != 2 || f[0] != "u" {
+		return false, errors.New("power: unexpected metered property " + strconv.Quote(out))
+	}
+	v, err := strconv.Atoi(f[1])
+	if err != nil {
+		return false, err
+	}
+	return v == 1 || v == 3, nil
+}
+
+// parsePmset reads the output of "pmset -g batt", whose first line names
+// the power source, e.g. "Now drawing from 'Battery Power'".
+func parsePmset(out string) (bool, error) {
+	line, _, _ := strings.Cut(out, "\n")
+	if !strings.Contains(line, "drawing from") {
+		return false, errors.New("power: unexpected pmset output")
+	}
+	return strings.Contains(line, "'Battery Power'"), nil
+}
@@ -0,0 +1,26 @@
+//go:build darwin
+
+package power
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+// read only knows the power source; macOS has no command line view of
+// whether the network is metered.
+func read() (Status, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "pmset", "-g", "batt").Output()
+	if err != nil {
+		return Status{}, err
+	}
+	onBattery, err := parsePmset(string(out))
+	if err != nil {
+		return Status{}, err
+	}
+	return Status{OnBattery: onBattery}, nil
+}
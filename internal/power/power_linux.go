@@ -0,0 +1,82 @@
+//go:build linux
+
+package power
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const powerSupplyDir = "/sys/class/power_supply"
+
+func read() (Status, error) {
+	onBattery, err := onBattery(powerSupplyDir)
+	if err != nil {
+		return Status{}, err
+	}
+	return Status{OnBattery: onBattery, Metered: metered()}, nil
+}
+
+// onBattery reports running from a system battery with no mains or USB
+// adapter online. Supplies scoped to a device, like a wireless mouse's
+// battery, don't count. A battery is trusted to say it is discharging
+// only when no adapter is listed at all. Machines without a battery never
+// are on battery.
+func onBattery(dir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var battery, discharging, adapters bool
+	for _, e := range entries {
+		supply := filepath.Join(dir, e.Name())
+		if sysfsValue(supply, "scope") == "Device" {
+			continue
+		}
+		switch sysfsValue(supply, "type") {
+		case "Mains", "USB":
+			if sysfsValue(supply, "online") == "1" {
+				return false, nil
+			}
+			adapters = true
+		case "Battery":
+			battery = true
+			if sysfsValue(supply, "status") == "Discharging" {
+				discharging = true
+			}
+		}
+	}
+	return battery && (adapters || discharging), nil
+}
+
+func sysfsValue(dir, name string) string {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// metered asks NetworkManager over D-Bus. Without it there is no portable
+// answer, so the connection counts as unmetered.
+func metered() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "busctl", "get-property",
+		"org.freedesktop.NetworkManager", "/org/freedesktop/NetworkManager",
+		"org.freedesktop.NetworkManager", "Metered").Output()
+	if err != nil {
+		return false
+	}
+	m, err := parseMetered(string(out))
+	return err == nil && m
+}
@@ -0,0 +1,84 @@
+//go:build linux
+
+package power
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOnBattery(t *testing.T) {
+	type supply map[string]string
+
+	tests := []struct {
+		name     string
+		supplies map[string]supply
+		want     bool
+	}{
+		{"no supplies", nil, false},
+		{
+			"adapter online",
+			map[string]supply{
+				"AC":   {"type": "Mains", "online": "1"},
+				"BAT0": {"type": "Battery", "status": "Discharging"},
+			},
+			false,
+		},
+		{
+			"adapter offline",
+			map[string]supply{
+				"AC":   {"type": "Mains", "online": "0"},
+				"BAT0": {"type": "Battery", "status": "Unknown"},
+			},
+			true,
+		},
+		{
+			"usb adapter online",
+			map[string]supply{
+				"ucsi-source-psy-USBC000:001": {"type": "USB", "online": "1"},
+				"BAT0":                        {"type": "Battery", "status": "Discharging"},
+			},
+			false,
+		},
+		{
+			"no adapter listed",
+			map[string]supply{"BAT0": {"type": "Battery", "status": "Discharging"}},
+			true,
+		},
+		{
+			"device battery only",
+			map[string]supply{
+				"AC":            {"type": "Mains", "online": "0"},
+				"hidpp_battery": {"type": "Battery", "scope": "Device", "status": "Discharging"},
+			},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, values := range tt.supplies {
+				supplyDir := filepath.Join(dir, name)
+				if err := os.Mkdir(supplyDir, 0o755); err != nil {
+					t.Fatal(err)
+				}
+				for file, value := range values {
+					path := filepath.Join(supplyDir, file)
+					if err := os.WriteFile(path, []byte(value+"\n"), 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			got, err := onBattery(dir)
+			if err != nil {
+				t.Fatalf("onBattery() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("onBattery() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !windows
+
+package power
+
+func read() (Status, error) {
+	return Status{}, ErrUnsupported
+}
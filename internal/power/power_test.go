@@ -0,0 +1,103 @@
+package power
+
+import "testing"
+
+func TestConfig_Decide(t *testing.T) {
+	cfg := &Config{OnBattery: PolicyLimit, OnMetered: PolicyPause}
+
+	tests := []struct {
+		name   string
+		status Status
+		want   Decision
+	}{
+		{"neither", Status{}, Decision{}},
+		{"battery", Status{OnBattery: true}, Decision{PolicyLimit, ReasonBattery}},
+		{"metered", Status{Metered: true}, Decision{PolicyPause, ReasonMetered}},
+		{"pause wins", Status{OnBattery: true, Metered: true}, Decision{PolicyPause, ReasonMetered}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.Decide(tt.status); got != tt.want {
+				t.Errorf("Decide(%+v) = %+v, want %+v", tt.status, got, tt.want)
+			}
+		})
+	}
+
+	if got := WithDefaultConfig().Decide(Status{OnBattery: true, Metered: true}); got != (Decision{}) {
+		t.Errorf("default config decided %+v, want nothing", got)
+	}
+}
+
+func TestLimitRate(t *testing.T) {
+	tests := []struct{ configured, limit, want uint64 }{
+		{0, 0, 0},
+		{0, 100, 100},
+		{100, 0, 100},
+		{50, 100, 50},
+		{200, 100, 100},
+	}
+	for _, tt := range tests {
+		if got := LimitRate(tt.configured, tt.limit); got != tt.want {
+			t.Errorf("LimitRate(%d, %d) = %d, want %d", tt.configured, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestParseMetered(t *testing.T) {
+	tests := []struct {
+		out     string
+		want    bool
+		wantErr bool
+	}{
+		{"u 1\n", true, false},
+		{"u 3\n", true, false},
+		{"u 2\n", false, false},
+		{"u 0\n", false, false},
+		{"s \"yes\"\n", false, true},
+		{"", false, true},
+	}
+	for _, tt := range tests {
+		got, err := parseMetered(tt.out)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseMetered(%q) = %v, %v", tt.out, got, err)
+		}
+	}
+}
+
+func TestParsePmset(t *testing.T) {
+	battery := "Now drawing from 'Battery Power'\n -InternalBattery-0 (id=1)\t87%; discharging\n"
+	ac := "Now drawing from 'AC Power'\n -InternalBattery-0 (id=1)\t100%; charged\n"
+
+	if got, err := parsePmset(battery); err != nil || !got {
+		t.Errorf("parsePmset(battery) = %v, %v", got, err)
+	}
+	if got, err := parsePmset(ac); err != nil || got {
+		t.Errorf("parsePmset(ac) = %v, %v", got, err)
+	}
+	if _, err := parsePmset("garbage"); err == nil {
+		t.Error("parsePmset(garbage) succeeded")
+	}
+}
+
+func TestConfig_KeepAwake(t *testing.T) {
+	tests := []struct {
+		name                 string
+		cfg                  Config
+		downloading, seeding bool
+		want                 bool
+	}{
+		{"off", Config{}, true, true, false},
+		{"idle", Config{PreventSleep: true}, false, false, false},
+		{"downloading", Config{PreventSleep: true}, true, false, true},
+		{"seeding", Config{PreventSleep: true}, false, true, true},
+		{"seeding allowed to sleep", Config{PreventSleep: true, SleepWhileSeeding: true}, false, true, false},
+		{"downloading while seeding", Config{PreventSleep: true, SleepWhileSeeding: true}, true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.KeepAwake(tt.downloading, tt.seeding); got != tt.want {
+				t.Errorf("KeepAwake(%v, %v) = %v, want %v", tt.downloading, tt.seeding, got, tt.want)
+			}
+		})
+	}
+}
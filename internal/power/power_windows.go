@@ -0,0 +1,32 @@
+//go:build windows
+
+package power
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetSystemPowerStatus = syscall.NewLazyDLL("kernel32.dll").NewProc("GetSystemPowerStatus")
+
+// systemPowerStatus mirrors SYSTEM_POWER_STATUS.
+type systemPowerStatus struct {
+	ACLineStatus        byte
+	BatteryFlag         byte
+	BatteryLifePercent  byte
+	SystemStatusFlag    byte
+	BatteryLifeTime     uint32
+	BatteryFullLifeTime uint32
+}
+
+// read only knows the power source; the metered cost of a connection is
+// behind WinRT APIs we don't bind.
+func read() (Status, error) {
+	var sps systemPowerStatus
+	ok, _, err := procGetSystemPowerStatus.Call(uintptr(unsafe.Pointer(&sps)))
+	if ok == 0 {
+		return Status{}, err
+	}
+	// ACLineStatus is 0 offline, 1 online and 255 unknown.
+	return Status{OnBattery: sps.ACLineStatus == 0}, nil
+}
@@ -0,0 +1,105 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prxssh/rabbit/internal/bencode"
+	"github.com/prxssh/rabbit/pkg/cast"
+)
+
+// ExtHandshakeID is the extended message id of the extension handshake.
+// Every other id is one the receiver assigned in its handshake's "m".
+const ExtHandshakeID = 0
+
+var ErrBadExtendedHandshake = errors.New("protocol: malformed extended handshake")
+
+// ExtendedHandshake is the BEP 10 handshake, sent after the BitTorrent
+// handshake by peers that both set the extension bit, and again whenever
+// one of its fields changes.
+type ExtendedHandshake struct {
+	// Messages maps the extension messages the sender understands to the
+	// ids it wants them sent with; 0 disables one.
+	Messages map[string]int
+	// Client is the sender's name and version, e.g. "rabbit 0.1.0".
+	Client string
+	// UploadOnly is the BEP 21 flag of a peer that wants no more pieces:
+	// a seed, or a partial seed done with the files it selected.
+	UploadOnly bool
+	// Port is the sender's listen port, "p" on the wire, so a peer that
+	// connected to us can tell others where to reach us. 0 leaves it out.
+	Port uint16
+}
+
+// MessageExtended wraps an extension message payload.
+func MessageExtended(id uint8, payload []byte) *Message {
+	buf := make([]byte, 1+len(payload))
+	buf[0] = id
+	copy(buf[1:], payload)
+	return &Message{ID: Extended, Payload: buf}
+}
+
+// MessageExtendedHandshake encodes h as an extension handshake.
+func MessageExtendedHandshake(h ExtendedHandshake) (*Message, error) {
+	m := make(map[string]any, len(h.Messages))
+	for name, id := range h.Messages {
+		m[name] = id
+	}
+
+	dict := map[string]any{"m": m}
+	if h.Client != "" {
+		dict["v"] = h.Client
+	}
+	if h.UploadOnly {
+		dict["upload_only"] = 1
+	}
+	if h.Port != 0 {
+		dict["p"] = int(h.Port)
+	}
+
+	payload, err := bencode.Marshal(dict)
+	if err != nil {
+		return nil, err
+	}
+	return MessageExtended(ExtHandshakeID, payload), nil
+}
+
+// ParseExtended splits an extended message into its extension id and
+// payload.
+func (m *Message) ParseExtended() (id uint8, payload []byte, ok bool) {
+	if m == nil || m.ID != Extended || len(m.Payload) < 1 {
+		return 0, nil, false
+	}
+	return m.Payload[0], m.Payload[1:], true
+}
+
+// ParseExtendedHandshake decodes the payload of an extension handshake.
+// Unknown keys are ignored, as are "m" entries that aren't integers.
+func ParseExtendedHandshake(payload []byte) (ExtendedHandshake, error) {
+	raw, err := bencode.Unmarshal(payload)
+	if err != nil {
+		return ExtendedHandshake{}, fmt.Errorf("%w: %w", ErrBadExtendedHandshake, err)
+	}
+	dict, ok := raw.(map[string]any)
+	if !ok {
+		return ExtendedHandshake{}, fmt.Errorf("%w: expected dict but got %T", ErrBadExtendedHandshake, raw)
+	}
+
+	var h ExtendedHandshake
+	if m, ok := dict["m"].(map[string]any); ok {
+		h.Messages = make(map[string]int, len(m))
+		for name, v := range m {
+			if id, err := cast.ToInt(v); err == nil {
+				h.Messages[name] = int(id)
+			}
+		}
+	}
+	h.Client, _ = cast.ToString(dict["v"])
+	if uploadOnly, err := cast.ToInt(dict["upload_only"]); err == nil {
+		h.UploadOnly = uploadOnly != 0
+	}
+	if port, err := cast.ToInt(dict["p"]); err == nil && port > 0 && port <= 0xffff {
+		h.Port = uint16(port)
+	}
+	return h, nil
+}
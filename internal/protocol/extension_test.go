@@ -0,0 +1,64 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExtendedHandshake_RoundTrip(t *testing.T) {
+	want := ExtendedHandshake{
+		Messages:   map[string]int{"ut_metadata": 2},
+		Client:     "rabbit 0.1.0",
+		UploadOnly: true,
+		Port:       51413,
+	}
+
+	m, err := MessageExtendedHandshake(want)
+	if err != nil {
+		t.Fatalf("MessageExtendedHandshake error: %v", err)
+	}
+	if err := m.ValidatePayloadSize(); err != nil {
+		t.Fatalf("ValidatePayloadSize(Extended) err: %v", err)
+	}
+
+	id, payload, ok := m.ParseExtended()
+	if !ok || id != ExtHandshakeID {
+		t.Fatalf("ParseExtended = (%d,%v), want (%d,true)", id, ok, ExtHandshakeID)
+	}
+
+	got, err := ParseExtendedHandshake(payload)
+	if err != nil {
+		t.Fatalf("ParseExtendedHandshake error: %v", err)
+	}
+	if got.Client != want.Client || got.UploadOnly != want.UploadOnly || got.Port != want.Port {
+		t.Fatalf("handshake = %+v, want %+v", got, want)
+	}
+	if got.Messages["ut_metadata"] != 2 || len(got.Messages) != 1 {
+		t.Fatalf("Messages = %v, want %v", got.Messages, want.Messages)
+	}
+}
+
+func TestParseExtendedHandshake_Foreign(t *testing.T) {
+	payload := []byte("d1:md11:ut_metadatai3e6:ut_pex3:bade1:pi6881e11:upload_onlyi0e1:v13:qBittorrent 5e")
+
+	got, err := ParseExtendedHandshake(payload)
+	if err != nil {
+		t.Fatalf("ParseExtendedHandshake error: %v", err)
+	}
+	if got.UploadOnly {
+		t.Error("UploadOnly = true, want false")
+	}
+	if got.Client != "qBittorrent 5" {
+		t.Errorf("Client = %q, want %q", got.Client, "qBittorrent 5")
+	}
+	if got.Port != 6881 {
+		t.Errorf("Port = %d, want 6881", got.Port)
+	}
+	if _, ok := got.Messages["ut_pex"]; ok || got.Messages["ut_metadata"] != 3 {
+		t.Errorf("Messages = %v, want only ut_metadata=3", got.Messages)
+	}
+
+	if _, err := ParseExtendedHandshake([]byte("li1ee")); !errors.Is(err, ErrBadExtendedHandshake) {
+		t.Errorf("list payload error = %v, want ErrBadExtendedHandshake", err)
+	}
+}
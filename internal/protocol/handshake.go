@@ -12,6 +12,9 @@ const (
 	reservedN  = 8
 )
 
+// HandshakeLen is the wire size of a canonical handshake.
+const HandshakeLen = 1 + len(btProtocol) + reservedN + sha1.Size + sha1.Size
+
 // Handshake represents the initial BitTorrent wire handshake.
 //
 // Wire format (in bytes):
@@ -58,6 +61,24 @@ func NewHandshake(infoHash, peerID [sha1.Size]byte) *Handshake {
 	}
 }
 
+// extensionByte and extensionBit locate the reserved bit advertising the
+// BEP 10 extension protocol.
+const (
+	extensionByte = 5
+	extensionBit  = 0x10
+)
+
+// EnableExtensions advertises support for the extension protocol.
+func (h *Handshake) EnableExtensions() {
+	h.Reserved[extensionByte] |= extensionBit
+}
+
+// SupportsExtensions reports whether the sender speaks the extension
+// protocol.
+func (h Handshake) SupportsExtensions() bool {
+	return h.Reserved[extensionByte]&extensionBit != 0
+}
+
 // MarshalBinary encodes the handshake into its wire representation.
 //
 // The result can be written directly to a network connection or buffer.
@@ -73,7 +94,7 @@ func (h *Handshake) MarshalBinary() ([]byte, error) {
 	buf[0] = byte(len(h.Pstr))
 	offset := 1
 	offset += copy(buf[offset:], []byte(h.Pstr))
-	offset += copy(buf[offset:], make([]byte, reservedN))
+	offset += copy(buf[offset:], h.Reserved[:])
 	offset += copy(buf[offset:], h.InfoHash[:])
 	offset += copy(buf[offset:], h.PeerID[:])
 
@@ -160,6 +181,51 @@ func ReadHandshake(r io.Reader) (Handshake, error) {
 	return h, err
 }
 
+// ReadHandshakeHead reads an incoming handshake up to and including its
+// info hash, leaving the peer ID unread. That is enough for a listener
+// serving several torrents to pick one, or drop the connection, before
+// answering; ReadPeerID finishes the read.
+func ReadHandshakeHead(r io.Reader) (Handshake, error) {
+	var hdr [1]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return Handshake{}, shortRead(err)
+	}
+	pstrlen := int(hdr[0])
+	if pstrlen == 0 {
+		return Handshake{}, ErrBadPstrlen
+	}
+
+	rest := make([]byte, pstrlen+reservedN+sha1.Size)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return Handshake{}, shortRead(err)
+	}
+
+	h := Handshake{Pstr: string(rest[:pstrlen])}
+	if h.Pstr != btProtocol {
+		return Handshake{}, ErrProtocolMismatch
+	}
+	copy(h.Reserved[:], rest[pstrlen:pstrlen+reservedN])
+	copy(h.InfoHash[:], rest[pstrlen+reservedN:])
+	return h, nil
+}
+
+// ReadPeerID reads the peer ID that ends a handshake whose head was read
+// by ReadHandshakeHead.
+func ReadPeerID(r io.Reader) ([sha1.Size]byte, error) {
+	var id [sha1.Size]byte
+	if _, err := io.ReadFull(r, id[:]); err != nil {
+		return id, shortRead(err)
+	}
+	return id, nil
+}
+
+func shortRead(err error) error {
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrShortHandshake
+	}
+	return err
+}
+
 // WriteHandshake writes h to w in wire format.
 func WriteHandshake(w io.Writer, h Handshake) error {
 	_, err := h.WriteTo(w)
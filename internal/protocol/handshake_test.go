@@ -130,6 +130,70 @@ func TestHandshake_ReadWrite_Wrappers(t *testing.T) {
 	}
 }
 
+func TestHandshake_Extensions(t *testing.T) {
+	h := NewHandshake(mustBytes20("info_hash_1234567890"), mustBytes20("peer_id_1234567890_"))
+	if h.SupportsExtensions() {
+		t.Fatal("new handshake advertises extensions")
+	}
+	h.EnableExtensions()
+
+	b, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error: %v", err)
+	}
+	var got Handshake
+	if err := (&got).UnmarshalBinary(b); err != nil {
+		t.Fatalf("UnmarshalBinary error: %v", err)
+	}
+	if !got.SupportsExtensions() {
+		t.Fatalf("extension bit lost: reserved %v", got.Reserved)
+	}
+}
+
+func TestReadHandshakeHead(t *testing.T) {
+	info := mustBytes20("info_hash_1234567890")
+	peer := mustBytes20("peer_id_1234567890_")
+	h := NewHandshake(info, peer)
+	h.Reserved[5] = 0x10
+
+	b, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error: %v", err)
+	}
+	rd := bytes.NewReader(b)
+
+	head, err := ReadHandshakeHead(rd)
+	if err != nil {
+		t.Fatalf("ReadHandshakeHead error: %v", err)
+	}
+	if head.InfoHash != info {
+		t.Fatalf("InfoHash mismatch: got %x, want %x", head.InfoHash, info)
+	}
+	if rd.Len() != sha1.Size {
+		t.Fatalf("head read %d bytes, want %d", len(b)-rd.Len(), len(b)-sha1.Size)
+	}
+
+	id, err := ReadPeerID(rd)
+	if err != nil {
+		t.Fatalf("ReadPeerID error: %v", err)
+	}
+	if id != peer {
+		t.Fatalf("PeerID mismatch: got %x, want %x", id, peer)
+	}
+}
+
+func TestReadHandshakeHead_Bad(t *testing.T) {
+	other := append([]byte{19}, []byte(strings.Repeat("x", 19+reservedN+sha1.Size))...)
+	if _, err := ReadHandshakeHead(bytes.NewReader(other)); !errors.Is(err, ErrProtocolMismatch) {
+		t.Fatalf("want ErrProtocolMismatch, got %v", err)
+	}
+
+	short := append([]byte{19}, []byte(btProtocol)...)
+	if _, err := ReadHandshakeHead(bytes.NewReader(short)); !errors.Is(err, ErrShortHandshake) {
+		t.Fatalf("want ErrShortHandshake, got %v", err)
+	}
+}
+
 // rwPair allows reading from a fixed reader and capturing writes.
 type rwPair struct {
 	io.Reader
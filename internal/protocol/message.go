@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 )
 
 type MessageID uint8
@@ -20,6 +21,7 @@ const (
 	Request       MessageID = 6
 	Piece         MessageID = 7
 	Cancel        MessageID = 8
+	Extended      MessageID = 20
 )
 
 func (mid MessageID) String() string {
@@ -42,6 +44,8 @@ func (mid MessageID) String() string {
 		return "Piece"
 	case Cancel:
 		return "Cancel"
+	case Extended:
+		return "Extended"
 	default:
 		return fmt.Sprintf("Unknown(%d)", mid)
 	}
@@ -54,17 +58,25 @@ func (mid MessageID) String() string {
 //	keep-alive: <length=0>
 //	otherwise: <length:4><id:1><payload:length-1>
 //
-// A nil *Message denotes a keep-alive frame.
-// For non-nil messages, Payload may be empty for messages that carry no data.
+// A keep-alive is a Message with KeepAlive set; it has no id or payload.
+// Payload may be empty for other messages that carry no data.
 type Message struct {
-	ID      MessageID
-	Payload []byte
+	ID        MessageID
+	Payload   []byte
+	KeepAlive bool
 }
 
+// MaxMessageLength is the largest length prefix we accept: a 256 KiB
+// payload plus the piece message header (id, index, begin). It also fits
+// the bitfield of any torrent with up to two million pieces.
+const MaxMessageLength = 256<<10 + 9
+
 var (
 	ErrShortMessage    = errors.New("protocol: short message")
 	ErrBadLengthPrefix = errors.New("protocol: invalid length prefix")
 	ErrBadPayloadSize  = errors.New("protocol: invalid payload size for message")
+	ErrMessageTooLarge = errors.New("protocol: message exceeds maximum length")
+	ErrNilMessage      = errors.New("protocol: nil message")
 )
 
 var (
@@ -74,10 +86,10 @@ var (
 	_ io.ReaderFrom              = (*Message)(nil)
 )
 
-// IsKeepAlive reports whether m denotes a keep-alive frame.
-// By convention, a nil *Message is a keep-alive.
-func IsKeepAlive(m *Message) bool { return m == nil }
+// IsKeepAlive reports whether m is a keep-alive frame.
+func IsKeepAlive(m *Message) bool { return m != nil && m.KeepAlive }
 
+func MessageKeepAlive() *Message     { return &Message{KeepAlive: true} }
 func MessageChoke() *Message         { return &Message{ID: Choke} }
 func MessageUnchoke() *Message       { return &Message{ID: Unchoke} }
 func MessageInterested() *Message    { return &Message{ID: Interested} }
@@ -147,6 +159,19 @@ func (m *Message) ParseRequest() (idx, begin, length uint32, ok bool) {
 		true
 }
 
+// ParseCancel parses a Cancel payload into index, begin, and length.
+// ok is false if the payload length is not exactly 12 bytes.
+func (m *Message) ParseCancel() (idx, begin, length uint32, ok bool) {
+	if m == nil || m.ID != Cancel || len(m.Payload) != 12 {
+		return 0, 0, 0, false
+	}
+
+	return binary.BigEndian.Uint32(m.Payload[0:4]),
+		binary.BigEndian.Uint32(m.Payload[4:8]),
+		binary.BigEndian.Uint32(m.Payload[8:12]),
+		true
+}
+
 // ParsePiece parses a Piece payload into index, begin, and the data block.
 // ok is false if there are fewer than 8 bytes of header.
 func (m *Message) ParsePiece() (idx, begin uint32, block []byte, ok bool) {
@@ -159,8 +184,31 @@ func (m *Message) ParsePiece() (idx, begin uint32, block []byte, ok bool) {
 		m.Payload[8:], true
 }
 
+// WireLen returns the number of bytes m occupies on the wire, including the
+// 4-byte length prefix.
+func (m *Message) WireLen() int {
+	if m == nil || m.KeepAlive {
+		return 4
+	}
+
+	return 5 + len(m.Payload)
+}
+
+// DataLen returns the number of piece data bytes carried by m. Everything
+// else on the wire is protocol overhead.
+func (m *Message) DataLen() int {
+	if m == nil || m.ID != Piece || len(m.Payload) < 8 {
+		return 0
+	}
+
+	return len(m.Payload) - 8
+}
+
 func (m *Message) MarshalBinary() ([]byte, error) {
-	if m == nil {
+	if err := m.ValidatePayloadSize(); err != nil {
+		return nil, err
+	}
+	if m.KeepAlive {
 		return []byte{0, 0, 0, 0}, nil
 	}
 
@@ -188,27 +236,33 @@ func (m *Message) UnmarshalBinary(b []byte) error {
 
 	length := binary.BigEndian.Uint32(b[0:4])
 	if length == 0 {
-		*m = Message{}
+		*m = Message{KeepAlive: true}
 		return nil
 	}
+	if length > MaxMessageLength {
+		return ErrMessageTooLarge
+	}
 	if len(b) < 4+int(length) {
 		return ErrShortMessage
 	}
 
-	id := b[4]
 	payload := b[5 : 4+int(length)]
-	m.ID = MessageID(id)
+	m.ID = MessageID(b[4])
 	m.Payload = append(m.Payload[:0], payload...)
+	m.KeepAlive = false
 
 	return nil
 }
 
 // WriteTo implements io.WriterTo.
 //
-// For keep-alive (m==nil), it writes 4 zero bytes.
+// For keep-alive, it writes 4 zero bytes.
 // For normal messages, it writes the 4-byte length prefix, id, and payload.
 func (m *Message) WriteTo(w io.Writer) (int64, error) {
-	if m == nil {
+	if err := m.ValidatePayloadSize(); err != nil {
+		return 0, err
+	}
+	if m.KeepAlive {
 		var z [4]byte
 		n, err := w.Write(z[:])
 		return int64(n), err
@@ -234,9 +288,8 @@ func (m *Message) WriteTo(w io.Writer) (int64, error) {
 
 // ReadFrom implements io.ReaderFrom.
 //
-// It reads a full message frame from r. For keep-alive (length=0),
-// the receiver is zeroed (ID=0, Payload=nil) and the caller can use IsKeepAlive(nil)
-// convention by checking the return of ReadMessage wrapper.
+// It reads a full message frame from r. A zero length prefix yields a
+// keep-alive.
 func (m *Message) ReadFrom(r io.Reader) (int64, error) {
 	var lp [4]byte
 	if _, err := io.ReadFull(r, lp[:]); err != nil {
@@ -245,12 +298,17 @@ func (m *Message) ReadFrom(r io.Reader) (int64, error) {
 
 	length := binary.BigEndian.Uint32(lp[:])
 	if length == 0 {
-		*m = Message{} // keep-alive frame
+		*m = Message{KeepAlive: true}
 		return 4, nil
 	}
 	if length < 1 {
 		return 4, ErrBadLengthPrefix
 	}
+	// Checked before allocating so a peer can't make us reserve up to
+	// 4 GiB with a single length prefix.
+	if length > MaxMessageLength {
+		return 4, ErrMessageTooLarge
+	}
 
 	buf := make([]byte, length)
 	if _, err := io.ReadFull(r, buf); err != nil {
@@ -258,6 +316,7 @@ func (m *Message) ReadFrom(r io.Reader) (int64, error) {
 	}
 	m.ID = MessageID(buf[0])
 	m.Payload = append(m.Payload[:0], buf[1:]...)
+	m.KeepAlive = false
 
 	return int64(4 + len(buf)), nil
 }
@@ -267,25 +326,55 @@ func ReadMessage(r io.Reader) (*Message, error) {
 	if _, err := m.ReadFrom(r); err != nil {
 		return nil, err
 	}
-
-	// Normalize keep-alive to nil.
-	if m.Payload == nil && m.ID == 0 {
-		return nil, nil
-	}
-
 	return &m, nil
 }
 
 // WriteMessage writes m to w.
-// If m is nil, it writes a keep-alive frame.
 func WriteMessage(w io.Writer, m *Message) error {
 	_, err := m.WriteTo(w)
 	return err
 }
 
+// WriteMessages writes msgs back to back with a single vectored write
+// where w supports one, such as a TCP connection, instead of two writes
+// per message. Payloads are not copied.
+func WriteMessages(w io.Writer, msgs []*Message) (int64, error) {
+	for _, m := range msgs {
+		if err := m.ValidatePayloadSize(); err != nil {
+			return 0, err
+		}
+	}
+
+	hdrs := make([]byte, 5*len(msgs))
+	bufs := make(net.Buffers, 0, 2*len(msgs))
+	for i, m := range msgs {
+		hdr := hdrs[5*i : 5*i+5]
+		if m.KeepAlive {
+			bufs = append(bufs, hdr[:4])
+			continue
+		}
+
+		binary.BigEndian.PutUint32(hdr[0:4], uint32(1+len(m.Payload)))
+		hdr[4] = byte(m.ID)
+		bufs = append(bufs, hdr)
+		if len(m.Payload) > 0 {
+			bufs = append(bufs, m.Payload)
+		}
+	}
+
+	return bufs.WriteTo(w)
+}
+
 func (m *Message) ValidatePayloadSize() error {
 	if m == nil {
-		return nil // keep-alive
+		return ErrNilMessage
+	}
+
+	if m.KeepAlive {
+		if len(m.Payload) != 0 {
+			return ErrBadPayloadSize
+		}
+		return nil
 	}
 
 	switch m.ID {
@@ -301,6 +390,10 @@ func (m *Message) ValidatePayloadSize() error {
 		if len(m.Payload) < 8 {
 			return ErrBadPayloadSize
 		}
+	case Extended:
+		if len(m.Payload) < 1 {
+			return ErrBadPayloadSize
+		}
 	}
 	return nil
 }
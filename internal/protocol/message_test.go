@@ -8,7 +8,7 @@ import (
 )
 
 func TestMessage_KeepAlive_MarshalUnmarshal(t *testing.T) {
-	var m *Message
+	m := MessageKeepAlive()
 	b, err := m.MarshalBinary()
 	if err != nil {
 		t.Fatalf("MarshalBinary keep-alive error: %v", err)
@@ -22,11 +22,42 @@ func TestMessage_KeepAlive_MarshalUnmarshal(t *testing.T) {
 	if err := (&dec).UnmarshalBinary(b); err != nil {
 		t.Fatalf("UnmarshalBinary keep-alive: %v", err)
 	}
-	if dec.ID != 0 || dec.Payload != nil {
+	if !IsKeepAlive(&dec) || dec.Payload != nil {
 		t.Fatalf("decoded keep-alive unexpected: %+v", dec)
 	}
 }
 
+func TestMessage_NilAndUnknownID(t *testing.T) {
+	var m *Message
+	if _, err := m.MarshalBinary(); !errors.Is(err, ErrNilMessage) {
+		t.Errorf("MarshalBinary(nil) error = %v, want ErrNilMessage", err)
+	}
+	if err := WriteMessage(&bytes.Buffer{}, nil); !errors.Is(err, ErrNilMessage) {
+		t.Errorf("WriteMessage(nil) error = %v, want ErrNilMessage", err)
+	}
+	if IsKeepAlive(nil) {
+		t.Error("IsKeepAlive(nil) = true")
+	}
+
+	// Unknown ids, 255 included, are plain messages for the caller to
+	// ignore.
+	frame := []byte{0, 0, 0, 2, 0xFF, 7}
+	m, err := ReadMessage(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatalf("ReadMessage(id 255) error: %v", err)
+	}
+	if m.ID != 0xFF || IsKeepAlive(m) || !bytes.Equal(m.Payload, []byte{7}) {
+		t.Errorf("ReadMessage(id 255) = %+v", m)
+	}
+	dec := Message{KeepAlive: true}
+	if err := dec.UnmarshalBinary(frame); err != nil {
+		t.Fatalf("UnmarshalBinary(id 255) error: %v", err)
+	}
+	if dec.ID != 0xFF || IsKeepAlive(&dec) {
+		t.Errorf("UnmarshalBinary(id 255) = %+v", dec)
+	}
+}
+
 func TestMessage_ConstructorsAndParsers(t *testing.T) {
 	// Have
 	m := MessageHave(42)
@@ -47,6 +78,16 @@ func TestMessage_ConstructorsAndParsers(t *testing.T) {
 		t.Fatalf("ValidatePayloadSize(Request) err: %v", err)
 	}
 
+	// Cancel
+	m = MessageCancel(7, 16, 16384)
+	i, b, l, ok = m.ParseCancel()
+	if !ok || i != 7 || b != 16 || l != 16384 {
+		t.Fatalf("ParseCancel got (%d,%d,%d,%v)", i, b, l, ok)
+	}
+	if _, _, _, ok := MessageRequest(7, 16, 16384).ParseCancel(); ok {
+		t.Fatalf("ParseCancel accepted a Request")
+	}
+
 	// Piece
 	block := []byte("data block")
 	m = MessagePiece(3, 32, block)
@@ -67,6 +108,29 @@ func TestMessage_ConstructorsAndParsers(t *testing.T) {
 	}
 }
 
+func TestMessage_WireLenAndDataLen(t *testing.T) {
+	tests := []struct {
+		name     string
+		m        *Message
+		wantWire int
+		wantData int
+	}{
+		{name: "keep-alive", m: MessageKeepAlive(), wantWire: 4, wantData: 0},
+		{name: "choke", m: MessageChoke(), wantWire: 5, wantData: 0},
+		{name: "have", m: MessageHave(1), wantWire: 9, wantData: 0},
+		{name: "piece", m: MessagePiece(1, 0, make([]byte, 100)), wantWire: 113, wantData: 100},
+	}
+
+	for _, tt := range tests {
+		if got := tt.m.WireLen(); got != tt.wantWire {
+			t.Errorf("%s: WireLen() = %d, want %d", tt.name, got, tt.wantWire)
+		}
+		if got := tt.m.DataLen(); got != tt.wantData {
+			t.Errorf("%s: DataLen() = %d, want %d", tt.name, got, tt.wantData)
+		}
+	}
+}
+
 func TestMessage_ValidatePayloadSize_Errors(t *testing.T) {
 	tests := []Message{
 		{ID: Have, Payload: []byte{}},
@@ -121,15 +185,60 @@ func TestMessage_WriteRead_RoundTrip(t *testing.T) {
 	}
 }
 
-func TestReadMessage_KeepAliveNormalization(t *testing.T) {
+func TestWriteMessages(t *testing.T) {
+	msgs := []*Message{
+		MessageRequest(1, 0, 16384),
+		MessageKeepAlive(),
+		MessageChoke(),
+		MessagePiece(2, 16384, []byte("block")),
+		MessageHave(7),
+	}
+
+	var want bytes.Buffer
+	for _, m := range msgs {
+		if err := WriteMessage(&want, m); err != nil {
+			t.Fatalf("WriteMessage error: %v", err)
+		}
+	}
+
+	var got bytes.Buffer
+	n, err := WriteMessages(&got, msgs)
+	if err != nil {
+		t.Fatalf("WriteMessages error: %v", err)
+	}
+	if int(n) != want.Len() || !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Fatalf("WriteMessages wrote %x (%d), want %x", got.Bytes(), n, want.Bytes())
+	}
+
+	if _, err := WriteMessages(&got, []*Message{{ID: Have}}); !errors.Is(err, ErrBadPayloadSize) {
+		t.Fatalf("WriteMessages(bad) error = %v, want ErrBadPayloadSize", err)
+	}
+}
+
+func TestReadMessage_KeepAlive(t *testing.T) {
 	// 4 zero bytes represent a keep-alive
 	r := bytes.NewReader([]byte{0, 0, 0, 0})
 	m, err := ReadMessage(r)
 	if err != nil {
 		t.Fatalf("ReadMessage error: %v", err)
 	}
-	if m != nil {
-		t.Fatalf("want nil for keep-alive, got %+v", m)
+	if !IsKeepAlive(m) {
+		t.Fatalf("want keep-alive, got %+v", m)
+	}
+}
+
+func TestReadMessage_Choke(t *testing.T) {
+	// A choke has no payload and id 0; it must not read as a keep-alive.
+	var buf bytes.Buffer
+	if err := WriteMessage(&buf, MessageChoke()); err != nil {
+		t.Fatalf("WriteMessage error: %v", err)
+	}
+	m, err := ReadMessage(&buf)
+	if err != nil {
+		t.Fatalf("ReadMessage error: %v", err)
+	}
+	if m.ID != Choke || IsKeepAlive(m) {
+		t.Fatalf("ReadMessage = %+v, want Choke", m)
 	}
 }
 
@@ -147,3 +256,27 @@ func TestMessage_ReadFrom_Errors(t *testing.T) {
 		t.Fatalf("expected error for truncated message, got nil")
 	}
 }
+
+func TestMessage_ReadFrom_TooLarge(t *testing.T) {
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], 0xFFFFFFFF)
+
+	var m Message
+	if _, err := (&m).ReadFrom(bytes.NewReader(hdr[:])); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("ReadFrom() error = %v, want ErrMessageTooLarge", err)
+	}
+	if err := (&m).UnmarshalBinary(hdr[:]); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("UnmarshalBinary() error = %v, want ErrMessageTooLarge", err)
+	}
+
+	// The largest allowed frame still reads.
+	block := make([]byte, MaxMessageLength-9)
+	msg := MessagePiece(1, 0, block)
+	var buf bytes.Buffer
+	if _, err := msg.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	if _, err := (&m).ReadFrom(&buf); err != nil {
+		t.Fatalf("ReadFrom(max frame) error = %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package scheduler
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+const (
+	// maxDeadlinePressure caps how far missed deadlines push the picker.
+	maxDeadlinePressure = 3
+	// deadlineRecovery is how many deadline pieces in a row must arrive
+	// on time for the pressure to ease by one.
+	deadlineRecovery = 8
+	// deadlineDecay is how long the pressure lasts without a miss before
+	// easing by one, so it wears off once streaming stops rather than
+	// staying up for the next reader.
+	deadlineDecay = 30 * time.Second
+)
+
+// DeadlineMiss is a piece verified after its deadline.
+type DeadlineMiss struct {
+	Piece uint32        `json:"piece"`
+	Late  time.Duration `json:"late"`
+	// Pressure is the deadline pressure after the miss.
+	Pressure int `json:"pressure"`
+}
+
+// OnDeadlineMiss sets fn to be called, outside the scheduler's locks, when
+// a piece is verified after its deadline, so a player can rebuffer
+// instead of stalling. Set it before Run.
+func (s *Scheduler) OnDeadlineMiss(fn func(DeadlineMiss)) {
+	s.onDeadlineMiss = fn
+}
+
+// DeadlinePressure is how far behind its deadlines streaming has
+// fallen, from 0 to 3. Each miss raises it; a run of pieces on time, or
+// a while without misses, lowers it. While it's up, readers fetch
+// further ahead and fewer blocks are duplicated by stealing, leaving
+// bandwidth to the pieces that are due.
+func (s *Scheduler) DeadlinePressure() int {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	pressure, _ := s.decayedPressureLocked()
+	return pressure
+}
+
+// decayedPressureLocked returns the deadline pressure once the time since
+// it last changed has worn it down, and when that decay started. Called
+// with s.mut held.
+func (s *Scheduler) decayedPressureLocked() (int, time.Time) {
+	if s.deadlinePressure == 0 {
+		return 0, s.deadlinePressureAt
+	}
+
+	steps := int(s.clock.Since(s.deadlinePressureAt) / deadlineDecay)
+	if steps >= s.deadlinePressure {
+		return 0, s.deadlinePressureAt
+	}
+	return s.deadlinePressure - steps, s.deadlinePressureAt.Add(time.Duration(steps) * deadlineDecay)
+}
+
+// DeadlineMisses counts the pieces verified after their deadline.
+func (s *Scheduler) DeadlineMisses() uint64 {
+	return s.deadlineMisses.Load()
+}
+
+// checkDeadlineLocked updates the deadline pressure for pieceIdx being
+// verified now, and returns the miss if it was late. Called with s.mut
+// held, before pieceDone drops the deadline.
+func (s *Scheduler) checkDeadlineLocked(pieceIdx uint32) (DeadlineMiss, bool) {
+	deadline, ok := s.deadlines[pieceIdx]
+	if !ok {
+		return DeadlineMiss{}, false
+	}
+	s.deadlinePressure, s.deadlinePressureAt = s.decayedPressureLocked()
+
+	late := s.clock.Since(deadline)
+	if late <= 0 {
+		s.deadlinesOnTime++
+		if s.deadlinesOnTime >= deadlineRecovery && s.deadlinePressure > 0 {
+			s.deadlinePressure--
+			s.deadlinePressureAt = s.clock.Now()
+			s.deadlinesOnTime = 0
+		}
+		return DeadlineMiss{}, false
+	}
+
+	s.deadlineMisses.Add(1)
+	s.deadlinesOnTime = 0
+	s.deadlinePressure = min(s.deadlinePressure+1, maxDeadlinePressure)
+	s.deadlinePressureAt = s.clock.Now()
+	return DeadlineMiss{Piece: pieceIdx, Late: late, Pressure: s.deadlinePressure}, true
+}
+
+// SetPieceDeadline asks for a piece to be fetched ahead of the regular
+// download strategy. Pieces with earlier deadlines are requested first.
+func (s *Scheduler) SetPieceDeadline(pieceIdx uint32, deadline time.Time) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.downloadedPieces.Has(int(pieceIdx)) {
+		return
+	}
+	s.deadlines[pieceIdx] = deadline
+}
+
+func (s *Scheduler) ClearPieceDeadline(pieceIdx uint32) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	delete(s.deadlines, pieceIdx)
+}
+
+// WaitPiece blocks until the piece is verified and on disk, or ctx is done.
+func (s *Scheduler) WaitPiece(ctx context.Context, pieceIdx uint32) error {
+	s.mut.Lock()
+	if s.downloadedPieces.Has(int(pieceIdx)) {
+		s.mut.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	s.pieceWaiters[pieceIdx] = append(s.pieceWaiters[pieceIdx], ch)
+	s.mut.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// deadlinePieces returns the pieces with a deadline that the given bitfield
+// has, earliest first.
+func (s *Scheduler) deadlinePieces(has func(int) bool) []uint32 {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	if len(s.deadlines) == 0 {
+		return nil
+	}
+
+	out := make([]uint32, 0, len(s.deadlines))
+	for idx := range s.deadlines {
+		if has(int(idx)) {
+			out = append(out, idx)
+		}
+	}
+	slices.SortFunc(out, func(a, b uint32) int {
+		return s.deadlines[a].Compare(s.deadlines[b])
+	})
+
+	return out
+}
+
+// pieceDone must be called with s.mut held.
+func (s *Scheduler) pieceDone(pieceIdx uint32) {
+	if s.downloadedPieces.Set(int(pieceIdx)) && !s.skippedLocked(int(pieceIdx)) {
+		s.wantedMissing--
+	}
+	delete(s.deadlines, pieceIdx)
+
+	for _, ch := range s.pieceWaiters[pieceIdx] {
+		close(ch)
+	}
+	delete(s.pieceWaiters, pieceIdx)
+}
@@ -0,0 +1,130 @@
+package scheduler
+
+import (
+	"math/bits"
+
+	"github.com/prxssh/rabbit/pkg/bitfield"
+)
+
+// pieceHolders is the peers' bitfields turned around: for every piece, the
+// set of connected peers that have it, as bits over peer slots. It lets
+// deadline and endgame requests go to the fastest holders of a piece
+// without scanning every peer's bitfield. Guarded by s.peerMut.
+type pieceHolders struct {
+	pieces int
+	// words is how many uint64s each piece's row takes.
+	words int
+	rows  []uint64
+	// peers maps a slot to its peer; nil slots are free.
+	peers []*peerState
+}
+
+func newPieceHolders(pieces, peers int) *pieceHolders {
+	words := max(1, (peers+63)/64)
+	return &pieceHolders{
+		pieces: pieces,
+		words:  words,
+		rows:   make([]uint64, pieces*words),
+		peers:  make([]*peerState, words*64),
+	}
+}
+
+// add gives peer a slot, growing the rows when all are taken.
+func (h *pieceHolders) add(peer *peerState) {
+	for slot, p := range h.peers {
+		if p == nil {
+			h.peers[slot] = peer
+			peer.slot = slot
+			return
+		}
+	}
+
+	slot := len(h.peers)
+	h.grow(h.words * 2)
+	h.peers[slot] = peer
+	peer.slot = slot
+}
+
+func (h *pieceHolders) grow(words int) {
+	rows := make([]uint64, h.pieces*words)
+	for i := range h.pieces {
+		copy(rows[i*words:], h.rows[i*h.words:(i+1)*h.words])
+	}
+	h.rows, h.words = rows, words
+	h.peers = append(h.peers, make([]*peerState, words*64-len(h.peers))...)
+}
+
+// remove clears peer from every piece and frees its slot.
+func (h *pieceHolders) remove(peer *peerState) {
+	if peer.slot < 0 || peer.slot >= len(h.peers) || h.peers[peer.slot] != peer {
+		return
+	}
+
+	h.reset(peer)
+	h.peers[peer.slot] = nil
+	peer.slot = -1
+}
+
+// reset clears peer from every piece.
+func (h *pieceHolders) reset(peer *peerState) {
+	w, mask := peer.slot/64, uint64(1)<<(peer.slot%64)
+	for i := range h.pieces {
+		h.rows[i*h.words+w] &^= mask
+	}
+}
+
+// setAll records peer as holding exactly the pieces in bf.
+func (h *pieceHolders) setAll(peer *peerState, bf bitfield.Bitfield) {
+	h.reset(peer)
+	for i := range h.pieces {
+		if bf.Has(i) {
+			h.set(peer, i)
+		}
+	}
+}
+
+func (h *pieceHolders) set(peer *peerState, pieceIdx int) {
+	if pieceIdx < 0 || pieceIdx >= h.pieces {
+		return
+	}
+	h.rows[pieceIdx*h.words+peer.slot/64] |= uint64(1) << (peer.slot % 64)
+}
+
+// count returns how many peers have the piece.
+func (h *pieceHolders) count(pieceIdx int) int {
+	var n int
+	for _, word := range h.rows[pieceIdx*h.words : (pieceIdx+1)*h.words] {
+		n += bits.OnesCount64(word)
+	}
+	return n
+}
+
+// each calls fn for every peer that has the piece until fn returns false.
+func (h *pieceHolders) each(pieceIdx int, fn func(*peerState) bool) {
+	for w, word := range h.rows[pieceIdx*h.words : (pieceIdx+1)*h.words] {
+		for word != 0 {
+			bit := bits.TrailingZeros64(word)
+			word &^= uint64(1) << bit
+			if !fn(h.peers[w*64+bit]) {
+				return
+			}
+		}
+	}
+}
+
+// amongFastest reports whether peer is one of the k fastest unchoked peers
+// that have the piece.
+func (h *pieceHolders) amongFastest(pieceIdx int, peer *peerState, k int) bool {
+	if pieceIdx < 0 || pieceIdx >= h.pieces {
+		return false
+	}
+
+	var faster int
+	h.each(pieceIdx, func(other *peerState) bool {
+		if other != peer && !other.choking && other.downloadRate > peer.downloadRate {
+			faster++
+		}
+		return faster < k
+	})
+	return faster < k
+}
@@ -0,0 +1,127 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+)
+
+// timeoutScanInterval is how often in-flight requests are checked against
+// their peer's timeout.
+const timeoutScanInterval = time.Second
+
+// lateBlockWindow is how many request timeouts a timed out block is still
+// accepted for before it is forgotten and counts as unrequested.
+const lateBlockWindow = 4
+
+// latency tracks a smoothed block round-trip time and its mean deviation,
+// the same estimator TCP uses for its retransmission timeout.
+type latency struct {
+	srtt    time.Duration
+	rttvar  time.Duration
+	samples uint32
+}
+
+func (l *latency) observe(rtt time.Duration) {
+	if rtt < 0 {
+		return
+	}
+
+	if l.samples == 0 {
+		l.srtt = rtt
+		l.rttvar = rtt / 2
+		l.samples = 1
+		return
+	}
+
+	delta := l.srtt - rtt
+	if delta < 0 {
+		delta = -delta
+	}
+	l.rttvar = (3*l.rttvar + delta) / 4
+	l.srtt = (7*l.srtt + rtt) / 8
+	l.samples++
+}
+
+// timeout returns srtt + 4*rttvar clamped to [lo, hi], or def when there
+// are no samples yet.
+func (l *latency) timeout(def, lo, hi time.Duration) time.Duration {
+	if l.samples == 0 {
+		return def
+	}
+	return min(max(l.srtt+4*l.rttvar, lo), hi)
+}
+
+type expiredRequest struct {
+	peer     *peerState
+	pieceIdx uint32
+	begin    uint32
+	length   uint32
+}
+
+func (s *Scheduler) reclaimTimedOutRequests(ctx context.Context) error {
+	logger := s.logger.With("source", "request timeout loop")
+	logger.Debug("started")
+
+	ticker := s.clock.NewTicker(timeoutScanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case now := <-ticker.C():
+			s.mut.RLock()
+			def := s.cfg.RequestTimeout
+			lo := s.cfg.MinRequestTimeout
+			hi := s.cfg.MaxRequestTimeout
+			s.mut.RUnlock()
+
+			var expired []expiredRequest
+
+			s.peerMut.Lock()
+			for _, peer := range s.peers {
+				timeout := peer.latency.timeout(def, lo, hi)
+				for key, at := range peer.timedOut {
+					if now.Sub(at) >= lateBlockWindow*timeout {
+						delete(peer.timedOut, key)
+					}
+				}
+				for key, req := range peer.blockAssignments {
+					if now.Sub(req.sentAt) < timeout {
+						continue
+					}
+
+					delete(peer.blockAssignments, key)
+					peer.timedOut[key] = now
+					expired = append(expired, expiredRequest{
+						peer:     peer,
+						pieceIdx: uint32(key >> 32),
+						begin:    uint32(key & 0xFFFFFFFF),
+						length:   req.length,
+					})
+				}
+			}
+			s.peerMut.Unlock()
+
+			if len(expired) == 0 {
+				continue
+			}
+
+			s.mut.Lock()
+			s.inflightPieceRequests -= int32(len(expired))
+			s.mut.Unlock()
+
+			for _, req := range expired {
+				s.pieceManager.UnassignBlock(req.peer.addr, req.pieceIdx, req.begin)
+
+				select {
+				case req.peer.work <- NewCancelEvent(req.peer.addr, req.pieceIdx, req.begin, req.length):
+				default:
+				}
+			}
+
+			logger.Debug("reclaimed timed out requests", "count", len(expired))
+		}
+	}
+}
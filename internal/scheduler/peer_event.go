@@ -19,16 +19,19 @@ type PeerEvent[T any] struct {
 func (e PeerEvent[T]) event() {}
 
 type (
-	PeerHandshakeEvent = PeerEvent[HandshakeData]
-	PeerBitfieldEvent  = PeerEvent[bitfield.Bitfield]
-	PeerHaveEvent      = PeerEvent[HaveData]
-	PeerUnchokedEvent  = PeerEvent[UnchokedData]
-	PeerChokedEvent    = PeerEvent[ChokedData]
-	PeerPieceEvent     = PeerEvent[PieceData]
-	PeerRequestEvent   = PeerEvent[RequestPieceData]
-	PeerCancelEvent    = PeerEvent[CancelData]
-	PeerGoneEvent      = PeerEvent[GoneData]
-	PeerSpeedEvent     = PeerEvent[PeerSpeedUpdate]
+	PeerHandshakeEvent  = PeerEvent[HandshakeData]
+	PeerBitfieldEvent   = PeerEvent[bitfield.Bitfield]
+	PeerHaveEvent       = PeerEvent[HaveData]
+	PeerHavesEvent      = PeerEvent[HavesData]
+	PeerUnchokedEvent   = PeerEvent[UnchokedData]
+	PeerChokedEvent     = PeerEvent[ChokedData]
+	PeerPieceEvent      = PeerEvent[PieceData]
+	PeerRequestEvent    = PeerEvent[RequestPieceData]
+	PeerCancelEvent     = PeerEvent[CancelData]
+	PeerGoneEvent       = PeerEvent[GoneData]
+	PeerSpeedEvent      = PeerEvent[PeerSpeedUpdate]
+	PeerUploadOnlyEvent = PeerEvent[UploadOnlyData]
+	PeerUnsentEvent     = PeerEvent[UnsentData]
 )
 
 type (
@@ -66,6 +69,16 @@ func NewHaveEvent(addr netip.AddrPort, pieceIdx uint32) PeerHaveEvent {
 	return PeerHaveEvent{Peer: addr, Data: HaveData{Piece: pieceIdx}}
 }
 
+// HavesData is a batch of pieces to announce to a peer, one HAVE each,
+// or a burst of HAVEs a peer sent us.
+type HavesData struct {
+	Pieces []uint32
+}
+
+func NewHavesEvent(addr netip.AddrPort, pieces []uint32) PeerHavesEvent {
+	return PeerHavesEvent{Peer: addr, Data: HavesData{Pieces: pieces}}
+}
+
 type PieceData struct {
 	PieceIdx uint32
 	Begin    uint32
@@ -117,6 +130,27 @@ func NewCancelEvent(addr netip.AddrPort, pieceIdx, begin, length uint32) PeerCan
 	}
 }
 
+// UnsentData names a request of ours the peer's outbox had no room for,
+// so it never went out.
+type UnsentData struct {
+	PieceIdx uint32
+	Begin    uint32
+}
+
+func NewUnsentEvent(addr netip.AddrPort, pieceIdx, begin uint32) PeerUnsentEvent {
+	return PeerUnsentEvent{Peer: addr, Data: UnsentData{PieceIdx: pieceIdx, Begin: begin}}
+}
+
+// UploadOnlyData tells a peer whether we want any more pieces, for
+// clients that would rather connect to peers that do.
+type UploadOnlyData struct {
+	UploadOnly bool
+}
+
+func NewUploadOnlyEvent(addr netip.AddrPort, uploadOnly bool) PeerUploadOnlyEvent {
+	return PeerUploadOnlyEvent{Peer: addr, Data: UploadOnlyData{UploadOnly: uploadOnly}}
+}
+
 type PeerSpeedUpdate struct {
 	DownloadBytesPerSec uint64
 }
@@ -144,6 +178,8 @@ func (s *Scheduler) handlePeerEvent(event Event) {
 		s.handlePeerBitfieldEvent(e.Peer, e.Data)
 	case PeerHaveEvent:
 		s.handlePeerHaveEvent(e.Peer, e.Data)
+	case PeerHavesEvent:
+		s.handlePeerHavesEvent(e.Peer, e.Data)
 	case PeerPieceEvent:
 		s.handlePeerPieceEvent(e.Peer, e.Data)
 	case PeerRequestEvent:
@@ -152,14 +188,21 @@ func (s *Scheduler) handlePeerEvent(event Event) {
 		s.handlePeerCancelEvent(e.Peer, e.Data)
 	case PeerSpeedEvent:
 		s.handlePeerSpeedEvent(e.Peer, e.Data)
+	case PeerUnsentEvent:
+		s.handlePeerUnsentEvent(e.Peer, e.Data)
 	default:
 		s.logger.Warn("unknown peer event", "event", e)
 	}
 }
 
 func (s *Scheduler) handlePeerHandshakeEvent(addr netip.AddrPort) {
-	s.peerMut.RLock()
-	defer s.peerMut.RUnlock()
+	s.mut.RLock()
+	ours := s.downloadedPieces.Clone()
+	uploadOnly := s.uploadOnlyLocked()
+	s.mut.RUnlock()
+
+	s.peerMut.Lock()
+	defer s.peerMut.Unlock()
 
 	peer, ok := s.peers[addr]
 	if !ok {
@@ -167,7 +210,8 @@ func (s *Scheduler) handlePeerHandshakeEvent(addr netip.AddrPort) {
 	}
 
 	select {
-	case peer.work <- NewBitfieldEvent(addr, s.downloadedPieces):
+	case peer.work <- NewBitfieldEvent(addr, ours):
+		peer.announced = ours.Clone()
 
 	default:
 		s.logger.Warn(
@@ -175,6 +219,16 @@ func (s *Scheduler) handlePeerHandshakeEvent(addr netip.AddrPort) {
 			"peer", addr,
 			"message", "bitfield",
 		)
+		return
+	}
+
+	// Always sent, so the peer opens with its extension handshake.
+	select {
+	case peer.work <- NewUploadOnlyEvent(addr, uploadOnly):
+		peer.uploadOnly = uploadOnly
+	default:
+		// Left for the next flush to retry.
+		peer.uploadOnly = !uploadOnly
 	}
 }
 
@@ -205,29 +259,86 @@ func (s *Scheduler) handlePeerUnchokedEvent(addr netip.AddrPort) {
 
 func (s *Scheduler) handlePeerBitfieldEvent(addr netip.AddrPort, data bitfield.Bitfield) {
 	s.peerMut.Lock()
-	defer s.peerMut.Unlock()
-
 	peer, ok := s.peers[addr]
 	if !ok {
+		s.peerMut.Unlock()
 		return
 	}
 
-	peer.pieces = data
-	s.updateAvailability(data, 1)
+	// A repeated bitfield replaces the first rather than adding to it.
+	if peer.pieces != nil {
+		s.updateAvailability(peer.pieces, -1)
+	}
+	seed := s.setPeerPieces(peer, data)
+	s.updateAvailability(peer.pieces, 1)
+	s.peerMut.Unlock()
+
+	if seed && s.onPeerSeed != nil {
+		s.onPeerSeed(addr)
+	}
 }
 
 func (s *Scheduler) handlePeerHaveEvent(addr netip.AddrPort, data HaveData) {
 	s.peerMut.Lock()
-	defer s.peerMut.Unlock()
-
 	peer, ok := s.peers[addr]
 	if !ok {
+		s.peerMut.Unlock()
 		return
 	}
 
+	// Only the new piece gains availability; counting the whole bitfield
+	// again would inflate every piece the peer already announced.
 	pieceIdx := int(data.Piece)
-	peer.pieces.Set(pieceIdx)
-	s.updateAvailability(peer.pieces, 1)
+	added, seed := s.addPeerPiece(peer, pieceIdx)
+	if added {
+		s.mut.RLock()
+		have := s.downloadedPieces.Has(pieceIdx)
+		s.mut.RUnlock()
+		if !have {
+			s.pieceAvailabilityBucket.Move(pieceIdx, 1)
+		}
+	}
+	s.peerMut.Unlock()
+
+	if seed && s.onPeerSeed != nil {
+		s.onPeerSeed(addr)
+	}
+}
+
+// handlePeerHavesEvent records a burst of HAVEs under one lock and moves
+// their availability in one go.
+func (s *Scheduler) handlePeerHavesEvent(addr netip.AddrPort, data HavesData) {
+	s.peerMut.Lock()
+	peer, ok := s.peers[addr]
+	if !ok {
+		s.peerMut.Unlock()
+		return
+	}
+
+	added := make([]int, 0, len(data.Pieces))
+	var seed bool
+	for _, pieceIdx := range data.Pieces {
+		isNew, completed := s.addPeerPiece(peer, int(pieceIdx))
+		if isNew {
+			added = append(added, int(pieceIdx))
+		}
+		seed = seed || completed
+	}
+
+	s.mut.RLock()
+	s.pieceAvailabilityBucket.MoveAll(func(yield func(int) bool) {
+		for _, pieceIdx := range added {
+			if !s.downloadedPieces.Has(pieceIdx) && !yield(pieceIdx) {
+				return
+			}
+		}
+	}, 1)
+	s.mut.RUnlock()
+	s.peerMut.Unlock()
+
+	if seed && s.onPeerSeed != nil {
+		s.onPeerSeed(addr)
+	}
 }
 
 func (s *Scheduler) handlePeerPieceEvent(addr netip.AddrPort, data PieceData) {
@@ -239,14 +350,71 @@ func (s *Scheduler) handlePeerPieceEvent(addr netip.AddrPort, data PieceData) {
 		s.peerMut.Unlock()
 		return
 	}
+	req, requested := peer.blockAssignments[key]
+	_, late := peer.timedOut[key]
+	malformed := requested && req.length != uint32(len(data.Block))
+	bad := malformed || (!requested && !late)
+
 	delete(peer.blockAssignments, key)
+	delete(peer.timedOut, key)
+	if requested && !malformed {
+		peer.latency.observe(s.clock.Since(req.sentAt))
+	}
+	if bad {
+		peer.badBlocks++
+	}
+	kick := bad && s.shouldKick(peer)
 	s.peerMut.Unlock()
 
-	s.mut.Lock()
-	s.inflightPieceRequests--
-	s.mut.Unlock()
+	if kick {
+		s.kickPeer(addr)
+	}
+
+	if malformed {
+		// Hand the block back so it is requested again, possibly from
+		// someone else.
+		s.wasteMalformed.Add(uint64(len(data.Block)))
+		s.pieceManager.UnassignBlock(addr, data.PieceIdx, data.Begin)
+		s.mut.Lock()
+		s.inflightPieceRequests--
+		s.mut.Unlock()
+		s.logger.Debug(
+			"dropping malformed block",
+			"peer", addr,
+			"piece", data.PieceIdx,
+			"begin", data.Begin,
+			"length", len(data.Block),
+			"want", req.length,
+		)
+		return
+	}
+
+	if bad {
+		s.wasteUnrequested.Add(uint64(len(data.Block)))
+		s.logger.Debug(
+			"dropping unrequested block",
+			"peer", addr,
+			"piece", data.PieceIdx,
+			"begin", data.Begin,
+		)
+		return
+	}
 
-	s.pieceManager.MarkBlockComplete(addr, data.PieceIdx, data.Begin)
+	// A late block was already reclaimed and dropped from the in-flight
+	// count, but the data is still good if nobody beat it.
+	if requested {
+		s.mut.Lock()
+		s.inflightPieceRequests--
+		s.mut.Unlock()
+	}
+
+	if s.pieceManager.BlockDone(data.PieceIdx, data.Begin) {
+		s.wasteRedundant.Add(uint64(len(data.Block)))
+		return
+	}
+
+	redundant := s.pieceManager.MarkBlockComplete(addr, data.PieceIdx, data.Begin)
+	s.cancelRedundant(redundant, key)
 
 	s.outBlocks <- &BlockData{
 		PieceIdx: data.PieceIdx,
@@ -256,14 +424,180 @@ func (s *Scheduler) handlePeerPieceEvent(addr netip.AddrPort, data PieceData) {
 	}
 }
 
-// TODO
+// cancelRedundant withdraws the block key from the other peers it was
+// also requested from, as in endgame or after a steal, once one copy has
+// arrived.
+func (s *Scheduler) cancelRedundant(owners []netip.AddrPort, key uint64) {
+	if len(owners) == 0 {
+		return
+	}
+
+	var cancels []expiredRequest
+	s.peerMut.Lock()
+	for _, addr := range owners {
+		peer, ok := s.peers[addr]
+		if !ok {
+			continue
+		}
+		req, ok := peer.blockAssignments[key]
+		if !ok {
+			continue
+		}
+		// Remembered like a timeout, so a copy already on the wire
+		// isn't taken for an unrequested block.
+		delete(peer.blockAssignments, key)
+		peer.timedOut[key] = s.clock.Now()
+		cancels = append(cancels, expiredRequest{
+			peer:     peer,
+			pieceIdx: uint32(key >> 32),
+			begin:    uint32(key & 0xFFFFFFFF),
+			length:   req.length,
+		})
+	}
+	s.peerMut.Unlock()
+
+	if len(cancels) == 0 {
+		return
+	}
+
+	s.mut.Lock()
+	s.inflightPieceRequests -= int32(len(cancels))
+	s.mut.Unlock()
+
+	for _, req := range cancels {
+		select {
+		case req.peer.work <- NewCancelEvent(req.peer.addr, req.pieceIdx, req.begin, req.length):
+		default:
+		}
+	}
+}
+
+// shouldKick reports whether peer has sent more bad blocks than allowed.
+// It must be called with s.peerMut held, and returns true only once per
+// peer.
+func (s *Scheduler) shouldKick(peer *peerState) bool {
+	s.mut.RLock()
+	limit := s.cfg.MaxBadBlocks
+	s.mut.RUnlock()
+
+	if limit == 0 || peer.kicked || peer.badBlocks <= limit {
+		return false
+	}
+	peer.kicked = true
+	return true
+}
+
+// kickPeer asks the peer's connection to close. The peer's own gone event
+// then cleans up its scheduler state.
+func (s *Scheduler) kickPeer(addr netip.AddrPort) {
+	s.peerMut.RLock()
+	peer, ok := s.peers[addr]
+	s.peerMut.RUnlock()
+	if !ok {
+		return
+	}
+
+	s.logger.Warn("disconnecting peer for sending bad blocks",
+		"peer", addr,
+		"bad_blocks", peer.badBlocks,
+	)
+
+	select {
+	case peer.work <- NewGoneEvent(addr):
+	default:
+		s.logger.Warn(
+			"peer work queue full; dropping message",
+			"peer", addr,
+			"message", "disconnect",
+		)
+	}
+}
+
+// handlePeerRequestEvent reads a block a peer asked us for and hands it
+// to the peer as a Piece. Requests for pieces we don't have, or that run
+// past the end of the piece, are dropped.
 func (s *Scheduler) handlePeerRequestEvent(addr netip.AddrPort, data RequestPieceData) {
+	if s.reader == nil {
+		return
+	}
+
+	s.mut.RLock()
+	have := s.downloadedPieces.Has(int(data.PieceIdx))
+	s.mut.RUnlock()
+
+	end := uint64(data.Begin) + uint64(data.Length)
+	if !have || data.Length == 0 || data.Length > maxRequestLength ||
+		end > uint64(s.pieceManager.PieceLength(data.PieceIdx)) {
+		s.logger.Debug("ignoring request",
+			"peer", addr,
+			"piece", data.PieceIdx,
+			"begin", data.Begin,
+			"length", data.Length,
+		)
+		return
+	}
+
+	s.reader.ReadBlock(data.PieceIdx, data.Begin, data.Length, func(block []byte, err error) {
+		if err != nil {
+			s.logger.Warn("read requested block failed",
+				"peer", addr,
+				"piece", data.PieceIdx,
+				"error", err.Error(),
+			)
+			return
+		}
+
+		s.peerMut.RLock()
+		defer s.peerMut.RUnlock()
+
+		peer, ok := s.peers[addr]
+		if !ok {
+			return
+		}
+
+		select {
+		case peer.work <- NewPieceEvent(addr, data.PieceIdx, data.Begin, block):
+
+		default:
+			s.logger.Warn(
+				"peer work queue full; dropping message",
+				"peer", addr,
+				"message", "piece",
+			)
+		}
+	})
 }
 
 // TOOD
 func (s *Scheduler) handlePeerCancelEvent(addr netip.AddrPort, data CancelData) {
 }
 
+// handlePeerUnsentEvent frees a block whose request never left the peer,
+// so it is asked for again now instead of after the request timeout.
+func (s *Scheduler) handlePeerUnsentEvent(addr netip.AddrPort, data UnsentData) {
+	key := blockKey(data.PieceIdx, data.Begin)
+
+	s.peerMut.Lock()
+	peer, ok := s.peers[addr]
+	if !ok {
+		s.peerMut.Unlock()
+		return
+	}
+	_, assigned := peer.blockAssignments[key]
+	delete(peer.blockAssignments, key)
+	s.peerMut.Unlock()
+
+	if !assigned {
+		return
+	}
+
+	s.pieceManager.UnassignBlock(addr, data.PieceIdx, data.Begin)
+
+	s.mut.Lock()
+	s.inflightPieceRequests--
+	s.mut.Unlock()
+}
+
 func (s *Scheduler) handlePeerGoneEvent(addr netip.AddrPort) {
 	s.peerMut.Lock()
 	peer, ok := s.peers[addr]
@@ -272,6 +606,7 @@ func (s *Scheduler) handlePeerGoneEvent(addr netip.AddrPort) {
 		return
 	}
 	delete(s.peers, addr)
+	s.holders.remove(peer)
 	s.peerMut.Unlock()
 
 	for key := range peer.blockAssignments {
@@ -296,6 +631,7 @@ func (s *Scheduler) handlePeerSpeedEvent(addr netip.AddrPort, data PeerSpeedUpda
 		return
 	}
 
+	peer.downloadRate = data.DownloadBytesPerSec
 	blockPerSecond := data.DownloadBytesPerSec / piece.MaxBlockLength
 	peer.maxInflightRequests = max(5, uint32(blockPerSecond))
 }
@@ -0,0 +1,93 @@
+package scheduler
+
+import (
+	"net/netip"
+
+	"github.com/prxssh/rabbit/pkg/bitfield"
+)
+
+// Remote bitfields live here only: the peer connection forwards what it
+// reads and keeps no copy. A peer's bitfield is allocated on its first
+// BITFIELD or HAVE, and one that has every piece shares s.allPieces, so
+// seeds, often most of a swarm, cost no bitfield of their own.
+
+// OnPeerSeed sets fn to be called, outside the scheduler's locks, when a
+// connected peer turns out to have every piece. Set it before Run.
+func (s *Scheduler) OnPeerSeed(fn func(netip.AddrPort)) {
+	s.onPeerSeed = fn
+}
+
+// PeerPieceCount returns how many pieces a connected peer has announced.
+func (s *Scheduler) PeerPieceCount(addr netip.AddrPort) int {
+	s.peerMut.RLock()
+	defer s.peerMut.RUnlock()
+
+	if peer, ok := s.peers[addr]; ok {
+		return peer.havePieces
+	}
+	return 0
+}
+
+// setPeerPieces replaces a peer's bitfield with bf, which it takes
+// ownership of, and reports whether the peer is a seed. Must be called
+// with s.peerMut held.
+func (s *Scheduler) setPeerPieces(peer *peerState, bf bitfield.Bitfield) bool {
+	n := int(s.pieceManager.PieceCount())
+	bf = fitBitfield(bf, n)
+
+	peer.havePieces = bf.Count()
+	if n > 0 && peer.havePieces == n {
+		bf = s.allPieces
+	}
+	peer.pieces = bf
+	s.holders.setAll(peer, bf)
+	return n > 0 && peer.havePieces == n
+}
+
+// addPeerPiece records a HAVE. It reports whether the piece is new to the
+// peer and whether that made it a seed. Must be called with s.peerMut
+// held.
+func (s *Scheduler) addPeerPiece(peer *peerState, pieceIdx int) (added, seed bool) {
+	n := int(s.pieceManager.PieceCount())
+	if pieceIdx < 0 || pieceIdx >= n || peer.havePieces == n {
+		return false, false
+	}
+
+	if peer.pieces == nil {
+		peer.pieces = bitfield.New(n)
+	}
+	if !peer.pieces.Set(pieceIdx) {
+		return false, false
+	}
+
+	peer.havePieces++
+	s.holders.set(peer, pieceIdx)
+	if peer.havePieces == n {
+		peer.pieces = s.allPieces
+		return true, true
+	}
+	return true, false
+}
+
+// fitBitfield sizes bf for n pieces, clearing the spare bits a peer may
+// have set past the last piece.
+func fitBitfield(bf bitfield.Bitfield, n int) bitfield.Bitfield {
+	size := (n + 7) / 8
+	if len(bf) < size {
+		bf = append(bf, make([]byte, size-len(bf))...)
+	}
+	bf = bf[:size]
+	for i := n; i < bf.Len(); i++ {
+		bf.Clear(i)
+	}
+	return bf
+}
+
+// completeBitfield returns a bitfield with each of n pieces set.
+func completeBitfield(n int) bitfield.Bitfield {
+	bf := bitfield.New(n)
+	for i := range n {
+		bf.Set(i)
+	}
+	return bf
+}
@@ -0,0 +1,114 @@
+package scheduler
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/prxssh/rabbit/pkg/bitfield"
+)
+
+// Priority ranks pieces for download. Higher tiers are requested before
+// lower ones, whatever the download strategy; PrioritySkip pieces are not
+// requested at all. The zero value is PriorityNormal.
+type Priority int8
+
+const (
+	PrioritySkip   Priority = -2
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+	// PriorityTop is for the first and last pieces of files being
+	// previewed, which media players read to probe the container.
+	PriorityTop Priority = 2
+)
+
+var ErrPriorityCount = errors.New("scheduler: priority count differs from piece count")
+
+// SetPiecePriorities sets the priority of every piece, indexed by piece.
+// nil makes every piece normal again.
+func (s *Scheduler) SetPiecePriorities(prios []Priority) error {
+	if prios != nil && len(prios) != s.PieceCount() {
+		return ErrPriorityCount
+	}
+	if !slices.ContainsFunc(prios, func(p Priority) bool { return p != PriorityNormal }) {
+		prios = nil
+	}
+
+	s.mut.Lock()
+	s.priorities = slices.Clone(prios)
+	s.wantedMissing = 0
+	for i := range s.PieceCount() {
+		if !s.downloadedPieces.Has(i) && !s.skippedLocked(i) {
+			s.wantedMissing++
+		}
+	}
+	s.mut.Unlock()
+
+	// Pieces just raised from skip may sit behind the sequential cursor.
+	s.pieceManager.ResetSequentialState()
+	// Peers may need telling we want pieces again, or no longer do.
+	s.broadcastHave()
+	return nil
+}
+
+// skippedLocked reports whether a piece is skipped. Called with s.mut
+// held.
+func (s *Scheduler) skippedLocked(pieceIdx int) bool {
+	return pieceIdx < len(s.priorities) && s.priorities[pieceIdx] == PrioritySkip
+}
+
+// PiecePriority returns the priority of a piece.
+func (s *Scheduler) PiecePriority(pieceIdx uint32) Priority {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	if int(pieceIdx) >= len(s.priorities) {
+		return PriorityNormal
+	}
+	return s.priorities[pieceIdx]
+}
+
+// pieceTiers is the pieces a peer has, split by priority.
+type pieceTiers struct {
+	top    []uint32
+	high   []uint32
+	normal bitfield.Bitfield
+	low    bitfield.Bitfield
+	// wanted is every tier but skip.
+	wanted bitfield.Bitfield
+}
+
+// tiers splits the pieces in have by priority. Without priorities set,
+// have is simply the normal tier.
+func (s *Scheduler) tiers(have bitfield.Bitfield) pieceTiers {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	if s.priorities == nil {
+		return pieceTiers{normal: have, wanted: have}
+	}
+
+	n := len(s.priorities)
+	t := pieceTiers{normal: bitfield.New(n), wanted: bitfield.New(n)}
+	for i, prio := range s.priorities {
+		if !have.Has(i) || s.downloadedPieces.Has(i) || prio == PrioritySkip {
+			continue
+		}
+
+		t.wanted.Set(i)
+		switch {
+		case prio >= PriorityTop:
+			t.top = append(t.top, uint32(i))
+		case prio == PriorityHigh:
+			t.high = append(t.high, uint32(i))
+		case prio == PriorityLow:
+			if t.low == nil {
+				t.low = bitfield.New(n)
+			}
+			t.low.Set(i)
+		default:
+			t.normal.Set(i)
+		}
+	}
+	return t
+}
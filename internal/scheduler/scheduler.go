@@ -3,22 +3,64 @@ package scheduler
 import (
 	"context"
 	"log/slog"
+	"math/bits"
 	"net/netip"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prxssh/rabbit/internal/piece"
 	"github.com/prxssh/rabbit/pkg/availabilitybucket"
 	"github.com/prxssh/rabbit/pkg/bitfield"
+	"github.com/prxssh/rabbit/pkg/clock"
 	"golang.org/x/sync/errgroup"
 )
 
-const peerMinInflightRequests = 5
+const (
+	peerMinInflightRequests = 5
+
+	// peerWorkQueueSize buffers work for a peer so a burst of requests
+	// isn't dropped while its worker is busy with the previous one.
+	peerWorkQueueSize = 128
+)
 
 type Config struct {
 	DownloadStrategy         DownloadStrategy
 	EndgameThreshold         uint8
 	EndgameDuplicatePerBlock uint8
+	// RandomFirstPieces is how many pieces DownloadStrategyRandom picks at
+	// random before moving on to rarest-first.
+	RandomFirstPieces uint32
+
+	// RequestTimeout is how long a block request may stay unanswered
+	// before it is reclaimed, used until a peer has latency samples.
+	RequestTimeout time.Duration
+	// MinRequestTimeout and MaxRequestTimeout clamp the per-peer adaptive
+	// timeout derived from observed block round-trip times.
+	MinRequestTimeout time.Duration
+	MaxRequestTimeout time.Duration
+
+	// MaxBadBlocks is how many unrequested or wrongly sized blocks a peer
+	// may send before it is disconnected. Zero never disconnects.
+	MaxBadBlocks uint32
+
+	// HaveBatchDelay is how long verified pieces are collected before
+	// they are announced, so a burst such as a recheck goes out as one
+	// batch per peer. Zero announces right away.
+	HaveBatchDelay time.Duration
+
+	// DeadlinePeers is how many of the fastest peers that have a piece
+	// with a deadline are asked for it.
+	DeadlinePeers uint8
+
+	// StealBudget caps how many blocks may be duplicated at once so a
+	// peer with capacity to spare and nothing left to request can take
+	// over blocks stuck with slower peers before endgame. Zero disables
+	// stealing. StealAfter is how long a block must have been in flight
+	// before it may be stolen.
+	StealBudget uint16
+	StealAfter  time.Duration
 }
 
 func WithDefaultConfig() *Config {
@@ -26,17 +68,61 @@ func WithDefaultConfig() *Config {
 		DownloadStrategy:         DownloadStrategySequential,
 		EndgameThreshold:         5, // 5% of pieces
 		EndgameDuplicatePerBlock: 5,
+		RandomFirstPieces:        4,
+		RequestTimeout:           25 * time.Second,
+		MinRequestTimeout:        5 * time.Second,
+		MaxRequestTimeout:        60 * time.Second,
+		MaxBadBlocks:             32,
+		HaveBatchDelay:           100 * time.Millisecond,
+		DeadlinePeers:            3,
+		StealBudget:              32,
+		StealAfter:               5 * time.Second,
 	}
 }
 
+// maxRequestLength is the largest block a peer may request from us.
+const maxRequestLength = 128 * 1024
+
+// BlockReader reads the blocks peers request from us. deliver is called
+// once, possibly from another goroutine.
+type BlockReader interface {
+	ReadBlock(piece, begin, length uint32, deliver func(data []byte, err error))
+}
+
 type peerState struct {
 	inflightRequests    uint32
 	maxInflightRequests uint32
 	addr                netip.AddrPort
 	choking             bool
 	work                chan Event
-	pieces              bitfield.Bitfield
-	blockAssignments    map[uint64]struct{}
+	// pieces is the peer's bitfield, nil until it announces any and
+	// s.allPieces once it has them all; havePieces counts it.
+	pieces     bitfield.Bitfield
+	havePieces int
+	// slot is the peer's column in s.holders.
+	slot int
+	// downloadRate is what we last measured the peer sending us, in
+	// bytes/sec.
+	downloadRate uint64
+	// announced is what the peer has been told we have: the bitfield
+	// sent after the handshake plus every HAVE since. Nil until the
+	// bitfield is queued.
+	announced bitfield.Bitfield
+	// uploadOnly is what the peer was last told about our wanting more
+	// pieces.
+	uploadOnly       bool
+	blockAssignments map[uint64]pendingRequest
+	timedOut         map[uint64]time.Time
+	latency          latency
+	// badBlocks counts blocks the peer sent that we never asked for, or
+	// whose length didn't match the request.
+	badBlocks uint32
+	kicked    bool
+}
+
+type pendingRequest struct {
+	sentAt time.Time
+	length uint32
 }
 
 func blockKey(pieceIdx, begin uint32) uint64 {
@@ -44,8 +130,28 @@ func blockKey(pieceIdx, begin uint32) uint64 {
 }
 
 type PieceResult struct {
-	PieceIdx uint32
-	Success  bool
+	PieceIdx     uint32
+	Success      bool
+	HashMismatch bool
+	// FromDisk marks a piece found intact in pre-existing files rather
+	// than downloaded.
+	FromDisk bool
+}
+
+// WasteStats counts downloaded bytes that never made it to disk.
+type WasteStats struct {
+	// HashFailed is the size of pieces discarded after failing SHA-1
+	// verification.
+	HashFailed uint64 `json:"hashFailed"`
+	// Redundant is blocks we already had, mostly duplicate endgame
+	// responses.
+	Redundant uint64 `json:"redundant"`
+	// Unrequested is blocks a peer sent without us asking for them.
+	Unrequested uint64 `json:"unrequested"`
+	// Malformed is blocks whose length didn't match what we requested.
+	Malformed uint64 `json:"malformed"`
+	// Total is the sum of the above.
+	Total uint64 `json:"total"`
 }
 
 type BlockData struct {
@@ -58,17 +164,51 @@ type BlockData struct {
 type Scheduler struct {
 	cfg    *Config
 	logger *slog.Logger
+	clock  clock.Clock
 
 	mut                   sync.RWMutex
 	downloadedPieces      bitfield.Bitfield
 	endgameStarted        bool
 	inflightPieceRequests int32
+	deadlines             map[uint32]time.Time
+	pieceWaiters          map[uint32][]chan struct{}
+	// priorities holds each piece's Priority; nil means all normal.
+	priorities []Priority
+	// wantedMissing counts the pieces not skipped that we don't have yet.
+	wantedMissing int
+	// downloadPaused stops new requests while we keep uploading.
+	downloadPaused bool
+	// deadlinePressure rises with missed deadlines and eases after
+	// deadlinesOnTime pieces in a row are on time, or every deadlineDecay
+	// since deadlinePressureAt.
+	deadlinePressure   int
+	deadlinePressureAt time.Time
+	deadlinesOnTime    int
+	deadlineMisses     atomic.Uint64
+
+	wasteHashFailed  atomic.Uint64
+	wasteRedundant   atomic.Uint64
+	wasteUnrequested atomic.Uint64
+	wasteMalformed   atomic.Uint64
 
 	peerMut sync.RWMutex
 	peers   map[netip.AddrPort]*peerState
+	holders *pieceHolders
 
 	pieceAvailabilityBucket *availabilitybucket.Bucket
 	pieceManager            *piece.Manager
+	reader                  BlockReader
+
+	// allPieces is the bitfield seeds share; it is never written.
+	allPieces  bitfield.Bitfield
+	onPeerSeed func(netip.AddrPort)
+	onComplete func()
+	// onDeadlineMiss is told of pieces verified after their deadline.
+	onDeadlineMiss func(DeadlineMiss)
+
+	// haveReady is signalled when pieces are verified and peers may need
+	// to hear about them.
+	haveReady chan struct{}
 
 	peerEvent   chan Event
 	outBlocks   chan<- *BlockData
@@ -79,6 +219,13 @@ type Opts struct {
 	Logger   *slog.Logger
 	Config   *Config
 	MaxPeers uint8
+
+	// Clock drives request timeouts and work assignment. Defaults to the
+	// wall clock.
+	Clock clock.Clock
+
+	// Reader serves peers' requests. Without one, requests are ignored.
+	Reader BlockReader
 }
 
 func NewScheduler(
@@ -100,28 +247,71 @@ func NewScheduler(
 	return &Scheduler{
 		cfg:                     opts.Config,
 		logger:                  opts.Logger.With("component", "scheduler"),
+		clock:                   clock.Or(opts.Clock),
 		peers:                   make(map[netip.AddrPort]*peerState),
+		holders:                 newPieceHolders(n, maxAvail),
 		downloadedPieces:        bitfield.New(n),
+		wantedMissing:           n,
+		allPieces:               completeBitfield(n),
 		endgameStarted:          false,
 		inflightPieceRequests:   0,
+		deadlines:               make(map[uint32]time.Time),
+		pieceWaiters:            make(map[uint32][]chan struct{}),
 		pieceAvailabilityBucket: availabilitybucket.NewBucket(n, maxAvail),
+		haveReady:               make(chan struct{}, 1),
 		peerEvent:               make(chan Event, 1000),
 		pieceManager:            pieceManager,
+		reader:                  opts.Reader,
 		outBlocks:               outBlocksQueue,
 		pieceResult:             pieceResultQueue,
 	}
 }
 
 func (s *Scheduler) Run(ctx context.Context) error {
+	s.resetPeers()
+
 	g, gctx := errgroup.WithContext(ctx)
 
 	g.Go(func() error { return s.listenPeerEvent(gctx) })
 	g.Go(func() error { return s.listenVerifiedPieces(gctx) })
 	g.Go(func() error { return s.assignPeerWork(gctx) })
+	g.Go(func() error { return s.reclaimTimedOutRequests(gctx) })
+	g.Go(func() error { return s.announceHaves(gctx) })
 
 	return g.Wait()
 }
 
+// resetPeers forgets peers left over from a previous run, releasing their
+// block assignments and dropping events they queued on the way out.
+func (s *Scheduler) resetPeers() {
+	s.peerMut.Lock()
+	stale := s.peers
+	s.peers = make(map[netip.AddrPort]*peerState)
+	for _, peer := range stale {
+		s.holders.remove(peer)
+	}
+	s.peerMut.Unlock()
+
+	for addr, peer := range stale {
+		for key := range peer.blockAssignments {
+			s.pieceManager.UnassignBlock(addr, uint32(key>>32), uint32(key&0xFFFFFFFF))
+		}
+		s.updateAvailability(peer.pieces, -1)
+	}
+
+	s.mut.Lock()
+	s.inflightPieceRequests = 0
+	s.mut.Unlock()
+
+	for {
+		select {
+		case <-s.peerEvent:
+		default:
+			return
+		}
+	}
+}
+
 func (s *Scheduler) UpdateConfig(newCfg *Config) {
 	if newCfg == nil {
 		return
@@ -139,6 +329,194 @@ func (s *Scheduler) UpdateConfig(newCfg *Config) {
 	}
 }
 
+func (s *Scheduler) WasteStats() WasteStats {
+	w := WasteStats{
+		HashFailed:  s.wasteHashFailed.Load(),
+		Redundant:   s.wasteRedundant.Load(),
+		Unrequested: s.wasteUnrequested.Load(),
+		Malformed:   s.wasteMalformed.Load(),
+	}
+	w.Total = w.HashFailed + w.Redundant + w.Unrequested + w.Malformed
+	return w
+}
+
+// QueueDepth is the number of peer events waiting to be handled.
+func (s *Scheduler) QueueDepth() int {
+	return len(s.peerEvent)
+}
+
+// WorkQueueDepth is the number of work events queued for peers' request
+// workers and not yet taken.
+func (s *Scheduler) WorkQueueDepth() int {
+	s.peerMut.RLock()
+	defer s.peerMut.RUnlock()
+
+	var n int
+	for _, peer := range s.peers {
+		n += len(peer.work)
+	}
+	return n
+}
+
+// InflightRequests is the number of block requests sent and not yet
+// answered, and whether endgame has started.
+func (s *Scheduler) InflightRequests() (requests int, endgame bool) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	return int(s.inflightPieceRequests), s.endgameStarted
+}
+
+// PeerView is the scheduler's side of a peer: what it has and what we are
+// waiting on from it.
+type PeerView struct {
+	// Relevance is the share of the pieces we still need that the peer
+	// can give us, from 0 to 1.
+	Relevance float64
+	// RequestsInFlight is how many block requests are outstanding.
+	RequestsInFlight int
+}
+
+func (s *Scheduler) PieceCount() int {
+	return int(s.pieceManager.PieceCount())
+}
+
+// OnComplete sets fn to be called, outside the scheduler's locks, when the
+// last piece is verified. Set it before Run.
+func (s *Scheduler) OnComplete(fn func()) {
+	s.onComplete = fn
+}
+
+// Complete reports whether we have every piece.
+func (s *Scheduler) Complete() bool {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	return s.downloadedPieces.Count() == int(s.pieceManager.PieceCount())
+}
+
+// ForgetPieces drops verified pieces whose data on disk is corrupt so they
+// are downloaded again. Peers already told we have them are not told
+// otherwise; their requests for those pieces go unanswered until then.
+func (s *Scheduler) ForgetPieces(pieces []uint32) {
+	s.peerMut.RLock()
+	defer s.peerMut.RUnlock()
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	for _, idx := range pieces {
+		i := int(idx)
+		if i >= s.PieceCount() || !s.downloadedPieces.Has(i) {
+			continue
+		}
+		s.downloadedPieces.Clear(i)
+		s.pieceManager.ResetPiece(idx)
+		if !s.skippedLocked(i) {
+			s.wantedMissing++
+		}
+
+		// Availability stops counting once a piece is ours; catch up.
+		bucket := s.pieceAvailabilityBucket
+		bucket.Move(i, s.holders.count(i)-bucket.Availability(i))
+	}
+	s.broadcastHave()
+}
+
+// SetDownloadPaused stops or resumes requesting pieces. Peers are still
+// served while downloading is paused.
+func (s *Scheduler) SetDownloadPaused(paused bool) {
+	s.mut.Lock()
+	s.downloadPaused = paused
+	s.mut.Unlock()
+
+	s.broadcastHave()
+}
+
+// DownloadPaused reports whether requesting pieces is paused.
+func (s *Scheduler) DownloadPaused() bool {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	return s.downloadPaused
+}
+
+// UploadOnly reports whether we want no pieces from peers, having every
+// one not skipped or downloading being paused.
+func (s *Scheduler) UploadOnly() bool {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	return s.uploadOnlyLocked()
+}
+
+func (s *Scheduler) uploadOnlyLocked() bool {
+	return s.wantedMissing == 0 || s.downloadPaused
+}
+
+// PartialSeed reports whether we have every piece we want but not the
+// whole torrent, some of its files being skipped.
+func (s *Scheduler) PartialSeed() bool {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	return s.wantedMissing == 0 && s.downloadedPieces.Count() < s.PieceCount()
+}
+
+// DistributedCopies is the number of complete copies of the torrent among
+// connected peers: the availability of the rarest piece, plus the share of
+// pieces more common than that.
+func (s *Scheduler) DistributedCopies() float64 {
+	n := int(s.pieceManager.PieceCount())
+	if n == 0 {
+		return 0
+	}
+
+	counts := make([]int, n)
+
+	s.peerMut.RLock()
+	for i := range counts {
+		counts[i] = s.holders.count(i)
+	}
+	s.peerMut.RUnlock()
+
+	rarest := slices.Min(counts)
+	var above int
+	for _, c := range counts {
+		if c > rarest {
+			above++
+		}
+	}
+	return float64(rarest) + float64(above)/float64(n)
+}
+
+func (s *Scheduler) PeerViews() map[netip.AddrPort]PeerView {
+	s.mut.RLock()
+	ours := s.downloadedPieces.Clone()
+	s.mut.RUnlock()
+
+	total := int(s.pieceManager.PieceCount())
+	missing := total - ours.Count()
+
+	s.peerMut.RLock()
+	defer s.peerMut.RUnlock()
+
+	views := make(map[netip.AddrPort]PeerView, len(s.peers))
+	for addr, peer := range s.peers {
+		v := PeerView{RequestsInFlight: len(peer.blockAssignments)}
+		if missing > 0 {
+			var wanted int
+			for i := range peer.pieces {
+				if i < len(ours) {
+					wanted += bits.OnesCount8(peer.pieces[i] &^ ours[i])
+				}
+			}
+			v.Relevance = float64(wanted) / float64(missing)
+		}
+		views[addr] = v
+	}
+	return views
+}
+
 func (s *Scheduler) GetPeerEventQueue() chan<- Event {
 	return s.peerEvent
 }
@@ -156,11 +534,12 @@ func (s *Scheduler) GetPeerWorkQueue(addr netip.AddrPort) <-chan Event {
 		addr:                addr,
 		choking:             true,
 		maxInflightRequests: 50,
-		work:                make(chan Event),
-		pieces:              bitfield.New(int(s.pieceManager.PieceCount())),
-		blockAssignments:    make(map[uint64]struct{}),
+		work:                make(chan Event, peerWorkQueueSize),
+		blockAssignments:    make(map[uint64]pendingRequest),
+		timedOut:            make(map[uint64]time.Time),
 	}
 	s.peers[addr] = peerState
+	s.holders.add(peerState)
 
 	return peerState.work
 }
@@ -205,9 +584,42 @@ func (s *Scheduler) listenVerifiedPieces(ctx context.Context) error {
 				return nil
 			}
 
-			s.pieceManager.MarkPieceVerified(result.PieceIdx, result.Success)
+			if result.HashMismatch {
+				s.wasteHashFailed.Add(
+					uint64(s.pieceManager.PieceLength(result.PieceIdx)),
+				)
+			}
+
+			if result.FromDisk {
+				s.pieceManager.MarkPieceHave(result.PieceIdx)
+			} else {
+				s.pieceManager.MarkPieceVerified(result.PieceIdx, result.Success)
+			}
+
 			if result.Success {
-				s.broadcastHave(result.PieceIdx)
+				s.mut.Lock()
+				miss, missed := s.checkDeadlineLocked(result.PieceIdx)
+				wasComplete := s.downloadedPieces.Count() == s.PieceCount()
+				s.pieceDone(result.PieceIdx)
+				completed := !wasComplete &&
+					s.downloadedPieces.Count() == s.PieceCount()
+				s.mut.Unlock()
+
+				s.broadcastHave()
+
+				if missed {
+					s.logger.Debug("piece missed its deadline",
+						"piece", miss.Piece,
+						"late", miss.Late,
+						"pressure", miss.Pressure,
+					)
+					if s.onDeadlineMiss != nil {
+						s.onDeadlineMiss(miss)
+					}
+				}
+				if completed && s.onComplete != nil {
+					s.onComplete()
+				}
 			}
 		}
 	}
@@ -217,7 +629,7 @@ func (s *Scheduler) assignPeerWork(ctx context.Context) error {
 	logger := s.logger.With("source", "work assignment loop")
 	logger.Debug("started")
 
-	ticker := time.NewTicker(200 * time.Millisecond)
+	ticker := s.clock.NewTicker(200 * time.Millisecond)
 	defer ticker.Stop()
 
 	for {
@@ -225,7 +637,7 @@ func (s *Scheduler) assignPeerWork(ctx context.Context) error {
 		case <-ctx.Done():
 			return nil
 
-		case <-ticker.C:
+		case <-ticker.C():
 			candidates := make([]netip.AddrPort, 0, len(s.peers))
 
 			s.peerMut.RLock()
@@ -243,38 +655,111 @@ func (s *Scheduler) assignPeerWork(ctx context.Context) error {
 	}
 }
 
-func (s *Scheduler) broadcastHave(pieceIdx uint32) {
-	s.peerMut.RLock()
-	defer s.peerMut.RUnlock()
+// broadcastHave wakes the announce loop after a piece is verified.
+func (s *Scheduler) broadcastHave() {
+	select {
+	case s.haveReady <- struct{}{}:
+	default:
+	}
+}
+
+// announceHaves tells peers about newly verified pieces. Wake-ups are
+// collected for HaveBatchDelay so that a burst of verifications costs each
+// peer one work item rather than one per piece.
+func (s *Scheduler) announceHaves(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-s.haveReady:
+		}
+
+		s.mut.RLock()
+		delay := s.cfg.HaveBatchDelay
+		s.mut.RUnlock()
+
+		if delay > 0 {
+			timer := s.clock.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+
+			case <-timer.C():
+			}
+		}
+
+		s.flushHaves()
+	}
+}
+
+// flushHaves sends each peer a HAVE for every piece we have but haven't
+// announced to it, and word of whether we still want pieces when that
+// changed. Working from the difference rather than a list of new pieces
+// means HAVEs lost to a full work queue go out with the next batch.
+func (s *Scheduler) flushHaves() {
+	s.mut.RLock()
+	ours := s.downloadedPieces.Clone()
+	uploadOnly := s.uploadOnlyLocked()
+	s.mut.RUnlock()
+
+	s.peerMut.Lock()
+	defer s.peerMut.Unlock()
 
 	for addr, peer := range s.peers {
-		if peer.pieces.Has(int(pieceIdx)) {
+		// Peers still waiting for their bitfield will get these in it.
+		if peer.announced == nil {
+			continue
+		}
+
+		if peer.uploadOnly != uploadOnly {
+			select {
+			case peer.work <- NewUploadOnlyEvent(addr, uploadOnly):
+				peer.uploadOnly = uploadOnly
+			default:
+			}
+		}
+
+		var batch []uint32
+		for _, i := range ours.Missing(peer.announced) {
+			// A peer that has the piece has no use for the HAVE.
+			if !peer.pieces.Has(i) {
+				batch = append(batch, uint32(i))
+			}
+		}
+		if len(batch) == 0 {
 			continue
 		}
 
 		select {
-		case peer.work <- NewHaveEvent(addr, uint32(pieceIdx)):
+		case peer.work <- NewHavesEvent(addr, batch):
+			for _, i := range batch {
+				peer.announced.Set(int(i))
+			}
 
 		default:
 			s.logger.Warn(
-				"unable to send HAVE message; work queue full",
+				"unable to send HAVE messages; work queue full",
 				"peer", addr,
-				"piece", pieceIdx,
+				"pieces", len(batch),
 			)
 		}
 	}
 }
 
 func (s *Scheduler) updateAvailability(bf bitfield.Bitfield, delta int) {
-	s.mut.Lock()
-	ourBF := s.downloadedPieces.Clone()
-	s.mut.Unlock()
-
-	for i := 0; i < int(s.pieceManager.PieceCount()); i++ {
-		if bf.Has(i) && !ourBF.Has(i) {
-			s.pieceAvailabilityBucket.Move(i, delta)
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	n := int(s.pieceManager.PieceCount())
+	s.pieceAvailabilityBucket.MoveAll(func(yield func(int) bool) {
+		for i := 0; i < n; i++ {
+			if bf.Has(i) && !s.downloadedPieces.Has(i) && !yield(i) {
+				return
+			}
 		}
-	}
+	}, delta)
 }
 
 func (s *Scheduler) assignBlockToPeer(peer *peerState, block *piece.BlockInfo) {
@@ -285,7 +770,7 @@ func (s *Scheduler) assignBlockToPeer(peer *peerState, block *piece.BlockInfo) {
 	key := blockKey(block.PieceIdx, block.Begin)
 
 	s.peerMut.Lock()
-	peer.blockAssignments[key] = struct{}{}
+	peer.blockAssignments[key] = pendingRequest{sentAt: s.clock.Now(), length: block.Length}
 	s.peerMut.Unlock()
 
 	select {
@@ -3,11 +3,16 @@ package scheduler
 import (
 	"math/rand/v2"
 	"net/netip"
+
+	"github.com/prxssh/rabbit/pkg/bitfield"
 )
 
 type DownloadStrategy uint8
 
 const (
+	// DownloadStrategyRandom picks pieces at random until RandomFirstPieces
+	// are done, so there is something to trade quickly, then switches to
+	// rarest-first.
 	DownloadStrategyRandom DownloadStrategy = iota
 	DownloadStrategyRarestFirst
 	DownloadStrategySequential
@@ -20,43 +25,149 @@ func (s *Scheduler) nextForPeer(addr netip.AddrPort) {
 		s.peerMut.RUnlock()
 		return
 	}
+	// The event loop swaps pieces out as the peer announces more, and
+	// retunes maxInflightRequests.
+	pieces, maxInflight := peer.pieces, peer.maxInflightRequests
+	// Pieces with a deadline are left to their fastest holders.
+	urgent := s.deadlinePieces(s.fastestHolder(peer, pieces, s.cfg.DeadlinePeers))
 	s.peerMut.RUnlock()
 
-	if peer.maxInflightRequests < 1 {
+	if maxInflight < 1 || s.DownloadPaused() {
 		return
 	}
+
+	tiers := s.tiers(pieces)
 	if s.endgameStarted {
-		s.selectEndgameBlocks(peer, peer.maxInflightRequests)
+		s.selectEndgameBlocks(peer, tiers.wanted, maxInflight)
 		return
 	}
 
+	remCapacity := maxInflight
+
+	if len(urgent) > 0 {
+		remCapacity = s.assignPieces(peer, urgent, remCapacity)
+	}
+	if len(tiers.top) > 0 && remCapacity > 0 {
+		remCapacity = s.assignPieces(peer, tiers.top, remCapacity)
+	}
+	if len(tiers.high) > 0 && remCapacity > 0 {
+		remCapacity = s.assignPieces(peer, tiers.high, remCapacity)
+	}
+
 	assignedBlocks, remCapacity := s.pieceManager.AssignInProgressBlocks(
 		addr,
-		peer.pieces,
-		peer.maxInflightRequests,
+		tiers.wanted,
+		remCapacity,
 	)
 	for _, block := range assignedBlocks {
 		s.assignBlockToPeer(peer, block)
 	}
 
-	var pieceSelectionStrategy func(*peerState, uint32)
+	var pieceSelectionStrategy func(*peerState, bitfield.Bitfield, uint32) uint32
 
 	switch s.cfg.DownloadStrategy {
 	case DownloadStrategySequential:
-		pieceSelectionStrategy = s.selectSequentialBlocks
+		// The sequential cursor only moves forward, so a pass over the
+		// normal tier would leave low pieces behind it for good.
+		remCapacity = s.selectSequentialBlocks(peer, tiers.wanted, remCapacity)
 	case DownloadStrategyRandom:
-		pieceSelectionStrategy = s.selectRandomBlocks
+		if s.warmingUp() {
+			pieceSelectionStrategy = s.selectRandomBlocks
+		} else {
+			pieceSelectionStrategy = s.selectRarestFirstBlocks
+		}
 	default:
 		pieceSelectionStrategy = s.selectRarestFirstBlocks
 	}
 
-	pieceSelectionStrategy(peer, remCapacity)
+	if pieceSelectionStrategy != nil {
+		remCapacity = pieceSelectionStrategy(peer, tiers.normal, remCapacity)
+		if tiers.low != nil && remCapacity > 0 {
+			remCapacity = pieceSelectionStrategy(peer, tiers.low, remCapacity)
+		}
+	}
+
+	if remCapacity > 0 {
+		s.stealBlocks(peer, tiers.wanted, remCapacity)
+	}
 }
 
-func (s *Scheduler) selectEndgameBlocks(peer *peerState, n uint32) {
+// stealBlocks duplicates onto peer, which has capacity left after every
+// tier, blocks long in flight to peers slower than it. The first to arrive
+// wins and the other request is cancelled, as in endgame.
+func (s *Scheduler) stealBlocks(peer *peerState, have bitfield.Bitfield, n uint32) {
+	// Behind on deadlines, duplicates take bandwidth the due pieces need.
+	budget := uint32(s.cfg.StealBudget) >> s.DeadlinePressure()
+	if budget == 0 {
+		return
+	}
+
+	s.peerMut.RLock()
+	rate := peer.downloadRate
+	slower := make(map[netip.AddrPort]struct{})
+	for addr, other := range s.peers {
+		if other != peer && other.downloadRate < rate {
+			slower[addr] = struct{}{}
+		}
+	}
+	s.peerMut.RUnlock()
+	if len(slower) == 0 {
+		return
+	}
+
+	assignedBlocks, _ := s.pieceManager.StealBlocks(
+		peer.addr,
+		have,
+		n,
+		budget,
+		s.cfg.StealAfter,
+		func(owner netip.AddrPort) bool {
+			_, ok := slower[owner]
+			return ok
+		},
+	)
+	for _, block := range assignedBlocks {
+		s.assignBlockToPeer(peer, block)
+	}
+}
+
+// assignPieces assigns the unclaimed blocks of pieces, in order, and
+// returns the capacity left.
+func (s *Scheduler) assignPieces(peer *peerState, pieces []uint32, n uint32) uint32 {
+	assignedBlocks, rem := s.pieceManager.AssignPieceBlocks(peer.addr, pieces, n)
+	for _, block := range assignedBlocks {
+		s.assignBlockToPeer(peer, block)
+	}
+	return rem
+}
+
+// warmingUp reports whether fewer than RandomFirstPieces pieces are done.
+func (s *Scheduler) warmingUp() bool {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	return s.downloadedPieces.Count() < int(s.cfg.RandomFirstPieces)
+}
+
+// selectEndgameBlocks duplicates outstanding blocks onto peer for the
+// pieces it is among the fastest holders of, up to the duplicate limit.
+func (s *Scheduler) selectEndgameBlocks(peer *peerState, have bitfield.Bitfield, n uint32) {
+	s.peerMut.RLock()
+	s.mut.RLock()
+	fastest := s.fastestHolder(peer, have, s.cfg.EndgameDuplicatePerBlock)
+	mine := bitfield.New(have.Len())
+	for i := range have.Len() {
+		if !s.downloadedPieces.Has(i) && fastest(i) {
+			mine.Set(i)
+		}
+	}
+	s.mut.RUnlock()
+	s.peerMut.RUnlock()
+	have = mine
+
 	assignedBlocks, _ := s.pieceManager.AssignEndgameBlocks(
 		peer.addr,
-		peer.pieces,
+		have,
 		n,
 		uint32(s.cfg.EndgameDuplicatePerBlock),
 	)
@@ -65,39 +176,53 @@ func (s *Scheduler) selectEndgameBlocks(peer *peerState, n uint32) {
 	}
 }
 
-func (s *Scheduler) selectSequentialBlocks(peer *peerState, n uint32) {
-	assignedBlocks, _ := s.pieceManager.AssignSequentialBlocks(peer.addr, peer.pieces, n)
+// fastestHolder returns whether peer has a piece and is one of the k
+// fastest unchoked peers that do; k of zero only asks that it has it. It
+// and the function it returns must be called with s.peerMut held.
+func (s *Scheduler) fastestHolder(peer *peerState, have bitfield.Bitfield, k uint8) func(int) bool {
+	if k == 0 {
+		return have.Has
+	}
+	return func(i int) bool {
+		return have.Has(i) && s.holders.amongFastest(i, peer, int(k))
+	}
+}
+
+func (s *Scheduler) selectSequentialBlocks(peer *peerState, have bitfield.Bitfield, n uint32) uint32 {
+	assignedBlocks, rem := s.pieceManager.AssignSequentialBlocks(peer.addr, have, n)
 	for _, block := range assignedBlocks {
 		s.assignBlockToPeer(peer, block)
 	}
+	return rem
 }
 
-func (s *Scheduler) selectRandomBlocks(peer *peerState, n uint32) {
+func (s *Scheduler) selectRandomBlocks(peer *peerState, have bitfield.Bitfield, n uint32) uint32 {
 	pieceCount := s.pieceManager.PieceCount()
 	available := make([]uint32, 0, pieceCount)
 
 	for i := uint32(0); i < pieceCount; i++ {
-		if peer.pieces.Has(int(i)) {
+		if have.Has(int(i)) {
 			available = append(available, i)
 		}
 	}
 	if len(available) == 0 {
-		return
+		return n
 	}
 	rand.Shuffle(len(available), func(i, j int) {
 		available[i], available[j] = available[j], available[i]
 	})
 
-	assignedBlocks, _ := s.pieceManager.AssignBlocksFromList(peer.addr, available, n)
+	assignedBlocks, rem := s.pieceManager.AssignBlocksFromList(peer.addr, available, n)
 	for _, blockInfo := range assignedBlocks {
 		s.assignBlockToPeer(peer, blockInfo)
 	}
+	return rem
 }
 
-func (s *Scheduler) selectRarestFirstBlocks(peer *peerState, n uint32) {
+func (s *Scheduler) selectRarestFirstBlocks(peer *peerState, have bitfield.Bitfield, n uint32) uint32 {
 	rarestAvail, ok := s.pieceAvailabilityBucket.FirstNonEmpty()
 	if !ok {
-		return
+		return n
 	}
 
 	pieceIndices := make([]uint32, 0)
@@ -113,15 +238,16 @@ func (s *Scheduler) selectRarestFirstBlocks(peer *peerState, n uint32) {
 		})
 
 		for _, pieceIdx := range bucket {
-			if peer.pieces.Has(pieceIdx) &&
+			if have.Has(pieceIdx) &&
 				!s.pieceManager.PieceComplete(uint32(pieceIdx)) {
 				pieceIndices = append(pieceIndices, uint32(pieceIdx))
 			}
 		}
 	}
 
-	assignedBlocks, _ := s.pieceManager.AssignBlocksFromList(peer.addr, pieceIndices, n)
+	assignedBlocks, rem := s.pieceManager.AssignBlocksFromList(peer.addr, pieceIndices, n)
 	for _, block := range assignedBlocks {
 		s.assignBlockToPeer(peer, block)
 	}
+	return rem
 }
@@ -0,0 +1,174 @@
+//go:build sim
+
+package sim
+
+import (
+	"context"
+	"crypto/sha1"
+	"math/rand/v2"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/prxssh/rabbit/internal/protocol"
+	"github.com/prxssh/rabbit/pkg/bitfield"
+	"github.com/prxssh/rabbit/pkg/clock"
+)
+
+// fakePeer serves a slice of the scenario's content over in-process
+// connections, following its script.
+type fakePeer struct {
+	script   PeerScript
+	content  []byte
+	pieceLen uint32
+	have     bitfield.Bitfield
+	seed     uint64
+	peerID   [sha1.Size]byte
+	infoHash [sha1.Size]byte
+	clock    clock.Clock
+
+	conns  atomic.Uint64
+	served atomic.Uint64
+}
+
+func newFakePeer(
+	script PeerScript,
+	content []byte,
+	pieceLen uint32,
+	seed uint64,
+	clk clock.Clock,
+) *fakePeer {
+	count := (len(content) + int(pieceLen) - 1) / int(pieceLen)
+
+	rng := rand.New(rand.NewPCG(seed, 0))
+	have := bitfield.New(count)
+	for i := 0; i < count; i++ {
+		if script.Have >= 1 || rng.Float64() < script.Have {
+			have.Set(i)
+		}
+	}
+
+	p := &fakePeer{
+		script:   script,
+		content:  content,
+		pieceLen: pieceLen,
+		have:     have,
+		seed:     seed,
+		clock:    clk,
+	}
+	copy(p.peerID[:], "-SM0001-")
+	sum := sha1.Sum([]byte(script.Name))
+	copy(p.peerID[8:], sum[:])
+	return p
+}
+
+// connect returns our end of a new connection to the peer.
+func (p *fakePeer) connect(ctx context.Context) net.Conn {
+	ours, theirs := net.Pipe()
+	go p.serve(ctx, theirs, p.conns.Add(1))
+	return ours
+}
+
+func (p *fakePeer) serve(ctx context.Context, conn net.Conn, n uint64) {
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	// We answer the handshake rather than start it: net.Pipe is
+	// unbuffered and the client writes first.
+	remote, err := protocol.ReadHandshake(conn)
+	if err != nil || remote.InfoHash != p.infoHash {
+		return
+	}
+	if err := protocol.WriteHandshake(conn, *protocol.NewHandshake(p.infoHash, p.peerID)); err != nil {
+		return
+	}
+
+	requests := make(chan *protocol.Message, 256)
+	go p.writeLoop(ctx, conn, requests, rand.New(rand.NewPCG(p.seed, n)))
+
+	defer close(requests)
+	for {
+		m, err := protocol.ReadMessage(conn)
+		if err != nil {
+			return
+		}
+		if m.ID != protocol.Request {
+			continue
+		}
+
+		select {
+		case requests <- m:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *fakePeer) writeLoop(
+	ctx context.Context,
+	conn net.Conn,
+	requests <-chan *protocol.Message,
+	rng *rand.Rand,
+) {
+	if err := protocol.WriteMessage(conn, protocol.MessageBitfield(p.have)); err != nil {
+		return
+	}
+	if !p.script.Snub {
+		if err := protocol.WriteMessage(conn, protocol.MessageUnchoke()); err != nil {
+			return
+		}
+	}
+
+	for m := range requests {
+		index, begin, length, ok := m.ParseRequest()
+		if !ok || !p.have.Has(int(index)) || p.script.Snub {
+			continue
+		}
+
+		off := uint64(index)*uint64(p.pieceLen) + uint64(begin)
+		if off+uint64(length) > uint64(len(p.content)) {
+			continue
+		}
+		block := p.content[off : off+uint64(length)]
+
+		if rng.Float64() < p.script.Corrupt {
+			block = make([]byte, length)
+			for i := range block {
+				block[i] = byte(rng.UintN(256))
+			}
+		}
+
+		if !p.wait(ctx, len(block)) {
+			return
+		}
+		if err := protocol.WriteMessage(conn, protocol.MessagePiece(index, begin, block)); err != nil {
+			return
+		}
+		p.served.Add(1)
+	}
+}
+
+// wait sleeps, on the simulation clock, for the peer's latency plus the time its rate allows for n
+// bytes. It reports false if ctx ended first.
+func (p *fakePeer) wait(ctx context.Context, n int) bool {
+	d := time.Duration(p.script.Latency)
+	if p.script.Rate > 0 {
+		d += time.Duration(float64(n) / float64(p.script.Rate) * float64(time.Second))
+	}
+	if d <= 0 {
+		return true
+	}
+
+	timer := p.clock.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
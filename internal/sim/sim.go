@@ -0,0 +1,287 @@
+//go:build sim
+
+// Package sim runs a torrent end-to-end against scripted in-process peers,
+// so the scheduler, choker and storage can be exercised without a network.
+//
+// A scenario fixes the torrent's content, which pieces each fake peer has
+// and how it behaves, all derived from one seed. The peers are reached
+// through net.Pipe and announced by an in-process HTTP tracker. The
+// torrent and the peers share a fake clock that Run advances in fixed
+// steps once the swarm has gone quiet, so timeouts and peer latencies
+// don't depend on how fast or loaded the machine is, and Elapsed is
+// simulated time. Goroutine scheduling still varies the details, such as
+// which peer serves a given block.
+//
+// Build with -tags sim; go test -tags sim ./internal/sim runs the scenarios
+// in testdata.
+package sim
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/prxssh/rabbit/internal/bencode"
+	"github.com/prxssh/rabbit/internal/scheduler"
+	"github.com/prxssh/rabbit/internal/torrent"
+	"github.com/prxssh/rabbit/pkg/clock"
+)
+
+// simStep is how far the fake clock moves per step. Before each step the
+// run waits for the torrent and peers to go quiet, so how much work fits
+// between two ticks doesn't depend on the machine.
+const simStep = 5 * time.Millisecond
+
+// Scenario describes one simulated swarm.
+type Scenario struct {
+	Name string `json:"name"`
+	Seed uint64 `json:"seed"`
+
+	// Size and PieceLength shape the generated torrent, in bytes.
+	Size        uint64 `json:"size"`
+	PieceLength uint32 `json:"pieceLength"`
+
+	Strategy scheduler.DownloadStrategy `json:"strategy"`
+
+	// Timeout bounds the run in simulated time. Defaults to a minute.
+	Timeout Duration `json:"timeout"`
+
+	Peers []PeerScript `json:"peers"`
+}
+
+// PeerScript is how one fake peer behaves.
+type PeerScript struct {
+	Name string `json:"name"`
+
+	// Have is the share of pieces the peer holds, from 0 to 1. The pieces
+	// are picked from the scenario seed.
+	Have float64 `json:"have"`
+
+	// Latency delays every block the peer sends.
+	Latency Duration `json:"latency"`
+
+	// Rate caps the peer's upload in bytes/sec. Zero is unlimited.
+	Rate uint64 `json:"rate"`
+
+	// Corrupt is the share of blocks sent with garbage data.
+	Corrupt float64 `json:"corrupt"`
+
+	// Snub keeps the peer choking us for the whole run.
+	Snub bool `json:"snub"`
+}
+
+// Duration is a time.Duration written as a string ("250ms") in scenario
+// files.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Load reads a scenario file.
+func Load(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var sc Scenario
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return nil, fmt.Errorf("sim: parse %s: %w", path, err)
+	}
+	if err := sc.validate(); err != nil {
+		return nil, fmt.Errorf("sim: %s: %w", path, err)
+	}
+	return &sc, nil
+}
+
+func (sc *Scenario) validate() error {
+	switch {
+	case sc.Size == 0:
+		return errors.New("size must be positive")
+	case sc.PieceLength == 0 || sc.PieceLength%(16<<10) != 0:
+		return errors.New("piece length must be a positive multiple of 16 KiB")
+	case len(sc.Peers) == 0:
+		return errors.New("no peers")
+	case len(sc.Peers) > 250:
+		return errors.New("at most 250 peers")
+	}
+	return nil
+}
+
+// Report is the outcome of a run.
+type Report struct {
+	Completed bool
+	// Elapsed is simulated time.
+	Elapsed time.Duration
+	Wasted  scheduler.WasteStats
+	// Served is how many blocks each fake peer sent, by name.
+	Served map[string]uint64
+}
+
+// Run downloads the scenario's torrent into dir from its fake peers and
+// stops once every piece is verified or the timeout passes.
+func Run(ctx context.Context, sc *Scenario, dir string) (*Report, error) {
+	timeout := time.Duration(sc.Timeout)
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	rng := rand.New(rand.NewPCG(sc.Seed, sc.Seed^0x9e3779b97f4a7c15))
+	clk := clock.NewFake(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	content := make([]byte, sc.Size)
+	for i := range content {
+		content[i] = byte(rng.UintN(256))
+	}
+
+	peers := make(map[netip.AddrPort]*fakePeer, len(sc.Peers))
+	addrs := make([]netip.AddrPort, 0, len(sc.Peers))
+	for i, script := range sc.Peers {
+		addr := netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 77, 0, byte(i + 1)}), 6881)
+		peers[addr] = newFakePeer(script, content, sc.PieceLength, rng.Uint64(), clk)
+		addrs = append(addrs, addr)
+	}
+
+	tracker := newTracker(addrs)
+	defer tracker.Close()
+
+	data, err := buildTorrent(sc, content, tracker.URL+"/announce")
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := torrent.WithDefaultConfig()
+	cfg.Storage.DownloadDir = dir
+	cfg.Scheduler.DownloadStrategy = sc.Strategy
+
+	var clientID [sha1.Size]byte
+	copy(clientID[:], "-RB0000-simulation00")
+
+	t, err := torrent.NewTorrent(data, &torrent.Opts{
+		ClientID: clientID,
+		Config:   cfg,
+		Clock:    clk,
+		Dial: func(ctx context.Context, addr netip.AddrPort, _ time.Duration) (net.Conn, error) {
+			p, ok := peers[addr]
+			if !ok {
+				return nil, fmt.Errorf("sim: no peer at %s", addr)
+			}
+			return p.connect(ctx), nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range peers {
+		p.infoHash = t.Metainfo.InfoHash
+	}
+
+	start := clk.Now()
+	done := make(chan error, 1)
+	go func() { done <- t.Run(ctx) }()
+
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+
+	report := &Report{Served: make(map[string]uint64, len(peers))}
+	var last activitySnapshot
+wait:
+	for clk.Since(start) < timeout {
+		select {
+		case <-ctx.Done():
+			break wait
+		case err := <-done:
+			if err != nil {
+				return nil, err
+			}
+			break wait
+		case <-ticker.C:
+		}
+
+		if t.Completed() {
+			report.Completed = true
+			break wait
+		}
+
+		// Quiet means no block served and no queue moved for a tick.
+		cur := activity(t, peers)
+		if cur != last {
+			last = cur
+			continue
+		}
+		clk.Advance(simStep)
+	}
+	report.Elapsed = clk.Since(start)
+	report.Wasted = t.GetStats().Wasted
+
+	t.Stop()
+	cancel()
+	<-done
+
+	for _, p := range peers {
+		report.Served[p.script.Name] = p.served.Load()
+	}
+	return report, nil
+}
+
+type activitySnapshot struct {
+	served uint64
+	queues torrent.QueueDepths
+}
+
+func activity(t *torrent.Torrent, peers map[netip.AddrPort]*fakePeer) activitySnapshot {
+	var a activitySnapshot
+	for _, p := range peers {
+		a.served += p.served.Load()
+	}
+	a.queues = t.QueueDepths()
+	return a
+}
+
+func buildTorrent(sc *Scenario, content []byte, announce string) ([]byte, error) {
+	var pieces []byte
+	for off := uint64(0); off < sc.Size; off += uint64(sc.PieceLength) {
+		end := min(off+uint64(sc.PieceLength), sc.Size)
+		sum := sha1.Sum(content[off:end])
+		pieces = append(pieces, sum[:]...)
+	}
+
+	name := sc.Name
+	if name == "" {
+		name = "simulation"
+	}
+
+	return bencode.Marshal(map[string]any{
+		"announce": announce,
+		"info": map[string]any{
+			"name":         name,
+			"length":       int64(sc.Size),
+			"piece length": int64(sc.PieceLength),
+			"pieces":       string(pieces),
+		},
+	})
+}
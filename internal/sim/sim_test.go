@@ -0,0 +1,41 @@
+//go:build sim
+
+package sim
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestScenarios(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.json"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no scenarios: %v", err)
+	}
+
+	for _, path := range paths {
+		sc, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load(%s) error = %v", path, err)
+		}
+
+		t.Run(sc.Name, func(t *testing.T) {
+			report, err := Run(context.Background(), sc, t.TempDir())
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if !report.Completed {
+				t.Fatalf("download incomplete after %v: %+v", report.Elapsed, report)
+			}
+			t.Logf("completed in %v, served %v, wasted %+v",
+				report.Elapsed, report.Served, report.Wasted)
+
+			for _, p := range sc.Peers {
+				if p.Snub && report.Served[p.Name] > 0 {
+					t.Errorf("snubbing peer %s served %d blocks", p.Name, report.Served[p.Name])
+				}
+			}
+		})
+	}
+}
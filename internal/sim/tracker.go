@@ -0,0 +1,36 @@
+//go:build sim
+
+package sim
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+
+	"github.com/prxssh/rabbit/internal/bencode"
+)
+
+// newTracker serves announces that always return the scenario's peers.
+func newTracker(addrs []netip.AddrPort) *httptest.Server {
+	compact := make([]byte, 0, len(addrs)*6)
+	for _, addr := range addrs {
+		ip := addr.Addr().As4()
+		compact = append(compact, ip[:]...)
+		compact = append(compact, byte(addr.Port()>>8), byte(addr.Port()))
+	}
+
+	body, err := bencode.Marshal(map[string]any{
+		"interval":   int64(1800),
+		"complete":   int64(len(addrs)),
+		"incomplete": int64(0),
+		"peers":      string(compact),
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write(body)
+	}))
+}
@@ -0,0 +1,54 @@
+package storage
+
+// Backend holds the bytes of a torrent's files. Files are addressed by
+// their index in the metainfo; offsets are relative to the file's start.
+type Backend interface {
+	ReadAt(file int, p []byte, off int64) (int, error)
+	WriteAt(file int, p []byte, off int64) (int, error)
+	Flush() error
+	Close() error
+}
+
+// Renamer is implemented by backends whose files can be moved.
+type Renamer interface {
+	RenameFile(file int, newPath string) error
+	RenameRoot(newName string) error
+}
+
+// Resizer is implemented by backends that can find files whose size on
+// disk disagrees with the metainfo, and fix them.
+type Resizer interface {
+	SizeMismatches() []int
+	FixSizes() error
+}
+
+// Preexisting is implemented by backends that can tell whether a file
+// already held data when it was opened.
+type Preexisting interface {
+	Preexisting(file int) bool
+}
+
+// Pooled is implemented by backends holding files open, which can share a
+// FilePool with the other torrents' backends.
+type Pooled interface {
+	UseFilePool(p *FilePool)
+}
+
+// Locator is implemented by backends whose content lives at a path on
+// disk.
+type Locator interface {
+	ContentPath() string
+}
+
+// Deleter is implemented by backends that can remove the torrent's data.
+type Deleter interface {
+	Delete() error
+}
+
+// Finalizer is implemented by backends that keep files somewhere else, or
+// under other names, until they complete. FinalizeFile is called as each
+// file's last piece is stored, Finalize once the whole torrent is.
+type Finalizer interface {
+	FinalizeFile(index int) error
+	Finalize() error
+}
@@ -0,0 +1,295 @@
+package storage
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/prxssh/rabbit/internal/piece"
+	"github.com/prxssh/rabbit/internal/scheduler"
+	"github.com/prxssh/rabbit/pkg/bitfield"
+)
+
+// maxCheckBatch caps how many pieces a check reads ahead and hashes in
+// parallel, bounding its memory to that many pieces.
+const maxCheckBatch = 8
+
+// checkProgressInterval spaces out progress reports of a running check.
+const checkProgressInterval = 500 * time.Millisecond
+
+// Checked is closed once pre-existing files have been hash-checked, or
+// right away when there were none.
+func (s *Store) Checked() <-chan struct{} {
+	return s.checked
+}
+
+// Checking reports whether the background check of existing data is
+// still running.
+func (s *Store) Checking() bool {
+	return s.checking.Load()
+}
+
+// SizeMismatches returns the indices of files whose size on disk differs
+// from the metainfo.
+func (s *Store) SizeMismatches() []int {
+	r, ok := s.backend.(Resizer)
+	if !ok {
+		return nil
+	}
+	return r.SizeMismatches()
+}
+
+// FixSizes resizes mismatched files so the torrent can be rechecked.
+func (s *Store) FixSizes() error {
+	r, ok := s.backend.(Resizer)
+	if !ok {
+		return nil
+	}
+	return r.FixSizes()
+}
+
+// TrustPieces marks pieces as already verified by someone else, e.g. the
+// resume data of the client a torrent was imported from. Those pieces are
+// not hashed when existing files are checked, as long as their files are
+// present. It must be called before the first Run.
+func (s *Store) TrustPieces(have bitfield.Bitfield) {
+	s.trusted = have.Clone()
+}
+
+// RecheckPiece reads a piece back from disk and reports whether it matches
+// its hash.
+func (s *Store) RecheckPiece(index uint32) (bool, error) {
+	if int(index) >= len(s.pieceHashes) {
+		return false, fmt.Errorf("piece %d out of range", index)
+	}
+
+	data := make([]byte, s.pieceLength(index))
+	if err := s.readPiece(int(index), data); err != nil {
+		return false, err
+	}
+
+	return s.verify(index, data), nil
+}
+
+// UseCheckQueue makes the existing-data check wait its turn in q and
+// share its read throttle. It must be called before the first Run.
+func (s *Store) UseCheckQueue(q *CheckQueue) {
+	s.checks = q
+}
+
+// SetCheckPriority ranks the existing-data check in the check queue;
+// higher goes first. It takes effect even while the check waits.
+func (s *Store) SetCheckPriority(priority int) {
+	s.checkPriority.Store(int32(priority))
+}
+
+// CheckQueued reports whether the existing-data check is waiting for
+// other torrents' checks to finish.
+func (s *Store) CheckQueued() bool {
+	return s.checkQueued.Load()
+}
+
+// CheckProgress reports how far the existing-data check has got.
+func (s *Store) CheckProgress() CheckProgress {
+	return CheckProgress{
+		InfoHash: hex.EncodeToString(s.infoHash[:]),
+		Checked:  int(s.checkPos.Load()),
+		Total:    int(s.checkTotal.Load()),
+		Intact:   int(s.checkIntact.Load()),
+		Done:     s.checkDone.Load(),
+	}
+}
+
+// verifyExisting hash-checks every piece backed by data that was on disk
+// before the torrent was added and reports the intact ones as verified.
+//
+// The pass runs once per Store. If ctx is cancelled part way (the torrent
+// was paused) it picks up where it left off on the next Run.
+func (s *Store) verifyExisting(ctx context.Context) error {
+	if s.checkDone.Load() {
+		return nil
+	}
+
+	pieces := s.existingPieces()
+	if len(pieces) == 0 {
+		s.finishCheck()
+		return nil
+	}
+	s.checkTotal.Store(int64(len(pieces)))
+
+	s.checkQueued.Store(true)
+	release, err := s.checks.acquire(ctx, &s.checkPriority)
+	s.checkQueued.Store(false)
+	if err != nil {
+		return nil
+	}
+	defer release()
+
+	s.checking.Store(true)
+	defer s.checking.Store(false)
+
+	start := int(s.checkPos.Load())
+	s.log.Info("checking existing data", "pieces", len(pieces), "from", start)
+
+	workers := max(1, min(runtime.GOMAXPROCS(0), maxCheckBatch))
+
+	var lastReport time.Time
+	for i := start; i < len(pieces); {
+		batch := pieces[i:min(i+workers, len(pieces))]
+		intact := s.checkBatch(ctx, batch)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		for j, idx := range batch {
+			if intact[j] {
+				s.dropBuffer(idx)
+				s.pieceStored(idx)
+
+				select {
+				case s.PieceResultQueue <- &scheduler.PieceResult{
+					PieceIdx: idx,
+					Success:  true,
+					FromDisk: true,
+				}:
+					s.checkIntact.Add(1)
+				case <-ctx.Done():
+					return nil
+				}
+			}
+			s.checkPos.Store(int64(i + j + 1))
+		}
+		i += len(batch)
+
+		if time.Since(lastReport) >= checkProgressInterval {
+			lastReport = time.Now()
+			s.checks.progress(s.CheckProgress())
+		}
+	}
+
+	s.log.Info("existing data checked",
+		"pieces", len(pieces),
+		"intact", s.checkIntact.Load(),
+	)
+	s.finishCheck()
+	s.checks.progress(s.CheckProgress())
+	return nil
+}
+
+// checkBatch reports which of pieces are intact on disk. Trusted pieces
+// are taken as they are if all their files were there; the rest are
+// hashed.
+func (s *Store) checkBatch(ctx context.Context, pieces []uint32) []bool {
+	intact := make([]bool, len(pieces))
+	var (
+		pos     []int
+		pending []uint32
+	)
+
+	for i, idx := range pieces {
+		if s.trusted.Has(int(idx)) && s.preexisting(idx) {
+			intact[i] = true
+			continue
+		}
+		pos = append(pos, i)
+		pending = append(pending, idx)
+	}
+
+	for k, ok := range s.hashPieces(ctx, pending) {
+		intact[pos[k]] = ok
+	}
+	return intact
+}
+
+// hashPieces reports which of pieces match their hash on disk. They are
+// read one after another under the queue's throttle and at low I/O
+// priority, so a long check doesn't stall the desktop, then hashed in
+// parallel; unreadable pieces don't match.
+func (s *Store) hashPieces(ctx context.Context, pieces []uint32) []bool {
+	defer lowerIOPriority()()
+
+	intact := make([]bool, len(pieces))
+	var (
+		pos  []int
+		data [][]byte
+	)
+
+	for i, idx := range pieces {
+		n := s.pieceLength(idx)
+		if err := s.checks.read(ctx, int(n)); err != nil {
+			return intact
+		}
+
+		buf := make([]byte, n)
+		if err := s.readPiece(int(idx), buf); err != nil {
+			s.log.Warn("recheck piece failed", "piece", idx, "error", err.Error())
+			continue
+		}
+		pos = append(pos, i)
+		data = append(data, buf)
+	}
+
+	for k, sum := range piece.SumBatch(s.hasher, data, len(data)) {
+		i := pos[k]
+		intact[i] = s.matches(pieces[i], sum)
+	}
+	return intact
+}
+
+func (s *Store) finishCheck() {
+	s.checkDone.Store(true)
+	close(s.checked)
+}
+
+// preexisting reports whether every file a piece covers held data before
+// the torrent was added. A trusted piece spilling into a skipped file that
+// was never downloaded would otherwise be advertised without its data.
+func (s *Store) preexisting(index uint32) bool {
+	pre, ok := s.backend.(Preexisting)
+	if !ok {
+		return false
+	}
+
+	start := uint64(index) * uint64(s.pieceLen)
+	for _, i := range s.filesIn(start, start+uint64(s.pieceLength(index))) {
+		if !pre.Preexisting(i) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Store) existingPieces() []uint32 {
+	pre, ok := s.backend.(Preexisting)
+	if !ok {
+		return nil
+	}
+
+	var out []uint32
+	seen := make(map[uint32]struct{})
+
+	for i, file := range s.files {
+		if file.length == 0 || !pre.Preexisting(i) {
+			continue
+		}
+
+		first := uint32(file.offset / uint64(s.pieceLen))
+		last := uint32((file.offset + file.length - 1) / uint64(s.pieceLen))
+		for idx := first; idx <= last; idx++ {
+			if _, ok := seen[idx]; ok {
+				continue
+			}
+			seen[idx] = struct{}{}
+			out = append(out, idx)
+		}
+	}
+
+	return out
+}
+
+func (s *Store) pieceLength(index uint32) uint32 {
+	start := uint64(index) * uint64(s.pieceLen)
+	return uint32(min(uint64(s.pieceLen), s.totalSize-start))
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prxssh/rabbit/pkg/ratelimit"
+)
+
+// CheckQueue is shared by every torrent of a client and admits their
+// existing-data checks a few at a time, by priority and then in the order
+// they asked, while
+// throttling the disk reads of the checks it lets through. A torrent
+// being verified this way doesn't starve downloads of disk bandwidth.
+//
+// A nil CheckQueue admits every check at once, unthrottled.
+type CheckQueue struct {
+	reads *ratelimit.Limiter
+
+	// OnProgress, when set, is called as checks advance and once more when
+	// each finishes. Set it before the queue is handed to any torrent.
+	OnProgress func(CheckProgress)
+
+	mut     sync.Mutex
+	limit   int
+	running int
+	waiting []*checkWaiter
+}
+
+type checkWaiter struct {
+	ready    chan struct{}
+	priority *atomic.Int32
+}
+
+// CheckProgress reports how far a torrent's existing-data check has got.
+type CheckProgress struct {
+	InfoHash string `json:"infoHash"`
+	Checked  int    `json:"checked"`
+	Total    int    `json:"total"`
+	Intact   int    `json:"intact"`
+	Done     bool   `json:"done"`
+}
+
+// NewCheckQueue returns a queue running up to concurrency checks at once,
+// 0 meaning no limit, and reading at most readRate bytes/sec between them,
+// 0 meaning unthrottled.
+func NewCheckQueue(concurrency int, readRate uint64) *CheckQueue {
+	return &CheckQueue{
+		reads: ratelimit.NewLimiter(readRate),
+		limit: max(0, concurrency),
+	}
+}
+
+// SetConcurrency changes how many checks may run at once. Raising it lets
+// waiting checks start right away; lowering it lets running checks finish.
+func (q *CheckQueue) SetConcurrency(n int) {
+	q.mut.Lock()
+	q.limit = max(0, n)
+	q.admit()
+	q.mut.Unlock()
+}
+
+// SetReadRate changes the shared read throttle; 0 removes it.
+func (q *CheckQueue) SetReadRate(bytesPerSec uint64) {
+	q.reads.SetRate(bytesPerSec)
+}
+
+// Waiting returns how many checks are queued behind the running ones.
+func (q *CheckQueue) Waiting() int {
+	if q == nil {
+		return 0
+	}
+
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	return len(q.waiting)
+}
+
+// acquire blocks until the check may start or ctx is done. The returned
+// release must be called once the check stops, whether it finished or not.
+// priority is read each time a slot frees up, so it may change while the
+// check waits.
+func (q *CheckQueue) acquire(
+	ctx context.Context,
+	priority *atomic.Int32,
+) (release func(), err error) {
+	if q == nil {
+		return func() {}, nil
+	}
+
+	ready := make(chan struct{})
+
+	q.mut.Lock()
+	q.waiting = append(q.waiting, &checkWaiter{ready: ready, priority: priority})
+	q.admit()
+	q.mut.Unlock()
+
+	select {
+	case <-ready:
+	case <-ctx.Done():
+		q.mut.Lock()
+		defer q.mut.Unlock()
+
+		select {
+		case <-ready:
+			// Admitted while we were giving up; hand the slot on.
+			q.running--
+			q.admit()
+		default:
+			q.remove(ready)
+		}
+		return nil, ctx.Err()
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.mut.Lock()
+			q.running--
+			q.admit()
+			q.mut.Unlock()
+		})
+	}, nil
+}
+
+// read waits until n more bytes may be read for a check.
+func (q *CheckQueue) read(ctx context.Context, n int) error {
+	if q == nil {
+		return nil
+	}
+	return q.reads.WaitN(ctx, n)
+}
+
+func (q *CheckQueue) progress(p CheckProgress) {
+	if q != nil && q.OnProgress != nil {
+		q.OnProgress(p)
+	}
+}
+
+// admit starts waiting checks while there is room, the highest priority
+// first. Called with q.mut held.
+func (q *CheckQueue) admit() {
+	for len(q.waiting) > 0 && (q.limit == 0 || q.running < q.limit) {
+		next := 0
+		for i, w := range q.waiting {
+			if w.priority.Load() > q.waiting[next].priority.Load() {
+				next = i
+			}
+		}
+
+		close(q.waiting[next].ready)
+		q.waiting = slices.Delete(q.waiting, next, next+1)
+		q.running++
+	}
+}
+
+// remove drops a check that gave up waiting. Called with q.mut held.
+func (q *CheckQueue) remove(ready chan struct{}) {
+	for i, w := range q.waiting {
+		if w.ready == ready {
+			q.waiting = slices.Delete(q.waiting, i, i+1)
+			return
+		}
+	}
+}
@@ -0,0 +1,481 @@
+package storage
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prxssh/rabbit/internal/meta"
+)
+
+// FileBackend stores the torrent as regular files under a download
+// directory.
+type FileBackend struct {
+	// mut guards the file paths; renames change them while reads and
+	// writes hold the read lock.
+	mut     sync.RWMutex
+	files   []*datafile
+	handles *FilePool
+	// closed is set by Close and Delete; files aren't reopened after.
+	closed      bool
+	downloadDir string
+	rootName    string
+	multiFile   bool
+
+	// While staged, downloadDir is the .incomplete folder or some files
+	// carry suffix, until Finalize moves them to their final paths.
+	staged   bool
+	finalDir string
+	suffix   string
+}
+
+type datafile struct {
+	// f is the open handle, nil while closed. f, elem and users are
+	// guarded by the FilePool's mut.
+	f     *os.File
+	elem  *list.Element
+	users int
+	// dirty is set by writes since the file was last synced.
+	dirty atomic.Bool
+
+	length uint64
+	path   string
+	// existing is set when the file already held data before we opened
+	// it, so its pieces are worth hash-checking instead of downloading.
+	existing bool
+	// sizeMismatch is set when an existing file's size differs from the
+	// metainfo. It is left untouched until FixSizes is called.
+	sizeMismatch bool
+	// partial is set while the file's name carries the incomplete suffix.
+	partial bool
+}
+
+func NewFileBackend(metainfo *meta.Metainfo, cfg *Config) (*FileBackend, error) {
+	workDir, suffix := stagedLayout(metainfo, cfg)
+
+	files, err := setupFiles(metainfo, workDir, suffix)
+	if err != nil {
+		return nil, err
+	}
+	staged := workDir != cfg.DownloadDir || slices.ContainsFunc(files, isPartial)
+
+	return &FileBackend{
+		files:       files,
+		handles:     NewFilePool(cfg.MaxOpenFiles),
+		downloadDir: workDir,
+		rootName:    metainfo.Info.Name,
+		multiFile:   metainfo.Info.Length == 0,
+		staged:      staged,
+		finalDir:    cfg.DownloadDir,
+		suffix:      suffix,
+	}, nil
+}
+
+func (b *FileBackend) ReadAt(file int, p []byte, off int64) (int, error) {
+	b.mut.RLock()
+	defer b.mut.RUnlock()
+
+	if file < 0 || file >= len(b.files) {
+		return 0, ErrFileNotFound
+	}
+
+	df := b.files[file]
+	f, err := b.open(df)
+	if err != nil {
+		return 0, err
+	}
+	defer b.handles.release(df)
+
+	return f.ReadAt(p, off)
+}
+
+func (b *FileBackend) WriteAt(file int, p []byte, off int64) (int, error) {
+	b.mut.RLock()
+	defer b.mut.RUnlock()
+
+	if file < 0 || file >= len(b.files) {
+		return 0, ErrFileNotFound
+	}
+
+	df := b.files[file]
+	f, err := b.open(df)
+	if err != nil {
+		return 0, err
+	}
+	defer b.handles.release(df)
+
+	// Marked after the write, so a Flush racing it can't clear the mark
+	// before the data it covers is in.
+	n, err := f.WriteAt(p, off)
+	df.dirty.Store(true)
+	return n, err
+}
+
+// UseFilePool moves the backend's files to p, shared with other
+// torrents, in place of the pool of its own it was created with.
+func (b *FileBackend) UseFilePool(p *FilePool) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	_ = b.handles.closeAll(b.files)
+	b.handles = p
+}
+
+// open acquires df's handle, unless the backend is closed. Called with
+// b.mut held.
+func (b *FileBackend) open(df *datafile) (*os.File, error) {
+	if b.closed {
+		return nil, os.ErrClosed
+	}
+	return b.handles.acquire(df)
+}
+
+// Flush syncs the files written since the last flush, reopening those
+// closed in the meantime: syncing any handle flushes the file.
+func (b *FileBackend) Flush() error {
+	b.mut.RLock()
+	defer b.mut.RUnlock()
+
+	var errs []error
+	for _, file := range b.files {
+		if !file.dirty.Swap(false) {
+			continue
+		}
+		if err := b.sync(file); err != nil {
+			file.dirty.Store(true)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (b *FileBackend) sync(df *datafile) error {
+	f, err := b.open(df)
+	if err != nil {
+		return err
+	}
+	defer b.handles.release(df)
+
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync %s: %w", df.path, err)
+	}
+	return nil
+}
+
+func (b *FileBackend) Close() error {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	b.closed = true
+	return b.handles.closeAll(b.files)
+}
+
+// ContentPath is the torrent's root folder, or its only file, following
+// renames.
+func (b *FileBackend) ContentPath() string {
+	b.mut.RLock()
+	defer b.mut.RUnlock()
+
+	if !b.multiFile {
+		return b.files[0].path
+	}
+	return filepath.Join(b.downloadDir, b.rootName)
+}
+
+// Delete closes and removes the torrent's files, then prunes the
+// directories they leave empty. Nothing outside the torrent's own paths
+// is touched, and the download directory itself is kept.
+func (b *FileBackend) Delete() error {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	var errs []error
+	b.closed = true
+	if err := b.handles.closeAll(b.files); err != nil {
+		errs = append(errs, err)
+	}
+	for _, file := range b.files {
+		if err := os.Remove(file.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("remove %s: %w", file.path, err))
+			continue
+		}
+		b.removeEmptyDirs(filepath.Dir(file.path))
+	}
+
+	// The root folder goes too unless something else was put in it.
+	if b.multiFile {
+		_ = os.Remove(filepath.Join(b.downloadDir, b.rootName))
+	}
+	// Likewise the .incomplete folder.
+	if b.downloadDir != b.finalDir {
+		_ = os.Remove(b.downloadDir)
+	}
+	return errors.Join(errs...)
+}
+
+func (b *FileBackend) Preexisting(file int) bool {
+	b.mut.RLock()
+	defer b.mut.RUnlock()
+
+	return file >= 0 && file < len(b.files) && b.files[file].existing
+}
+
+func (b *FileBackend) SizeMismatches() []int {
+	b.mut.RLock()
+	defer b.mut.RUnlock()
+
+	var out []int
+	for i, file := range b.files {
+		if file.sizeMismatch {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// FixSizes resizes mismatched files to their metainfo length. Their
+// contents are kept for the hash check that follows.
+func (b *FileBackend) FixSizes() error {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	for _, file := range b.files {
+		if !file.sizeMismatch {
+			continue
+		}
+		if err := os.Truncate(file.path, int64(file.length)); err != nil {
+			return fmt.Errorf("resize %s: %w", file.path, err)
+		}
+		file.sizeMismatch = false
+	}
+	return nil
+}
+
+func (b *FileBackend) RenameFile(index int, newPath string) error {
+	if !b.multiFile {
+		if index != 0 {
+			return ErrFileNotFound
+		}
+		return b.RenameRoot(newPath)
+	}
+
+	rel, err := cleanRelPath(newPath)
+	if err != nil {
+		return err
+	}
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if index < 0 || index >= len(b.files) {
+		return ErrFileNotFound
+	}
+
+	file := b.files[index]
+	dst := filepath.Join(b.downloadDir, b.rootName, rel)
+	if file.partial {
+		dst += b.suffix
+	}
+	if dst == file.path {
+		return nil
+	}
+
+	return b.moveFile(file, dst)
+}
+
+func (b *FileBackend) RenameRoot(newName string) error {
+	name, err := cleanRelPath(newName)
+	if err != nil {
+		return err
+	}
+	if strings.ContainsRune(name, filepath.Separator) {
+		return fmt.Errorf("%w: root name must not contain separators", ErrInvalidPath)
+	}
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if name == b.rootName {
+		return nil
+	}
+
+	if !b.multiFile {
+		dst := filepath.Join(b.downloadDir, name)
+		if b.files[0].partial {
+			dst += b.suffix
+		}
+		if err := b.moveFile(b.files[0], dst); err != nil {
+			return err
+		}
+		b.rootName = name
+		return nil
+	}
+
+	oldRoot := filepath.Join(b.downloadDir, b.rootName)
+	newRoot := filepath.Join(b.downloadDir, name)
+	if _, err := os.Lstat(newRoot); err == nil {
+		return ErrPathExists
+	}
+
+	// Handles are closed across the rename since Windows refuses to move
+	// a directory with open files in it. They reopen on next use.
+	_ = b.handles.closeAll(b.files)
+
+	if err := os.Rename(oldRoot, newRoot); err != nil {
+		return fmt.Errorf("rename %s: %w", oldRoot, err)
+	}
+	b.rootName = name
+	for _, f := range b.files {
+		rel, _ := filepath.Rel(oldRoot, f.path)
+		f.path = filepath.Join(newRoot, rel)
+	}
+
+	return nil
+}
+
+func (b *FileBackend) moveFile(file *datafile, dst string) error {
+	if _, err := os.Lstat(dst); err == nil {
+		return ErrPathExists
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+
+	_ = b.handles.close(file)
+
+	oldPath := file.path
+	if err := os.Rename(oldPath, dst); err != nil {
+		return fmt.Errorf("rename %s: %w", oldPath, err)
+	}
+	file.path = dst
+
+	if b.multiFile {
+		b.removeEmptyDirs(filepath.Dir(oldPath))
+	}
+
+	return nil
+}
+
+// removeEmptyDirs prunes directories left empty by a rename, stopping at
+// the torrent's root folder.
+func (b *FileBackend) removeEmptyDirs(dir string) {
+	root := filepath.Join(b.downloadDir, b.rootName)
+	for dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// setupFiles creates the torrent's files under downloadDir, leaving them
+// closed until first used. With a suffix, a file not yet at its final
+// name is created under the suffixed one. Empty files are complete from
+// the start, and no piece would ever strip their suffix, so they get
+// their final name right away.
+func setupFiles(metainfo *meta.Metainfo, downloadDir, suffix string) ([]*datafile, error) {
+	if err := os.MkdirAll(downloadDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	paths := filePaths(metainfo, downloadDir)
+	datafiles := make([]*datafile, 0, len(paths))
+	for i, fp := range paths {
+		length := fileLength(metainfo, i)
+		partial := false
+		switch {
+		case suffix == "":
+		case length == 0:
+			removeEmptyFile(fp + suffix)
+		default:
+			if _, err := os.Lstat(fp); err != nil {
+				fp, partial = fp+suffix, true
+			}
+		}
+
+		mapping, err := createFileMapping(fp, length)
+		if err != nil {
+			return nil, err
+		}
+		mapping.partial = partial
+
+		datafiles = append(datafiles, mapping)
+	}
+
+	return datafiles, nil
+}
+
+// removeEmptyFile removes path if it is an empty regular file, such as
+// an empty file a previous session left under the incomplete suffix.
+func removeEmptyFile(path string) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode().IsRegular() && fi.Size() == 0 {
+		_ = os.Remove(path)
+	}
+}
+
+// filePaths returns where each of the torrent's files lives under
+// downloadDir, in metainfo order.
+func filePaths(metainfo *meta.Metainfo, downloadDir string) []string {
+	if metainfo.Info.Length > 0 {
+		return []string{filepath.Join(downloadDir, metainfo.Info.Name)}
+	}
+
+	paths := make([]string, 0, len(metainfo.Info.Files))
+	for _, file := range metainfo.Info.Files {
+		fp := filepath.Join(downloadDir, metainfo.Info.Name)
+		for _, pathPart := range file.Path {
+			fp = filepath.Join(fp, pathPart)
+		}
+		paths = append(paths, fp)
+	}
+	return paths
+}
+
+func fileLength(metainfo *meta.Metainfo, index int) uint64 {
+	if metainfo.Info.Length > 0 {
+		return metainfo.Info.Length
+	}
+	return metainfo.Info.Files[index].Length
+}
+
+func createFileMapping(path string, size uint64) (*datafile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	var existing, mismatch bool
+	if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() && fi.Size() > 0 {
+		existing = true
+		mismatch = uint64(fi.Size()) != size
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	// A file of the wrong size was modified behind our back; resizing it
+	// now would silently destroy or shift data, so leave it for a recheck.
+	if !mismatch {
+		if err := file.Truncate(int64(size)); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+
+	return &datafile{
+		path:         path,
+		length:       size,
+		existing:     existing,
+		sizeMismatch: mismatch,
+	}, nil
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FilePool keeps at most max files open across the backends sharing it,
+// so torrents with thousands of files between them don't run the process
+// out of descriptors. Files are opened on first use and the least
+// recently used one is closed when another is needed, whichever torrent
+// it belongs to; files mid read or write are never closed.
+type FilePool struct {
+	mut sync.Mutex
+	max int
+	// lru holds the open files, most recently used first.
+	lru list.List
+}
+
+// NewFilePool returns a pool holding at most limit files open. Zero keeps
+// them all open.
+func NewFilePool(limit int) *FilePool {
+	return &FilePool{max: limit}
+}
+
+// acquire returns df's handle, opening it if needed. It stays open until
+// the matching release.
+func (h *FilePool) acquire(df *datafile) (*os.File, error) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	if df.f != nil {
+		h.lru.MoveToFront(df.elem)
+	} else {
+		f, err := os.OpenFile(df.path, os.O_RDWR, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("open %s: %w", df.path, err)
+		}
+		df.f = f
+		df.elem = h.lru.PushFront(df)
+		h.evictLocked()
+	}
+
+	df.users++
+	return df.f, nil
+}
+
+func (h *FilePool) release(df *datafile) {
+	h.mut.Lock()
+	df.users--
+	h.mut.Unlock()
+}
+
+// evictLocked closes unused files, oldest first, until at most max are
+// open. Called with h.mut held.
+func (h *FilePool) evictLocked() {
+	if h.max <= 0 {
+		return
+	}
+
+	for e := h.lru.Back(); e != nil && h.lru.Len() > h.max; {
+		prev := e.Prev()
+		if df := e.Value.(*datafile); df.users == 0 {
+			_ = h.closeLocked(df)
+		}
+		e = prev
+	}
+}
+
+// close closes df's handle, if open, e.g. before it is moved. The next
+// acquire reopens it at its new path.
+func (h *FilePool) close(df *datafile) error {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	return h.closeLocked(df)
+}
+
+func (h *FilePool) closeLocked(df *datafile) error {
+	if df.f == nil {
+		return nil
+	}
+
+	// df.path may be changing under its backend's lock; the handle's name
+	// is fixed.
+	name := df.f.Name()
+	err := df.f.Close()
+	h.lru.Remove(df.elem)
+	df.f, df.elem = nil, nil
+	if err != nil && !errors.Is(err, os.ErrClosed) {
+		return fmt.Errorf("close %s: %w", name, err)
+	}
+	return nil
+}
+
+// closeAll closes the open files among files, e.g. all of a backend's.
+func (h *FilePool) closeAll(files []*datafile) error {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	var errs []error
+	for _, df := range files {
+		if err := h.closeLocked(df); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/prxssh/rabbit/internal/meta"
+)
+
+func TestFilePool_SharedAcrossBackends(t *testing.T) {
+	dir := t.TempDir()
+	pool := NewFilePool(1)
+
+	a := testMetainfo()
+	b := testMetainfo()
+	b.Info.Name = "u"
+
+	var backends []*FileBackend
+	for _, metainfo := range []*meta.Metainfo{a, b} {
+		fb, err := NewFileBackend(metainfo, testConfig(dir, IncompleteInPlace))
+		if err != nil {
+			t.Fatalf("NewFileBackend(%s) error = %v", metainfo.Info.Name, err)
+		}
+		fb.UseFilePool(pool)
+		backends = append(backends, fb)
+	}
+
+	for i, fb := range backends {
+		if _, err := fb.WriteAt(0, []byte("x"), 0); err != nil {
+			t.Fatalf("backend %d WriteAt() error = %v", i, err)
+		}
+		if got := pool.lru.Len(); got != 1 {
+			t.Errorf("after backend %d wrote, %d files open, want 1", i, got)
+		}
+	}
+	if backends[0].files[0].f != nil {
+		t.Error("first backend's file still open after the second took the slot")
+	}
+
+	// Closing one backend leaves the other's files usable.
+	if err := backends[1].Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := backends[1].ReadAt(0, make([]byte, 1), 0); err == nil {
+		t.Error("ReadAt() on a closed backend succeeded")
+	}
+	if _, err := backends[0].ReadAt(0, make([]byte, 1), 0); err != nil {
+		t.Errorf("ReadAt() on the open backend error = %v", err)
+	}
+	backends[0].Close()
+}
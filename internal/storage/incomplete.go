@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/prxssh/rabbit/internal/meta"
+)
+
+// IncompleteMode is where a torrent's files are kept until it completes,
+// so half-finished files stay out of media library scans.
+type IncompleteMode string
+
+const (
+	// IncompleteInPlace writes files at their final paths from the start.
+	IncompleteInPlace IncompleteMode = ""
+	// IncompleteDir downloads into a ".incomplete" folder under the
+	// download directory and moves the torrent out of it once complete.
+	IncompleteDir IncompleteMode = "dir"
+	// IncompleteSuffix appends Config.IncompleteSuffix to the name of
+	// every unfinished file and strips it as each file's last piece is
+	// stored.
+	IncompleteSuffix IncompleteMode = "suffix"
+)
+
+const incompleteDirName = ".incomplete"
+
+// Finalize moves a complete torrent's files to their final paths. It does
+// nothing for backends that don't stage incomplete files.
+func (s *Store) Finalize() error {
+	if f, ok := s.backend.(Finalizer); ok {
+		return f.Finalize()
+	}
+	return nil
+}
+
+// filePieceCounts returns how many pieces each file spans.
+func filePieceCounts(files []fileSpan, pieceLen uint32) []int {
+	counts := make([]int, len(files))
+	for i, file := range files {
+		if file.length == 0 || pieceLen == 0 {
+			continue
+		}
+		first := file.offset / uint64(pieceLen)
+		last := (file.offset + file.length - 1) / uint64(pieceLen)
+		counts[i] = int(last - first + 1)
+	}
+	return counts
+}
+
+// pieceStored records a piece as on disk and finalizes the files it
+// completes.
+func (s *Store) pieceStored(index uint32) {
+	fin, ok := s.backend.(Finalizer)
+	if !ok {
+		return
+	}
+
+	s.storedMut.Lock()
+	if s.stored.Has(int(index)) {
+		s.storedMut.Unlock()
+		return
+	}
+	s.stored.Set(int(index))
+
+	var done []int
+	start := uint64(index) * uint64(s.pieceLen)
+	end := start + uint64(s.pieceLength(index))
+	for i, file := range s.files {
+		if file.length == 0 || file.offset >= end || file.offset+file.length <= start {
+			continue
+		}
+		s.fileMissing[i]--
+		if s.fileMissing[i] == 0 {
+			done = append(done, i)
+		}
+	}
+	s.storedMut.Unlock()
+
+	for _, i := range done {
+		if err := fin.FinalizeFile(i); err != nil {
+			s.log.Error("finalize file failed", "file", i, "error", err)
+		}
+	}
+}
+
+// stagedLayout returns where a torrent's files go while incomplete: the
+// directory standing in for the download directory and the suffix added to
+// unfinished file names. A torrent already present at its final paths, say
+// from an earlier session, is not moved into .incomplete.
+func stagedLayout(metainfo *meta.Metainfo, cfg *Config) (string, string) {
+	switch cfg.Incomplete {
+	case IncompleteSuffix:
+		return cfg.DownloadDir, cfg.IncompleteSuffix
+	case IncompleteDir:
+		for _, fp := range filePaths(metainfo, cfg.DownloadDir) {
+			if _, err := os.Lstat(fp); err == nil {
+				return cfg.DownloadDir, ""
+			}
+		}
+		return filepath.Join(cfg.DownloadDir, incompleteDirName), ""
+	default:
+		return cfg.DownloadDir, ""
+	}
+}
+
+func isPartial(f *datafile) bool { return f.partial }
+
+// FinalizeFile strips the suffix from a file whose every piece is stored.
+// In dir mode files only move together, on Finalize.
+func (b *FileBackend) FinalizeFile(index int) error {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if index < 0 || index >= len(b.files) {
+		return ErrFileNotFound
+	}
+	if err := b.stripSuffix(b.files[index]); err != nil {
+		return err
+	}
+
+	b.staged = b.downloadDir != b.finalDir || slices.ContainsFunc(b.files, isPartial)
+	return nil
+}
+
+// Finalize moves the torrent's root out of the .incomplete folder, or the
+// suffix off any file still carrying it.
+func (b *FileBackend) Finalize() error {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if !b.staged {
+		return nil
+	}
+
+	if b.downloadDir != b.finalDir {
+		// As for RenameRoot, handles are closed across the move.
+		_ = b.handles.closeAll(b.files)
+		if err := b.leaveIncompleteDir(); err != nil {
+			return err
+		}
+	}
+
+	var errs []error
+	for _, f := range b.files {
+		if err := b.stripSuffix(f); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	b.staged = false
+	return nil
+}
+
+// stripSuffix renames a partial file to its final name. Called with b.mut
+// held.
+func (b *FileBackend) stripSuffix(f *datafile) error {
+	if !f.partial {
+		return nil
+	}
+
+	dst := strings.TrimSuffix(f.path, b.suffix)
+	if err := b.moveFile(f, dst); err != nil {
+		return fmt.Errorf("finalize %s: %w", f.path, err)
+	}
+	f.partial = false
+	return nil
+}
+
+// leaveIncompleteDir moves the root file or folder into the download
+// directory in one rename, and removes the .incomplete folder if that
+// left it empty.
+func (b *FileBackend) leaveIncompleteDir() error {
+	src := filepath.Join(b.downloadDir, b.rootName)
+	dst := filepath.Join(b.finalDir, b.rootName)
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("%w: %s", ErrPathExists, dst)
+	}
+	if err := os.MkdirAll(b.finalDir, 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return fmt.Errorf("rename %s: %w", src, err)
+	}
+
+	for _, f := range b.files {
+		rel, _ := filepath.Rel(src, f.path)
+		f.path = filepath.Join(dst, rel)
+	}
+	_ = os.Remove(b.downloadDir)
+	b.downloadDir = b.finalDir
+	return nil
+}
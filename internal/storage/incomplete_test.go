@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prxssh/rabbit/internal/meta"
+)
+
+// testMetainfo describes a torrent "t" of three files, the middle one
+// empty, over two 8-byte pieces: a spans both, c only the second.
+func testMetainfo() *meta.Metainfo {
+	return &meta.Metainfo{
+		Size: 15,
+		Info: &meta.Info{
+			Name:        "t",
+			PieceLength: 8,
+			Pieces:      make([][sha1.Size]byte, 2),
+			Files: []*meta.File{
+				{Length: 10, Path: []string{"a"}},
+				{Length: 0, Path: []string{"b"}},
+				{Length: 5, Path: []string{"sub", "c"}},
+			},
+		},
+	}
+}
+
+func testConfig(dir string, mode IncompleteMode) *Config {
+	cfg := WithDefaultConfig()
+	cfg.DownloadDir = dir
+	cfg.Incomplete = mode
+	return cfg
+}
+
+func assertExists(t *testing.T, path string) {
+	t.Helper()
+	if _, err := os.Lstat(path); err != nil {
+		t.Errorf("%s missing: %v", path, err)
+	}
+}
+
+func assertMissing(t *testing.T, path string) {
+	t.Helper()
+	if _, err := os.Lstat(path); err == nil {
+		t.Errorf("%s exists, want it gone", path)
+	}
+}
+
+func TestFileBackend_SuffixStagesUnfinishedFiles(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "t")
+
+	// An empty file left suffixed by an earlier session.
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "b.!rb"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewFileBackend(testMetainfo(), testConfig(dir, IncompleteSuffix))
+	if err != nil {
+		t.Fatalf("NewFileBackend() error = %v", err)
+	}
+	defer b.Close()
+
+	assertExists(t, filepath.Join(root, "a.!rb"))
+	assertExists(t, filepath.Join(root, "sub", "c.!rb"))
+	assertMissing(t, filepath.Join(root, "a"))
+	// Empty files are complete already and never carry the suffix.
+	assertExists(t, filepath.Join(root, "b"))
+	assertMissing(t, filepath.Join(root, "b.!rb"))
+
+	if !b.staged {
+		t.Error("staged = false with suffixed files")
+	}
+}
+
+func TestFileBackend_FinalizeFileStripsSuffix(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "t")
+	cfg := testConfig(dir, IncompleteSuffix)
+
+	b, err := NewFileBackend(testMetainfo(), cfg)
+	if err != nil {
+		t.Fatalf("NewFileBackend() error = %v", err)
+	}
+
+	if err := b.FinalizeFile(0); err != nil {
+		t.Fatalf("FinalizeFile(0) error = %v", err)
+	}
+	assertExists(t, filepath.Join(root, "a"))
+	assertMissing(t, filepath.Join(root, "a.!rb"))
+	if !b.staged {
+		t.Error("staged = false while c is still suffixed")
+	}
+
+	if err := b.FinalizeFile(2); err != nil {
+		t.Fatalf("FinalizeFile(2) error = %v", err)
+	}
+	assertExists(t, filepath.Join(root, "sub", "c"))
+	if b.staged {
+		t.Error("staged = true after every file was finalized")
+	}
+	b.Close()
+
+	// Reopening finds finished files under their final names.
+	b, err = NewFileBackend(testMetainfo(), cfg)
+	if err != nil {
+		t.Fatalf("NewFileBackend() reopen error = %v", err)
+	}
+	defer b.Close()
+	if b.staged {
+		t.Error("staged = true on reopening a finished torrent")
+	}
+	assertMissing(t, filepath.Join(root, "a.!rb"))
+}
+
+func TestFileBackend_FinalizeLeavesIncompleteDir(t *testing.T) {
+	dir := t.TempDir()
+	staging := filepath.Join(dir, incompleteDirName)
+
+	b, err := NewFileBackend(testMetainfo(), testConfig(dir, IncompleteDir))
+	if err != nil {
+		t.Fatalf("NewFileBackend() error = %v", err)
+	}
+	defer b.Close()
+
+	assertExists(t, filepath.Join(staging, "t", "a"))
+	assertMissing(t, filepath.Join(dir, "t"))
+
+	// Files only move together, with the whole torrent.
+	if err := b.FinalizeFile(0); err != nil {
+		t.Fatalf("FinalizeFile(0) error = %v", err)
+	}
+	assertMissing(t, filepath.Join(dir, "t"))
+
+	if err := b.Finalize(); err != nil {
+		t.Fatalf("Finalize() error = %v", err)
+	}
+	assertExists(t, filepath.Join(dir, "t", "a"))
+	assertExists(t, filepath.Join(dir, "t", "sub", "c"))
+	assertMissing(t, staging)
+	if got, want := b.ContentPath(), filepath.Join(dir, "t"); got != want {
+		t.Errorf("ContentPath() = %s, want %s", got, want)
+	}
+
+	if err := b.Finalize(); err != nil {
+		t.Errorf("Finalize() again error = %v", err)
+	}
+}
+
+func TestFileBackend_FinalizeStripsRemainingSuffixes(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "t")
+
+	b, err := NewFileBackend(testMetainfo(), testConfig(dir, IncompleteSuffix))
+	if err != nil {
+		t.Fatalf("NewFileBackend() error = %v", err)
+	}
+	defer b.Close()
+
+	if err := b.Finalize(); err != nil {
+		t.Fatalf("Finalize() error = %v", err)
+	}
+	assertExists(t, filepath.Join(root, "a"))
+	assertExists(t, filepath.Join(root, "sub", "c"))
+	assertMissing(t, filepath.Join(root, "a.!rb"))
+	assertMissing(t, filepath.Join(root, "sub", "c.!rb"))
+}
+
+func TestStore_PieceStoredFinalizesCompletedFiles(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "t")
+	metainfo := testMetainfo()
+	cfg := testConfig(dir, IncompleteSuffix)
+
+	b, err := NewFileBackend(metainfo, cfg)
+	if err != nil {
+		t.Fatalf("NewFileBackend() error = %v", err)
+	}
+	s := NewStorageWithBackend(metainfo, cfg, b, nil)
+	defer s.Close()
+
+	s.pieceStored(0)
+	assertExists(t, filepath.Join(root, "a.!rb"))
+
+	// A repeat of a stored piece counts once.
+	s.pieceStored(0)
+	assertExists(t, filepath.Join(root, "a.!rb"))
+
+	s.pieceStored(1)
+	assertExists(t, filepath.Join(root, "a"))
+	assertExists(t, filepath.Join(root, "sub", "c"))
+	assertMissing(t, filepath.Join(root, "sub", "c.!rb"))
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"runtime"
+
+	"github.com/prxssh/rabbit/pkg/bitfield"
+)
+
+// IntegrityReport is the outcome of VerifyData: which verified pieces no
+// longer match their hash on disk, and where they fall in each file.
+type IntegrityReport struct {
+	Checked      int             `json:"checked"`
+	Corrupt      []uint32        `json:"corrupt"`
+	CorruptBytes uint64          `json:"corruptBytes"`
+	Files        []FileIntegrity `json:"files"`
+}
+
+// FileIntegrity lists the corrupt parts of one file. Files without any are
+// left out of the report.
+type FileIntegrity struct {
+	Index  int         `json:"index"`
+	Ranges []ByteRange `json:"ranges"`
+	Pieces []uint32    `json:"pieces"`
+}
+
+// ByteRange is a span of a file, relative to its start.
+type ByteRange struct {
+	Offset uint64 `json:"offset"`
+	Length uint64 `json:"length"`
+}
+
+// VerifyData hashes the pieces in have as they are on disk and reports
+// those that don't match, without changing what the torrent holds.
+// Unreadable pieces count as corrupt. It waits its turn in the check queue
+// and shares its read throttle.
+func (s *Store) VerifyData(ctx context.Context, have bitfield.Bitfield) (*IntegrityReport, error) {
+	var pieces []uint32
+	for i := range s.pieceHashes {
+		if have.Has(i) {
+			pieces = append(pieces, uint32(i))
+		}
+	}
+
+	release, err := s.checks.acquire(ctx, &s.checkPriority)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	s.log.Info("verifying data", "pieces", len(pieces))
+
+	workers := max(1, min(runtime.GOMAXPROCS(0), maxCheckBatch))
+	report := &IntegrityReport{Checked: len(pieces)}
+
+	for i := 0; i < len(pieces); i += workers {
+		batch := pieces[i:min(i+workers, len(pieces))]
+		intact := s.hashPieces(ctx, batch)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		for j, idx := range batch {
+			if !intact[j] {
+				report.Corrupt = append(report.Corrupt, idx)
+				report.CorruptBytes += uint64(s.pieceLength(idx))
+			}
+		}
+	}
+	report.Files = s.corruptFiles(report.Corrupt)
+
+	s.log.Info("data verified", "pieces", len(pieces), "corrupt", len(report.Corrupt))
+	return report, nil
+}
+
+// corruptFiles maps corrupt pieces, in ascending order, onto the files
+// they cover.
+func (s *Store) corruptFiles(corrupt []uint32) []FileIntegrity {
+	byFile := make(map[int]*FileIntegrity)
+	var order []int
+
+	for _, idx := range corrupt {
+		start := uint64(idx) * uint64(s.pieceLen)
+		end := start + uint64(s.pieceLength(idx))
+
+		for _, i := range s.filesIn(start, end) {
+			file := s.files[i]
+			from := max(start, file.offset) - file.offset
+			to := min(end, file.offset+file.length) - file.offset
+
+			fi, ok := byFile[i]
+			if !ok {
+				fi = &FileIntegrity{Index: i}
+				byFile[i] = fi
+				order = append(order, i)
+			}
+			fi.Pieces = append(fi.Pieces, idx)
+
+			if n := len(fi.Ranges); n > 0 && fi.Ranges[n-1].Offset+fi.Ranges[n-1].Length == from {
+				fi.Ranges[n-1].Length += to - from
+			} else {
+				fi.Ranges = append(fi.Ranges, ByteRange{Offset: from, Length: to - from})
+			}
+		}
+	}
+
+	out := make([]FileIntegrity, 0, len(order))
+	for _, i := range order {
+		out = append(out, *byFile[i])
+	}
+	return out
+}
+
+// ForgetPieces marks pieces as no longer on disk so they are written, and
+// their files finalized, again once downloaded anew.
+func (s *Store) ForgetPieces(pieces []uint32) {
+	s.storedMut.Lock()
+	defer s.storedMut.Unlock()
+
+	for _, idx := range pieces {
+		if int(idx) >= len(s.pieceHashes) {
+			continue
+		}
+		s.trusted.Clear(int(idx))
+		if !s.stored.Clear(int(idx)) {
+			continue
+		}
+
+		start := uint64(idx) * uint64(s.pieceLen)
+		end := start + uint64(s.pieceLength(idx))
+		for _, i := range s.filesIn(start, end) {
+			s.fileMissing[i]++
+		}
+	}
+}
@@ -0,0 +1,40 @@
+//go:build linux
+
+package storage
+
+import (
+	"runtime"
+	"syscall"
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+	ioprioClassBE    = 2
+)
+
+// lowerIOPriority drops the calling thread to the lowest best-effort I/O
+// priority, as "ionice -c2 -n7" would, until restore is called. The idle
+// class would be lower still, but starves the check outright while the
+// disk is busy with downloads. The goroutine is pinned to its thread
+// meanwhile; should the old priority not come back, the thread stays
+// pinned and exits with the goroutine rather than serving others.
+func lowerIOPriority() (restore func()) {
+	runtime.LockOSThread()
+
+	prev, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_GET, ioprioWhoProcess, 0, 0)
+	if errno != 0 {
+		runtime.UnlockOSThread()
+		return func() {}
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, 0, ioprioClassBE<<ioprioClassShift|7); errno != 0 {
+		runtime.UnlockOSThread()
+		return func() {}
+	}
+
+	return func() {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, 0, prev); errno == 0 {
+			runtime.UnlockOSThread()
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !windows
+
+package storage
+
+// lowerIOPriority is a no-op where there is no per-thread I/O priority we
+// can set without cgo.
+func lowerIOPriority() (restore func()) {
+	return func() {}
+}
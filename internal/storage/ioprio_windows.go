@@ -0,0 +1,39 @@
+//go:build windows
+
+package storage
+
+import (
+	"runtime"
+	"syscall"
+)
+
+var (
+	procGetCurrentThread  = syscall.NewLazyDLL("kernel32.dll").NewProc("GetCurrentThread")
+	procSetThreadPriority = syscall.NewLazyDLL("kernel32.dll").NewProc("SetThreadPriority")
+)
+
+const (
+	threadModeBackgroundBegin = 0x00010000
+	threadModeBackgroundEnd   = 0x00020000
+)
+
+// lowerIOPriority puts the calling thread in background mode, which
+// throttles its disk and memory priority, until restore is called. The
+// goroutine is pinned to its thread meanwhile; should background mode
+// not end, the thread stays pinned and exits with the goroutine rather
+// than serving others.
+func lowerIOPriority() (restore func()) {
+	runtime.LockOSThread()
+
+	thread, _, _ := procGetCurrentThread.Call()
+	if ok, _, _ := procSetThreadPriority.Call(thread, threadModeBackgroundBegin); ok == 0 {
+		runtime.UnlockOSThread()
+		return func() {}
+	}
+
+	return func() {
+		if ok, _, _ := procSetThreadPriority.Call(thread, threadModeBackgroundEnd); ok != 0 {
+			runtime.UnlockOSThread()
+		}
+	}
+}
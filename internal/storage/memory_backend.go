@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"io"
+	"sync"
+
+	"github.com/prxssh/rabbit/internal/meta"
+)
+
+// MemoryBackend keeps the torrent entirely in memory. Useful for tests and
+// for content that is only ever streamed.
+type MemoryBackend struct {
+	mut   sync.RWMutex
+	files [][]byte
+}
+
+func NewMemoryBackend(metainfo *meta.Metainfo) *MemoryBackend {
+	spans := fileSpans(metainfo)
+	files := make([][]byte, len(spans))
+	for i, span := range spans {
+		files[i] = make([]byte, span.length)
+	}
+
+	return &MemoryBackend{files: files}
+}
+
+func (b *MemoryBackend) ReadAt(file int, p []byte, off int64) (int, error) {
+	b.mut.RLock()
+	defer b.mut.RUnlock()
+
+	if file < 0 || file >= len(b.files) {
+		return 0, ErrFileNotFound
+	}
+
+	data := b.files[file]
+	if off < 0 || off > int64(len(data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (b *MemoryBackend) WriteAt(file int, p []byte, off int64) (int, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if file < 0 || file >= len(b.files) {
+		return 0, ErrFileNotFound
+	}
+
+	data := b.files[file]
+	if off < 0 || off+int64(len(p)) > int64(len(data)) {
+		return 0, io.ErrShortWrite
+	}
+
+	return copy(data[off:], p), nil
+}
+
+func (b *MemoryBackend) Flush() error { return nil }
+
+func (b *MemoryBackend) Close() error { return nil }
+
+func (b *MemoryBackend) Delete() error {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	for i := range b.files {
+		b.files[i] = nil
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// maxReadBatch caps how many bytes of adjacent requests are merged into a
+// single disk read.
+const maxReadBatch = 256 * 1024
+
+var ErrReadsClosed = errors.New("storage: read source closed")
+
+// ReadScheduler serves the block reads peers ask of every torrent of a
+// client. Torrents take turns, one batch each, so a popular torrent can't
+// monopolise the disk; queued requests for adjacent data are merged into
+// one read; and no more than a few reads hit any one file at a time.
+type ReadScheduler struct {
+	workers int
+	perFile int
+
+	mut     sync.Mutex
+	wake    *sync.Cond
+	sources []*ReadSource
+	next    int
+	busy    map[fileKey]int
+	closed  bool
+
+	reads    uint64
+	requests uint64
+	bytes    uint64
+}
+
+// ReadSource is one torrent's queue in a ReadScheduler.
+type ReadSource struct {
+	rs     *ReadScheduler
+	store  *Store
+	queue  []*readRequest
+	closed bool
+}
+
+// ReadStats is a ReadScheduler reading.
+type ReadStats struct {
+	Queued int `json:"queued"`
+	// Reads is disk reads issued; Requests is blocks served by them.
+	Reads    uint64 `json:"reads"`
+	Requests uint64 `json:"requests"`
+	Bytes    uint64 `json:"bytes"`
+}
+
+type readRequest struct {
+	off     uint64
+	length  uint32
+	deliver func([]byte, error)
+}
+
+type fileKey struct {
+	store *Store
+	file  int
+}
+
+// NewReadScheduler returns a scheduler issuing up to workers reads at once,
+// at most perFile of them against the same file. perFile 0 is unlimited.
+func NewReadScheduler(workers, perFile int) *ReadScheduler {
+	rs := &ReadScheduler{
+		workers: max(1, workers),
+		perFile: max(0, perFile),
+		busy:    make(map[fileKey]int),
+	}
+	rs.wake = sync.NewCond(&rs.mut)
+	return rs
+}
+
+// Run serves reads until ctx is done. Requests still queued then are
+// failed with ErrReadsClosed.
+func (rs *ReadScheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range rs.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rs.work()
+		}()
+	}
+
+	<-ctx.Done()
+
+	rs.mut.Lock()
+	rs.closed = true
+	var dropped []*readRequest
+	for _, src := range rs.sources {
+		dropped = append(dropped, src.queue...)
+		src.queue = nil
+	}
+	rs.wake.Broadcast()
+	rs.mut.Unlock()
+
+	wg.Wait()
+	for _, req := range dropped {
+		req.deliver(nil, ErrReadsClosed)
+	}
+}
+
+// Source registers a torrent's store and returns the queue its peers'
+// requests go through.
+func (rs *ReadScheduler) Source(store *Store) *ReadSource {
+	src := &ReadSource{rs: rs, store: store}
+
+	rs.mut.Lock()
+	rs.sources = append(rs.sources, src)
+	rs.mut.Unlock()
+
+	return src
+}
+
+func (rs *ReadScheduler) Stats() ReadStats {
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+
+	st := ReadStats{Reads: rs.reads, Requests: rs.requests, Bytes: rs.bytes}
+	for _, src := range rs.sources {
+		st.Queued += len(src.queue)
+	}
+	return st
+}
+
+// ReadBlock queues a read of length bytes at begin within piece and calls
+// deliver with the data, or the error, from a worker goroutine. The data
+// must not be modified.
+func (src *ReadSource) ReadBlock(
+	piece, begin, length uint32,
+	deliver func([]byte, error),
+) {
+	rs := src.rs
+	req := &readRequest{
+		off:     uint64(piece)*uint64(src.store.pieceLen) + uint64(begin),
+		length:  length,
+		deliver: deliver,
+	}
+
+	rs.mut.Lock()
+	if rs.closed || src.closed {
+		rs.mut.Unlock()
+		deliver(nil, ErrReadsClosed)
+		return
+	}
+	src.queue = append(src.queue, req)
+	rs.wake.Signal()
+	rs.mut.Unlock()
+}
+
+// Close unregisters the source and fails its queued requests.
+func (src *ReadSource) Close() {
+	rs := src.rs
+
+	rs.mut.Lock()
+	dropped := src.queue
+	src.queue = nil
+	src.closed = true
+	for i, s := range rs.sources {
+		if s == src {
+			rs.sources = append(rs.sources[:i], rs.sources[i+1:]...)
+			break
+		}
+	}
+	rs.mut.Unlock()
+
+	for _, req := range dropped {
+		req.deliver(nil, ErrReadsClosed)
+	}
+}
+
+func (rs *ReadScheduler) work() {
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+
+	for {
+		if rs.closed {
+			return
+		}
+
+		src, batch, files := rs.pick()
+		if batch == nil {
+			rs.wake.Wait()
+			continue
+		}
+
+		for _, f := range files {
+			rs.busy[fileKey{src.store, f}]++
+		}
+		rs.mut.Unlock()
+
+		off, total := batch[0].off, 0
+		for _, req := range batch {
+			total += int(req.length)
+		}
+		buf := make([]byte, total)
+		n, err := src.store.ReadAt(buf, int64(off))
+		if err == io.EOF && n == total {
+			err = nil
+		}
+
+		var pos int
+		for _, req := range batch {
+			if err != nil {
+				req.deliver(nil, err)
+				continue
+			}
+			req.deliver(buf[pos:pos+int(req.length)], nil)
+			pos += int(req.length)
+		}
+
+		rs.mut.Lock()
+		for _, f := range files {
+			key := fileKey{src.store, f}
+			if rs.busy[key]--; rs.busy[key] <= 0 {
+				delete(rs.busy, key)
+			}
+		}
+		rs.reads++
+		rs.requests += uint64(len(batch))
+		rs.bytes += uint64(total)
+		// A file just freed up may unblock a request another worker
+		// passed over.
+		rs.wake.Broadcast()
+	}
+}
+
+// pick takes the next batch to read, visiting sources round-robin and
+// skipping any whose next request touches a file at its read cap. Called
+// with rs.mut held.
+func (rs *ReadScheduler) pick() (*ReadSource, []*readRequest, []int) {
+	for k := range rs.sources {
+		i := (rs.next + k) % len(rs.sources)
+		src := rs.sources[i]
+		if len(src.queue) == 0 {
+			continue
+		}
+
+		batch := src.takeBatch()
+		end := batch[len(batch)-1].off + uint64(batch[len(batch)-1].length)
+		files := src.store.filesIn(batch[0].off, end)
+		if !rs.admits(src.store, files) {
+			src.queue = append(batch, src.queue...)
+			continue
+		}
+
+		rs.next = i + 1
+		return src, batch, files
+	}
+	return nil, nil, nil
+}
+
+func (rs *ReadScheduler) admits(store *Store, files []int) bool {
+	if rs.perFile == 0 {
+		return true
+	}
+	for _, f := range files {
+		if rs.busy[fileKey{store, f}] >= rs.perFile {
+			return false
+		}
+	}
+	return true
+}
+
+// takeBatch removes the oldest request plus any queued requests that
+// continue it back to back, up to maxReadBatch bytes.
+func (src *ReadSource) takeBatch() []*readRequest {
+	head := src.queue[0]
+	src.queue = src.queue[1:]
+
+	batch := []*readRequest{head}
+	end, total := head.off+uint64(head.length), int(head.length)
+	for total < maxReadBatch {
+		i := -1
+		for j, req := range src.queue {
+			if req.off == end && total+int(req.length) <= maxReadBatch {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			break
+		}
+
+		req := src.queue[i]
+		src.queue = append(src.queue[:i], src.queue[i+1:]...)
+		batch = append(batch, req)
+		end += uint64(req.length)
+		total += int(req.length)
+	}
+	return batch
+}
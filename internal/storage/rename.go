@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrInvalidPath  = errors.New("storage: invalid path")
+	ErrPathExists   = errors.New("storage: destination already exists")
+	ErrFileNotFound = errors.New("storage: file index out of range")
+	ErrNoRename     = errors.New("storage: backend does not support renaming")
+	ErrNoDelete     = errors.New("storage: backend does not support deleting data")
+)
+
+// RenameFile moves the file at index to newPath, relative to the torrent's
+// root folder (or the download directory for single-file torrents).
+func (s *Store) RenameFile(index int, newPath string) error {
+	r, ok := s.backend.(Renamer)
+	if !ok {
+		return ErrNoRename
+	}
+	return r.RenameFile(index, newPath)
+}
+
+// RenameRoot renames the torrent's root folder, or the file itself for
+// single-file torrents.
+func (s *Store) RenameRoot(newName string) error {
+	r, ok := s.backend.(Renamer)
+	if !ok {
+		return ErrNoRename
+	}
+	return r.RenameRoot(newName)
+}
+
+func cleanRelPath(p string) (string, error) {
+	p = filepath.Clean(filepath.FromSlash(strings.TrimSpace(p)))
+	if p == "." || p == "" || filepath.IsAbs(p) || filepath.VolumeName(p) != "" {
+		return "", ErrInvalidPath
+	}
+	if p == ".." || strings.HasPrefix(p, ".."+string(filepath.Separator)) {
+		return "", ErrInvalidPath
+	}
+	return p, nil
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/prxssh/rabbit/internal/meta"
+)
+
+// SpacePlan estimates what adding a torrent under a download directory
+// will take on disk, so the add dialog can warn before anything is
+// created.
+type SpacePlan struct {
+	// Total is the size of the torrent's content.
+	Total uint64 `json:"total"`
+	// Required is what still has to be allocated: Total less whatever
+	// the files already on disk cover.
+	Required uint64 `json:"required"`
+	// Available is free space on the directory's filesystem for an
+	// unprivileged user. It is zero when AvailableKnown is false.
+	Available      uint64 `json:"available"`
+	AvailableKnown bool   `json:"availableKnown"`
+	// Enough is set unless the free space is known to fall short.
+	Enough bool `json:"enough"`
+
+	// Existing counts the torrent's files already present, Matching
+	// those of them at the size the metainfo expects. Either way their
+	// data is hash-checked after adding rather than downloaded again.
+	Existing      int    `json:"existing"`
+	Matching      int    `json:"matching"`
+	ExistingBytes uint64 `json:"existingBytes"`
+	Files         int    `json:"files"`
+}
+
+// PlanSpace inspects downloadDir for the torrent's files and its
+// filesystem for free space. It creates and modifies nothing; a directory
+// that doesn't exist yet is measured on its nearest existing parent.
+func PlanSpace(metainfo *meta.Metainfo, downloadDir string) (*SpacePlan, error) {
+	paths := filePaths(metainfo, downloadDir)
+	plan := &SpacePlan{Files: len(paths)}
+
+	for i, fp := range paths {
+		length := fileLength(metainfo, i)
+		plan.Total += length
+
+		fi, err := os.Stat(fp)
+		if err != nil || !fi.Mode().IsRegular() || fi.Size() == 0 {
+			plan.Required += length
+			continue
+		}
+
+		size := uint64(fi.Size())
+		plan.Existing++
+		plan.ExistingBytes += min(size, length)
+		if size == length {
+			plan.Matching++
+		}
+		if size < length {
+			plan.Required += length - size
+		}
+	}
+
+	avail, err := freeSpace(nearestDir(downloadDir))
+	if err == nil {
+		plan.Available = avail
+		plan.AvailableKnown = true
+	}
+	plan.Enough = !plan.AvailableKnown || plan.Available >= plan.Required
+
+	return plan, nil
+}
+
+// nearestDir returns dir, or the closest of its parents that exists.
+func nearestDir(dir string) string {
+	dir = filepath.Clean(dir)
+	for {
+		if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
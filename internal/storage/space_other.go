@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package storage
+
+import "errors"
+
+func freeSpace(string) (uint64, error) {
+	return 0, errors.New("storage: free space unknown on this platform")
+}
+
+// IsDiskFull reports whether err comes from a filesystem out of space.
+func IsDiskFull(error) bool {
+	return false
+}
+
+// isTransient reports whether err is a disk error worth retrying.
+func isTransient(error) bool {
+	return false
+}
@@ -0,0 +1,30 @@
+//go:build linux || darwin || freebsd
+
+package storage
+
+import (
+	"errors"
+	"syscall"
+)
+
+func freeSpace(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+// IsDiskFull reports whether err comes from a filesystem out of space.
+func IsDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+// isTransient reports whether err is a disk error worth retrying:
+// interrupted or busy calls and network filesystems timing out.
+func isTransient(err error) bool {
+	return errors.Is(err, syscall.EINTR) ||
+		errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EBUSY) ||
+		errors.Is(err, syscall.ETIMEDOUT)
+}
@@ -0,0 +1,48 @@
+//go:build windows
+
+package storage
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+func freeSpace(dir string) (uint64, error) {
+	path, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var avail uint64
+	ok, _, err := procGetDiskFreeSpaceEx.Call(
+		uintptr(unsafe.Pointer(path)),
+		uintptr(unsafe.Pointer(&avail)),
+		0,
+		0,
+	)
+	if ok == 0 {
+		return 0, err
+	}
+	return avail, nil
+}
+
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+	errorHandleDiskFull   syscall.Errno = 39
+	errorDiskFull         syscall.Errno = 112
+)
+
+// IsDiskFull reports whether err comes from a filesystem out of space.
+func IsDiskFull(err error) bool {
+	return errors.Is(err, errorDiskFull) || errors.Is(err, errorHandleDiskFull)
+}
+
+// isTransient reports whether err is a disk error worth retrying, such as
+// a virus scanner or indexer holding the file for a moment.
+func isTransient(err error) bool {
+	return errors.Is(err, errorSharingViolation) || errors.Is(err, errorLockViolation)
+}
@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	goruntime "runtime"
+	"slices"
+	"sync"
+)
+
+// spool holds partial pieces pushed out of memory, one slot of a piece
+// length each, in a temporary file. The file is unlinked as soon as it is
+// created where the OS allows it, so a crash leaves nothing behind; it is
+// otherwise removed on close. Nothing in it outlives the session: pieces
+// spilled when the client stops are downloaded again.
+type spool struct {
+	mut      sync.Mutex
+	f        *os.File
+	slotSize int64
+	slots    int
+	free     []int
+}
+
+func newSpool(slotSize uint32) *spool {
+	return &spool{slotSize: int64(slotSize)}
+}
+
+// alloc reserves a slot, creating the file on first use.
+func (sp *spool) alloc() (int, error) {
+	sp.mut.Lock()
+	defer sp.mut.Unlock()
+
+	if sp.f == nil {
+		f, err := os.CreateTemp("", "rabbit-spool-*")
+		if err != nil {
+			return 0, fmt.Errorf("spool: %w", err)
+		}
+		// Windows can't unlink an open file; it goes on close instead.
+		if goruntime.GOOS != "windows" {
+			_ = os.Remove(f.Name())
+		}
+		sp.f = f
+	}
+
+	if n := len(sp.free); n > 0 {
+		slot := sp.free[n-1]
+		sp.free = sp.free[:n-1]
+		return slot, nil
+	}
+	sp.slots++
+	return sp.slots - 1, nil
+}
+
+func (sp *spool) release(slot int) {
+	sp.mut.Lock()
+	defer sp.mut.Unlock()
+
+	if !slices.Contains(sp.free, slot) {
+		sp.free = append(sp.free, slot)
+	}
+}
+
+func (sp *spool) writeAt(slot int, data []byte, off uint32) error {
+	_, err := sp.f.WriteAt(data, int64(slot)*sp.slotSize+int64(off))
+	return err
+}
+
+func (sp *spool) readAt(slot int, data []byte, off uint32) error {
+	_, err := sp.f.ReadAt(data, int64(slot)*sp.slotSize+int64(off))
+	return err
+}
+
+func (sp *spool) close() error {
+	sp.mut.Lock()
+	defer sp.mut.Unlock()
+
+	if sp.f == nil {
+		return nil
+	}
+	err := sp.f.Close()
+	if goruntime.GOOS == "windows" {
+		_ = os.Remove(sp.f.Name())
+	}
+	sp.f, sp.slots, sp.free = nil, 0, nil
+	return err
+}
+
+// spillBuffers moves the oldest partial pieces other than keep out of
+// memory until the buffered blocks fit BufferMemory again. Pieces the
+// spool can't take stay in memory: downloading is never held up.
+func (s *Store) spillBuffers(keep uint32) {
+	limit := int64(s.cfg.BufferMemory)
+	if limit <= 0 {
+		return
+	}
+
+	for s.buffered.Load() > limit {
+		buf := s.oldestBuffer(keep)
+		if buf == nil {
+			return
+		}
+		if err := s.spillBuffer(buf); err != nil {
+			s.log.Warn("spill piece buffer failed", "piece", buf.index, "error", err)
+			return
+		}
+		s.log.Debug("spilled piece buffer", "piece", buf.index, "bytes", buf.received)
+	}
+}
+
+// oldestBuffer returns the earliest started piece still held in memory,
+// other than keep.
+func (s *Store) oldestBuffer(keep uint32) *pieceBuffer {
+	s.pieceBufferMut.RLock()
+	defer s.pieceBufferMut.RUnlock()
+
+	var oldest *pieceBuffer
+	for idx, buf := range s.pieceBuffers {
+		if idx == keep || buf.inMemory.Load() == 0 {
+			continue
+		}
+		if oldest == nil || buf.seq < oldest.seq {
+			oldest = buf
+		}
+	}
+	return oldest
+}
+
+func (s *Store) spillBuffer(buf *pieceBuffer) error {
+	buf.mut.Lock()
+	defer buf.mut.Unlock()
+
+	if buf.dropped || buf.spilled != nil || len(buf.blocks) == 0 {
+		return nil
+	}
+
+	slot, err := s.spool.alloc()
+	if err != nil {
+		return err
+	}
+	spilled := make(map[uint32]uint32, len(buf.blocks))
+	for off, data := range buf.blocks {
+		if err := s.spool.writeAt(slot, data, off); err != nil {
+			s.spool.release(slot)
+			return err
+		}
+		spilled[off] = uint32(len(data))
+	}
+
+	buf.spilled, buf.slot = spilled, slot
+	buf.blocks = make(map[uint32][]byte)
+	s.buffered.Add(-int64(buf.received))
+	buf.inMemory.Store(0)
+	return nil
+}
+
+// reloadBuffer reads a spilled piece's blocks back into memory. Called
+// with buf.mut held.
+func (s *Store) reloadBuffer(buf *pieceBuffer) error {
+	if buf.spilled == nil {
+		return nil
+	}
+
+	for off, n := range buf.spilled {
+		data := make([]byte, n)
+		if err := s.spool.readAt(buf.slot, data, off); err != nil {
+			return err
+		}
+		buf.blocks[off] = data
+	}
+
+	s.spool.release(buf.slot)
+	buf.spilled = nil
+	s.buffered.Add(int64(buf.received))
+	buf.inMemory.Store(int64(buf.received))
+	return nil
+}
+
+// dropBuffer forgets a partial piece, wherever its blocks are.
+func (s *Store) dropBuffer(index uint32) {
+	s.pieceBufferMut.Lock()
+	buf, ok := s.pieceBuffers[index]
+	delete(s.pieceBuffers, index)
+	s.pieceBufferMut.Unlock()
+	if !ok {
+		return
+	}
+
+	buf.mut.Lock()
+	defer buf.mut.Unlock()
+
+	buf.dropped = true
+	if buf.spilled != nil {
+		s.spool.release(buf.slot)
+		buf.spilled = nil
+	}
+	s.buffered.Add(-buf.inMemory.Swap(0))
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"testing"
+
+	"github.com/prxssh/rabbit/internal/meta"
+	"github.com/prxssh/rabbit/internal/scheduler"
+)
+
+func TestStore_SpilledPieceStaysSpooledUntilComplete(t *testing.T) {
+	data := []byte("aaaabbbbccccdddd")
+	metainfo := &meta.Metainfo{
+		Size: 24,
+		Info: &meta.Info{
+			Name:        "t",
+			PieceLength: 12,
+			Pieces:      [][sha1.Size]byte{sha1.Sum(data[:12]), sha1.Sum(append(data[12:], "eeeeffff"...))},
+			Length:      24,
+		},
+	}
+	cfg := WithDefaultConfig()
+	cfg.BufferMemory = 4
+
+	s := NewStorageWithBackend(metainfo, cfg, NewMemoryBackend(metainfo), nil)
+	defer s.Close()
+
+	block := func(piece, begin uint32, data []byte) {
+		t.Helper()
+		err := s.handlePieceBlock(&scheduler.BlockData{
+			PieceIdx: piece,
+			Begin:    begin,
+			PieceLen: 12,
+			Data:     data,
+		})
+		if err != nil {
+			t.Fatalf("handlePieceBlock(%d, %d) error = %v", piece, begin, err)
+		}
+	}
+
+	block(0, 0, data[0:4])
+	// Starting piece 1 goes over BufferMemory and spills piece 0.
+	block(1, 0, data[12:16])
+	buf := s.pieceBuffers[0]
+	if buf.spilled == nil {
+		t.Fatal("piece 0 not spilled")
+	}
+
+	// Its next block joins it in the spool rather than reloading it.
+	block(0, 4, data[4:8])
+	block(0, 4, data[4:8])
+	if buf.spilled == nil || len(buf.spilled) != 2 || buf.inMemory.Load() != 0 {
+		t.Fatalf("piece 0 spilled = %v, inMemory = %d; want 2 spooled blocks", buf.spilled, buf.inMemory.Load())
+	}
+	if got := s.buffered.Load(); got != 4 {
+		t.Errorf("buffered = %d, want only piece 1's 4 bytes", got)
+	}
+
+	// The last block brings it back in to be verified.
+	block(0, 8, data[8:12])
+	select {
+	case piece := <-s.diskWriteQueue:
+		if piece.index != 0 || !bytes.Equal(piece.data, data[:12]) {
+			t.Errorf("queued piece %d = %q, want piece 0 = %q", piece.index, piece.data, data[:12])
+		}
+	default:
+		t.Fatal("piece 0 not queued for writing")
+	}
+	if got := s.buffered.Load(); got != 4 {
+		t.Errorf("buffered = %d after piece 0 completed, want 4", got)
+	}
+}
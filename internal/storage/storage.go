@@ -1,16 +1,26 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha1"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/prxssh/rabbit/internal/meta"
+	"github.com/prxssh/rabbit/internal/piece"
 	"github.com/prxssh/rabbit/internal/scheduler"
+	"github.com/prxssh/rabbit/pkg/bitfield"
+	"github.com/prxssh/rabbit/pkg/retry"
+	"github.com/prxssh/rabbit/pkg/supervise"
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 	"golang.org/x/sync/errgroup"
 )
@@ -19,13 +29,41 @@ type Config struct {
 	DownloadDir    string
 	PieceQueueSize int
 	DiskQueueSize  int
+
+	// Incomplete chooses where files are kept until they complete, and
+	// IncompleteSuffix the suffix IncompleteSuffix mode adds to their
+	// names.
+	Incomplete       IncompleteMode
+	IncompleteSuffix string
+
+	// BufferMemory caps the bytes of partial pieces held in memory; past
+	// it the oldest are spilled to a temporary file. Zero never spills.
+	BufferMemory uint64
+
+	// WriteRetries is how many times a piece write failing with a
+	// transient error, such as an interrupted call or a network
+	// filesystem timing out, is retried before the torrent is failed.
+	// WriteRetryDelay is the first wait, doubled on every retry.
+	WriteRetries    int
+	WriteRetryDelay time.Duration
+
+	// MaxOpenFiles caps how many of a torrent's files are held open at
+	// once, unless it shares a FilePool; the least recently used is
+	// closed to open another. Zero keeps them all open.
+	MaxOpenFiles int
 }
 
 func WithDefaultConfig() *Config {
 	return &Config{
-		DownloadDir:    getDefaultDownloadDir(),
-		PieceQueueSize: 200,
-		DiskQueueSize:  100,
+		DownloadDir:      getDefaultDownloadDir(),
+		PieceQueueSize:   200,
+		DiskQueueSize:    100,
+		Incomplete:       IncompleteInPlace,
+		IncompleteSuffix: ".!rb",
+		BufferMemory:     64 << 20,
+		WriteRetries:     4,
+		WriteRetryDelay:  200 * time.Millisecond,
+		MaxOpenFiles:     128,
 	}
 }
 
@@ -49,17 +87,53 @@ func getDefaultDownloadDir() string {
 }
 
 type Store struct {
-	cfg              *Config
-	log              *slog.Logger
-	pieceBufferMut   sync.RWMutex
-	pieceBuffers     map[uint32]*pieceBuffer
-	pieceHashes      [][sha1.Size]byte
+	cfg            *Config
+	log            *slog.Logger
+	backend        Backend
+	pieceBufferMut sync.RWMutex
+	pieceBuffers   map[uint32]*pieceBuffer
+	// bufferSeq numbers new piece buffers; buffered counts the bytes they
+	// hold in memory and spool the ones spilled.
+	bufferSeq        uint64
+	buffered         atomic.Int64
+	spool            *spool
+	pieceHashes      [][]byte
 	PieceQueue       chan *scheduler.BlockData
 	diskWriteQueue   chan *completePiece
 	PieceResultQueue chan *scheduler.PieceResult
 	pieceLen         uint32
-	files            []*datafile
 	totalSize        uint64
+	files            []fileSpan
+
+	infoHash   [sha1.Size]byte
+	hasher     piece.Hasher
+	supervisor *supervise.Supervisor
+	// onFault is told of the first persistent write failure of each run;
+	// faulted marks that it has been.
+	onFault func(error)
+	faulted atomic.Bool
+
+	checks        *CheckQueue
+	checkPriority atomic.Int32
+	checkQueued   atomic.Bool
+	checking      atomic.Bool
+	checkDone     atomic.Bool
+	checked       chan struct{}
+	// checkPos indexes the next of existingPieces to check, so a check
+	// cut short by a pause resumes rather than starting over.
+	checkPos    atomic.Int64
+	checkTotal  atomic.Int64
+	checkIntact atomic.Int64
+	// trusted marks pieces another client's resume data says are on disk;
+	// the existing-data check takes them without hashing.
+	trusted bitfield.Bitfield
+
+	// stored marks pieces known to be on disk and fileMissing counts the
+	// pieces each file still lacks, so partial files are finalized as
+	// their last piece lands.
+	storedMut   sync.Mutex
+	stored      bitfield.Bitfield
+	fileMissing []int
 }
 
 type pieceBuffer struct {
@@ -68,13 +142,51 @@ type pieceBuffer struct {
 	size     uint32
 	received uint32
 	mut      sync.Mutex
+
+	// digest has been fed the piece's first hashed bytes, in order, as
+	// blocks arrived, so a piece downloaded front to back is verified by
+	// the time its last block lands.
+	digest hash.Hash
+	hashed uint32
+
+	// seq orders buffers by when their first block arrived, so the
+	// oldest spill first.
+	seq uint64
+	// spilled maps the offsets of blocks moved to the spool, in slot, to
+	// their lengths; nil while every block is in memory.
+	spilled map[uint32]uint32
+	slot    int
+	// inMemory is how many of the piece's bytes count against
+	// Config.BufferMemory.
+	inMemory atomic.Int64
+	// dropped is set once the buffer is forgotten.
+	dropped bool
 }
 
-type datafile struct {
-	f      *os.File
+// advance feeds the digest every block that now continues it. Called with
+// b.mut held.
+func (b *pieceBuffer) advance() {
+	for b.hashed < b.size {
+		block, ok := b.blocks[b.hashed]
+		if !ok {
+			return
+		}
+		b.digest.Write(block)
+		b.hashed += uint32(len(block))
+	}
+}
+
+func (b *pieceBuffer) reset() {
+	b.blocks = make(map[uint32][]byte)
+	b.received = 0
+	b.digest.Reset()
+	b.hashed = 0
+}
+
+// fileSpan places a file of the torrent within the contiguous piece space.
+type fileSpan struct {
 	offset uint64
 	length uint64
-	path   string
 }
 
 type completePiece struct {
@@ -83,6 +195,24 @@ type completePiece struct {
 }
 
 func NewStorage(metainfo *meta.Metainfo, cfg *Config, log *slog.Logger) (*Store, error) {
+	if cfg == nil {
+		cfg = WithDefaultConfig()
+	}
+
+	backend, err := NewFileBackend(metainfo, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("setup files: %w", err)
+	}
+
+	return NewStorageWithBackend(metainfo, cfg, backend, log), nil
+}
+
+func NewStorageWithBackend(
+	metainfo *meta.Metainfo,
+	cfg *Config,
+	backend Backend,
+	log *slog.Logger,
+) *Store {
 	if log == nil {
 		log = slog.Default()
 	}
@@ -92,33 +222,152 @@ func NewStorage(metainfo *meta.Metainfo, cfg *Config, log *slog.Logger) (*Store,
 		cfg = WithDefaultConfig()
 	}
 
-	files, err := setupFiles(metainfo, cfg.DownloadDir)
-	if err != nil {
-		return nil, fmt.Errorf("setup files: %w", err)
-	}
+	files := fileSpans(metainfo)
 
-	s := &Store{
+	return &Store{
 		cfg:              cfg,
 		log:              log,
+		backend:          backend,
+		infoHash:         metainfo.InfoHash,
+		hasher:           piece.SHA1,
 		files:            files,
-		pieceHashes:      metainfo.Info.Pieces,
+		stored:           bitfield.New(len(metainfo.Info.Pieces)),
+		fileMissing:      filePieceCounts(files, metainfo.Info.PieceLength),
+		totalSize:        metainfo.Size,
+		checked:          make(chan struct{}),
+		pieceHashes:      piece.Digests(metainfo.Info.Pieces),
 		pieceLen:         metainfo.Info.PieceLength,
 		pieceBuffers:     make(map[uint32]*pieceBuffer),
+		spool:            newSpool(metainfo.Info.PieceLength),
 		PieceResultQueue: make(chan *scheduler.PieceResult, cfg.DiskQueueSize),
 		diskWriteQueue:   make(chan *completePiece, cfg.DiskQueueSize),
 		PieceQueue:       make(chan *scheduler.BlockData, cfg.PieceQueueSize),
 	}
+}
+
+func fileSpans(metainfo *meta.Metainfo) []fileSpan {
+	if metainfo.Info.Length > 0 {
+		return []fileSpan{{offset: 0, length: metainfo.Info.Length}}
+	}
 
-	return s, nil
+	spans := make([]fileSpan, len(metainfo.Info.Files))
+	var offset uint64
+	for i, f := range metainfo.Info.Files {
+		spans[i] = fileSpan{offset: offset, length: f.Length}
+		offset += f.Length
+	}
+	return spans
 }
 
 func (s *Store) Run(ctx context.Context) error {
+	s.faulted.Store(false)
+
 	g, gctx := errgroup.WithContext(ctx)
 
-	g.Go(func() error { return s.processPiecesLoop(gctx) })
-	g.Go(func() error { return s.writeToDiskLoop(gctx) })
+	g.Go(func() error { return s.supervisor.Run(gctx, "piece loop", s.processPiecesLoop) })
+	g.Go(func() error { return s.supervisor.Run(gctx, "disk write loop", s.writeToDiskLoop) })
+	// The check runs once, so it can only be recovered from, not restarted.
+	g.Go(func() error {
+		return s.supervisor.Recover("check", func() error { return s.verifyExisting(gctx) })
+	})
+
+	err := g.Wait()
+
+	if ferr := s.backend.Flush(); ferr != nil {
+		s.log.Error("flush storage failed", "error", ferr.Error())
+	}
+
+	return err
+}
 
-	return g.Wait()
+// OnFault sets fn to be called when a piece can't be written even after
+// retrying, e.g. because the disk is full or gone. The write loop carries
+// on reporting failed pieces; fn is what stops the torrent. Set it before
+// Run.
+func (s *Store) OnFault(fn func(error)) {
+	s.onFault = fn
+}
+
+// UseSupervisor has the piece and disk write loops restarted when they
+// fail rather than ending Run. It must be called before the first Run.
+func (s *Store) UseSupervisor(sup *supervise.Supervisor) {
+	s.supervisor = sup
+}
+
+// UseFilePool has the backend's files held open in p, shared with other
+// torrents, in place of a pool of its own sized by MaxOpenFiles. A nil p
+// or a backend that isn't Pooled is left alone. It must be called before
+// the first Run.
+func (s *Store) UseFilePool(p *FilePool) {
+	if pooled, ok := s.backend.(Pooled); ok && p != nil {
+		pooled.UseFilePool(p)
+	}
+}
+
+// UseHasher replaces the SHA-1 pieces are verified with by default.
+// digests holds what h makes of each piece; nil keeps the metainfo's
+// SHA-1 hashes, which only fit a hasher with SHA-1 sized digests. It must
+// be called before the first Run.
+func (s *Store) UseHasher(h piece.Hasher, digests [][]byte) error {
+	if digests == nil {
+		digests = s.pieceHashes
+	}
+	if len(digests) != len(s.pieceHashes) {
+		return fmt.Errorf("%d piece digests for %d pieces", len(digests), len(s.pieceHashes))
+	}
+	for i, d := range digests {
+		if len(d) != h.Size() {
+			return fmt.Errorf(
+				"hasher %s makes %d-byte digests, piece %d has %d",
+				h.Name(), h.Size(), i, len(d),
+			)
+		}
+	}
+
+	s.hasher = h
+	s.pieceHashes = digests
+	return nil
+}
+
+// verify reports whether data matches the hash of piece index.
+func (s *Store) verify(index uint32, data []byte) bool {
+	return piece.Verify(s.hasher, data, s.pieceHashes[index])
+}
+
+// matches reports whether sum is the hash of piece index.
+func (s *Store) matches(index uint32, sum []byte) bool {
+	return bytes.Equal(sum, s.pieceHashes[index])
+}
+
+// Close releases the backend and the spool. The Store must not be run
+// again afterwards.
+func (s *Store) Close() error {
+	return errors.Join(s.backend.Close(), s.spool.close())
+}
+
+// ContentPath returns where the torrent's content lives on disk, or "" if
+// the backend doesn't keep it in files.
+func (s *Store) ContentPath() string {
+	if l, ok := s.backend.(Locator); ok {
+		return l.ContentPath()
+	}
+	return ""
+}
+
+// QueueDepths reports the blocks waiting to be buffered, the pieces
+// waiting to be written and the results not yet taken by the scheduler.
+func (s *Store) QueueDepths() (blocks, writes, results int) {
+	return len(s.PieceQueue), len(s.diskWriteQueue), len(s.PieceResultQueue)
+}
+
+// DeleteData removes the torrent's files from the backend. The Store must
+// not be run afterwards.
+func (s *Store) DeleteData() error {
+	d, ok := s.backend.(Deleter)
+	if !ok {
+		return ErrNoDelete
+	}
+	return d.Delete()
 }
 
 func (s *Store) processPiecesLoop(ctx context.Context) error {
@@ -143,10 +392,13 @@ func (s *Store) handlePieceBlock(block *scheduler.BlockData) error {
 	s.pieceBufferMut.Lock()
 	buf, exists := s.pieceBuffers[block.PieceIdx]
 	if !exists {
+		s.bufferSeq++
 		buf = &pieceBuffer{
 			index:  block.PieceIdx,
 			blocks: make(map[uint32][]byte),
 			size:   block.PieceLen,
+			digest: s.hasher.New(),
+			seq:    s.bufferSeq,
 		}
 		s.pieceBuffers[block.PieceIdx] = buf
 	}
@@ -154,6 +406,36 @@ func (s *Store) handlePieceBlock(block *scheduler.BlockData) error {
 
 	buf.mut.Lock()
 
+	// Forgotten since it was looked up, e.g. found intact on disk.
+	if buf.dropped {
+		buf.mut.Unlock()
+		return nil
+	}
+	if _, dup := buf.spilled[block.Begin]; dup {
+		buf.mut.Unlock()
+		return nil
+	}
+	// A spilled piece takes its blocks straight to the spool and is only
+	// read back once the last one arrives, rather than going back and
+	// forth with every block.
+	if buf.spilled != nil && buf.received+uint32(len(block.Data)) < buf.size {
+		if err := s.spool.writeAt(buf.slot, block.Data, block.Begin); err == nil {
+			buf.spilled[block.Begin] = uint32(len(block.Data))
+			buf.received += uint32(len(block.Data))
+			buf.mut.Unlock()
+			return nil
+		}
+		// Otherwise the piece comes back into memory, with the block.
+	}
+	if err := s.reloadBuffer(buf); err != nil {
+		// The spilled blocks are gone; the piece starts over.
+		buf.mut.Unlock()
+		s.dropBuffer(block.PieceIdx)
+
+		s.PieceResultQueue <- &scheduler.PieceResult{PieceIdx: block.PieceIdx, Success: false}
+		return fmt.Errorf("piece %d: reload spilled blocks: %w", block.PieceIdx, err)
+	}
+
 	if _, exists := buf.blocks[block.Begin]; exists {
 		buf.mut.Unlock()
 		s.log.Debug(
@@ -166,9 +448,13 @@ func (s *Store) handlePieceBlock(block *scheduler.BlockData) error {
 
 	buf.blocks[block.Begin] = block.Data
 	buf.received += uint32(len(block.Data))
+	buf.inMemory.Add(int64(len(block.Data)))
+	s.buffered.Add(int64(len(block.Data)))
+	buf.advance()
 
 	if buf.received != buf.size {
 		buf.mut.Unlock()
+		s.spillBuffers(block.PieceIdx)
 		return nil
 	}
 
@@ -177,27 +463,36 @@ func (s *Store) handlePieceBlock(block *scheduler.BlockData) error {
 		copy(completeData[offset:], block)
 	}
 
+	// Blocks that never lined up (overlapping or oddly sized) leave the
+	// digest short; hash the assembled piece instead.
+	var ok bool
+	if buf.hashed == buf.size {
+		ok = s.matches(block.PieceIdx, buf.digest.Sum(nil))
+	} else {
+		ok = s.verify(block.PieceIdx, completeData)
+	}
+
 	buf.mut.Unlock()
 
-	hash := sha1.Sum(completeData)
-	if hash != s.pieceHashes[block.PieceIdx] {
+	if !ok {
 		s.log.Warn("piece hash mismatch, discarding", "piece", block.PieceIdx)
 
 		buf.mut.Lock()
-		buf.blocks = make(map[uint32][]byte)
-		buf.received = 0
+		s.buffered.Add(-buf.inMemory.Swap(0))
+		buf.reset()
 		buf.mut.Unlock()
 
-		s.PieceResultQueue <- &scheduler.PieceResult{PieceIdx: block.PieceIdx, Success: false}
+		s.PieceResultQueue <- &scheduler.PieceResult{
+			PieceIdx:     block.PieceIdx,
+			Success:      false,
+			HashMismatch: true,
+		}
 
 		return fmt.Errorf("piece %d: hash mismatch", block.PieceIdx)
 	}
 
 	s.diskWriteQueue <- &completePiece{index: block.PieceIdx, data: completeData}
-
-	s.pieceBufferMut.Lock()
-	delete(s.pieceBuffers, block.PieceIdx)
-	s.pieceBufferMut.Unlock()
+	s.dropBuffer(block.PieceIdx)
 
 	return nil
 }
@@ -215,27 +510,61 @@ func (s *Store) writeToDiskLoop(ctx context.Context) error {
 
 			success := true
 
-			if err := s.writePiece(piece); err != nil {
+			if err := s.writePieceRetrying(ctx, piece); err != nil {
 				s.log.Error("failed to write piece to disk",
 					"index", piece.index,
 					"error", err.Error(),
 				)
 
 				success = false
+				if ctx.Err() == nil && s.onFault != nil && s.faulted.CompareAndSwap(false, true) {
+					s.onFault(err)
+				}
 			}
 
+			if success {
+				s.pieceStored(piece.index)
+			}
 			s.PieceResultQueue <- &scheduler.PieceResult{PieceIdx: piece.index, Success: success}
 		}
 	}
 }
 
+// maxWriteRetryDelay caps the wait between retries of a piece write.
+const maxWriteRetryDelay = 5 * time.Second
+
+// writePieceRetrying writes piece, retrying transient errors with
+// backoff.
+func (s *Store) writePieceRetrying(ctx context.Context, piece *completePiece) error {
+	if s.cfg.WriteRetries <= 0 {
+		return s.writePiece(piece)
+	}
+
+	return retry.Do(
+		ctx,
+		func(context.Context) error { return s.writePiece(piece) },
+		retry.WithMaxAttempts(s.cfg.WriteRetries+1),
+		retry.WithInitialDelay(s.cfg.WriteRetryDelay),
+		retry.WithMaxDelay(maxWriteRetryDelay),
+		retry.WithRetryIf(isTransient),
+		retry.WithOnRetry(func(attempt int, err error, next time.Duration) {
+			s.log.Warn("piece write failed, retrying",
+				"index", piece.index,
+				"attempt", attempt,
+				"in", next,
+				"error", err,
+			)
+		}),
+	)
+}
+
 func (s *Store) writePiece(piece *completePiece) error {
 	s.log.Info("piece complete, writing to disk", "piece", piece.index)
 
 	pieceAbsStart := uint64(piece.index) * uint64(s.pieceLen)
 	pieceAbsEnd := pieceAbsStart + uint64(len(piece.data))
 
-	for _, file := range s.files {
+	for i, file := range s.files {
 		fileAbsStart := file.offset
 		fileAbsEnd := fileAbsStart + file.length
 
@@ -250,17 +579,18 @@ func (s *Store) writePiece(piece *completePiece) error {
 		offsetInFile := overlapStart - fileAbsStart
 		offsetInData := overlapStart - pieceAbsStart
 
-		n, err := file.f.WriteAt(
+		n, err := s.backend.WriteAt(
+			i,
 			piece.data[offsetInData:offsetInData+writeLen],
 			int64(offsetInFile),
 		)
 		if err != nil {
-			return fmt.Errorf("file write error for %s: %w", file.path, err)
+			return fmt.Errorf("file %d write error: %w", i, err)
 		}
 		if uint64(n) != writeLen {
 			return fmt.Errorf(
-				"incomplete write to file %s: wrote %d, expected %d",
-				file.path,
+				"incomplete write to file %d: wrote %d, expected %d",
+				i,
 				n,
 				writeLen,
 			)
@@ -271,10 +601,42 @@ func (s *Store) writePiece(piece *completePiece) error {
 }
 
 func (s *Store) readPiece(index int, data []byte) error {
-	pieceAbsStart := uint64(index) * uint64(s.pieceLen)
+	return s.readAt(data, uint64(index)*uint64(s.pieceLen))
+}
+
+// ReadAt reads from the torrent's contiguous data starting at byte off,
+// regardless of whether the covered pieces have been downloaded.
+func (s *Store) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || uint64(off) >= s.totalSize {
+		return 0, io.EOF
+	}
+
+	n := int(min(uint64(len(p)), s.totalSize-uint64(off)))
+	if err := s.readAt(p[:n], uint64(off)); err != nil {
+		return 0, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// filesIn returns the indices of files overlapping [start, end) of the
+// torrent's contiguous data.
+func (s *Store) filesIn(start, end uint64) []int {
+	var out []int
+	for i, file := range s.files {
+		if file.length > 0 && file.offset < end && start < file.offset+file.length {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+func (s *Store) readAt(data []byte, pieceAbsStart uint64) error {
 	pieceAbsEnd := pieceAbsStart + uint64(len(data))
 
-	for _, file := range s.files {
+	for i, file := range s.files {
 		fileAbsStart := file.offset
 		fileAbsEnd := file.offset + file.length
 
@@ -289,17 +651,18 @@ func (s *Store) readPiece(index int, data []byte) error {
 		offsetInFile := overlapStart - fileAbsStart
 		offsetInData := overlapStart - pieceAbsStart
 
-		n, err := file.f.ReadAt(
+		n, err := s.backend.ReadAt(
+			i,
 			data[offsetInData:offsetInData+readLen],
 			int64(offsetInFile),
 		)
 		if err != nil {
-			return fmt.Errorf("file read error for %s: %w", file.path, err)
+			return fmt.Errorf("file %d read error: %w", i, err)
 		}
 		if uint64(n) != readLen {
 			return fmt.Errorf(
-				"incomplete read from file %s: read %d, expected %d",
-				file.path,
+				"incomplete read from file %d: read %d, expected %d",
+				i,
 				n,
 				readLen,
 			)
@@ -308,60 +671,3 @@ func (s *Store) readPiece(index int, data []byte) error {
 
 	return nil
 }
-
-func setupFiles(metainfo *meta.Metainfo, downloadDir string) ([]*datafile, error) {
-	if err := os.MkdirAll(downloadDir, 0o755); err != nil {
-		return nil, err
-	}
-
-	var (
-		currentOffset uint64
-		datafiles     []*datafile
-	)
-
-	if metainfo.Info.Length > 0 {
-		fp := filepath.Join(downloadDir, metainfo.Info.Name)
-		mapping, err := createFileMapping(fp, metainfo.Info.Length, currentOffset)
-		if err != nil {
-			return nil, err
-		}
-
-		datafiles = append(datafiles, mapping)
-		return datafiles, nil
-	}
-
-	for _, file := range metainfo.Info.Files {
-		fp := filepath.Join(downloadDir, metainfo.Info.Name)
-		for _, pathPart := range file.Path {
-			fp = filepath.Join(fp, pathPart)
-		}
-
-		mapping, err := createFileMapping(fp, file.Length, currentOffset)
-		if err != nil {
-			return nil, err
-		}
-
-		datafiles = append(datafiles, mapping)
-		currentOffset += file.Length
-	}
-
-	return datafiles, nil
-}
-
-func createFileMapping(path string, size, offset uint64) (*datafile, error) {
-	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
-		return nil, err
-	}
-
-	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
-	if err != nil {
-		return nil, err
-	}
-
-	if err := file.Truncate(int64(size)); err != nil {
-		file.Close()
-		return nil, err
-	}
-
-	return &datafile{path: path, length: size, offset: offset, f: file}, nil
-}
@@ -5,6 +5,7 @@ import (
 	"github.com/prxssh/rabbit/internal/scheduler"
 	"github.com/prxssh/rabbit/internal/storage"
 	"github.com/prxssh/rabbit/internal/tracker"
+	"github.com/prxssh/rabbit/pkg/supervise"
 )
 
 type Config struct {
@@ -12,6 +13,7 @@ type Config struct {
 	Storage   *storage.Config
 	Peer      *peer.Config
 	Tracker   *tracker.Config
+	Supervise *supervise.Config
 }
 
 func WithDefaultConfig() *Config {
@@ -20,5 +22,6 @@ func WithDefaultConfig() *Config {
 		Storage:   storage.WithDefaultConfig(),
 		Peer:      peer.WithDefaultConfig(),
 		Tracker:   tracker.WithDefaultConfig(),
+		Supervise: supervise.WithDefaultConfig(),
 	}
 }
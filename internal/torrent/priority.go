@@ -0,0 +1,193 @@
+package torrent
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/prxssh/rabbit/internal/scheduler"
+)
+
+var (
+	ErrFilePriorityCount = errors.New("torrent: priority count differs from file count")
+	ErrFilePreviewCount  = errors.New("torrent: preview flag count differs from file count")
+	ErrUnknownPriority   = errors.New("torrent: unknown priority")
+)
+
+// Priority ranks a torrent against the client's others. Higher priority
+// torrents have their existing data checked first, get a larger part of
+// the client-wide rate limits while others compete for them and announce
+// somewhat more often. The zero value is PriorityNormal.
+type Priority int8
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+// bandwidthWeight is the torrent's weight in splitting the rate limits.
+func (p Priority) bandwidthWeight() int {
+	switch p {
+	case PriorityLow:
+		return 1
+	case PriorityHigh:
+		return 4
+	default:
+		return 2
+	}
+}
+
+// announceScale nudges the interval between announces.
+func (p Priority) announceScale() float64 {
+	switch p {
+	case PriorityLow:
+		return 1.25
+	case PriorityHigh:
+		return 0.75
+	default:
+		return 1
+	}
+}
+
+// SetPriority changes the torrent's priority, taking effect at once for
+// bandwidth and the check queue and from the next announce for trackers.
+func (t *Torrent) SetPriority(p Priority) error {
+	if p < PriorityLow || p > PriorityHigh {
+		return ErrUnknownPriority
+	}
+
+	t.stateMut.Lock()
+	t.priority = p
+	t.stateMut.Unlock()
+
+	t.bandwidth.SetWeight(p.bandwidthWeight())
+	t.storage.SetCheckPriority(int(p))
+	if t.tracker != nil {
+		t.tracker.SetIntervalScale(p.announceScale())
+	}
+	return nil
+}
+
+// Priority returns the torrent's priority.
+func (t *Torrent) Priority() Priority {
+	t.stateMut.RLock()
+	defer t.stateMut.RUnlock()
+
+	return t.priority
+}
+
+// previewBytes is how much of each end of a previewed file is fetched
+// first; at least a piece either way.
+const previewBytes = 1 << 20
+
+// SetFilePriorities sets each file's download priority, indexed like the
+// metainfo's files; nil makes them all normal again. A piece spanning
+// several files takes the highest priority among them, so a skipped file
+// can still get the pieces it shares with a wanted neighbour.
+func (t *Torrent) SetFilePriorities(prios []scheduler.Priority) error {
+	if prios != nil && len(prios) != len(t.fileLengths()) {
+		return ErrFilePriorityCount
+	}
+
+	t.stateMut.Lock()
+	defer t.stateMut.Unlock()
+
+	if err := t.scheduler.SetPiecePriorities(t.piecePriorities(prios, t.filePreview)); err != nil {
+		return err
+	}
+	t.filePriorities = slices.Clone(prios)
+	return nil
+}
+
+// SetFilePreview marks files, indexed like the metainfo's, whose first and
+// last pieces are downloaded ahead of everything else; nil clears them.
+// Skipped files are never previewed.
+func (t *Torrent) SetFilePreview(preview []bool) error {
+	if preview != nil && len(preview) != len(t.fileLengths()) {
+		return ErrFilePreviewCount
+	}
+
+	t.stateMut.Lock()
+	defer t.stateMut.Unlock()
+
+	if err := t.scheduler.SetPiecePriorities(t.piecePriorities(t.filePriorities, preview)); err != nil {
+		return err
+	}
+	t.filePreview = slices.Clone(preview)
+	return nil
+}
+
+// FilePreview returns which files are previewed, or nil if none were set.
+func (t *Torrent) FilePreview() []bool {
+	t.stateMut.RLock()
+	defer t.stateMut.RUnlock()
+
+	return slices.Clone(t.filePreview)
+}
+
+// piecePriorities maps file priorities and preview flags onto pieces. It
+// returns nil when every piece is normal.
+func (t *Torrent) piecePriorities(prios []scheduler.Priority, preview []bool) []scheduler.Priority {
+	if prios == nil && !slices.Contains(preview, true) {
+		return nil
+	}
+
+	pieces := make([]scheduler.Priority, len(t.Metainfo.Info.Pieces))
+	if prios != nil {
+		for i := range pieces {
+			pieces[i] = scheduler.PrioritySkip
+		}
+	}
+
+	pieceLen := uint64(t.Metainfo.Info.PieceLength)
+	var offset uint64
+	for i, length := range t.fileLengths() {
+		if length == 0 {
+			continue
+		}
+
+		prio := scheduler.PriorityNormal
+		if prios != nil {
+			prio = prios[i]
+		}
+		first, last := offset/pieceLen, (offset+length-1)/pieceLen
+		for idx := first; idx <= last; idx++ {
+			pieces[idx] = max(pieces[idx], prio)
+		}
+
+		if i < len(preview) && preview[i] && prio != scheduler.PrioritySkip {
+			edge := min(length, previewBytes)
+			head := (offset + edge - 1) / pieceLen
+			tail := (offset + length - edge) / pieceLen
+			for idx := first; idx <= head; idx++ {
+				pieces[idx] = scheduler.PriorityTop
+			}
+			for idx := tail; idx <= last; idx++ {
+				pieces[idx] = scheduler.PriorityTop
+			}
+		}
+
+		offset += length
+	}
+	return pieces
+}
+
+// FilePriorities returns each file's priority, or nil if none were set.
+func (t *Torrent) FilePriorities() []scheduler.Priority {
+	t.stateMut.RLock()
+	defer t.stateMut.RUnlock()
+
+	return slices.Clone(t.filePriorities)
+}
+
+func (t *Torrent) fileLengths() []uint64 {
+	if len(t.Metainfo.Info.Files) == 0 {
+		return []uint64{t.Metainfo.Info.Length}
+	}
+
+	lengths := make([]uint64, len(t.Metainfo.Info.Files))
+	for i, f := range t.Metainfo.Info.Files {
+		lengths[i] = f.Length
+	}
+	return lengths
+}
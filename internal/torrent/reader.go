@@ -0,0 +1,173 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// readaheadPieces is how many pieces past the read position get a deadline
+// so playback doesn't stall at every piece boundary. It doubles with each
+// step of the scheduler's deadline pressure.
+const readaheadPieces = 4
+
+var errReaderClosed = errors.New("torrent: reader closed")
+
+// FileReader reads one file of a torrent as if it were complete. Reads of
+// regions we don't have yet block until the covering pieces arrive, and
+// those pieces are fetched ahead of everything else. It satisfies
+// io.ReadSeekCloser, so it can back http.ServeContent directly.
+type FileReader struct {
+	t      *Torrent
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	offset int64
+	length int64
+
+	mut       sync.Mutex
+	pos       int64
+	closed    bool
+	deadlines map[uint32]struct{}
+}
+
+// NewFileReader opens the file at index for reading. ctx bounds every
+// blocking read, and Close cuts short any that are still waiting.
+func (t *Torrent) NewFileReader(ctx context.Context, index int) (*FileReader, error) {
+	info := t.Metainfo.Info
+
+	var offset, length int64
+	switch {
+	case len(info.Files) == 0 && index == 0:
+		length = int64(info.Length)
+	case index >= 0 && index < len(info.Files):
+		for _, f := range info.Files[:index] {
+			offset += int64(f.Length)
+		}
+		length = int64(info.Files[index].Length)
+	default:
+		return nil, errors.New("torrent: file index out of range")
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	return &FileReader{
+		t:         t,
+		ctx:       ctx,
+		cancel:    cancel,
+		offset:    offset,
+		length:    length,
+		deadlines: make(map[uint32]struct{}),
+	}, nil
+}
+
+func (r *FileReader) Read(p []byte) (int, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	n, err := r.readAt(p, r.pos)
+	r.pos += int64(n)
+	return n, err
+}
+
+func (r *FileReader) ReadAt(p []byte, off int64) (int, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	return r.readAt(p, off)
+}
+
+func (r *FileReader) Seek(offset int64, whence int) (int64, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = r.pos + offset
+	case io.SeekEnd:
+		pos = r.length + offset
+	default:
+		return 0, errors.New("torrent: invalid whence")
+	}
+	if pos < 0 {
+		return 0, errors.New("torrent: negative position")
+	}
+
+	r.pos = pos
+	return pos, nil
+}
+
+func (r *FileReader) Close() error {
+	// A read holds r.mut while it waits for pieces; cancelling first lets
+	// it return so the lock can be taken.
+	r.cancel(errReaderClosed)
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.closed = true
+	r.clearDeadlines()
+	return nil
+}
+
+// readAt must be called with r.mut held.
+func (r *FileReader) readAt(p []byte, off int64) (int, error) {
+	if r.closed {
+		return 0, errReaderClosed
+	}
+	if off >= r.length {
+		return 0, io.EOF
+	}
+	if rem := r.length - off; int64(len(p)) > rem {
+		p = p[:rem]
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	abs := r.offset + off
+	pieceLen := int64(r.t.Metainfo.Info.PieceLength)
+	first := uint32(abs / pieceLen)
+	last := uint32((abs + int64(len(p)) - 1) / pieceLen)
+
+	r.prioritize(first, last)
+
+	for idx := first; idx <= last; idx++ {
+		if err := r.t.scheduler.WaitPiece(r.ctx, idx); err != nil {
+			return 0, context.Cause(r.ctx)
+		}
+	}
+
+	n, err := r.t.storage.ReadAt(p, abs)
+	if err == io.EOF && n == len(p) {
+		err = nil
+	}
+	return n, err
+}
+
+// prioritize puts deadlines on the pieces being read plus the readahead
+// window, replacing deadlines from earlier reads. The window widens while
+// deadlines are being missed, so pieces are asked for earlier.
+func (r *FileReader) prioritize(first, last uint32) {
+	pieceCount := uint32(len(r.t.Metainfo.Info.Pieces))
+	readahead := uint32(readaheadPieces) << r.t.scheduler.DeadlinePressure()
+	end := min(last+readahead, pieceCount-1)
+
+	r.clearDeadlines()
+
+	now := time.Now()
+	for idx := first; idx <= end; idx++ {
+		r.t.scheduler.SetPieceDeadline(idx, now.Add(time.Duration(idx-first)*time.Second))
+		r.deadlines[idx] = struct{}{}
+	}
+}
+
+func (r *FileReader) clearDeadlines() {
+	for idx := range r.deadlines {
+		r.t.scheduler.ClearPieceDeadline(idx)
+		delete(r.deadlines, idx)
+	}
+}
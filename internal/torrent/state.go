@@ -0,0 +1,107 @@
+package torrent
+
+import (
+	"errors"
+
+	"github.com/prxssh/rabbit/internal/piece"
+	"github.com/prxssh/rabbit/internal/scheduler"
+)
+
+type State string
+
+const (
+	StateChecking    State = "checking"
+	StateDownloading State = "downloading"
+	StateSeeding     State = "seeding"
+	StatePaused      State = "paused"
+	StateStopped     State = "stopped"
+	// StateNeedsRecheck means files on disk disagree with the metainfo and
+	// the torrent won't touch them until the user asks for a recheck.
+	StateNeedsRecheck State = "needsRecheck"
+	// StateError means the torrent stopped because its files can't be
+	// written, e.g. the disk filled up or went away. Resume retries.
+	StateError State = "error"
+)
+
+var (
+	ErrNeedsRecheck = errors.New("torrent: files on disk differ from metainfo, recheck required")
+	ErrRunning      = errors.New("torrent: already running")
+	ErrNotRunning   = errors.New("torrent: not running")
+	ErrClosed       = errors.New("torrent: stopped for good")
+	ErrNoTrackers   = errors.New("torrent: not announcing to trackers")
+)
+
+func (t *Torrent) setState(s State, err error) {
+	t.stateMut.Lock()
+	t.state = s
+	t.stateErr = err
+	if s != StatePaused {
+		t.pauseReason = ""
+	}
+	t.stateMut.Unlock()
+}
+
+func (t *Torrent) setPaused(reason string) {
+	t.stateMut.Lock()
+	t.state = StatePaused
+	t.stateErr = nil
+	t.pauseReason = reason
+	t.stateMut.Unlock()
+}
+
+// PauseReason returns why a paused torrent was paused by the client rather
+// than the user, e.g. "battery". It is empty otherwise.
+func (t *Torrent) PauseReason() string {
+	t.stateMut.RLock()
+	defer t.stateMut.RUnlock()
+
+	return t.pauseReason
+}
+
+// State returns the torrent's lifecycle state and the error that caused
+// it, if any. Downloading turns into seeding once every piece is verified.
+func (t *Torrent) State() (State, error) {
+	t.stateMut.RLock()
+	s, err := t.state, t.stateErr
+	t.stateMut.RUnlock()
+
+	if s == StateDownloading && t.Completed() {
+		return StateSeeding, nil
+	}
+	return s, err
+}
+
+// Completed reports whether every piece has been verified, so the files
+// on disk hold the full content.
+func (t *Torrent) Completed() bool {
+	for _, st := range t.pieceManager.PieceStatus() {
+		if st != piece.StatusDone {
+			return false
+		}
+	}
+	return true
+}
+
+// Have returns how many bytes of verified data the torrent holds.
+func (t *Torrent) Have() uint64 {
+	var have uint64
+	for i, st := range t.pieceManager.PieceStatus() {
+		if st == piece.StatusDone {
+			have += uint64(t.pieceManager.PieceLength(uint32(i)))
+		}
+	}
+	return have
+}
+
+// Left returns how many bytes of wanted pieces are still missing. Pieces
+// covered only by skipped files don't count.
+func (t *Torrent) Left() uint64 {
+	var left uint64
+	for i, st := range t.pieceManager.PieceStatus() {
+		if st == piece.StatusDone || t.scheduler.PiecePriority(uint32(i)) == scheduler.PrioritySkip {
+			continue
+		}
+		left += uint64(t.pieceManager.PieceLength(uint32(i)))
+	}
+	return left
+}
@@ -1,39 +1,166 @@
 package torrent
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha1"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/netip"
+	"slices"
+	"sync"
 
+	"github.com/prxssh/rabbit/internal/connectivity"
 	"github.com/prxssh/rabbit/internal/meta"
 	"github.com/prxssh/rabbit/internal/peer"
 	"github.com/prxssh/rabbit/internal/piece"
 	"github.com/prxssh/rabbit/internal/scheduler"
 	"github.com/prxssh/rabbit/internal/storage"
 	"github.com/prxssh/rabbit/internal/tracker"
+	"github.com/prxssh/rabbit/pkg/bitfield"
+	"github.com/prxssh/rabbit/pkg/clock"
+	"github.com/prxssh/rabbit/pkg/supervise"
 	"golang.org/x/sync/errgroup"
 )
 
 type Torrent struct {
 	Metainfo *meta.Metainfo `json:"metainfo"`
 
+	// raw is the .torrent file the torrent was added from.
+	raw          []byte
 	clientID     [sha1.Size]byte
 	cfg          *Config
 	logger       *slog.Logger
 	tracker      *tracker.Tracker
 	peerManager  *peer.Swarm
 	storage      *storage.Store
+	reads        *storage.ReadSource
 	scheduler    *scheduler.Scheduler
 	pieceManager *piece.Manager
-	cancel       context.CancelFunc
+	// bandwidth is the torrent's share of the client-wide limiters.
+	bandwidth *peer.Bandwidth
+	// supervisor restarts the tracker, swarm and storage loops that fail.
+	supervisor *supervise.Supervisor
+
+	runMut  sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running bool
+	paused  bool
+	closed  bool
+
+	stateMut sync.RWMutex
+	state    State
+	stateErr error
+	label    string
+	priority Priority
+	// pauseReason is what PauseFor was given, while paused.
+	pauseReason string
+	// filePriorities is what SetFilePriorities was last given.
+	filePriorities []scheduler.Priority
+	// filePreview is what SetFilePreview was last given.
+	filePreview []bool
 }
 
-func NewTorrent(clientID [sha1.Size]byte, data []byte, cfg *Config) (*Torrent, error) {
+type Opts struct {
+	// ClientID is our peer ID for this torrent, sent to trackers and in
+	// handshakes. Defaults to the session's.
+	ClientID [sha1.Size]byte
+	Config   *Config
+
+	// PeerCache is the client-wide cache of known-good peers. Optional.
+	PeerCache *peer.Cache
+
+	// Bandwidth holds the client-wide rate limiters. Optional.
+	Bandwidth *peer.Bandwidth
+
+	// HTTPSCache records which trackers support HTTPS across the client.
+	// Optional.
+	HTTPSCache *tracker.HTTPSCache
+
+	// HavePieces are pieces known to be on disk already, e.g. from another
+	// client's resume data. They skip hashing when existing files are
+	// checked. Optional.
+	HavePieces bitfield.Bitfield
+
+	// Dial opens peer connections. Defaults to TCP.
+	Dial peer.Dialer
+
+	// Clock drives the torrent's timers. Defaults to the wall clock.
+	Clock clock.Clock
+
+	// UploadSlots is the client-wide unchoke slot pool. Optional.
+	UploadSlots *peer.SlotPool
+
+	// Registry routes incoming connections to the torrent while it runs.
+	// Optional; without it the torrent only dials out.
+	Registry *peer.Registry
+
+	// Reads is the client-wide scheduler serving peers' block requests.
+	// Without it the torrent doesn't upload.
+	Reads *storage.ReadScheduler
+
+	// Hasher verifies pieces. Defaults to SHA-1.
+	Hasher piece.Hasher
+	// PieceDigests holds Hasher's digest of each piece, e.g. a v2
+	// torrent's SHA-256 piece layer. Defaults to the metainfo's SHA-1
+	// hashes.
+	PieceDigests [][]byte
+
+	// Checks is the client-wide queue existing-data checks wait in.
+	// Optional.
+	Checks *storage.CheckQueue
+
+	// Files is the client-wide pool of open file handles. Without it the
+	// torrent keeps its own, sized by Storage.MaxOpenFiles.
+	Files *storage.FilePool
+
+	// Paused creates the torrent paused: Resume starts it rather than Run.
+	// PauseReason is recorded as for PauseFor.
+	Paused      bool
+	PauseReason string
+
+	// SkipCheck trusts every piece of files already on disk instead of
+	// hashing them, as if HavePieces listed them all.
+	SkipCheck bool
+
+	Label    string
+	Priority Priority
+
+	// FilePriorities sets each file's Priority, indexed like the
+	// metainfo's files. Optional.
+	FilePriorities []scheduler.Priority
+	// FilePreview marks files whose first and last pieces are fetched
+	// first, indexed like the metainfo's files. Optional.
+	FilePreview []bool
+
+	// ExtraTrackers are appended to the torrent's own as a last tier,
+	// tried when those fail. Ignored for private torrents.
+	ExtraTrackers []string
+
+	// OnDeadlineMiss is told of pieces a reader was waiting on that
+	// arrived after their deadline. Optional.
+	OnDeadlineMiss func(scheduler.DeadlineMiss)
+
+	// Connectivity is the client-wide record of the port we announce to
+	// trackers and peers. Optional.
+	Connectivity *connectivity.Manager
+}
+
+func NewTorrent(data []byte, opts *Opts) (_ *Torrent, err error) {
+	cfg := opts.Config
 	if cfg == nil {
 		cfg = WithDefaultConfig()
 	}
+	clientID := opts.ClientID
+	if clientID == ([sha1.Size]byte{}) {
+		id, err := peer.SessionID()
+		if err != nil {
+			return nil, err
+		}
+		clientID = id
+	}
 
 	metainfo, err := meta.ParseMetainfo(data)
 	if err != nil {
@@ -41,14 +168,60 @@ func NewTorrent(clientID [sha1.Size]byte, data []byte, cfg *Config) (*Torrent, e
 	}
 
 	logger := slog.Default().With("torrent", metainfo.Info.Name)
+	supervisor := supervise.New(&supervise.Opts{
+		Config: cfg.Supervise,
+		Logger: logger,
+		Clock:  opts.Clock,
+	})
 
+	var (
+		reads     *storage.ReadSource
+		bandwidth *peer.Bandwidth
+	)
 	storage, err := storage.NewStorage(metainfo, cfg.Storage, logger)
 	if err != nil {
 		return nil, err
 	}
+	// From here on a failure must undo what closeStorage would: the store
+	// is open and the read source and bandwidth share are registered with
+	// client-wide schedulers.
+	defer func() {
+		if err == nil {
+			return
+		}
+		if reads != nil {
+			reads.Close()
+		}
+		bandwidth.Release()
+		if cerr := storage.Close(); cerr != nil {
+			logger.Error("close storage failed", "error", cerr)
+		}
+	}()
+	switch {
+	case opts.SkipCheck:
+		all := bitfield.New(len(metainfo.Info.Pieces))
+		for i := range len(metainfo.Info.Pieces) {
+			all.Set(i)
+		}
+		storage.TrustPieces(all)
+	case opts.HavePieces != nil:
+		storage.TrustPieces(opts.HavePieces)
+	}
+	storage.UseCheckQueue(opts.Checks)
+	storage.UseFilePool(opts.Files)
+	storage.UseSupervisor(supervisor)
+	digests := opts.PieceDigests
+	if digests == nil {
+		digests = piece.Digests(metainfo.Info.Pieces)
+	}
+	if opts.Hasher != nil {
+		if err := storage.UseHasher(opts.Hasher, digests); err != nil {
+			return nil, err
+		}
+	}
 
 	pieceManager, err := piece.NewManager(
-		metainfo.Info.Pieces,
+		digests,
 		metainfo.Info.PieceLength,
 		metainfo.Size,
 		logger,
@@ -57,23 +230,40 @@ func NewTorrent(clientID [sha1.Size]byte, data []byte, cfg *Config) (*Torrent, e
 		return nil, err
 	}
 
+	schedOpts := &scheduler.Opts{
+		Config:   cfg.Scheduler,
+		Logger:   logger,
+		MaxPeers: cfg.Peer.MaxPeers,
+		Clock:    opts.Clock,
+	}
+	if opts.Reads != nil {
+		reads = opts.Reads.Source(storage)
+		schedOpts.Reader = reads
+	}
+
 	scheduler := scheduler.NewScheduler(
 		pieceManager,
 		storage.PieceQueue,
 		storage.PieceResultQueue,
-		&scheduler.Opts{
-			Config:   cfg.Scheduler,
-			Logger:   logger,
-			MaxPeers: cfg.Peer.MaxPeers,
-		},
+		schedOpts,
 	)
 
+	bandwidth = opts.Bandwidth.Shared(opts.Priority.bandwidthWeight())
 	peerManager, err := peer.NewSwarm(&peer.SwarmOpts{
-		Config:    cfg.Peer,
-		Logger:    logger,
-		Scheduler: scheduler,
-		InfoHash:  metainfo.InfoHash,
-		ClientID:  clientID,
+		Config:      cfg.Peer,
+		Logger:      logger,
+		Scheduler:   scheduler,
+		InfoHash:    metainfo.InfoHash,
+		ClientID:    clientID,
+		PeerCache:   opts.PeerCache,
+		Bandwidth:   bandwidth,
+		Dial:        opts.Dial,
+		Clock:       opts.Clock,
+		UploadSlots: opts.UploadSlots,
+		Registry:    opts.Registry,
+		Supervisor:  supervisor,
+
+		Connectivity: opts.Connectivity,
 	})
 	if err != nil {
 		return nil, err
@@ -81,6 +271,8 @@ func NewTorrent(clientID [sha1.Size]byte, data []byte, cfg *Config) (*Torrent, e
 
 	torrent := &Torrent{
 		Metainfo:     metainfo,
+		raw:          bytes.Clone(data),
+		state:        StateStopped,
 		clientID:     clientID,
 		cfg:          cfg,
 		logger:       logger,
@@ -88,55 +280,454 @@ func NewTorrent(clientID [sha1.Size]byte, data []byte, cfg *Config) (*Torrent, e
 		scheduler:    scheduler,
 		peerManager:  peerManager,
 		storage:      storage,
+		reads:        reads,
+		bandwidth:    bandwidth,
+		supervisor:   supervisor,
+		label:        opts.Label,
+	}
+	scheduler.OnComplete(torrent.finishDownload)
+	storage.OnFault(torrent.storageFailed)
+	if opts.OnDeadlineMiss != nil {
+		scheduler.OnDeadlineMiss(opts.OnDeadlineMiss)
+	}
+	if opts.Paused {
+		torrent.state = StatePaused
+		torrent.pauseReason = opts.PauseReason
+	}
+	if opts.FilePriorities != nil {
+		if err := torrent.SetFilePriorities(opts.FilePriorities); err != nil {
+			return nil, err
+		}
+	}
+	if opts.FilePreview != nil {
+		if err := torrent.SetFilePreview(opts.FilePreview); err != nil {
+			return nil, err
+		}
 	}
 
-	tracker, err := tracker.NewTracker(
+	tr, err := tracker.NewTracker(
 		metainfo.Announce,
-		metainfo.AnnounceList,
+		announceList(metainfo, opts.ExtraTrackers),
 		&tracker.TrackerOpts{
 			Config:        cfg.Tracker,
 			Logger:        logger,
 			PeerAddrQueue: peerManager.GetPeerConnectQueue(),
 			GetState:      torrent.buildAnnounceParams,
+			HTTPSCache:    opts.HTTPSCache,
+			Clock:         opts.Clock,
+			Supervisor:    supervisor,
+			Connectivity:  opts.Connectivity,
 		},
 	)
-	if err != nil {
+	switch {
+	case errors.Is(err, tracker.ErrNoAnnounceURLs):
+		logger.Info("torrent has no trackers, running trackerless")
+	case err != nil:
+		return nil, err
+	default:
+		torrent.tracker = tr
+	}
+	if err := torrent.SetPriority(opts.Priority); err != nil {
 		return nil, err
 	}
-	torrent.tracker = tracker
 
 	return torrent, nil
 }
 
 func (t *Torrent) Run(ctx context.Context) error {
+	t.runMut.Lock()
+	if t.closed {
+		t.runMut.Unlock()
+		return ErrClosed
+	}
+	if t.running {
+		t.runMut.Unlock()
+		return ErrRunning
+	}
 	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
 	t.cancel = cancel
+	t.done = done
+	t.running = true
+	t.paused = false
+	t.runMut.Unlock()
+
+	defer func() {
+		cancel()
+
+		t.runMut.Lock()
+		t.running = false
+		paused, closed := t.paused, t.closed
+		t.runMut.Unlock()
+
+		switch s, _ := t.State(); {
+		case closed:
+			t.closeStorage()
+			t.setState(StateStopped, nil)
+		case paused:
+			t.setState(StatePaused, nil)
+		case s != StateNeedsRecheck && s != StateError:
+			t.setState(StateStopped, nil)
+		}
+
+		close(done)
+	}()
+
+	if mismatched := t.storage.SizeMismatches(); len(mismatched) > 0 {
+		t.logger.Error("file sizes on disk differ from metainfo, not starting",
+			"files", mismatched,
+		)
+		t.setState(StateNeedsRecheck, ErrNeedsRecheck)
+		return ErrNeedsRecheck
+	}
+
+	t.setState(StateChecking, nil)
 
 	g, gctx := errgroup.WithContext(ctx)
 
-	g.Go(func() error { return t.tracker.Run(gctx) })
-	g.Go(func() error { return t.peerManager.Run(gctx) })
 	g.Go(func() error { return t.scheduler.Run(gctx) })
 	g.Go(func() error { return t.storage.Run(gctx) })
 
+	// Hold off on the swarm until existing files are checked so we don't
+	// request pieces we already have.
+	g.Go(func() error {
+		select {
+		case <-t.storage.Checked():
+		case <-gctx.Done():
+			return nil
+		}
+
+		t.setState(StateDownloading, nil)
+
+		if t.announces() {
+			g.Go(func() error { return t.tracker.Run(gctx) })
+		}
+		g.Go(func() error { return t.peerManager.Run(gctx) })
+		return nil
+	})
+
 	return g.Wait()
 }
 
+// announceList is the metainfo's announce list with the extra trackers
+// it doesn't have yet as one more tier. Private torrents must only use
+// their own trackers, so they get none.
+func announceList(m *meta.Metainfo, extra []string) [][]string {
+	if len(extra) == 0 || m.Info.Private {
+		return m.AnnounceList
+	}
+
+	seen := map[string]struct{}{m.Announce: {}}
+	for _, tier := range m.AnnounceList {
+		for _, u := range tier {
+			seen[u] = struct{}{}
+		}
+	}
+
+	var tier []string
+	for _, u := range extra {
+		if _, ok := seen[u]; ok {
+			continue
+		}
+		seen[u] = struct{}{}
+		tier = append(tier, u)
+	}
+	if len(tier) == 0 {
+		return m.AnnounceList
+	}
+	return append(slices.Clone(m.AnnounceList), tier)
+}
+
+// announces reports whether the torrent should announce to trackers:
+// it has some and trackerless mode is off.
+func (t *Torrent) announces() bool {
+	return t.tracker != nil && (t.cfg.Tracker == nil || !t.cfg.Tracker.Disabled)
+}
+
+// Reannounce announces to the tracker at trackerURL right away, or to all
+// of them when it is empty. See tracker.Tracker.Reannounce for the rate
+// limit that applies.
+func (t *Torrent) Reannounce(ctx context.Context, trackerURL string) error {
+	if !t.announces() {
+		return ErrNoTrackers
+	}
+	if s, _ := t.State(); s != StateDownloading && s != StateSeeding {
+		return ErrNotRunning
+	}
+	return t.tracker.Reannounce(ctx, trackerURL)
+}
+
+// Scrape asks the trackers how many seeders and leechers the swarm has,
+// without announcing. Unlike Reannounce it works while paused, so queued
+// torrents can be compared with running ones.
+func (t *Torrent) Scrape(ctx context.Context) (*tracker.ScrapeResponse, error) {
+	if !t.announces() {
+		return nil, ErrNoTrackers
+	}
+	return t.tracker.Scrape(ctx)
+}
+
+// NetworkChanged tells a running torrent the network changed or came
+// back, so it announces right away and redials peers it had given up on
+// instead of waiting out their backoffs.
+func (t *Torrent) NetworkChanged() {
+	if s, _ := t.State(); s != StateDownloading && s != StateSeeding {
+		return
+	}
+	t.peerManager.Reconnect()
+	if t.announces() {
+		t.tracker.Wake()
+	}
+}
+
+// Stop shuts the torrent down for good and releases its files.
 func (t *Torrent) Stop() {
+	t.runMut.Lock()
+	t.closed = true
+	running := t.running
+	if running {
+		t.cancel()
+	}
+	t.runMut.Unlock()
+
+	if !running {
+		t.closeStorage()
+	}
+}
+
+// closeStorage stops serving reads and releases the torrent's files and
+// bandwidth share.
+func (t *Torrent) closeStorage() {
+	if t.reads != nil {
+		t.reads.Close()
+	}
+	t.bandwidth.Release()
+	if err := t.storage.Close(); err != nil {
+		t.logger.Error("close storage failed", "error", err)
+	}
+}
+
+// storageFailed stops the torrent when its files can't be written, so it
+// doesn't keep downloading pieces it can't store. Other torrents carry on.
+func (t *Torrent) storageFailed(err error) {
+	t.logger.Error("storage failed, stopping torrent", "error", err)
+
+	t.runMut.Lock()
+	defer t.runMut.Unlock()
+
+	if !t.running || t.closed || t.paused {
+		return
+	}
+	t.setState(StateError, err)
 	t.cancel()
 }
 
+// finishDownload moves the files out of their incomplete location once the
+// last piece is in.
+func (t *Torrent) finishDownload() {
+	if err := t.storage.Finalize(); err != nil {
+		t.logger.Error("move completed files failed", "error", err)
+		return
+	}
+	t.logger.Info("download complete", "path", t.storage.ContentPath())
+}
+
+// Remove stops the torrent, waits for it to shut down and, if deleteData
+// is set, deletes the files it downloaded.
+func (t *Torrent) Remove(deleteData bool) error {
+	t.Stop()
+
+	t.runMut.Lock()
+	running, done := t.running, t.done
+	t.runMut.Unlock()
+	if running {
+		<-done
+	}
+
+	if !deleteData {
+		return nil
+	}
+	if err := t.storage.DeleteData(); err != nil {
+		return fmt.Errorf("torrent: delete data: %w", err)
+	}
+	t.logger.Info("torrent data deleted")
+	return nil
+}
+
+// Pause disconnects from the swarm and trackers while keeping the torrent
+// and its files around for Resume.
+func (t *Torrent) Pause() error {
+	return t.PauseFor("")
+}
+
+// PauseFor pauses like Pause, recording why the client did so. Pausing an
+// already paused torrent only updates the reason.
+func (t *Torrent) PauseFor(reason string) error {
+	t.runMut.Lock()
+	defer t.runMut.Unlock()
+
+	if t.closed {
+		return ErrClosed
+	}
+	if !t.running {
+		if s, _ := t.State(); s == StatePaused {
+			t.setPaused(reason)
+			return nil
+		}
+		return ErrNotRunning
+	}
+
+	t.paused = true
+	t.cancel()
+	t.setPaused(reason)
+	return nil
+}
+
+// Resume restarts a paused or failed torrent under ctx once the previous
+// run has finished shutting down. It does not block.
+func (t *Torrent) Resume(ctx context.Context) error {
+	if s, _ := t.State(); s != StatePaused && s != StateError {
+		return fmt.Errorf("torrent: cannot resume in state %s", s)
+	}
+
+	t.runMut.Lock()
+	running, done := t.running, t.done
+	t.runMut.Unlock()
+
+	go func() {
+		if running {
+			<-done
+		}
+		if err := t.Run(ctx); err != nil {
+			t.logger.Error("torrent stopped", "error", err)
+		}
+	}()
+	return nil
+}
+
+// SetDownloadPaused stops or resumes downloading while the torrent keeps
+// seeding what it has. Peers and trackers are told we only upload.
+func (t *Torrent) SetDownloadPaused(paused bool) {
+	t.scheduler.SetDownloadPaused(paused)
+}
+
+// DownloadPaused reports whether downloading is paused.
+func (t *Torrent) DownloadPaused() bool {
+	return t.scheduler.DownloadPaused()
+}
+
+func (t *Torrent) Label() string {
+	t.stateMut.RLock()
+	defer t.stateMut.RUnlock()
+
+	return t.label
+}
+
+func (t *Torrent) SetLabel(label string) {
+	t.stateMut.Lock()
+	t.label = label
+	t.stateMut.Unlock()
+}
+
+// Recheck resizes mismatched files and starts the torrent again, hash
+// checking what is on disk. Only valid in StateNeedsRecheck.
+func (t *Torrent) Recheck(ctx context.Context) error {
+	if s, _ := t.State(); s != StateNeedsRecheck {
+		return fmt.Errorf("torrent: recheck not needed in state %s", s)
+	}
+	if err := t.storage.FixSizes(); err != nil {
+		return err
+	}
+
+	go func() {
+		if err := t.Run(ctx); err != nil {
+			t.logger.Error("torrent stopped", "error", err)
+		}
+	}()
+	return nil
+}
+
+// VerifyData hash-checks every verified piece on disk and reports the
+// corrupt ones by file, leaving the torrent as it is. RedownloadPieces
+// fetches what it finds again.
+func (t *Torrent) VerifyData(ctx context.Context) (*storage.IntegrityReport, error) {
+	if !t.checked() {
+		return nil, errors.New("torrent: existing data not checked yet")
+	}
+
+	have := bitfield.New(int(t.pieceManager.PieceCount()))
+	for i, st := range t.pieceManager.PieceStatus() {
+		if st == piece.StatusDone {
+			have.Set(i)
+		}
+	}
+	return t.storage.VerifyData(ctx, have)
+}
+
+// RedownloadPieces drops pieces, typically the corrupt ones VerifyData
+// reported, and downloads them again. The rest of the torrent is kept.
+func (t *Torrent) RedownloadPieces(pieces []uint32) error {
+	if !t.checked() {
+		return errors.New("torrent: existing data not checked yet")
+	}
+	for _, idx := range pieces {
+		if idx >= t.pieceManager.PieceCount() {
+			return fmt.Errorf("torrent: piece %d out of range", idx)
+		}
+	}
+
+	t.storage.ForgetPieces(pieces)
+	t.scheduler.ForgetPieces(pieces)
+	t.logger.Info("redownloading pieces", "count", len(pieces))
+	return nil
+}
+
+// checked reports whether the initial check of existing data has finished.
+func (t *Torrent) checked() bool {
+	select {
+	case <-t.storage.Checked():
+		return true
+	default:
+		return false
+	}
+}
+
 type Stats struct {
 	peer.SwarmMetrics
 	tracker.TrackerMetrics
-	Progress    float64            `json:"progress"`
-	Peers       []peer.PeerMetrics `json:"peers"`
-	PieceStates []int              `json:"pieceStates"`
+	Progress      float64              `json:"progress"`
+	Peers         []peer.PeerMetrics   `json:"peers"`
+	PieceStates   []int                `json:"pieceStates"`
+	Wasted        scheduler.WasteStats `json:"wasted"`
+	Checking      bool                 `json:"checking"`
+	CheckQueued   bool                 `json:"checkQueued"`
+	CheckProgress float64              `json:"checkProgress"`
+	State         State                `json:"state"`
+	Error         string               `json:"error,omitempty"`
+	Label         string               `json:"label"`
+	Priority      Priority             `json:"priority"`
+	PauseReason   string               `json:"pauseReason,omitempty"`
+	Completed     bool                 `json:"completed"`
+	// PartialSeed is set once every file not skipped is complete.
+	PartialSeed    bool `json:"partialSeed"`
+	DownloadPaused bool `json:"downloadPaused"`
+	// Health lists the subsystems that failed recently and were, or are
+	// waiting to be, restarted.
+	Health []supervise.Warning `json:"health"`
+	// Queues shows where work is backing up.
+	Queues QueueDepths `json:"queues"`
+	// DeadlineMisses counts pieces streamed late; DeadlinePressure is
+	// how far behind streaming currently is, 0 to 3.
+	DeadlineMisses   uint64 `json:"deadlineMisses"`
+	DeadlinePressure int    `json:"deadlinePressure"`
 }
 
 func (t *Torrent) GetStats() *Stats {
 	swarmStats := t.peerManager.Stats()
-	trackerStats := t.tracker.Stats()
+	var trackerStats tracker.TrackerMetrics
+	if t.tracker != nil {
+		trackerStats = t.tracker.Stats()
+	}
 
 	// Get piece statuses and convert to []int for JSON marshaling
 	rawStates := t.pieceManager.PieceStatus()
@@ -146,12 +737,34 @@ func (t *Torrent) GetStats() *Stats {
 	}
 
 	s := &Stats{
-		Progress:    0.0,
-		Peers:       t.peerManager.PeerMetrics(),
-		PieceStates: pieceStates,
+		Progress:       0.0,
+		Peers:          t.peerManager.PeerMetrics(),
+		PieceStates:    pieceStates,
+		Wasted:         t.scheduler.WasteStats(),
+		Checking:       t.storage.Checking(),
+		CheckQueued:    t.storage.CheckQueued(),
+		Label:          t.Label(),
+		Priority:       t.Priority(),
+		PauseReason:    t.PauseReason(),
+		Completed:      t.Completed(),
+		PartialSeed:    t.scheduler.PartialSeed(),
+		DownloadPaused: t.DownloadPaused(),
+		Health:         t.supervisor.Warnings(),
+		Queues:         t.QueueDepths(),
+		DeadlineMisses: t.scheduler.DeadlineMisses(),
+	}
+	if state, err := t.State(); err != nil {
+		s.State, s.Error = state, err.Error()
+	} else {
+		s.State = state
 	}
 	s.SwarmMetrics = swarmStats
 	s.TrackerMetrics = trackerStats
+	s.DeadlinePressure = t.scheduler.DeadlinePressure()
+
+	if check := t.storage.CheckProgress(); check.Total > 0 {
+		s.CheckProgress = float64(check.Checked) / float64(check.Total) * 100.0
+	}
 
 	if total := len(s.PieceStates); total > 0 {
 		completed := 0
@@ -165,6 +778,92 @@ func (t *Torrent) GetStats() *Stats {
 	return s
 }
 
+// PeerInfo is one row of the peers tab.
+type PeerInfo struct {
+	peer.PeerSnapshot
+	Relevance        float64 `json:"relevance"`
+	RequestsInFlight int     `json:"requestsInFlight"`
+}
+
+// GetPeers describes every connected peer, combining what the swarm and
+// the scheduler know about it.
+func (t *Torrent) GetPeers() []PeerInfo {
+	snapshots := t.peerManager.PeerSnapshots()
+	views := t.scheduler.PeerViews()
+	out := make([]PeerInfo, 0, len(snapshots))
+	for _, snap := range snapshots {
+		info := PeerInfo{PeerSnapshot: snap}
+		if v, ok := views[snap.Addr]; ok {
+			info.Relevance = v.Relevance
+			info.RequestsInFlight = v.RequestsInFlight
+		}
+		out = append(out, info)
+	}
+	return out
+}
+
+// QueueDepths is how much work is backed up in each stage of a torrent's
+// pipeline, for spotting which one is the bottleneck.
+type QueueDepths struct {
+	PeerConnects int `json:"peerConnects"`
+	PeerOutboxes int `json:"peerOutboxes"`
+	// PeerOutboxFullest is the longest single peer outbox.
+	PeerOutboxFullest int `json:"peerOutboxFullest"`
+	// PeerWork is scheduler work queued for peers, not yet sent.
+	PeerWork        int `json:"peerWork"`
+	SchedulerEvents int `json:"schedulerEvents"`
+	StorageBlocks   int `json:"storageBlocks"`
+	DiskWrites      int `json:"diskWrites"`
+	PieceResults    int `json:"pieceResults"`
+	// InflightRequests is block requests the picker has out.
+	InflightRequests int  `json:"inflightRequests"`
+	Endgame          bool `json:"endgame"`
+}
+
+func (t *Torrent) QueueDepths() QueueDepths {
+	var q QueueDepths
+	q.PeerConnects, q.PeerOutboxes, q.PeerOutboxFullest = t.peerManager.QueueDepths()
+	q.PeerWork = t.scheduler.WorkQueueDepth()
+	q.SchedulerEvents = t.scheduler.QueueDepth()
+	q.InflightRequests, q.Endgame = t.scheduler.InflightRequests()
+	q.StorageBlocks, q.DiskWrites, q.PieceResults = t.storage.QueueDepths()
+	return q
+}
+
+// RenameFile moves a file within the torrent's root folder. Safe to call
+// while the torrent is running.
+func (t *Torrent) RenameFile(index int, newPath string) error {
+	return t.storage.RenameFile(index, newPath)
+}
+
+// RenameRoot renames the torrent's root folder, or its only file.
+func (t *Torrent) RenameRoot(newName string) error {
+	return t.storage.RenameRoot(newName)
+}
+
+// SavePath is the directory the torrent's content is saved under.
+func (t *Torrent) SavePath() string {
+	return t.cfg.Storage.DownloadDir
+}
+
+// ContentPath is the torrent's root folder, or its file for single-file
+// torrents.
+func (t *Torrent) ContentPath() string {
+	return t.storage.ContentPath()
+}
+
+// TorrentFile returns a copy of the .torrent file the torrent was added
+// from.
+func (t *Torrent) TorrentFile() []byte {
+	return bytes.Clone(t.raw)
+}
+
+// MagnetURI returns a magnet link carrying the info hash, display name and
+// every tracker of the torrent.
+func (t *Torrent) MagnetURI() string {
+	return t.Metainfo.Magnet().String()
+}
+
 func (t *Torrent) GetConfig() *Config {
 	return t.cfg
 }
@@ -200,13 +899,16 @@ func (t *Torrent) GetPeerMessageHistory(peerAddr string, limit int) ([]*peer.Eve
 
 func (t *Torrent) buildAnnounceParams() *tracker.AnnounceParams {
 	stats := t.peerManager.Stats()
-	downloaded := stats.TotalDownloaded
-	left := t.Metainfo.Size - downloaded
+	// Skipped files aren't wanted, so a partial seed reports nothing left.
+	left := t.Left()
 
-	event := tracker.EventNone
-	if left == 0 {
+	var event tracker.Event
+	switch {
+	case t.Completed():
 		event = tracker.EventCompleted
-	} else if left > 0 {
+	case t.scheduler.UploadOnly():
+		event = tracker.EventPaused
+	default:
 		event = tracker.EventStarted
 	}
 
@@ -216,6 +918,6 @@ func (t *Torrent) buildAnnounceParams() *tracker.AnnounceParams {
 		PeerID:     t.clientID,
 		Uploaded:   stats.TotalUploaded,
 		Downloaded: stats.TotalDownloaded,
-		Left:       t.Metainfo.Size - stats.TotalDownloaded,
+		Left:       left,
 	}
 }
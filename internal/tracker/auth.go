@@ -0,0 +1,121 @@
+package tracker
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TrackerAuth is extra request data some private trackers require on
+// their announce endpoint.
+type TrackerAuth struct {
+	Headers map[string]string
+	Cookies map[string]string
+}
+
+// secretParams are query parameters private trackers use to carry a
+// user's credentials.
+var secretParams = map[string]struct{}{
+	"passkey":      {},
+	"authkey":      {},
+	"auth":         {},
+	"torrent_pass": {},
+	"pid":          {},
+	"uid":          {},
+	"token":        {},
+	"apikey":       {},
+	"api_key":      {},
+}
+
+// minPasskeyLen is the shortest path segment treated as a passkey, e.g.
+// "/announce/0123456789abcdef0123456789abcdef".
+const minPasskeyLen = 16
+
+const redacted = "REDACTED"
+
+func (a *TrackerAuth) apply(req *http.Request) {
+	if a == nil {
+		return
+	}
+	for k, v := range a.Headers {
+		req.Header.Set(k, v)
+	}
+	for name, value := range a.Cookies {
+		req.AddCookie(&http.Cookie{Name: name, Value: value})
+	}
+}
+
+// strip removes the configured headers from a request about to be
+// redirected to another host. The transport already drops cookies.
+func (a *TrackerAuth) strip(req *http.Request) {
+	if a == nil {
+		return
+	}
+	for k := range a.Headers {
+		req.Header.Del(k)
+	}
+}
+
+// redactURL renders u with passkeys in the path or query replaced, for
+// logs and error messages.
+func redactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+
+	r := *u
+	r.User = nil
+
+	segments := strings.Split(r.Path, "/")
+	for i, seg := range segments {
+		if looksLikePasskey(seg) {
+			segments[i] = redacted
+		}
+	}
+	r.Path = strings.Join(segments, "/")
+	r.RawPath = ""
+
+	if r.RawQuery != "" {
+		q := r.Query()
+		for k := range q {
+			if _, ok := secretParams[strings.ToLower(k)]; ok {
+				q.Set(k, redacted)
+			}
+		}
+		q.Del("info_hash")
+		q.Del("peer_id")
+		r.RawQuery = q.Encode()
+	}
+
+	return r.String()
+}
+
+func looksLikePasskey(seg string) bool {
+	if len(seg) < minPasskeyLen {
+		return false
+	}
+	for _, c := range seg {
+		isAlnum := (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !isAlnum {
+			return false
+		}
+	}
+	return true
+}
+
+// redactError rewrites the URL inside a *url.Error so transport failures
+// don't leak passkeys into logs or the UI.
+func redactError(err error) error {
+	var uerr *url.Error
+	if !errors.As(err, &uerr) {
+		return err
+	}
+
+	if u, perr := url.Parse(uerr.URL); perr == nil {
+		uerr.URL = redactURL(u)
+	} else {
+		uerr.URL = redacted
+	}
+	return err
+}
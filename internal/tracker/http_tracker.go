@@ -1,15 +1,19 @@
 package tracker
 
 import (
+	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/netip"
 	"net/url"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prxssh/rabbit/internal/bencode"
@@ -18,28 +22,74 @@ import (
 
 const maxTrackerResponseSize = 2 * 1024 * 1024 // 2MB
 
+var errResponseTooLarge = errors.New("tracker: response too large")
+
 type HTTPTracker struct {
 	baseURL   *url.URL
 	client    *http.Client
+	userAgent string
+	auth      *TrackerAuth
+	stats     *Stats
 	mut       sync.RWMutex
 	trackerID string
 	logger    *slog.Logger
 }
 
-func NewHTTPTracker(url *url.URL, logger *slog.Logger) (*HTTPTracker, error) {
+func NewHTTPTracker(
+	url *url.URL,
+	cfg *Config,
+	stats *Stats,
+	logger *slog.Logger,
+) (*HTTPTracker, error) {
 	logger = logger.With("type", "http")
 
+	dialer := &net.Dialer{
+		Timeout:   cfg.HTTPConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	// Compression is negotiated and decoded by us rather than the
+	// transport, so the response size limit applies to the decoded body
+	// and trackers that gzip without being asked are still understood.
 	t := &http.Transport{
-		MaxIdleConns:        100,
-		IdleConnTimeout:     30 * time.Second,
-		DisableCompression:  false,
-		TLSHandshakeTimeout: 10 * time.Second,
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       30 * time.Second,
+		DisableCompression:    true,
+		TLSHandshakeTimeout:   cfg.HTTPConnectTimeout,
+		ResponseHeaderTimeout: cfg.HTTPReadTimeout,
+	}
+
+	maxRedirects := cfg.HTTPMaxRedirects
+
+	var auth *TrackerAuth
+	if a, ok := cfg.Auth[url.Hostname()]; ok {
+		auth = &a
+	}
+
+	client := &http.Client{
+		Transport: t,
+		Timeout:   cfg.AnnounceTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return fmt.Errorf("tracker: stopped after %d redirects", maxRedirects)
+			}
+			// Credentials for this tracker must not follow it elsewhere.
+			if req.URL.Hostname() != via[0].URL.Hostname() {
+				auth.strip(req)
+			}
+			return nil
+		},
 	}
 
 	return &HTTPTracker{
-		logger:  logger,
-		baseURL: url,
-		client:  &http.Client{Transport: t, Timeout: 30 * time.Second},
+		logger:    logger,
+		baseURL:   url,
+		client:    client,
+		userAgent: cfg.UserAgent,
+		auth:      auth,
+		stats:     stats,
 	}, nil
 }
 
@@ -47,48 +97,82 @@ func (ht *HTTPTracker) Announce(
 	ctx context.Context,
 	params *AnnounceParams,
 ) (*AnnounceResponse, error) {
-	req, err := http.NewRequestWithContext(
-		ctx,
-		http.MethodGet,
-		ht.buildAnnounceURL(params),
-		nil,
-	)
+	data, err := ht.get(ctx, ht.buildAnnounceURL(params), "announce")
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := ht.client.Do(req)
+	r, err := parseAnnounceResponse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.TrackerID != "" {
+		ht.mut.Lock()
+		ht.trackerID = r.TrackerID
+		ht.mut.Unlock()
+	}
+
+	return r, nil
+}
+
+// get fetches rawURL and returns the decoded body. what names the request
+// in errors.
+func (ht *HTTPTracker) get(ctx context.Context, rawURL, what string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
 	if err != nil {
 		return nil, err
 	}
+
+	req.Header.Set("Accept-Encoding", "gzip")
+	if ht.userAgent != "" {
+		req.Header.Set("User-Agent", ht.userAgent)
+	}
+	ht.auth.apply(req)
+
+	ht.stats.BytesSent.Add(uint64(requestSize(req)))
+
+	resp, err := ht.client.Do(req)
+	if err != nil {
+		return nil, redactError(err)
+	}
 	defer resp.Body.Close()
 
+	resp.Body = &countingReadCloser{ReadCloser: resp.Body, n: &ht.stats.BytesReceived}
+
+	body, err := decodeBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		msg, _ := io.ReadAll(io.LimitReader(body, 1024))
 		return nil, fmt.Errorf(
-			"tracker: announce returned non-ok status %d:%s",
+			"tracker: %s returned non-ok status %d:%s",
+			what,
 			resp.StatusCode,
-			string(body),
+			string(msg),
 		)
 	}
 
-	r, err := parseAnnounceResponse(resp.Body)
+	// Read one byte past the limit so an oversized body is an error
+	// instead of silently truncated bencode.
+	data, err := io.ReadAll(io.LimitReader(body, maxTrackerResponseSize+1))
 	if err != nil {
 		return nil, err
 	}
-
-	if r.TrackerID != "" {
-		ht.mut.Lock()
-		ht.trackerID = r.TrackerID
-		ht.mut.Unlock()
+	if len(data) > maxTrackerResponseSize {
+		return nil, errResponseTooLarge
 	}
-
-	return r, nil
+	return data, nil
 }
 
+// buildAnnounceURL appends our parameters to the announce URL, leaving its
+// own query (often a passkey) byte-for-byte as the tracker issued it.
 func (ht *HTTPTracker) buildAnnounceURL(params *AnnounceParams) string {
 	u := *ht.baseURL
-	q := u.Query()
+	q := url.Values{}
 
 	q.Set("info_hash", string(params.InfoHash[:]))
 	q.Set("peer_id", string(params.PeerID[:]))
@@ -107,6 +191,9 @@ func (ht *HTTPTracker) buildAnnounceURL(params *AnnounceParams) string {
 	if params.Event != EventNone {
 		q.Set("event", params.Event.String())
 	}
+	if params.IP != "" {
+		q.Set("ip", params.IP)
+	}
 
 	ht.mut.RLock()
 	trackerID := ht.trackerID
@@ -116,17 +203,55 @@ func (ht *HTTPTracker) buildAnnounceURL(params *AnnounceParams) string {
 		q.Set("trackerid", ht.trackerID)
 	}
 
-	u.RawQuery = q.Encode()
+	if u.RawQuery != "" {
+		u.RawQuery += "&" + q.Encode()
+	} else {
+		u.RawQuery = q.Encode()
+	}
 	return u.String()
 }
 
-func parseAnnounceResponse(r io.Reader) (*AnnounceResponse, error) {
-	lr := io.LimitReader(r, maxTrackerResponseSize)
-	data, err := io.ReadAll(lr)
+// requestSize estimates the bytes req takes on the wire: its request line,
+// Host and headers. The transport's own few headers aren't known here.
+func requestSize(req *http.Request) int {
+	// "GET " + URI + " HTTP/1.1\r\n" + "Host: " + host + "\r\n" + "\r\n"
+	n := len(req.Method) + 1 + len(req.URL.RequestURI()) + 11
+	n += 6 + len(req.URL.Host) + 2 + 2
+	for k, vs := range req.Header {
+		for _, v := range vs {
+			n += len(k) + 2 + len(v) + 2
+		}
+	}
+	return n
+}
+
+// countingReadCloser adds every byte read off the wire to n.
+type countingReadCloser struct {
+	io.ReadCloser
+	n *atomic.Uint64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n.Add(uint64(n))
+	return n, err
+}
+
+// decodeBody returns the response body, transparently gunzipping it when the
+// tracker says it is compressed. Closing it leaves resp.Body open.
+func decodeBody(resp *http.Response) (io.ReadCloser, error) {
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		return io.NopCloser(resp.Body), nil
+	}
+
+	zr, err := gzip.NewReader(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("tracker: gzip response: %w", err)
 	}
+	return zr, nil
+}
 
+func parseAnnounceResponse(data []byte) (*AnnounceResponse, error) {
 	raw, err := bencode.Unmarshal(data)
 	if err != nil {
 		return nil, err
@@ -137,12 +262,10 @@ func parseAnnounceResponse(r io.Reader) (*AnnounceResponse, error) {
 		return nil, fmt.Errorf("tracker: announce expected dict but got %T", raw)
 	}
 
-	if failure, ok := dict["failure reason"].(string); ok {
-		return nil, fmt.Errorf("tracker: announce failure %s", failure)
-	}
-	if warning, ok := dict["warning reason"].(string); ok {
-		return nil, fmt.Errorf("tracker: announce warning %s", warning)
+	if failure, err := cast.ToString(dict["failure reason"]); err == nil {
+		return nil, &FailureError{Reason: failure}
 	}
+	warning, _ := cast.ToString(dict["warning message"])
 
 	interval, err := cast.ToInt(dict["interval"])
 	if err != nil {
@@ -160,6 +283,7 @@ func parseAnnounceResponse(r io.Reader) (*AnnounceResponse, error) {
 	trackerID, _ := cast.ToString(dict["trackerid"])
 
 	return &AnnounceResponse{
+		Warning:     warning,
 		TrackerID:   trackerID,
 		Seeders:     seeders,
 		Leechers:    leechers,
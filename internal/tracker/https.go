@@ -0,0 +1,91 @@
+package tracker
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ErrPlaintextTracker is recorded for trackers skipped because HTTPSOnly is
+// set and they can't be reached over TLS.
+var ErrPlaintextTracker = errors.New("tracker: plaintext tracker skipped by HTTPS-only policy")
+
+// httpsProbeTTL is how long a failed HTTPS upgrade keeps a host on the
+// plaintext list before it is probed again.
+const httpsProbeTTL = 6 * time.Hour
+
+// HTTPSCache remembers which tracker hosts answer over HTTPS, so upgraded
+// announces don't re-probe hosts known to be plaintext only. It is safe for
+// concurrent use and meant to be shared by every torrent in a client.
+type HTTPSCache struct {
+	mut   sync.Mutex
+	hosts map[string]httpsProbe
+}
+
+type httpsProbe struct {
+	supported bool
+	at        time.Time
+}
+
+func NewHTTPSCache() *HTTPSCache {
+	return &HTTPSCache{hosts: make(map[string]httpsProbe)}
+}
+
+// unsupported reports whether host recently failed an HTTPS announce.
+func (c *HTTPSCache) unsupported(host string) bool {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	p, ok := c.hosts[host]
+	if !ok {
+		return false
+	}
+	if !p.supported && time.Since(p.at) > httpsProbeTTL {
+		delete(c.hosts, host)
+		return false
+	}
+	return !p.supported
+}
+
+func (c *HTTPSCache) record(host string, supported bool) {
+	c.mut.Lock()
+	c.hosts[host] = httpsProbe{supported: supported, at: time.Now()}
+	c.mut.Unlock()
+}
+
+// applyHTTPSPolicy returns the URL to announce to under the HTTPS-only
+// policy: https URLs as-is, http URLs upgraded unless the host is known not
+// to speak TLS, and nothing for UDP. upgraded is set when the result needs
+// its outcome recorded.
+func (t *Tracker) applyHTTPSPolicy(u *url.URL) (target *url.URL, upgraded bool, err error) {
+	if !t.cfg.HTTPSOnly {
+		return u, false, nil
+	}
+
+	switch u.Scheme {
+	case "https":
+		return u, false, nil
+	case "http":
+		if t.https.unsupported(u.Hostname()) {
+			return nil, false, ErrPlaintextTracker
+		}
+		return upgradeToHTTPS(u), true, nil
+	default:
+		return nil, false, ErrPlaintextTracker
+	}
+}
+
+// upgradeToHTTPS swaps the scheme, dropping an explicit port 80 so the
+// default HTTPS port is used. Other explicit ports are kept.
+func upgradeToHTTPS(u *url.URL) *url.URL {
+	out := *u
+	out.Scheme = "https"
+	if u.Port() == "80" {
+		out.Host = u.Hostname()
+		if len(out.Host) > 0 && u.Host[0] == '[' {
+			out.Host = "[" + out.Host + "]"
+		}
+	}
+	return &out
+}
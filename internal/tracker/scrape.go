@@ -0,0 +1,177 @@
+package tracker
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/prxssh/rabbit/internal/bencode"
+	"github.com/prxssh/rabbit/pkg/cast"
+)
+
+// ErrScrapeUnsupported is returned by Scrape when no tracker of the
+// torrent can be scraped.
+var ErrScrapeUnsupported = errors.New("tracker: scrape not supported")
+
+// ScrapeResponse is a tracker's count of a torrent's swarm, as returned
+// by a scrape: unlike an announce, it doesn't join us to the swarm.
+type ScrapeResponse struct {
+	Seeders   int64
+	Leechers  int64
+	Completed int64
+}
+
+// Scrape asks the first tracker of each tier in turn how big the swarm
+// is, and returns the first answer. It works while the torrent is paused
+// and doesn't count as an announce.
+func (t *Tracker) Scrape(ctx context.Context) (*ScrapeResponse, error) {
+	infoHash := t.getState().InfoHash
+
+	lastErr := ErrScrapeUnsupported
+	for tierIdx := range t.tiers {
+		tier := t.snapshotTier(tierIdx)
+		if len(tier) == 0 {
+			continue
+		}
+
+		target, _, err := t.applyHTTPSPolicy(tier[0])
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		tracker, err := t.getTracker(target)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		sctx, cancel := t.announceContext(ctx)
+		resp, err := tracker.Scrape(sctx, infoHash)
+		cancel()
+		if err == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		t.logger.Debug("scrape failed", "url", redactURL(tier[0]), "error", redactError(err))
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (ht *HTTPTracker) Scrape(
+	ctx context.Context,
+	infoHash [sha1.Size]byte,
+) (*ScrapeResponse, error) {
+	scrapeURL, ok := ht.scrapeURL(infoHash)
+	if !ok {
+		return nil, ErrScrapeUnsupported
+	}
+
+	data, err := ht.get(ctx, scrapeURL, "scrape")
+	if err != nil {
+		return nil, err
+	}
+	return parseScrapeResponse(data, infoHash)
+}
+
+// scrapeURL derives the scrape URL from the announce URL by the usual
+// convention: a last path segment starting with "announce" has it
+// replaced by "scrape". Trackers whose URL doesn't follow it can't be
+// scraped.
+func (ht *HTTPTracker) scrapeURL(infoHash [sha1.Size]byte) (string, bool) {
+	u := *ht.baseURL
+
+	i := strings.LastIndexByte(u.Path, '/')
+	if i < 0 || !strings.HasPrefix(u.Path[i+1:], "announce") {
+		return "", false
+	}
+	u.Path = u.Path[:i+1] + "scrape" + strings.TrimPrefix(u.Path[i+1:], "announce")
+	u.RawPath = ""
+
+	q := "info_hash=" + url.QueryEscape(string(infoHash[:]))
+	if u.RawQuery != "" {
+		u.RawQuery += "&" + q
+	} else {
+		u.RawQuery = q
+	}
+	return u.String(), true
+}
+
+func parseScrapeResponse(data []byte, infoHash [sha1.Size]byte) (*ScrapeResponse, error) {
+	raw, err := bencode.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dict, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("tracker: scrape expected dict but got %T", raw)
+	}
+	if failure, err := cast.ToString(dict["failure reason"]); err == nil {
+		return nil, &FailureError{Reason: failure}
+	}
+
+	files, ok := dict["files"].(map[string]any)
+	if !ok {
+		return nil, errors.New("tracker: scrape files missing")
+	}
+	file, ok := files[string(infoHash[:])].(map[string]any)
+	if !ok {
+		return nil, errors.New("tracker: scrape doesn't list the torrent")
+	}
+
+	seeders, _ := cast.ToInt(file["complete"])
+	leechers, _ := cast.ToInt(file["incomplete"])
+	completed, _ := cast.ToInt(file["downloaded"])
+	return &ScrapeResponse{Seeders: seeders, Leechers: leechers, Completed: completed}, nil
+}
+
+func (ut *UDPTracker) Scrape(
+	ctx context.Context,
+	infoHash [sha1.Size]byte,
+) (*ScrapeResponse, error) {
+	connID, err := ut.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var packet [36]byte
+	binary.BigEndian.PutUint64(packet[0:8], connID)
+	binary.BigEndian.PutUint32(packet[8:12], actionScrape)
+	copy(packet[16:36], infoHash[:])
+
+	resp, err := ut.socket.roundTrip(ctx, ut.addr, packet[:], ut.stats, ut.logger)
+	if err != nil {
+		return nil, err
+	}
+	return parseUDPScrapeResponse(resp)
+}
+
+func parseUDPScrapeResponse(packet []byte) (*ScrapeResponse, error) {
+	if len(packet) < 8 {
+		return nil, errPacketTooShort
+	}
+
+	action := binary.BigEndian.Uint32(packet[0:4])
+	if action == actionError {
+		return nil, &FailureError{Reason: string(packet[8:])}
+	}
+	if action != actionScrape {
+		return nil, errActionMismatch
+	}
+	if len(packet) < 20 {
+		return nil, errPacketTooShort
+	}
+
+	return &ScrapeResponse{
+		Seeders:   int64(binary.BigEndian.Uint32(packet[8:12])),
+		Completed: int64(binary.BigEndian.Uint32(packet[12:16])),
+		Leechers:  int64(binary.BigEndian.Uint32(packet[16:20])),
+	}, nil
+}
@@ -0,0 +1,94 @@
+package tracker
+
+import (
+	"context"
+	"crypto/sha1"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestHTTPTracker_ScrapeURL(t *testing.T) {
+	var infoHash [sha1.Size]byte
+	infoHash[0] = 0xAB
+	hash := url.QueryEscape(string(infoHash[:]))
+
+	tests := []struct {
+		announce string
+		want     string
+		ok       bool
+	}{
+		{"http://t.example/announce", "http://t.example/scrape?info_hash=" + hash, true},
+		{
+			"http://t.example/x/announce.php?pk=1",
+			"http://t.example/x/scrape.php?pk=1&info_hash=" + hash,
+			true,
+		},
+		{"http://t.example/a", "", false},
+		{"http://t.example/announce/x", "", false},
+	}
+
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.announce)
+		ht := &HTTPTracker{baseURL: u}
+
+		got, ok := ht.scrapeURL(infoHash)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("scrapeURL(%s) = %s, %v; want %s, %v", tt.announce, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestHTTPTracker_Scrape(t *testing.T) {
+	var infoHash [sha1.Size]byte
+	copy(infoHash[:], "aaaaaaaaaaaaaaaaaaaa")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/scrape" || r.URL.Query().Get("info_hash") != string(infoHash[:]) {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("d5:filesd20:" + string(infoHash[:]) +
+			"d8:completei5e10:downloadedi9e10:incompletei3eeee"))
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL + "/announce")
+	ht, err := NewHTTPTracker(u, WithDefaultConfig(), &Stats{}, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := ht.Scrape(context.Background(), infoHash)
+	if err != nil {
+		t.Fatalf("Scrape() error = %v", err)
+	}
+	if *resp != (ScrapeResponse{Seeders: 5, Leechers: 3, Completed: 9}) {
+		t.Errorf("Scrape() = %+v, want 5 seeders, 3 leechers, 9 completed", *resp)
+	}
+
+	var other [sha1.Size]byte
+	if _, err := ht.Scrape(context.Background(), other); err == nil {
+		t.Error("Scrape() of an unlisted torrent succeeded")
+	}
+}
+
+func TestUDPTracker_Scrape(t *testing.T) {
+	ft := newFakeUDPTracker(t)
+	go ft.serve(2)
+
+	ut := testUDPTracker(newUDPSocket(), ft.addr())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := ut.Scrape(ctx, [sha1.Size]byte{1})
+	if err != nil {
+		t.Fatalf("Scrape() error = %v", err)
+	}
+	if *resp != (ScrapeResponse{Seeders: 5, Leechers: 3, Completed: 9}) {
+		t.Errorf("Scrape() = %+v, want 5 seeders, 3 leechers, 9 completed", *resp)
+	}
+}
@@ -5,20 +5,43 @@ import (
 	"crypto/sha1"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"math"
 	"math/rand"
+	"net"
 	"net/netip"
 	"net/url"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/prxssh/rabbit/internal/connectivity"
+	"github.com/prxssh/rabbit/internal/version"
+	"github.com/prxssh/rabbit/pkg/clock"
+	"github.com/prxssh/rabbit/pkg/supervise"
 	"golang.org/x/sync/errgroup"
 )
 
 const baseDelay = 15 * time.Second
 
+var (
+	ErrNoAnnounceURLs = errors.New("tracker: no valid announce urls found")
+	// ErrUnreachable wraps the last error of an announce that no tracker
+	// answered at all, as opposed to one a tracker rejected or answered
+	// with an error status.
+	ErrUnreachable = errors.New("tracker: no tracker reachable")
+	// ErrUnknownTracker is returned by Reannounce for a URL that isn't in
+	// the announce list.
+	ErrUnknownTracker = errors.New("tracker: not in announce list")
+	// ErrReannounceTooSoon is returned by Reannounce when every tracker
+	// asked was announced to less than MinReannounceInterval, or its own
+	// min interval, ago.
+	ErrReannounceTooSoon = errors.New("tracker: announced too recently")
+)
+
 type Config struct {
 	// NumWant is the maximutm number of peers to request the tracker.
 	NumWant uint32
@@ -33,6 +56,10 @@ type Config struct {
 	// MinAnnounceInterval enforces a minimum time between announces.
 	MinAnnounceInterval time.Duration
 
+	// MinReannounceInterval is the least time between announces to one
+	// tracker that a forced reannounce will respect.
+	MinReannounceInterval time.Duration
+
 	// MaxAnnounceBackoff caps exponential backoff for failed announces.
 	MaxAnnounceBackoff time.Duration
 
@@ -45,8 +72,48 @@ type Config struct {
 	MaxConsecutiveFailures int
 
 	// Port is the TCP port this client listens on for incoming peer
-	// connections.
+	// connections. The client overwrites it with its bound listen port.
+	// TrackerOpts.Connectivity, when set, takes precedence.
 	Port uint16
+
+	// AnnounceTimeout bounds a single announce attempt against one tracker
+	// so a hung tracker cannot stall the announce loop.
+	AnnounceTimeout time.Duration
+
+	// HTTPConnectTimeout bounds TCP connect and TLS handshake for HTTP
+	// trackers.
+	HTTPConnectTimeout time.Duration
+
+	// HTTPReadTimeout bounds the wait for response headers from HTTP
+	// trackers.
+	HTTPReadTimeout time.Duration
+
+	// HTTPMaxRedirects is the maximum number of redirects followed for a
+	// single HTTP announce.
+	HTTPMaxRedirects int
+
+	// UserAgent is sent to HTTP trackers. It should identify the same
+	// client and version as the peer ID prefix.
+	UserAgent string
+
+	// Auth holds per-tracker headers and cookies, keyed by tracker host
+	// name without port.
+	Auth map[string]TrackerAuth
+
+	// Disabled never announces, leaving the torrent to find peers by other
+	// means. Takes effect the next time the torrent starts.
+	Disabled bool
+
+	// ExternalIP, when set, is reported to trackers as our address instead
+	// of the one they see us connect from: an IP address or host name,
+	// e.g. for a seedbox behind NAT with a forwarded port. UDP trackers
+	// only carry IPv4 addresses; anything else is left out there.
+	ExternalIP string
+
+	// HTTPSOnly announces over TLS only: http:// trackers are upgraded to
+	// https:// when they support it and skipped otherwise, and UDP
+	// trackers are never used.
+	HTTPSOnly bool
 }
 
 func WithDefaultConfig() *Config {
@@ -55,10 +122,17 @@ func WithDefaultConfig() *Config {
 		AnnounceInterval:        0,
 		DefaultAnnounceInterval: 15 * time.Minute,
 		MinAnnounceInterval:     5 * time.Minute,
+		MinReannounceInterval:   time.Minute,
 		MaxAnnounceBackoff:      30 * time.Minute,
 		MaxBackoffShift:         5, // 2^5 = 32 * 15s = ~8m
 		MaxConsecutiveFailures:  5,
 		Port:                    6969,
+		AnnounceTimeout:         30 * time.Second,
+		HTTPConnectTimeout:      10 * time.Second,
+		HTTPReadTimeout:         15 * time.Second,
+		HTTPMaxRedirects:        5,
+		UserAgent:               version.UserAgent(),
+		Auth:                    map[string]TrackerAuth{},
 	}
 }
 
@@ -77,6 +151,9 @@ type AnnounceParams struct {
 }
 
 type AnnounceResponse struct {
+	// Warning is the tracker's "warning message". The announce itself
+	// succeeded.
+	Warning     string
 	TrackerID   string
 	Interval    time.Duration
 	MinInterval time.Duration
@@ -85,6 +162,42 @@ type AnnounceResponse struct {
 	Peers       []netip.AddrPort
 }
 
+// FailureError is returned when a tracker explicitly rejects an announce with
+// a human readable reason, e.g. "torrent not registered with this tracker".
+//
+// HTTP trackers report it as the "failure reason" key and UDP trackers as an
+// error action packet.
+type FailureError struct {
+	Reason string
+}
+
+func (e *FailureError) Error() string {
+	return "tracker: announce failure: " + e.Reason
+}
+
+func isFailure(err error) bool {
+	var fe *FailureError
+	return errors.As(err, &fe)
+}
+
+// isTransport reports whether err means the tracker couldn't be reached:
+// its name didn't resolve, the connection failed or no answer came in
+// time. An error status, a failure reason or a malformed response is an
+// answer, and doesn't count.
+func isTransport(err error) bool {
+	if errors.Is(err, errAttemptsExhausted) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	// The HTTP client wraps everything in a url.Error, which is itself a
+	// net.Error; what matters is the cause.
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		err = ue.Err
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
 type Event uint32
 
 const (
@@ -92,6 +205,9 @@ const (
 	EventStarted
 	EventStopped
 	EventCompleted
+	// EventPaused is BEP 21's event for peers that only upload: partial
+	// seeds and torrents whose downloading is paused.
+	EventPaused
 )
 
 func (e Event) String() string {
@@ -102,6 +218,8 @@ func (e Event) String() string {
 		return "started"
 	case EventCompleted:
 		return "completed"
+	case EventPaused:
+		return "paused"
 	default:
 		return "stopped"
 	}
@@ -109,6 +227,7 @@ func (e Event) String() string {
 
 type TrackerProtocol interface {
 	Announce(ctx context.Context, params *AnnounceParams) (*AnnounceResponse, error)
+	Scrape(ctx context.Context, infoHash [sha1.Size]byte) (*ScrapeResponse, error)
 }
 
 type Stats struct {
@@ -120,17 +239,43 @@ type Stats struct {
 	TotalPeersReceived  atomic.Uint64
 	CurrentSeeders      atomic.Int64
 	CurrentLeechers     atomic.Int64
+
+	// BytesSent and BytesReceived count tracker protocol traffic, which is
+	// overhead and never part of the uploaded/downloaded we announce.
+	BytesSent     atomic.Uint64
+	BytesReceived atomic.Uint64
+}
+
+// TrackerStatus is the last known state of a single announce URL. URL has
+// passkeys redacted, as it is shown to users.
+type TrackerStatus struct {
+	URL           string    `json:"url"`
+	Tier          int       `json:"tier"`
+	LastAnnounce  time.Time `json:"lastAnnounce"`
+	LastSuccess   time.Time `json:"lastSuccess"`
+	LastError     string    `json:"lastError"`
+	FailureReason string    `json:"failureReason"`
+	Warning       string    `json:"warning"`
+	Seeders       int64     `json:"seeders"`
+	Leechers      int64     `json:"leechers"`
+	Peers         int       `json:"peers"`
+	// MinInterval is the least time between announces the tracker last
+	// asked for, zero if it didn't.
+	MinInterval time.Duration `json:"minInterval"`
 }
 
 type TrackerMetrics struct {
-	TotalAnnounces      uint64    `json:"totalAnnounces"`
-	SuccessfulAnnounces uint64    `json:"successfulAnnounces"`
-	FailedAnnounces     uint64    `json:"failedAnnounces"`
-	TotalPeersReceived  uint64    `json:"totalPeersReceived"`
-	CurrentSeeders      int64     `json:"currentSeeders"`
-	CurrentLeechers     int64     `json:"currentLeechers"`
-	LastAnnounce        time.Time `json:"lastAnnounce"`
-	LastSuccess         time.Time `json:"lastSuccess"`
+	TotalAnnounces      uint64          `json:"totalAnnounces"`
+	SuccessfulAnnounces uint64          `json:"successfulAnnounces"`
+	FailedAnnounces     uint64          `json:"failedAnnounces"`
+	TotalPeersReceived  uint64          `json:"totalPeersReceived"`
+	CurrentSeeders      int64           `json:"currentSeeders"`
+	CurrentLeechers     int64           `json:"currentLeechers"`
+	LastAnnounce        time.Time       `json:"lastAnnounce"`
+	LastSuccess         time.Time       `json:"lastSuccess"`
+	BytesSent           uint64          `json:"bytesSent"`
+	BytesReceived       uint64          `json:"bytesReceived"`
+	Trackers            []TrackerStatus `json:"trackers"`
 }
 
 type Tracker struct {
@@ -143,9 +288,23 @@ type Tracker struct {
 	trackerMut sync.Mutex
 	trackers   map[string]TrackerProtocol
 
+	statusMut sync.RWMutex
+	status    map[string]*TrackerStatus
+
 	stats         *Stats
 	peerAddrQueue chan<- netip.AddrPort
 	getState      func() *AnnounceParams
+	https         *HTTPSCache
+	clock         clock.Clock
+	supervisor    *supervise.Supervisor
+	connectivity  *connectivity.Manager
+
+	// intervalScale holds the float64 bits of SetIntervalScale's factor;
+	// 0 means unscaled.
+	intervalScale atomic.Uint64
+
+	// wake asks the announce loop to announce now; see Wake.
+	wake chan struct{}
 }
 
 type TrackerOpts struct {
@@ -153,6 +312,22 @@ type TrackerOpts struct {
 	PeerAddrQueue chan<- netip.AddrPort
 	Logger        *slog.Logger
 	Config        *Config
+
+	// HTTPSCache is the client-wide record of trackers' HTTPS support.
+	// Optional.
+	HTTPSCache *HTTPSCache
+
+	// Clock schedules announces. Defaults to the wall clock.
+	Clock clock.Clock
+
+	// Supervisor restarts the announce loop when it gives up. Optional;
+	// without it a failed loop ends Run.
+	Supervisor *supervise.Supervisor
+
+	// Connectivity is the client-wide record of the port to announce,
+	// read on every announce so a new port mapping goes out with the
+	// next one. Optional; without it Config.Port is announced.
+	Connectivity *connectivity.Manager
 }
 
 func NewTracker(announce string, announceList [][]string, opts *TrackerOpts) (*Tracker, error) {
@@ -184,6 +359,11 @@ func NewTracker(announce string, announceList [][]string, opts *TrackerOpts) (*T
 		logger = slog.Default()
 	}
 
+	httpsCache := opts.HTTPSCache
+	if httpsCache == nil {
+		httpsCache = NewHTTPSCache()
+	}
+
 	return &Tracker{
 		cfg:           opts.Config,
 		logger:        logger.With("source", "tracker"),
@@ -191,17 +371,52 @@ func NewTracker(announce string, announceList [][]string, opts *TrackerOpts) (*T
 		stats:         &Stats{},
 		peerAddrQueue: opts.PeerAddrQueue,
 		getState:      opts.GetState,
+		https:         httpsCache,
+		clock:         clock.Or(opts.Clock),
+		supervisor:    opts.Supervisor,
+		connectivity:  opts.Connectivity,
 		trackers:      make(map[string]TrackerProtocol),
+		status:        make(map[string]*TrackerStatus),
+		wake:          make(chan struct{}, 1),
 	}, nil
 }
 
 func (t *Tracker) Run(ctx context.Context) error {
 	g, gctx := errgroup.WithContext(ctx)
-	g.Go(func() error { return t.announceLoop(gctx) })
+	g.Go(func() error { return t.supervisor.Run(gctx, "announce loop", t.announceLoop) })
 
 	return g.Wait()
 }
 
+// Wake makes the announce loop announce right away and forget earlier
+// failures, e.g. once the network is back. After a successful announce
+// it still waits out the tracker's min interval, or MinReannounceInterval
+// if longer, so waking often can't get us banned. It does not block.
+func (t *Tracker) Wake() {
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// SetIntervalScale multiplies the interval between regular announces by
+// scale from the next announce on, e.g. 0.75 to announce more often. The
+// tracker's min interval and MinAnnounceInterval still apply.
+func (t *Tracker) SetIntervalScale(scale float64) {
+	if scale <= 0 {
+		scale = 1
+	}
+	t.intervalScale.Store(math.Float64bits(scale))
+}
+
+// IntervalScale returns the factor set by SetIntervalScale, 1 by default.
+func (t *Tracker) IntervalScale() float64 {
+	if bits := t.intervalScale.Load(); bits != 0 {
+		return math.Float64frombits(bits)
+	}
+	return 1
+}
+
 func (t *Tracker) Stats() TrackerMetrics {
 	s := t.stats
 
@@ -225,62 +440,95 @@ func (t *Tracker) Stats() TrackerMetrics {
 		CurrentLeechers:     s.CurrentLeechers.Load(),
 		LastAnnounce:        lastAnnT,
 		LastSuccess:         lastSucT,
+		BytesSent:           s.BytesSent.Load(),
+		BytesReceived:       s.BytesReceived.Load(),
+		Trackers:            t.TrackerStatuses(),
+	}
+}
+
+// TrackerStatuses returns the last known state of every announce URL that has
+// been tried, ordered by tier and URL.
+func (t *Tracker) TrackerStatuses() []TrackerStatus {
+	t.statusMut.RLock()
+	out := make([]TrackerStatus, 0, len(t.status))
+	for _, st := range t.status {
+		out = append(out, *st)
+	}
+	t.statusMut.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Tier != out[j].Tier {
+			return out[i].Tier < out[j].Tier
+		}
+		return out[i].URL < out[j].URL
+	})
+
+	return out
+}
+
+func (t *Tracker) recordStatus(
+	tier int,
+	u *url.URL,
+	resp *AnnounceResponse,
+	err error,
+) {
+	key := u.String()
+	now := t.clock.Now()
+
+	t.statusMut.Lock()
+	defer t.statusMut.Unlock()
+
+	st, ok := t.status[key]
+	if !ok {
+		st = &TrackerStatus{URL: redactURL(u)}
+		t.status[key] = st
+	}
+	st.Tier = tier
+	st.LastAnnounce = now
+
+	if err != nil {
+		st.LastError = err.Error()
+		st.FailureReason = ""
+		st.Warning = ""
+
+		var fe *FailureError
+		if errors.As(err, &fe) {
+			st.FailureReason = fe.Reason
+		}
+		return
 	}
+
+	st.LastSuccess = now
+	st.LastError = ""
+	st.FailureReason = ""
+	st.Warning = resp.Warning
+	st.Seeders = resp.Seeders
+	st.Leechers = resp.Leechers
+	st.MinInterval = resp.MinInterval
+	st.Peers = len(resp.Peers)
 }
 
 func (t *Tracker) Announce(ctx context.Context, params *AnnounceParams) (*AnnounceResponse, error) {
 	t.stats.TotalAnnounces.Add(1)
-	t.stats.LastAnnounce.Store(time.Now().Unix())
-
-	params.numWant = t.cfg.NumWant
-	params.port = t.cfg.Port
+	t.stats.LastAnnounce.Store(t.clock.Now().Unix())
 
-	var lastErr error
+	var (
+		lastErr     error
+		unreachable = true
+	)
 
 	for tierIdx := 0; tierIdx < len(t.tiers); tierIdx++ {
 		tier := t.snapshotTier(tierIdx)
 
 		for i, u := range tier {
-			tracker, err := t.getTracker(u)
-			if err != nil {
-				lastErr = err
-				continue
-			}
-
-			resp, err := tracker.Announce(ctx, params)
+			resp, err := t.announceTo(ctx, tierIdx, u, params)
 			if err != nil {
+				unreachable = unreachable && isTransport(err)
 				lastErr = err
 				continue
 			}
 
 			t.promoteWithinTier(tierIdx, i)
-
-			t.stats.SuccessfulAnnounces.Add(1)
-			t.stats.LastSuccess.Store(time.Now().Unix())
-			t.stats.TotalPeersReceived.Add(uint64(len(resp.Peers)))
-			t.stats.CurrentSeeders.Store(resp.Seeders)
-			t.stats.CurrentLeechers.Store(resp.Leechers)
-
-			if t.peerAddrQueue != nil && params.Event != EventStopped {
-				for _, peer := range resp.Peers {
-					select {
-					case t.peerAddrQueue <- peer:
-					default:
-						t.logger.Debug(
-							"peer addr queue full; droppping peer",
-						)
-					}
-				}
-			}
-
-			t.logger.Info("announce success",
-				"tier", tierIdx,
-				"url", u.String(),
-				"peers", len(resp.Peers),
-				"seeders", resp.Seeders,
-				"leechers", resp.Leechers,
-			)
-
 			return resp, nil
 		}
 
@@ -289,20 +537,211 @@ func (t *Tracker) Announce(ctx context.Context, params *AnnounceParams) (*Announ
 
 	t.stats.FailedAnnounces.Add(1)
 	if lastErr == nil {
-		lastErr = errors.New("tracker: no trackers available to announce")
+		return nil, errors.New("tracker: no trackers available to announce")
+	}
+	if unreachable && ctx.Err() == nil {
+		lastErr = fmt.Errorf("%w: %w", ErrUnreachable, lastErr)
 	}
 
 	return nil, lastErr
 }
 
+// announceTo announces to the single tracker u of tier tierIdx, records
+// how it went and queues the peers it returns.
+func (t *Tracker) announceTo(
+	ctx context.Context,
+	tierIdx int,
+	u *url.URL,
+	params *AnnounceParams,
+) (*AnnounceResponse, error) {
+	params.numWant = t.cfg.NumWant
+	params.port = t.port()
+	if params.IP == "" {
+		params.IP = t.cfg.ExternalIP
+	}
+
+	target, upgraded, err := t.applyHTTPSPolicy(u)
+	if err != nil {
+		t.recordStatus(tierIdx, u, nil, err)
+		return nil, err
+	}
+
+	tracker, err := t.getTracker(target)
+	if err != nil {
+		t.recordStatus(tierIdx, u, nil, err)
+		return nil, err
+	}
+
+	actx, cancel := t.announceContext(ctx)
+	resp, err := tracker.Announce(actx, params)
+	cancel()
+	if upgraded && ctx.Err() == nil {
+		// A tracker rejecting the announce still answered over TLS.
+		supported := err == nil || isFailure(err)
+		t.https.record(u.Hostname(), supported)
+		if !supported {
+			t.logger.Info("tracker does not support https, skipping",
+				"url", redactURL(u),
+				"error", redactError(err),
+			)
+		}
+	}
+	t.recordStatus(tierIdx, u, resp, err)
+	if err != nil {
+		if isFailure(err) {
+			t.logger.Warn("tracker rejected announce",
+				"url", redactURL(u),
+				"reason", err.Error(),
+			)
+		}
+		return nil, err
+	}
+	if resp.Warning != "" {
+		t.logger.Warn("tracker warning", "url", redactURL(u), "warning", resp.Warning)
+	}
+
+	t.stats.SuccessfulAnnounces.Add(1)
+	t.stats.LastSuccess.Store(t.clock.Now().Unix())
+	t.stats.TotalPeersReceived.Add(uint64(len(resp.Peers)))
+	t.stats.CurrentSeeders.Store(resp.Seeders)
+	t.stats.CurrentLeechers.Store(resp.Leechers)
+
+	if t.peerAddrQueue != nil && params.Event != EventStopped {
+		for _, peer := range resp.Peers {
+			select {
+			case t.peerAddrQueue <- peer:
+			default:
+				t.logger.Debug(
+					"peer addr queue full; droppping peer",
+				)
+			}
+		}
+	}
+
+	t.logger.Info("announce success",
+		"tier", tierIdx,
+		"url", redactURL(u),
+		"peers", len(resp.Peers),
+		"seeders", resp.Seeders,
+		"leechers", resp.Leechers,
+	)
+
+	return resp, nil
+}
+
+// port returns the port to announce.
+func (t *Tracker) port() uint16 {
+	if p := t.connectivity.Port(); p != 0 {
+		return p
+	}
+	return t.cfg.Port
+}
+
+// Reannounce announces right away to the tracker whose TrackerStatus URL
+// is target, or to every tracker in every tier when target is empty,
+// outside the regular schedule: e.g. after our address changed or when
+// the swarm looks stale. Trackers announced to within
+// MinReannounceInterval, or the min interval they asked for if longer,
+// are skipped so repeated requests can't get us banned; if that leaves
+// none, ErrReannounceTooSoon is returned. Otherwise the error is that of a
+// tracker that failed, nil if any succeeded.
+func (t *Tracker) Reannounce(ctx context.Context, target string) error {
+	type entry struct {
+		tier int
+		u    *url.URL
+	}
+
+	now := t.clock.Now()
+	var (
+		due   []entry
+		found bool
+	)
+	for tierIdx := range t.tiers {
+		for _, u := range t.snapshotTier(tierIdx) {
+			if target != "" && redactURL(u) != target {
+				continue
+			}
+			found = true
+			if last, wait := t.lastAnnounce(u); now.Sub(last) < max(wait, t.cfg.MinReannounceInterval) {
+				continue
+			}
+			due = append(due, entry{tierIdx, u})
+		}
+	}
+	switch {
+	case !found:
+		return ErrUnknownTracker
+	case len(due) == 0:
+		return ErrReannounceTooSoon
+	}
+
+	t.stats.TotalAnnounces.Add(1)
+	t.stats.LastAnnounce.Store(now.Unix())
+	t.logger.Info("reannouncing", "trackers", len(due))
+
+	errs := make([]error, len(due))
+	var wg sync.WaitGroup
+	for i, e := range due {
+		wg.Go(func() {
+			_, errs[i] = t.announceTo(ctx, e.tier, e.u, t.getState())
+		})
+	}
+	wg.Wait()
+
+	var (
+		lastErr     error
+		unreachable = true
+	)
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+		unreachable = unreachable && isTransport(err)
+		lastErr = err
+	}
+	t.stats.FailedAnnounces.Add(1)
+	if unreachable && ctx.Err() == nil {
+		lastErr = fmt.Errorf("%w: %w", ErrUnreachable, lastErr)
+	}
+	return lastErr
+}
+
+// lastAnnounce returns when u was last announced to, zero if never, and
+// the min interval it asked for.
+func (t *Tracker) lastAnnounce(u *url.URL) (time.Time, time.Duration) {
+	t.statusMut.RLock()
+	defer t.statusMut.RUnlock()
+
+	if st, ok := t.status[u.String()]; ok {
+		return st.LastAnnounce, st.MinInterval
+	}
+	return time.Time{}, 0
+}
+
+func (t *Tracker) announceContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t.cfg.AnnounceTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, t.cfg.AnnounceTimeout)
+}
+
 func (t *Tracker) announceLoop(ctx context.Context) error {
 	l := t.logger.With("component", "announce loop")
 	l.Debug("started")
 
 	consecutiveFailures := 0
-	timer := time.NewTimer(0)
+	timer := t.clock.NewTimer(0)
 	defer timer.Stop()
 
+	var (
+		// next is when the timer fires.
+		next = t.clock.Now()
+		// lastSuccess and wakeFloor bound how soon a wake may announce.
+		lastSuccess time.Time
+		wakeFloor   time.Duration
+	)
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -314,7 +753,20 @@ func (t *Tracker) announceLoop(ctx context.Context) error {
 
 			return nil
 
-		case <-timer.C:
+		case <-t.wake:
+			consecutiveFailures = 0
+			now := t.clock.Now()
+			at := now
+			if earliest := lastSuccess.Add(wakeFloor); earliest.After(at) {
+				at = earliest
+			}
+			if at.Before(next) {
+				l.Debug("woken, announcing", "in", at.Sub(now))
+				timer.Reset(at.Sub(now))
+				next = at
+			}
+
+		case <-timer.C():
 			if consecutiveFailures >= t.cfg.MaxConsecutiveFailures {
 				return fmt.Errorf(
 					"tracker: exceeded max %d consecutive failures",
@@ -343,12 +795,15 @@ func (t *Tracker) announceLoop(ctx context.Context) error {
 				)
 			} else {
 				consecutiveFailures = 0
-				nextInterval = getNextAnnounceInterval(resp, t.cfg.AnnounceInterval, t.cfg.MinAnnounceInterval, t.cfg.DefaultAnnounceInterval)
+				nextInterval = getNextAnnounceInterval(resp, t.cfg.AnnounceInterval, t.cfg.MinAnnounceInterval, t.cfg.DefaultAnnounceInterval, t.IntervalScale())
+				lastSuccess = t.clock.Now()
+				wakeFloor = max(resp.MinInterval, t.cfg.MinReannounceInterval)
 
 				l.Debug("announce success, next in", "interval", nextInterval)
 			}
 
 			timer.Reset(nextInterval)
+			next = t.clock.Now().Add(nextInterval)
 		}
 	}
 }
@@ -376,7 +831,7 @@ func (t *Tracker) promoteWithinTier(tierIdx, urlIdx int) {
 	t.logger.Debug("promoted tracker within tier",
 		"tier", tierIdx,
 		"from", urlIdx,
-		"url", u.String(),
+		"url", redactURL(u),
 	)
 }
 
@@ -393,7 +848,7 @@ func (t *Tracker) getTracker(u *url.URL) (TrackerProtocol, error) {
 	t.trackerMut.Lock()
 	defer t.trackerMut.Unlock()
 
-	log := t.logger.With("scheme", u.Scheme, "host", u.Host, "path", u.EscapedPath())
+	log := t.logger.With("url", redactURL(u))
 
 	var (
 		tracker TrackerProtocol
@@ -402,9 +857,9 @@ func (t *Tracker) getTracker(u *url.URL) (TrackerProtocol, error) {
 
 	switch u.Scheme {
 	case "http", "https":
-		tracker, err = NewHTTPTracker(u, log)
+		tracker, err = NewHTTPTracker(u, t.cfg, t.stats, log)
 	case "udp":
-		tracker, err = NewUDPTracker(u, log)
+		tracker, err = NewUDPTracker(u, t.stats, log)
 	default:
 		err = fmt.Errorf("tracker: unsupported scheme %q", u.Scheme)
 	}
@@ -413,7 +868,7 @@ func (t *Tracker) getTracker(u *url.URL) (TrackerProtocol, error) {
 	}
 
 	t.trackers[key] = tracker
-	t.logger.Debug("new tracker client cached", "url", key)
+	t.logger.Debug("new tracker client cached", "url", redactURL(u))
 
 	return tracker, nil
 }
@@ -441,11 +896,18 @@ func buildAnnounceURLs(announce string, announceList [][]string) ([][]*url.URL,
 	}
 
 	if len(tiers) == 0 {
-		return nil, errors.New("tracker: no vald announce urls found")
+		return nil, ErrNoAnnounceURLs
 	}
 	return tiers, nil
 }
 
+// IsAnnounceURL reports whether raw is a tracker URL the client can
+// announce to.
+func IsAnnounceURL(raw string) bool {
+	_, ok := parseTrackerURL(raw)
+	return ok
+}
+
 func parseTrackerURL(raw string) (*url.URL, bool) {
 	u, err := url.Parse(raw)
 	if err != nil {
@@ -472,6 +934,7 @@ func calculateBackoff(failures, maxShift int, maxAnnounceBackoff time.Duration)
 func getNextAnnounceInterval(
 	resp *AnnounceResponse,
 	userInterval, minInterval, defaultInterval time.Duration,
+	scale float64,
 ) time.Duration {
 	interval := resp.Interval
 
@@ -484,6 +947,7 @@ func getNextAnnounceInterval(
 		// fallback to default
 		interval = defaultInterval
 	}
+	interval = time.Duration(float64(interval) * scale)
 
 	if resp.MinInterval > interval {
 		interval = resp.MinInterval
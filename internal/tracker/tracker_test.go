@@ -0,0 +1,104 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prxssh/rabbit/pkg/clock"
+)
+
+func TestTracker_ReannounceRespectsMinInterval(t *testing.T) {
+	var announces atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		announces.Add(1)
+		_, _ = w.Write([]byte("d8:intervali1800e12:min intervali600e5:peers0:e"))
+	}))
+	defer srv.Close()
+
+	cfg := WithDefaultConfig()
+	cfg.MinReannounceInterval = 0
+	tr, err := NewTracker(srv.URL+"/announce", nil, &TrackerOpts{
+		Config:   cfg,
+		GetState: func() *AnnounceParams { return &AnnounceParams{} },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := tr.Reannounce(ctx, ""); err != nil {
+		t.Fatalf("Reannounce() error = %v", err)
+	}
+	if got := tr.TrackerStatuses()[0].MinInterval; got != 10*time.Minute {
+		t.Errorf("MinInterval = %v, want 10m", got)
+	}
+
+	// The tracker's min interval holds even with no MinReannounceInterval.
+	if err := tr.Reannounce(ctx, ""); !errors.Is(err, ErrReannounceTooSoon) {
+		t.Errorf("second Reannounce() error = %v, want ErrReannounceTooSoon", err)
+	}
+	if got := announces.Load(); got != 1 {
+		t.Errorf("tracker saw %d announces, want 1", got)
+	}
+}
+
+func TestTracker_WakeWaitsOutMinInterval(t *testing.T) {
+	var announces atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		announces.Add(1)
+		_, _ = w.Write([]byte("d8:intervali1800e12:min intervali600e5:peers0:e"))
+	}))
+	defer srv.Close()
+
+	fake := clock.NewFake(time.Now())
+	tr, err := NewTracker(srv.URL+"/announce", nil, &TrackerOpts{
+		Config:   WithDefaultConfig(),
+		GetState: func() *AnnounceParams { return &AnnounceParams{} },
+		Clock:    fake,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = tr.announceLoop(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitAnnounces := func(want int32) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for announces.Load() < want {
+			if time.Now().After(deadline) {
+				t.Fatalf("tracker saw %d announces, want %d", announces.Load(), want)
+			}
+			fake.Advance(0)
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitAnnounces(1)
+
+	// Woken 5 minutes in, the loop holds off until the 10 minute min
+	// interval is up, well before the regular 30 minute announce.
+	tr.Wake()
+	time.Sleep(50 * time.Millisecond)
+	fake.Advance(5 * time.Minute)
+	time.Sleep(50 * time.Millisecond)
+	if got := announces.Load(); got != 1 {
+		t.Fatalf("tracker saw %d announces before the min interval, want 1", got)
+	}
+
+	fake.Advance(5 * time.Minute)
+	waitAnnounces(2)
+}
@@ -0,0 +1,205 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// udpSocket is the one UDP socket every UDP tracker in the process
+// announces through. Requests are told apart by transaction ID: each
+// registers its ID in a table until answered, and a single read pump hands
+// every incoming packet to the request it names. A retransmission reuses
+// its request's ID, so a late answer to an earlier attempt still counts,
+// and any answer after the first is dropped.
+type udpSocket struct {
+	openOnce sync.Once
+	conn     *net.UDPConn
+	openErr  error
+	// log is the logger of the tracker that opened the socket, which the
+	// read pump reports to.
+	log *slog.Logger
+
+	mut     sync.Mutex
+	pending map[uint32]*udpTransaction
+
+	connMut sync.Mutex
+	connIDs map[netip.AddrPort]udpConnID
+}
+
+type udpTransaction struct {
+	to    netip.AddrPort
+	resp  chan []byte
+	stats *Stats
+}
+
+// udpConnID is a connection ID a tracker handed out, shared by every
+// torrent announcing to it.
+type udpConnID struct {
+	id      uint64
+	expires time.Time
+}
+
+var sharedUDP = newUDPSocket()
+
+func newUDPSocket() *udpSocket {
+	return &udpSocket{
+		pending: make(map[uint32]*udpTransaction),
+		connIDs: make(map[netip.AddrPort]udpConnID),
+	}
+}
+
+func (s *udpSocket) open(logger *slog.Logger) error {
+	s.openOnce.Do(func() {
+		s.log = logger.With("component", "udp socket")
+		s.conn, s.openErr = net.ListenUDP("udp", nil)
+		if s.openErr == nil {
+			go s.readPump()
+		}
+	})
+	return s.openErr
+}
+
+func (s *udpSocket) readPump() {
+	buf := make([]byte, maxUDPPacket)
+	for {
+		n, from, err := s.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.log.Debug("udp tracker read failed", "error", err)
+			continue
+		}
+		if n < 8 {
+			continue
+		}
+
+		txid := binary.BigEndian.Uint32(buf[4:8])
+		s.mut.Lock()
+		tx := s.pending[txid]
+		s.mut.Unlock()
+		if tx == nil || tx.to != unmapAddrPort(from) {
+			continue
+		}
+
+		tx.stats.BytesReceived.Add(uint64(n))
+		select {
+		case tx.resp <- append([]byte(nil), buf[:n]...):
+		default:
+		}
+	}
+}
+
+// roundTrip sends packet to a tracker and waits for the answer, resending
+// with growing timeouts as BEP 15 prescribes. It fills in the transaction
+// ID at packet[12:16], where every request carries it. A failed send is
+// retried like a lost one, since it is often transient, e.g. the network
+// going down for a moment.
+func (s *udpSocket) roundTrip(
+	ctx context.Context,
+	to netip.AddrPort,
+	packet []byte,
+	stats *Stats,
+	logger *slog.Logger,
+) ([]byte, error) {
+	if err := s.open(logger); err != nil {
+		return nil, err
+	}
+
+	tx := &udpTransaction{to: to, resp: make(chan []byte, 1), stats: stats}
+	txid, err := s.register(tx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.unregister(txid)
+	binary.BigEndian.PutUint32(packet[12:16], txid)
+
+	var sendErr error
+	for n := 0; n < maxRetries; n++ {
+		timeout, err := getTimeout(ctx, n)
+		if err != nil {
+			return nil, err
+		}
+
+		written, err := s.conn.WriteToUDPAddrPort(packet, to)
+		stats.BytesSent.Add(uint64(written))
+		if err != nil {
+			logger.Debug("udp tracker send failed", "attempt", n+1, "error", err)
+			sendErr = err
+		}
+
+		timer := time.NewTimer(timeout)
+		select {
+		case resp := <-tx.resp:
+			timer.Stop()
+			return resp, nil
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	if sendErr != nil {
+		return nil, fmt.Errorf("%w: %w", errAttemptsExhausted, sendErr)
+	}
+	return nil, errAttemptsExhausted
+}
+
+// register enters tx under a transaction ID no other request holds.
+func (s *udpSocket) register(tx *udpTransaction) (uint32, error) {
+	for {
+		txid, err := randU32()
+		if err != nil {
+			return 0, err
+		}
+
+		s.mut.Lock()
+		if _, taken := s.pending[txid]; !taken {
+			s.pending[txid] = tx
+			s.mut.Unlock()
+			return txid, nil
+		}
+		s.mut.Unlock()
+	}
+}
+
+func (s *udpSocket) unregister(txid uint32) {
+	s.mut.Lock()
+	delete(s.pending, txid)
+	s.mut.Unlock()
+}
+
+func (s *udpSocket) connID(to netip.AddrPort) (uint64, bool) {
+	s.connMut.Lock()
+	defer s.connMut.Unlock()
+
+	c, ok := s.connIDs[to]
+	if !ok || time.Now().After(c.expires) {
+		return 0, false
+	}
+	return c.id, true
+}
+
+func (s *udpSocket) setConnID(to netip.AddrPort, id uint64) {
+	s.connMut.Lock()
+	s.connIDs[to] = udpConnID{id: id, expires: time.Now().Add(connectionIDTTL)}
+	s.connMut.Unlock()
+}
+
+func (s *udpSocket) forgetConnID(to netip.AddrPort) {
+	s.connMut.Lock()
+	delete(s.connIDs, to)
+	s.connMut.Unlock()
+}
+
+func unmapAddrPort(ap netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
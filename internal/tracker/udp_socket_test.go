@@ -0,0 +1,202 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeUDPTracker answers BEP 15 connect, announce and scrape requests
+// on loopback. Before answering, respond may reorder or forge packets.
+type fakeUDPTracker struct {
+	conn    *net.UDPConn
+	connID  uint64
+	peers   []netip.AddrPort
+	respond func(req []byte, from netip.AddrPort, answer []byte)
+}
+
+func newFakeUDPTracker(t *testing.T) *fakeUDPTracker {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	ft := &fakeUDPTracker{conn: conn, connID: 0x1122334455667788}
+	ft.respond = func(_ []byte, from netip.AddrPort, answer []byte) {
+		_, _ = conn.WriteToUDPAddrPort(answer, from)
+	}
+	return ft
+}
+
+func (ft *fakeUDPTracker) addr() netip.AddrPort {
+	return ft.conn.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+// serve answers n requests.
+func (ft *fakeUDPTracker) serve(n int) {
+	buf := make([]byte, maxUDPPacket)
+	for range n {
+		size, from, err := ft.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+		req := append([]byte(nil), buf[:size]...)
+		ft.respond(req, from, ft.answer(req))
+	}
+}
+
+func (ft *fakeUDPTracker) answer(req []byte) []byte {
+	action := binary.BigEndian.Uint32(req[8:12])
+	txid := req[12:16]
+
+	switch action {
+	case actionConnect:
+		out := binary.BigEndian.AppendUint32(nil, actionConnect)
+		out = append(out, txid...)
+		return binary.BigEndian.AppendUint64(out, ft.connID)
+	case actionScrape:
+		out := binary.BigEndian.AppendUint32(nil, actionScrape)
+		out = append(out, txid...)
+		out = binary.BigEndian.AppendUint32(out, 5)  // seeders
+		out = binary.BigEndian.AppendUint32(out, 9)  // completed
+		return binary.BigEndian.AppendUint32(out, 3) // leechers
+	default:
+		out := binary.BigEndian.AppendUint32(nil, actionAnnounce)
+		out = append(out, txid...)
+		out = binary.BigEndian.AppendUint32(out, 1800) // interval
+		out = binary.BigEndian.AppendUint32(out, 3)    // leechers
+		out = binary.BigEndian.AppendUint32(out, 5)    // seeders
+		for _, p := range ft.peers {
+			ip := p.Addr().As4()
+			out = append(out, ip[:]...)
+			out = binary.BigEndian.AppendUint16(out, p.Port())
+		}
+		return out
+	}
+}
+
+func testUDPTracker(socket *udpSocket, addr netip.AddrPort) *UDPTracker {
+	return &UDPTracker{
+		logger: slog.Default(),
+		socket: socket,
+		addr:   addr,
+		key:    42,
+		stats:  &Stats{},
+	}
+}
+
+func TestUDPTracker_ConnectAndAnnounce(t *testing.T) {
+	ft := newFakeUDPTracker(t)
+	ft.peers = []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:6881")}
+	go ft.serve(2)
+
+	ut := testUDPTracker(newUDPSocket(), ft.addr())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := ut.Announce(ctx, &AnnounceParams{Event: EventStarted})
+	if err != nil {
+		t.Fatalf("Announce() error = %v", err)
+	}
+	if resp.Interval != 1800*time.Second || resp.Leechers != 3 || resp.Seeders != 5 {
+		t.Errorf("Announce() = %+v, want interval 30m, 3 leechers, 5 seeders", resp)
+	}
+	if len(resp.Peers) != 1 || resp.Peers[0] != ft.peers[0] {
+		t.Errorf("Peers = %v, want %v", resp.Peers, ft.peers)
+	}
+
+	if id, ok := ut.socket.connID(ft.addr()); !ok || id != ft.connID {
+		t.Errorf("connID() = %x, %v; want %x cached", id, ok, ft.connID)
+	}
+	if ut.stats.BytesSent.Load() != 16+98 {
+		t.Errorf("BytesSent = %d, want %d", ut.stats.BytesSent.Load(), 16+98)
+	}
+	if ut.stats.BytesReceived.Load() == 0 {
+		t.Error("BytesReceived = 0")
+	}
+}
+
+func TestUDPSocket_RoutesAnswersByTransaction(t *testing.T) {
+	ft := newFakeUDPTracker(t)
+
+	// Hold the first request's answer back until the second is in, so
+	// the answers cross.
+	var (
+		mut  sync.Mutex
+		held []func()
+	)
+	ft.respond = func(req []byte, from netip.AddrPort, answer []byte) {
+		send := func() { _, _ = ft.conn.WriteToUDPAddrPort(answer, from) }
+		mut.Lock()
+		defer mut.Unlock()
+		if len(held) == 0 {
+			held = append(held, send)
+			return
+		}
+		send()
+		held[0]()
+	}
+	go ft.serve(2)
+
+	socket := newUDPSocket()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := range 2 {
+		wg.Go(func() {
+			packet := make([]byte, 16)
+			binary.BigEndian.PutUint32(packet[8:12], actionConnect)
+
+			resp, err := socket.roundTrip(ctx, ft.addr(), packet, &Stats{}, slog.Default())
+			if err != nil {
+				t.Errorf("roundTrip(%d) error = %v", i, err)
+				return
+			}
+			if !bytes.Equal(resp[4:8], packet[12:16]) {
+				t.Errorf("roundTrip(%d) got transaction %x, want %x", i, resp[4:8], packet[12:16])
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func TestUDPSocket_IgnoresOtherSenders(t *testing.T) {
+	ft := newFakeUDPTracker(t)
+	forger, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer forger.Close()
+
+	ft.respond = func(req []byte, from netip.AddrPort, answer []byte) {
+		forged := append([]byte(nil), answer...)
+		binary.BigEndian.PutUint64(forged[8:16], 0xdead)
+		_, _ = forger.WriteToUDPAddrPort(forged, from)
+		// Give the forged packet a head start.
+		time.Sleep(20 * time.Millisecond)
+		_, _ = ft.conn.WriteToUDPAddrPort(answer, from)
+	}
+	go ft.serve(1)
+
+	ut := testUDPTracker(newUDPSocket(), ft.addr())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	id, err := ut.connect(ctx)
+	if err != nil {
+		t.Fatalf("connect() error = %v", err)
+	}
+	if id != ft.connID {
+		t.Errorf("connect() = %x, want %x from the tracker", id, ft.connID)
+	}
+}
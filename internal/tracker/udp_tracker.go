@@ -5,11 +5,10 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"errors"
-	"fmt"
 	"log/slog"
 	"net"
+	"net/netip"
 	"net/url"
-	"sync"
 	"time"
 )
 
@@ -29,33 +28,28 @@ const (
 )
 
 var (
-	errActionMismatch        = errors.New("action mismatch")
-	errTransactionIDMismatch = errors.New("transaction id mismatch")
-	errPacketTooShort        = errors.New("packet too short")
-	errAttemptsExhausted     = errors.New("tracker: exhausted all attempts")
+	errActionMismatch    = errors.New("action mismatch")
+	errPacketTooShort    = errors.New("packet too short")
+	errAttemptsExhausted = errors.New("tracker: exhausted all attempts")
 )
 
+// UDPTracker announces to a BEP 15 tracker over the process-wide UDP
+// socket, so any number of torrents can talk to it at once.
 type UDPTracker struct {
-	logger    *slog.Logger
-	mut       sync.Mutex
-	conn      *net.UDPConn
-	key       uint32
-	connID    uint64
-	connIDTTL time.Time
-	readBuf   []byte // reusable read buffer
+	logger *slog.Logger
+	socket *udpSocket
+	addr   netip.AddrPort
+	key    uint32
+	stats  *Stats
 }
 
-func NewUDPTracker(url *url.URL, logger *slog.Logger) (*UDPTracker, error) {
+func NewUDPTracker(url *url.URL, stats *Stats, logger *slog.Logger) (*UDPTracker, error) {
 	logger = logger.With("type", "udp")
 
 	addr, err := net.ResolveUDPAddr("udp", url.Host)
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.DialUDP("udp", nil, addr)
-	if err != nil {
-		return nil, err
-	}
 
 	key, err := randU32()
 	if err != nil {
@@ -63,10 +57,11 @@ func NewUDPTracker(url *url.URL, logger *slog.Logger) (*UDPTracker, error) {
 	}
 
 	return &UDPTracker{
-		conn:    conn,
-		key:     key,
-		logger:  logger,
-		readBuf: make([]byte, maxUDPPacket),
+		logger: logger,
+		socket: sharedUDP,
+		addr:   unmapAddrPort(addr.AddrPort()),
+		key:    key,
+		stats:  stats,
 	}, nil
 }
 
@@ -74,205 +69,147 @@ func (ut *UDPTracker) Announce(
 	ctx context.Context,
 	params *AnnounceParams,
 ) (*AnnounceResponse, error) {
-	ut.mut.Lock()
-	defer ut.mut.Unlock()
-
-	if time.Now().After(ut.connIDTTL) {
-		if err := ut.performConnect(ctx); err != nil {
-			return nil, err
-		}
+	connID, err := ut.connect(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	resp, err := ut.performAnnounce(ctx, params)
-	if err == nil {
-		return resp, nil
+	resp, err := ut.announce(ctx, connID, params)
+	if !errors.Is(err, errActionMismatch) {
+		return resp, err
 	}
 
-	if errors.Is(err, errActionMismatch) || errors.Is(err, errTransactionIDMismatch) {
-		ut.logger.Warn(
-			"announce failed, connection ID may be stale, reconnecting...",
-			"error", err,
-		)
-		ut.connIDTTL = time.Time{}
-
-		if err := ut.performConnect(ctx); err != nil {
-			return nil, err
-		}
+	ut.logger.Warn(
+		"announce failed, connection ID may be stale, reconnecting...",
+		"error", err,
+	)
+	ut.socket.forgetConnID(ut.addr)
 
-		return ut.performAnnounce(ctx, params)
+	if connID, err = ut.connect(ctx); err != nil {
+		return nil, err
 	}
-
-	return nil, err
+	return ut.announce(ctx, connID, params)
 }
 
-func (ut *UDPTracker) performConnect(ctx context.Context) error {
-	for n := 0; n < maxRetries; n++ {
-		if err := ctx.Err(); err != nil {
-			return err
-		}
-
-		timeout, err := getTimeout(ctx, n)
-		if err != nil {
-			return err
-		}
-		_ = ut.conn.SetDeadline(time.Now().Add(timeout))
-
-		transactionID, err := randU32()
-		if err != nil {
-			ut.logger.Warn("udp connect txid rand error", "error", err.Error())
-			continue
-		}
-
-		if err := ut.sendConnectPacket(transactionID); err != nil {
-			ut.logger.Warn("udp connect send error", "error", err.Error(), "retry", n)
-			continue
-		}
-
-		connID, err := ut.readConnectPacket(transactionID)
-		if err != nil {
-			ut.logger.Warn("udp connect read error", "error", err.Error(), "retry", n)
-			continue
-		}
+// connect returns a connection ID for the tracker, asking for a new one
+// when none is cached or it has expired.
+func (ut *UDPTracker) connect(ctx context.Context) (uint64, error) {
+	if id, ok := ut.socket.connID(ut.addr); ok {
+		return id, nil
+	}
 
-		ut.connID = connID
-		ut.connIDTTL = time.Now().Add(connectionIDTTL)
-		ut.logger.Debug("udp connect success", "connID", connID)
+	var packet [16]byte
+	binary.BigEndian.PutUint64(packet[0:8], protocolID)
+	binary.BigEndian.PutUint32(packet[8:12], actionConnect)
 
-		return nil
+	resp, err := ut.socket.roundTrip(ctx, ut.addr, packet[:], ut.stats, ut.logger)
+	if err != nil {
+		return 0, err
+	}
+	connID, err := parseUDPConnectResponse(resp)
+	if err != nil {
+		return 0, err
 	}
 
-	return errAttemptsExhausted
+	ut.socket.setConnID(ut.addr, connID)
+	ut.logger.Debug("udp connect success", "connID", connID)
+	return connID, nil
 }
 
-func (ut *UDPTracker) performAnnounce(
+func (ut *UDPTracker) announce(
 	ctx context.Context,
+	connID uint64,
 	params *AnnounceParams,
 ) (*AnnounceResponse, error) {
-	for n := 0; n < maxRetries; n++ {
-		if err := ctx.Err(); err != nil {
-			return nil, err
-		}
-
-		timeout, err := getTimeout(ctx, n)
-		if err != nil {
-			return nil, err
-		}
-		_ = ut.conn.SetDeadline(time.Now().Add(timeout))
+	packet := ut.announcePacket(connID, params)
 
-		transactionID, err := randU32()
-		if err != nil {
-			ut.logger.Warn("udp announce txid rand error", "error", err.Error())
-			continue
-		}
-
-		if err := ut.sendAnnouncePacket(transactionID, params); err != nil {
-			ut.logger.Warn("udp announce send error", "error", err.Error(), "retry", n)
-			continue
-		}
-
-		resp, err := ut.readAnnouncePacket(transactionID)
-		if err != nil {
-			if errors.Is(err, errActionMismatch) ||
-				errors.Is(err, errTransactionIDMismatch) {
-				ut.logger.Warn(
-					"udp announce failed, connection ID stale",
-					"error", err.Error(),
-				)
-				return nil, err
-			}
-
-			continue
-		}
-
-		return resp, nil
+	resp, err := ut.socket.roundTrip(ctx, ut.addr, packet, ut.stats, ut.logger)
+	if err != nil {
+		return nil, err
 	}
-
-	return nil, errAttemptsExhausted
-}
-
-func (ut *UDPTracker) sendConnectPacket(transactionID uint32) error {
-	var packet [16]byte
-
-	binary.BigEndian.PutUint64(packet[0:8], protocolID)
-	binary.BigEndian.PutUint32(packet[8:12], actionConnect)
-	binary.BigEndian.PutUint32(packet[12:16], transactionID)
-	_, err := ut.conn.Write(packet[:])
-
-	return err
+	return parseUDPAnnounceResponse(resp)
 }
 
-func (ut *UDPTracker) readConnectPacket(transactionID uint32) (uint64, error) {
-	var packet [16]byte
-
-	nread, err := ut.conn.Read(packet[:])
-	if err != nil {
-		return 0, err
-	}
-	if nread < 16 {
+func parseUDPConnectResponse(packet []byte) (uint64, error) {
+	if len(packet) < 8 {
 		return 0, errPacketTooShort
 	}
 
 	action := binary.BigEndian.Uint32(packet[0:4])
 	if action == actionError {
-		return 0, fmt.Errorf("tracker error: %s", string(packet[8:nread]))
+		return 0, &FailureError{Reason: string(packet[8:])}
 	}
 	if action != actionConnect {
 		return 0, errActionMismatch
 	}
-
-	receivedTransactionID := binary.BigEndian.Uint32(packet[4:8])
-	if receivedTransactionID != transactionID {
-		return 0, errTransactionIDMismatch
+	if len(packet) < 16 {
+		return 0, errPacketTooShort
 	}
 
 	return binary.BigEndian.Uint64(packet[8:16]), nil
 }
 
-func (ut *UDPTracker) sendAnnouncePacket(transactionID uint32, params *AnnounceParams) error {
-	var packet [98]byte
+// udpEvent is the event's code in a UDP announce, which numbers them
+// differently and has none for paused.
+func udpEvent(e Event) uint32 {
+	switch e {
+	case EventCompleted:
+		return 1
+	case EventStarted:
+		return 2
+	case EventStopped:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// announcePacket builds an announce request, leaving the transaction ID
+// for roundTrip to fill in.
+func (ut *UDPTracker) announcePacket(connID uint64, params *AnnounceParams) []byte {
+	packet := make([]byte, 98)
 
-	binary.BigEndian.PutUint64(packet[0:8], ut.connID)
+	binary.BigEndian.PutUint64(packet[0:8], connID)
 	binary.BigEndian.PutUint32(packet[8:12], actionAnnounce)
-	binary.BigEndian.PutUint32(packet[12:16], transactionID)
 	copy(packet[16:36], params.InfoHash[:])
 	copy(packet[36:56], params.PeerID[:])
 	binary.BigEndian.PutUint64(packet[56:64], params.Downloaded)
 	binary.BigEndian.PutUint64(packet[64:72], params.Left)
 	binary.BigEndian.PutUint64(packet[72:80], params.Uploaded)
-	binary.BigEndian.PutUint32(packet[80:84], uint32(params.Event))
-	binary.BigEndian.PutUint32(packet[84:88], 0)
+	binary.BigEndian.PutUint32(packet[80:84], udpEvent(params.Event))
+	copy(packet[84:88], announceIPv4(params.IP))
 	binary.BigEndian.PutUint32(packet[88:92], ut.key)
 	binary.BigEndian.PutUint32(packet[92:96], params.numWant)
 	binary.BigEndian.PutUint16(packet[96:98], params.port)
 
-	_, err := ut.conn.Write(packet[:])
-	return err
+	return packet
 }
 
-func (ut *UDPTracker) readAnnouncePacket(
-	transactionID uint32,
-) (*AnnounceResponse, error) {
-	nread, err := ut.conn.Read(ut.readBuf)
-	if err != nil {
-		return nil, err
+// announceIPv4 returns the IP field of a UDP announce: ip if it is an IPv4
+// address, otherwise zero so the tracker uses the packet's source.
+func announceIPv4(ip string) []byte {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !addr.Unmap().Is4() {
+		return make([]byte, 4)
 	}
+	b := addr.Unmap().As4()
+	return b[:]
+}
 
-	packet := ut.readBuf[:nread]
-	if nread < 20 {
+func parseUDPAnnounceResponse(packet []byte) (*AnnounceResponse, error) {
+	if len(packet) < 8 {
 		return nil, errPacketTooShort
 	}
 
 	action := binary.BigEndian.Uint32(packet[0:4])
 	if action == actionError {
-		return nil, fmt.Errorf("tracker error: %s", string(packet[8:nread]))
+		return nil, &FailureError{Reason: string(packet[8:])}
 	}
 	if action != actionAnnounce {
 		return nil, errActionMismatch
 	}
-
-	receivedTransactionID := binary.BigEndian.Uint32(packet[4:8])
-	if receivedTransactionID != transactionID {
-		return nil, errTransactionIDMismatch
+	if len(packet) < 20 {
+		return nil, errPacketTooShort
 	}
 
 	interval := binary.BigEndian.Uint32(packet[8:12])
@@ -0,0 +1,187 @@
+package ui
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prxssh/rabbit/internal/torrent"
+)
+
+// autoManageReason is the pause reason of completed torrents waiting for a
+// seeding slot.
+const autoManageReason = "queued"
+
+// autoManageInterval is how often the seeding limit is enforced between
+// rotations, so newly completed torrents don't exceed it for long.
+const autoManageInterval = time.Minute
+
+// autoManageScrapeTimeout bounds the scrapes of queued torrents before
+// slots are handed out.
+const autoManageScrapeTimeout = 30 * time.Second
+
+// seedCandidate is a completed torrent auto-management may start or stop.
+// known is unset when there are no current swarm counts for it: a queued
+// torrent has stopped announcing, so its own are stale, and its trackers
+// couldn't be scraped.
+type seedCandidate struct {
+	t        *torrent.Torrent
+	active   bool
+	known    bool
+	seeders  int64
+	leechers int64
+}
+
+// autoManageLoop keeps at most MaxActiveSeeds completed torrents seeding
+// and rotates which ones by swarm need.
+func (c *Client) autoManageLoop(ctx context.Context) {
+	if !c.cfg.AutoManageSeeds || c.cfg.MaxActiveSeeds <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(autoManageInterval)
+	defer ticker.Stop()
+
+	lastRotation := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			rotate := now.Sub(lastRotation) >= c.cfg.SeedRotationInterval
+			if rotate {
+				lastRotation = now
+			}
+			c.manageSeeds(rotate)
+		}
+	}
+}
+
+// manageSeeds picks the torrents that should be seeding, starting and
+// pausing others to match. Without rotate only the limit is enforced:
+// seeding torrents keep their slots while there are enough, and free
+// slots go to the queued torrents most in need.
+func (c *Client) manageSeeds(rotate bool) {
+	if c.policyPauseReason() != "" {
+		return
+	}
+
+	candidates := c.seedCandidates()
+	queued := 0
+	for _, sc := range candidates {
+		if !sc.active {
+			queued++
+		}
+	}
+	// Queued torrents compete for slots on a rotation, or when there are
+	// fewer free slots than of them. Only then are their counts needed.
+	free := c.cfg.MaxActiveSeeds - (len(candidates) - queued)
+	if queued > 0 && (rotate || (free > 0 && free < queued)) {
+		c.scrapeQueued(candidates)
+	}
+
+	slices.SortStableFunc(candidates, func(a, b seedCandidate) int {
+		if !rotate && a.active != b.active {
+			if a.active {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Or(
+			cmp.Compare(b.t.Priority(), a.t.Priority()),
+			// Without counts a torrent can't be said to be in need.
+			compareBool(b.known, a.known),
+			cmp.Compare(a.seeders, b.seeders),
+			cmp.Compare(b.leechers, a.leechers),
+		)
+	})
+
+	limit := min(c.cfg.MaxActiveSeeds, len(candidates))
+	for _, sc := range candidates[limit:] {
+		if !sc.active {
+			continue
+		}
+		if err := sc.t.PauseFor(autoManageReason); err != nil {
+			c.log.Warn("auto-manage pause failed", "name", sc.t.Metainfo.Info.Name, "error", err)
+		}
+	}
+	for _, sc := range candidates[:limit] {
+		if sc.active {
+			continue
+		}
+		if err := sc.t.Resume(c.ctx); err != nil {
+			c.log.Warn("auto-manage resume failed", "name", sc.t.Metainfo.Info.Name, "error", err)
+		}
+	}
+}
+
+// seedCandidates returns the completed torrents seeding or queued for a
+// seeding slot. Torrents the user or a power policy paused are not
+// candidates.
+func (c *Client) seedCandidates() []seedCandidate {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var out []seedCandidate
+	for _, t := range c.torrents {
+		var active bool
+		switch s, _ := t.State(); {
+		case s == torrent.StateSeeding:
+			active = true
+		case s == torrent.StatePaused && t.PauseReason() == autoManageReason:
+		default:
+			continue
+		}
+		if !t.Completed() {
+			continue
+		}
+
+		sc := seedCandidate{t: t, active: active}
+		if active {
+			stats := t.GetStats()
+			sc.known = !stats.LastSuccess.IsZero()
+			sc.seeders, sc.leechers = stats.CurrentSeeders, stats.CurrentLeechers
+		}
+		out = append(out, sc)
+	}
+	return out
+}
+
+// scrapeQueued fills in the swarm counts of the queued candidates from
+// their trackers, all at once.
+func (c *Client) scrapeQueued(candidates []seedCandidate) {
+	ctx, cancel := context.WithTimeout(c.ctx, autoManageScrapeTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := range candidates {
+		sc := &candidates[i]
+		if sc.active {
+			continue
+		}
+		wg.Go(func() {
+			resp, err := sc.t.Scrape(ctx)
+			if err != nil {
+				c.log.Debug("auto-manage scrape failed", "name", sc.t.Metainfo.Info.Name, "error", err)
+				return
+			}
+			sc.known = true
+			sc.seeders, sc.leechers = resp.Seeders, resp.Leechers
+		})
+	}
+	wg.Wait()
+}
+
+// compareBool orders false before true.
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
@@ -0,0 +1,172 @@
+package ui
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/prxssh/rabbit/internal/torrent"
+)
+
+// EventTorrentsChanged is emitted once per batch operation with a
+// BatchResult payload.
+const EventTorrentsChanged = "torrents:changed"
+
+type BatchAction string
+
+const (
+	BatchPause             BatchAction = "pause"
+	BatchResume            BatchAction = "resume"
+	BatchRemove            BatchAction = "remove"
+	BatchSetLabel          BatchAction = "setLabel"
+	BatchSetPriority       BatchAction = "setPriority"
+	BatchSetDownloadPaused BatchAction = "setDownloadPaused"
+)
+
+// BatchResult reports which torrents a batch operation applied to and why
+// the rest failed, keyed by hex info hash. Failures carry the same codes
+// as errors returned from the other bindings.
+type BatchResult struct {
+	Action    BatchAction       `json:"action"`
+	Succeeded []string          `json:"succeeded"`
+	Failed    map[string]*Error `json:"failed"`
+}
+
+func (c *Client) PauseTorrents(infoHashes []string) *BatchResult {
+	return c.batch(BatchPause, infoHashes, false, func(t *torrent.Torrent) error {
+		return t.Pause()
+	})
+}
+
+func (c *Client) ResumeTorrents(infoHashes []string) *BatchResult {
+	return c.batch(BatchResume, infoHashes, false, func(t *torrent.Torrent) error {
+		if !c.quotaFitsLocked(t.SavePath(), t.Left()) {
+			return ErrQuotaExceeded
+		}
+		return t.Resume(c.ctx)
+	})
+}
+
+// RemoveTorrents drops the torrents from the client, deleting their files
+// too when deleteData is set. Torrents leave the list under the lock, but
+// shutting them down and deleting data happens after it is released.
+func (c *Client) RemoveTorrents(infoHashes []string, deleteData bool) *BatchResult {
+	removed := make(map[string]*torrent.Torrent, len(infoHashes))
+	res := c.apply(BatchRemove, infoHashes, true, func(t *torrent.Torrent) error {
+		delete(c.torrents, t.Metainfo.InfoHash)
+		removed[hex.EncodeToString(t.Metainfo.InfoHash[:])] = t
+		return nil
+	})
+
+	succeeded := res.Succeeded[:0]
+	for _, h := range res.Succeeded {
+		t := removed[h]
+		if err := t.Remove(deleteData); err != nil {
+			res.Failed[h] = toError(err)
+			continue
+		}
+		succeeded = append(succeeded, h)
+	}
+	res.Succeeded = succeeded
+
+	c.emitBatch(res)
+	return res
+}
+
+func (c *Client) SetTorrentsLabel(infoHashes []string, label string) *BatchResult {
+	return c.batch(BatchSetLabel, infoHashes, false, func(t *torrent.Torrent) error {
+		t.SetLabel(label)
+		return nil
+	})
+}
+
+// SetTorrentsPriority ranks the torrents against the rest for the check
+// queue, bandwidth sharing and announce frequency.
+func (c *Client) SetTorrentsPriority(infoHashes []string, prio torrent.Priority) *BatchResult {
+	return c.batch(BatchSetPriority, infoHashes, false, func(t *torrent.Torrent) error {
+		return t.SetPriority(prio)
+	})
+}
+
+// SetTorrentsDownloadPaused stops or resumes downloading for the torrents,
+// which keep seeding what they have meanwhile.
+func (c *Client) SetTorrentsDownloadPaused(infoHashes []string, paused bool) *BatchResult {
+	return c.batch(BatchSetDownloadPaused, infoHashes, false, func(t *torrent.Torrent) error {
+		t.SetDownloadPaused(paused)
+		return nil
+	})
+}
+
+// batch applies fn to every listed torrent under a single acquisition of
+// the client lock and emits one change event for the whole set. write
+// takes the lock exclusively, for operations that change c.torrents.
+func (c *Client) batch(
+	action BatchAction,
+	infoHashes []string,
+	write bool,
+	fn func(*torrent.Torrent) error,
+) *BatchResult {
+	res := c.apply(action, infoHashes, write, fn)
+	c.emitBatch(res)
+	return res
+}
+
+// apply is batch without the change event.
+func (c *Client) apply(
+	action BatchAction,
+	infoHashes []string,
+	write bool,
+	fn func(*torrent.Torrent) error,
+) *BatchResult {
+	res := &BatchResult{
+		Action:    action,
+		Succeeded: make([]string, 0, len(infoHashes)),
+		Failed:    make(map[string]*Error),
+	}
+
+	if write {
+		c.mu.Lock()
+	} else {
+		c.mu.RLock()
+	}
+
+	for _, h := range infoHashes {
+		var infoHash [sha1.Size]byte
+
+		b, err := hex.DecodeString(h)
+		if err != nil || len(b) != sha1.Size {
+			res.Failed[h] = toError(fmt.Errorf("%w %q", ErrInvalidInfoHash, h))
+			continue
+		}
+		copy(infoHash[:], b)
+
+		t, ok := c.torrents[infoHash]
+		if !ok {
+			res.Failed[h] = ErrTorrentNotFound
+			continue
+		}
+
+		if err := fn(t); err != nil {
+			res.Failed[h] = toError(err)
+			continue
+		}
+		res.Succeeded = append(res.Succeeded, h)
+	}
+
+	if write {
+		c.mu.Unlock()
+	} else {
+		c.mu.RUnlock()
+	}
+
+	return res
+}
+
+func (c *Client) emitBatch(res *BatchResult) {
+	c.log.Debug("batch operation",
+		"action", res.Action,
+		"succeeded", len(res.Succeeded),
+		"failed", len(res.Failed),
+	)
+	c.emit(EventTorrentsChanged, res)
+}
@@ -0,0 +1,102 @@
+package ui
+
+import (
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"strings"
+
+	"github.com/prxssh/rabbit/internal/torrent"
+)
+
+// ContentInfo is what download managers like Sonarr and Radarr poll for:
+// where a torrent's content is and whether it's done.
+type ContentInfo struct {
+	InfoHash string `json:"infoHash"`
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	// SavePath is the directory the content is saved under.
+	SavePath string `json:"savePath"`
+	// ContentPath is the root folder, or the file for single-file
+	// torrents. It follows renames.
+	ContentPath string `json:"contentPath"`
+	// Completed is set once every piece is verified, and not before.
+	Completed bool          `json:"completed"`
+	Progress  float64       `json:"progress"`
+	State     torrent.State `json:"state"`
+}
+
+// GetCategories returns the configured categories and their save paths.
+func (c *Client) GetCategories() map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return maps.Clone(c.cfg.Categories)
+}
+
+// SetCategory adds or updates a category. Torrents already in it keep
+// their current save path.
+func (c *Client) SetCategory(name, savePath string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("%w: name is empty", ErrInvalidCategory)
+	}
+	if savePath != "" {
+		if !filepath.IsAbs(savePath) {
+			return fmt.Errorf("%w: category save path %q", ErrInvalidPath, savePath)
+		}
+		savePath = filepath.Clean(savePath)
+	}
+
+	c.mu.Lock()
+	if c.cfg.Categories == nil {
+		c.cfg.Categories = make(map[string]string)
+	}
+	c.cfg.Categories[name] = savePath
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Client) RemoveCategory(name string) {
+	c.mu.Lock()
+	delete(c.cfg.Categories, name)
+	c.mu.Unlock()
+}
+
+// AddTorrentToCategory adds a torrent saved under the category's path and
+// labelled with the category name.
+func (c *Client) AddTorrentToCategory(data []byte, category string) (*torrent.Torrent, error) {
+	c.mu.RLock()
+	savePath, ok := c.cfg.Categories[category]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownCategory, category)
+	}
+
+	return c.AddTorrent(data, nil, &AddTorrentOpts{
+		DownloadDir: savePath,
+		Label:       category,
+	})
+}
+
+// GetTorrentContent reports where a torrent's content lives and whether it
+// has completed, keyed by hex info hash.
+func (c *Client) GetTorrentContent(infoHashHex string) (*ContentInfo, error) {
+	t, err := c.lookupTorrent(infoHashHex)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := t.GetStats()
+	return &ContentInfo{
+		InfoHash:    hex.EncodeToString(t.Metainfo.InfoHash[:]),
+		Name:        t.Metainfo.Info.Name,
+		Category:    t.Label(),
+		SavePath:    t.SavePath(),
+		ContentPath: t.ContentPath(),
+		Completed:   stats.Completed,
+		Progress:    stats.Progress,
+		State:       stats.State,
+	}, nil
+}
@@ -0,0 +1,147 @@
+package ui
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prxssh/rabbit/internal/geo"
+	"github.com/prxssh/rabbit/internal/peer"
+	"github.com/prxssh/rabbit/internal/power"
+)
+
+// Config holds client-wide settings shared by every torrent.
+type Config struct {
+	Listen *peer.ListenConfig
+
+	// GeoIP configures the optional peer location lookups.
+	GeoIP *geo.Config
+
+	// Power pauses or rate-caps torrents while on battery or a metered
+	// network, and keeps the machine awake while they are active.
+	Power *power.Config
+
+	// DataDir holds the client's persistent state.
+	DataDir string
+
+	// IndexMaxAge is how long a torrent stays in the local search index
+	// after it was last seen.
+	IndexMaxAge time.Duration
+
+	// PeerCacheTTL is how long a known-good peer is remembered after it
+	// disconnects.
+	PeerCacheTTL time.Duration
+
+	// PeerCacheSize caps the number of remembered peers per torrent.
+	PeerCacheSize int
+
+	// DownloadRateLimit and UploadRateLimit cap client-wide throughput in
+	// bytes/sec. 0 is unlimited.
+	DownloadRateLimit uint64
+	UploadRateLimit   uint64
+
+	// RateLimitIncludesOverhead makes the rate limits apply to protocol
+	// overhead as well as piece data.
+	RateLimitIncludesOverhead bool
+
+	// ProfilingAddr, when set, serves net/http/pprof on this address, e.g.
+	// "127.0.0.1:6060". Keep it on loopback: the endpoints are unauthenticated.
+	ProfilingAddr string
+
+	// GlobalUploadSlots caps regular unchoke slots across all torrents,
+	// on top of each torrent's own UploadSlots. 0 is unlimited.
+	GlobalUploadSlots int
+
+	// ConcurrentChecks is how many torrents may hash-check existing data
+	// at once; the rest wait their turn. 0 is unlimited.
+	ConcurrentChecks int
+
+	// CheckReadRateLimit caps disk reads of those checks, in bytes/sec,
+	// summed over all of them. 0 is unlimited.
+	CheckReadRateLimit uint64
+
+	// DiskReadWorkers is how many disk reads for uploads may be in flight
+	// across all torrents, and DiskReadsPerFile how many of them may hit
+	// the same file. 0 per file is unlimited.
+	DiskReadWorkers  int
+	DiskReadsPerFile int
+
+	// MaxOpenFiles caps the data files held open across all torrents;
+	// the least recently used is closed to open another. 0 is unlimited.
+	MaxOpenFiles int
+
+	// ExternalIP is reported to trackers as our address for torrents that
+	// don't set their own. Empty lets trackers use the address we connect
+	// from.
+	ExternalIP string
+
+	// PeerIDPerTorrent gives each torrent its own random peer ID instead
+	// of the session's, so trackers and peers can't tell which torrents
+	// are ours.
+	PeerIDPerTorrent bool
+
+	// AutoManageSeeds limits completed torrents to MaxActiveSeeds seeding
+	// at once, pausing the rest. Every SeedRotationInterval the active set
+	// is picked again: higher priority torrents first, then those whose
+	// swarms have the fewest seeds.
+	AutoManageSeeds      bool
+	MaxActiveSeeds       int
+	SeedRotationInterval time.Duration
+
+	// DirQuotas caps, in bytes, how much the torrents saved under each
+	// directory may fill, subdirectories included. Torrents that would
+	// take a directory over its quota wait paused.
+	DirQuotas map[string]uint64
+
+	// Categories maps a category name to the directory its torrents are
+	// saved under. An empty path uses the default download directory.
+	Categories map[string]string
+
+	// AutoAddTrackers appends the known trackers list, kept in DataDir, to
+	// every public torrent added. Private torrents never get them.
+	AutoAddTrackers bool
+
+	// NetworkCheckInterval is how often the network is checked for
+	// changes and the machine for having slept; either makes running
+	// torrents reannounce and redial. 0 never checks.
+	NetworkCheckInterval time.Duration
+}
+
+func WithDefaultConfig() *Config {
+	return &Config{
+		Listen:        peer.WithDefaultListenConfig(),
+		GeoIP:         geo.WithDefaultConfig(),
+		Power:         power.WithDefaultConfig(),
+		DataDir:       defaultDataDir(),
+		IndexMaxAge:   90 * 24 * time.Hour,
+		PeerCacheTTL:  6 * time.Hour,
+		PeerCacheSize: 200,
+
+		DownloadRateLimit:         0,
+		UploadRateLimit:           0,
+		RateLimitIncludesOverhead: false,
+		GlobalUploadSlots:         0,
+		ConcurrentChecks:          1,
+		CheckReadRateLimit:        0,
+		DiskReadWorkers:           4,
+		DiskReadsPerFile:          2,
+		MaxOpenFiles:              256,
+
+		AutoManageSeeds:      false,
+		MaxActiveSeeds:       8,
+		SeedRotationInterval: time.Hour,
+
+		DirQuotas:  map[string]uint64{},
+		Categories: map[string]string{},
+
+		NetworkCheckInterval: 5 * time.Second,
+	}
+}
+
+func defaultDataDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return filepath.Join(".", ".rabbit")
+	}
+	return filepath.Join(dir, "rabbit")
+}
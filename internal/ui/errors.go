@@ -0,0 +1,86 @@
+package ui
+
+import (
+	"errors"
+
+	"github.com/prxssh/rabbit/internal/storage"
+	"github.com/prxssh/rabbit/internal/tracker"
+)
+
+// ErrorCode tells the frontend what went wrong without it having to parse
+// messages.
+type ErrorCode string
+
+const (
+	CodeInternal           ErrorCode = "internal"
+	CodeTorrentNotFound    ErrorCode = "torrentNotFound"
+	CodeInvalidInfoHash    ErrorCode = "invalidInfoHash"
+	CodeInvalidTorrent     ErrorCode = "invalidTorrent"
+	CodeDuplicateTorrent   ErrorCode = "duplicateTorrent"
+	CodeInvalidMagnet      ErrorCode = "invalidMagnet"
+	CodeDiskFull           ErrorCode = "diskFull"
+	CodeTrackerUnreachable ErrorCode = "trackerUnreachable"
+	CodeQuotaExceeded      ErrorCode = "quotaExceeded"
+	CodeTooSoon            ErrorCode = "tooSoon"
+	CodeUnknownCategory    ErrorCode = "unknownCategory"
+	CodeInvalidCategory    ErrorCode = "invalidCategory"
+	CodeInvalidPath        ErrorCode = "invalidPath"
+	CodeInvalidTracker     ErrorCode = "invalidTracker"
+)
+
+// Error is an error the client API returns deliberately. The sentinels
+// below are Errors; callers wrap them with %w to add detail, and
+// FormatError finds the code again.
+type Error struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+var (
+	ErrTorrentNotFound    = &Error{CodeTorrentNotFound, "torrent not found"}
+	ErrInvalidInfoHash    = &Error{CodeInvalidInfoHash, "invalid info hash"}
+	ErrInvalidTorrent     = &Error{CodeInvalidTorrent, "invalid torrent file"}
+	ErrDuplicateTorrent   = &Error{CodeDuplicateTorrent, "torrent already added"}
+	ErrInvalidMagnet      = &Error{CodeInvalidMagnet, "invalid magnet link"}
+	ErrDiskFull           = &Error{CodeDiskFull, "not enough disk space"}
+	ErrTrackerUnreachable = &Error{CodeTrackerUnreachable, "no tracker reachable"}
+	ErrQuotaExceeded      = &Error{CodeQuotaExceeded, "download directory quota exceeded"}
+	ErrUnknownCategory    = &Error{CodeUnknownCategory, "unknown category"}
+	ErrInvalidCategory    = &Error{CodeInvalidCategory, "invalid category"}
+	// ErrInvalidPath is for directories that must be absolute and aren't.
+	ErrInvalidPath    = &Error{CodeInvalidPath, "path is not absolute"}
+	ErrInvalidTracker = &Error{CodeInvalidTracker, "not a tracker URL"}
+)
+
+// FormatError is the Wails error formatter: every error a binding returns
+// reaches the frontend as an Error, with CodeInternal for those the API
+// has no code for.
+func FormatError(err error) any {
+	return toError(err)
+}
+
+// toError is FormatError for results that carry errors inside them, like
+// BatchResult.
+func toError(err error) *Error {
+	return &Error{Code: errorCode(err), Message: err.Error()}
+}
+
+func errorCode(err error) ErrorCode {
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		return e.Code
+	case storage.IsDiskFull(err):
+		return CodeDiskFull
+	case errors.Is(err, tracker.ErrUnreachable):
+		return CodeTrackerUnreachable
+	case errors.Is(err, tracker.ErrReannounceTooSoon):
+		return CodeTooSoon
+	default:
+		return CodeInternal
+	}
+}
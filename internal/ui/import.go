@@ -0,0 +1,73 @@
+package ui
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+
+	"github.com/prxssh/rabbit/internal/migrate"
+)
+
+// ImportResult reports which torrents were imported from another client
+// and why the rest were not, keyed by info hash or file name.
+type ImportResult struct {
+	Imported []string          `json:"imported"`
+	Skipped  map[string]string `json:"skipped"`
+}
+
+// ImportQBittorrent recreates the torrents of a qBittorrent BT_backup
+// directory with their save paths, categories and verified pieces.
+func (c *Client) ImportQBittorrent(dir string) (*ImportResult, error) {
+	res, err := migrate.QBittorrent(dir)
+	if err != nil {
+		return nil, err
+	}
+	return c.importTorrents(res), nil
+}
+
+// ImportDeluge recreates the torrents of a Deluge state directory with
+// their save paths and verified pieces.
+func (c *Client) ImportDeluge(dir string) (*ImportResult, error) {
+	res, err := migrate.Deluge(dir)
+	if err != nil {
+		return nil, err
+	}
+	return c.importTorrents(res), nil
+}
+
+func (c *Client) importTorrents(res *migrate.Result) *ImportResult {
+	out := &ImportResult{
+		Imported: make([]string, 0, len(res.Torrents)),
+		Skipped:  res.Skipped,
+	}
+
+	for _, mt := range res.Torrents {
+		var infoHash [sha1.Size]byte
+		if b, err := hex.DecodeString(mt.InfoHash); err == nil {
+			copy(infoHash[:], b)
+		}
+
+		c.mu.RLock()
+		_, exists := c.torrents[infoHash]
+		c.mu.RUnlock()
+		if exists {
+			out.Skipped[mt.InfoHash] = "already added"
+			continue
+		}
+
+		_, err := c.addTorrent(mt.Data, nil, &AddTorrentOpts{
+			DownloadDir: mt.SavePath,
+			Label:       mt.Category,
+		}, mt.Have)
+		if err != nil {
+			out.Skipped[mt.InfoHash] = err.Error()
+			continue
+		}
+		out.Imported = append(out.Imported, mt.InfoHash)
+	}
+
+	c.log.Info("imported torrents",
+		"imported", len(out.Imported),
+		"skipped", len(out.Skipped),
+	)
+	return out
+}
@@ -0,0 +1,146 @@
+package ui
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+// EventNetworkChange carries a NetworkChange whenever the network comes
+// back, moves or the machine wakes from sleep.
+const EventNetworkChange = "network:change"
+
+// networkChangeSettle is how long the addresses must hold still before a
+// change is acted on, so a Wi-Fi switch going down and up counts once.
+const networkChangeSettle = 3 * time.Second
+
+// NetworkChange describes why the client reconnected.
+type NetworkChange struct {
+	Online bool `json:"online"`
+	// Resumed is set when the machine woke from sleep.
+	Resumed bool `json:"resumed"`
+	// Addresses lists the interface addresses, IPv6 ones by their /64.
+	Addresses []string `json:"addresses"`
+}
+
+// networkLoop watches the machine's interface addresses and the wall
+// clock. When the addresses change for good, or the clock jumps because
+// the machine slept, every running torrent announces right away and
+// redials peers that had failed, so downloads recover in seconds rather
+// than at the next scheduled announce.
+func (c *Client) networkLoop(ctx context.Context) {
+	interval := c.cfg.NetworkCheckInterval
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	addrs := interfaceAddrs()
+	var (
+		pending   []string
+		changedAt time.Time
+		resumed   bool
+	)
+	last := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Round(0) drops the monotonic reading, which stops while the
+		// machine sleeps; the wall clock doesn't.
+		now := time.Now()
+		if now.Round(0).Sub(last.Round(0)) > 3*interval {
+			resumed = true
+		}
+		last = now
+
+		if cur := interfaceAddrs(); !slices.Equal(cur, addrs) {
+			if !slices.Equal(cur, pending) {
+				pending, changedAt = cur, now
+			}
+			if now.Sub(changedAt) < networkChangeSettle {
+				continue
+			}
+			addrs = cur
+		} else if !resumed {
+			pending = nil
+			continue
+		}
+		pending = nil
+
+		change := NetworkChange{Online: len(addrs) > 0, Resumed: resumed, Addresses: addrs}
+		resumed = false
+		c.log.Info("network changed",
+			"online", change.Online,
+			"resumed", change.Resumed,
+			"addresses", strings.Join(addrs, ","),
+		)
+		c.emit(EventNetworkChange, change)
+
+		if change.Online {
+			c.reconnectAll()
+		}
+	}
+}
+
+// reconnectAll tells every torrent the network changed.
+func (c *Client) reconnectAll() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, t := range c.torrents {
+		t.NetworkChanged()
+	}
+}
+
+// interfaceAddrs returns the addresses of the interfaces that are up,
+// loopback aside, sorted. IPv6 addresses are reduced to their /64: the
+// temporary addresses of RFC 8981 rotate within it every few hours, and
+// only a new prefix means the machine moved.
+func interfaceAddrs() []string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	var out []string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			if !ok || ipnet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			out = append(out, addrKey(ipnet.IP))
+		}
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
+
+// addrKey is how an interface address is compared between checks.
+func addrKey(ip net.IP) string {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return ip.String()
+	}
+	addr = addr.Unmap()
+	if addr.Is4() {
+		return addr.String()
+	}
+	return netip.PrefixFrom(addr, 64).Masked().String()
+}
@@ -0,0 +1,100 @@
+package ui
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/prxssh/rabbit/internal/piece"
+	"github.com/prxssh/rabbit/internal/storage"
+	"github.com/prxssh/rabbit/internal/torrent"
+)
+
+// recentGCPauses is how many of the latest GC pauses are reported.
+const recentGCPauses = 16
+
+// PerformanceStats is a snapshot of the process for the performance panel.
+type PerformanceStats struct {
+	Goroutines  int    `json:"goroutines"`
+	HeapAlloc   uint64 `json:"heapAlloc"`
+	HeapInuse   uint64 `json:"heapInuse"`
+	HeapObjects uint64 `json:"heapObjects"`
+	TotalAlloc  uint64 `json:"totalAlloc"`
+	Sys         uint64 `json:"sys"`
+	NumGC       uint32 `json:"numGC"`
+	// GCPauseTotal and RecentGCPauses are in nanoseconds, newest pause
+	// first.
+	GCPauseTotal   uint64   `json:"gcPauseTotal"`
+	RecentGCPauses []uint64 `json:"recentGCPauses"`
+	// Hashing is piece verification throughput across all torrents.
+	Hashing piece.HashStats `json:"hashing"`
+	// Reads is the upload read scheduler's activity.
+	Reads storage.ReadStats `json:"reads"`
+	// Queues holds each torrent's queue depths by info hash.
+	Queues map[string]torrent.QueueDepths `json:"queues"`
+}
+
+func (c *Client) GetPerformanceStats() *PerformanceStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	out := &PerformanceStats{
+		Goroutines:   runtime.NumGoroutine(),
+		HeapAlloc:    mem.HeapAlloc,
+		HeapInuse:    mem.HeapInuse,
+		HeapObjects:  mem.HeapObjects,
+		TotalAlloc:   mem.TotalAlloc,
+		Sys:          mem.Sys,
+		NumGC:        mem.NumGC,
+		GCPauseTotal: mem.PauseTotalNs,
+		Hashing:      c.hashes.Stats(),
+		Reads:        c.reads.Stats(),
+		Queues:       make(map[string]torrent.QueueDepths),
+	}
+
+	// PauseNs is a circular buffer; the latest pause is at (NumGC+255)%256.
+	n := min(int(mem.NumGC), recentGCPauses)
+	out.RecentGCPauses = make([]uint64, n)
+	for i := 0; i < n; i++ {
+		out.RecentGCPauses[i] = mem.PauseNs[(int(mem.NumGC)-1-i+len(mem.PauseNs))%len(mem.PauseNs)]
+	}
+
+	c.mu.RLock()
+	for hash, t := range c.torrents {
+		out.Queues[hex.EncodeToString(hash[:])] = t.QueueDepths()
+	}
+	c.mu.RUnlock()
+
+	return out
+}
+
+// serveProfiling exposes the pprof endpoints on cfg.ProfilingAddr until
+// ctx is done.
+func (c *Client) serveProfiling(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	srv := &http.Server{
+		Addr:              c.cfg.ProfilingAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	c.log.Info("profiling endpoints enabled", "addr", srv.Addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		c.log.Error("profiling server stopped", "error", err)
+	}
+}
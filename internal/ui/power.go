@@ -0,0 +1,246 @@
+package ui
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prxssh/rabbit/internal/power"
+	"github.com/prxssh/rabbit/internal/torrent"
+)
+
+// EventPowerPolicy carries a PowerState whenever the policy in force
+// changes.
+const EventPowerPolicy = "power:policy"
+
+// PowerState is the last power status read and what the client is doing
+// about it.
+type PowerState struct {
+	power.Status
+	power.Decision
+	Error string `json:"error,omitempty"`
+}
+
+// GetPowerState returns the current power status and policy.
+func (c *Client) GetPowerState() PowerState {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.power
+}
+
+// powerLoop polls the power status and moves between policies as it
+// changes. It does nothing unless some condition has a policy.
+func (c *Client) powerLoop(ctx context.Context) {
+	cfg := c.cfg.Power
+	if cfg == nil || !cfg.Enabled() {
+		return
+	}
+
+	interval := cfg.PollInterval
+	if interval <= 0 {
+		interval = power.WithDefaultConfig().PollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		c.updatePower(cfg)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sleepLoop keeps the machine from sleeping while torrents are active,
+// if PreventSleep is set.
+func (c *Client) sleepLoop(ctx context.Context) {
+	cfg := c.cfg.Power
+	if cfg == nil || !cfg.PreventSleep {
+		return
+	}
+
+	interval := cfg.PollInterval
+	if interval <= 0 {
+		interval = power.WithDefaultConfig().PollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var release func()
+	defer func() {
+		if release != nil {
+			release()
+		}
+	}()
+
+	for {
+		awake := cfg.KeepAwake(c.activity())
+		switch {
+		case awake && release == nil:
+			r, err := power.Inhibit("Transferring torrents")
+			if errors.Is(err, power.ErrUnsupported) {
+				c.log.Debug("sleep prevention not supported on this platform")
+				return
+			}
+			if err != nil {
+				c.log.Warn("prevent sleep failed", "error", err)
+				break
+			}
+			release = r
+			c.log.Info("preventing sleep while torrents are active")
+		case !awake && release != nil:
+			release()
+			release = nil
+			c.log.Info("allowing sleep again")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// activity reports whether some torrent is downloading or checking, and
+// whether some is only seeding.
+func (c *Client) activity() (downloading, seeding bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, t := range c.torrents {
+		switch s, _ := t.State(); s {
+		case torrent.StateChecking:
+			downloading = true
+		case torrent.StateDownloading:
+			if t.DownloadPaused() {
+				seeding = true
+			} else {
+				downloading = true
+			}
+		case torrent.StateSeeding:
+			seeding = true
+		}
+	}
+	return downloading, seeding
+}
+
+func (c *Client) updatePower(cfg *power.Config) {
+	status, err := power.Read()
+	next := PowerState{Status: status}
+	if err != nil {
+		// An unreadable status holds no condition, lifting any policy.
+		next.Error = err.Error()
+	}
+	next.Decision = cfg.Decide(status)
+
+	c.mu.Lock()
+	prev := c.power
+	c.power = next
+	c.mu.Unlock()
+
+	if prev.Decision == next.Decision {
+		if next.Error != prev.Error && next.Error != "" {
+			c.log.Warn("power status unavailable", "error", err)
+		}
+		return
+	}
+
+	c.log.Info("power policy changed",
+		"policy", next.Policy,
+		"reason", next.Reason,
+		"on_battery", next.OnBattery,
+		"metered", next.Metered,
+	)
+
+	switch {
+	case prev.Policy == power.PolicyPause && next.Policy == power.PolicyPause:
+		// Still paused, for another reason: nothing resumes in between.
+		c.repause(string(prev.Reason), string(next.Reason))
+		c.pauseRunning(string(next.Reason))
+	case prev.Policy == power.PolicyPause:
+		c.resumePaused(string(prev.Reason))
+	case next.Policy == power.PolicyPause:
+		c.pauseRunning(string(next.Reason))
+	}
+	c.applyRateLimits()
+	c.emit(EventPowerPolicy, next)
+}
+
+// pauseRunning pauses every running torrent for reason.
+func (c *Client) pauseRunning(reason string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, t := range c.torrents {
+		switch s, _ := t.State(); s {
+		case torrent.StateChecking, torrent.StateDownloading, torrent.StateSeeding:
+			if err := t.PauseFor(reason); err != nil {
+				c.log.Warn("policy pause failed", "name", t.Metainfo.Info.Name, "error", err)
+			}
+		}
+	}
+}
+
+// resumePaused resumes the torrents still paused for reason. Those the
+// user paused or resumed in the meantime are left alone.
+func (c *Client) resumePaused(reason string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, t := range c.torrents {
+		if s, _ := t.State(); s != torrent.StatePaused || t.PauseReason() != reason {
+			continue
+		}
+		if err := t.Resume(c.ctx); err != nil {
+			c.log.Warn("policy resume failed", "name", t.Metainfo.Info.Name, "error", err)
+		}
+	}
+}
+
+// repause moves the torrents still paused for from over to to, leaving
+// them paused.
+func (c *Client) repause(from, to string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, t := range c.torrents {
+		if s, _ := t.State(); s != torrent.StatePaused || t.PauseReason() != from {
+			continue
+		}
+		if err := t.PauseFor(to); err != nil {
+			c.log.Warn("policy pause failed", "name", t.Metainfo.Info.Name, "error", err)
+		}
+	}
+}
+
+// policyPauseReason returns the reason new torrents should start paused
+// for, if the pause policy is in force.
+func (c *Client) policyPauseReason() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.power.Policy != power.PolicyPause {
+		return ""
+	}
+	return string(c.power.Reason)
+}
+
+// applyRateLimits sets the limiters to the configured rates, lowered to
+// the policy caps while the limit policy is in force.
+func (c *Client) applyRateLimits() {
+	c.mu.RLock()
+	download, upload := c.cfg.DownloadRateLimit, c.cfg.UploadRateLimit
+	if c.power.Policy == power.PolicyLimit {
+		download = power.LimitRate(download, c.cfg.Power.DownloadRateLimit)
+		upload = power.LimitRate(upload, c.cfg.Power.UploadRateLimit)
+	}
+	c.mu.RUnlock()
+
+	c.bandwidth.Download.SetRate(download)
+	c.bandwidth.Upload.SetRate(upload)
+}
@@ -0,0 +1,256 @@
+package ui
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prxssh/rabbit/internal/torrent"
+)
+
+// quotaReason is the pause reason of torrents held back because their
+// download directory's quota can't fit what they have left.
+const quotaReason = "quota"
+
+// quotaInterval is how often quotas are enforced as torrents progress.
+const quotaInterval = time.Minute
+
+// DirUsage is how much of a download directory's quota the torrents saved
+// under it use now and would use once the running ones finish.
+type DirUsage struct {
+	Path  string `json:"path"`
+	Quota uint64 `json:"quota"`
+	// Used is the verified data of every torrent saved under Path.
+	Used uint64 `json:"used"`
+	// Projected is Used plus what the running torrents still have to
+	// download.
+	Projected uint64 `json:"projected"`
+	// Held counts torrents paused because they would not fit.
+	Held int `json:"held"`
+	// Exceeded is set while any torrent is held or Used alone is over the
+	// quota.
+	Exceeded bool `json:"exceeded"`
+}
+
+// quotaTorrent is an unfinished torrent counted against a quota.
+type quotaTorrent struct {
+	t      *torrent.Torrent
+	left   uint64
+	active bool
+}
+
+// GetDirQuotas reports the usage of every directory with a quota.
+func (c *Client) GetDirQuotas() []DirUsage {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	out := make([]DirUsage, 0, len(c.cfg.DirQuotas))
+	for dir := range c.cfg.DirQuotas {
+		usage, _ := c.dirUsageLocked(dir)
+		out = append(out, usage)
+	}
+	slices.SortFunc(out, func(a, b DirUsage) int { return cmp.Compare(a.Path, b.Path) })
+	return out
+}
+
+// SetDirQuota caps how much the torrents saved under dir, subdirectories
+// included, may fill, in bytes; 0 removes the cap. Torrents are paused or
+// resumed to match right away.
+func (c *Client) SetDirQuota(dir string, quota uint64) error {
+	if !filepath.IsAbs(dir) {
+		return fmt.Errorf("%w: quota directory %q", ErrInvalidPath, dir)
+	}
+	dir = filepath.Clean(dir)
+
+	c.mu.Lock()
+	if quota == 0 {
+		delete(c.cfg.DirQuotas, dir)
+	} else {
+		if c.cfg.DirQuotas == nil {
+			c.cfg.DirQuotas = make(map[string]uint64)
+		}
+		c.cfg.DirQuotas[dir] = quota
+	}
+	c.mu.Unlock()
+
+	c.enforceQuotas()
+	return nil
+}
+
+func (c *Client) quotaLoop(ctx context.Context) {
+	ticker := time.NewTicker(quotaInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.enforceQuotas()
+		}
+	}
+}
+
+// enforceQuotas lets the unfinished torrents of each directory with a
+// quota run for as long as what they have left fits, those already running
+// first and then the closest to done. The rest are paused until it does.
+// Torrents the user or a power policy paused are not counted.
+func (c *Client) enforceQuotas() {
+	if c.policyPauseReason() != "" {
+		return
+	}
+
+	var hold, release []*torrent.Torrent
+
+	c.mu.RLock()
+	for dir := range c.cfg.DirQuotas {
+		_, plan := c.dirUsageLocked(dir)
+		for _, qt := range plan.hold {
+			if qt.active {
+				hold = append(hold, qt.t)
+			}
+		}
+		for _, qt := range plan.run {
+			if !qt.active {
+				release = append(release, qt.t)
+			}
+		}
+	}
+	c.mu.RUnlock()
+
+	for _, t := range hold {
+		c.log.Info("download directory quota exceeded, pausing",
+			"name", t.Metainfo.Info.Name,
+			"path", t.SavePath(),
+		)
+		if err := t.PauseFor(quotaReason); err != nil {
+			c.log.Warn("quota pause failed", "name", t.Metainfo.Info.Name, "error", err)
+		}
+	}
+	for _, t := range release {
+		if err := t.Resume(c.ctx); err != nil {
+			c.log.Warn("quota resume failed", "name", t.Metainfo.Info.Name, "error", err)
+		}
+	}
+}
+
+// quotaPlan splits a directory's unfinished torrents into those that may
+// run and those to hold.
+type quotaPlan struct {
+	run  []quotaTorrent
+	hold []quotaTorrent
+}
+
+// dirUsageLocked measures dir against its quota. Called with c.mu held.
+func (c *Client) dirUsageLocked(dir string) (DirUsage, quotaPlan) {
+	usage := DirUsage{Path: dir, Quota: c.cfg.DirQuotas[dir]}
+
+	var unfinished []quotaTorrent
+	usage.Used, unfinished = c.dirTorrentsLocked(dir)
+
+	slices.SortFunc(unfinished, func(a, b quotaTorrent) int {
+		if a.active != b.active {
+			if a.active {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(a.left, b.left)
+	})
+
+	var plan quotaPlan
+	usage.Projected = usage.Used
+	for _, qt := range unfinished {
+		if usage.Projected+qt.left > usage.Quota {
+			plan.hold = append(plan.hold, qt)
+			continue
+		}
+		usage.Projected += qt.left
+		plan.run = append(plan.run, qt)
+	}
+	usage.Held = len(plan.hold)
+	usage.Exceeded = usage.Held > 0 || usage.Used > usage.Quota
+
+	return usage, plan
+}
+
+// dirTorrentsLocked returns the verified data of the torrents saved under
+// dir and those of them that are unfinished and running or held for the
+// quota. Called with c.mu held.
+func (c *Client) dirTorrentsLocked(dir string) (used uint64, unfinished []quotaTorrent) {
+	for _, t := range c.torrents {
+		if quotaDir(c.cfg.DirQuotas, t.SavePath()) != dir {
+			continue
+		}
+		used += t.Have()
+
+		var active bool
+		switch s, _ := t.State(); s {
+		case torrent.StateChecking, torrent.StateDownloading:
+			active = true
+		case torrent.StatePaused:
+			if t.PauseReason() != quotaReason {
+				continue
+			}
+		default:
+			continue
+		}
+		if left := t.Left(); left > 0 {
+			unfinished = append(unfinished, quotaTorrent{t: t, left: left, active: active})
+		}
+	}
+	return used, unfinished
+}
+
+// quotaFitsLocked reports whether a torrent saved under savePath with left
+// bytes to download may start next to those running. Called with c.mu
+// held.
+func (c *Client) quotaFitsLocked(savePath string, left uint64) bool {
+	dir := quotaDir(c.cfg.DirQuotas, savePath)
+	if dir == "" {
+		return true
+	}
+
+	projected, unfinished := c.dirTorrentsLocked(dir)
+	for _, qt := range unfinished {
+		if qt.active {
+			projected += qt.left
+		}
+	}
+	return projected+left <= c.cfg.DirQuotas[dir]
+}
+
+func (c *Client) quotaFits(savePath string, left uint64) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.quotaFitsLocked(savePath, left)
+}
+
+// quotaDir returns the innermost directory with a quota that savePath is
+// in, or "" if there is none.
+func quotaDir(quotas map[string]uint64, savePath string) string {
+	if len(quotas) == 0 {
+		return ""
+	}
+	path, err := filepath.Abs(savePath)
+	if err != nil {
+		return ""
+	}
+
+	var best string
+	for dir := range quotas {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if len(dir) > len(best) {
+			best = dir
+		}
+	}
+	return best
+}
@@ -0,0 +1,117 @@
+package ui
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/prxssh/rabbit/internal/tracker"
+	"github.com/prxssh/rabbit/pkg/atomicfile"
+)
+
+// knownTrackersFile holds the trackers AutoAddTrackers appends, one
+// announce URL per line, the format public tracker lists are published
+// in.
+const knownTrackersFile = "trackers.txt"
+
+// parseTrackerList reads a tracker list: one announce URL per line, with
+// blank lines and # comments skipped and duplicates dropped.
+func parseTrackerList(list string) ([]string, error) {
+	var (
+		urls []string
+		seen = make(map[string]struct{})
+	)
+
+	sc := bufio.NewScanner(strings.NewReader(list))
+	for line := 1; sc.Scan(); line++ {
+		u := strings.TrimSpace(sc.Text())
+		if u == "" || strings.HasPrefix(u, "#") {
+			continue
+		}
+		if !tracker.IsAnnounceURL(u) {
+			return nil, fmt.Errorf("%w: tracker list line %d: %q", ErrInvalidTracker, line, u)
+		}
+		if _, ok := seen[u]; ok {
+			continue
+		}
+		seen[u] = struct{}{}
+		urls = append(urls, u)
+	}
+	return urls, sc.Err()
+}
+
+// loadKnownTrackers reads the known trackers from dataDir. A missing
+// file is an empty list.
+func loadKnownTrackers(dataDir string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, knownTrackersFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseTrackerList(string(data))
+}
+
+// GetKnownTrackers returns the trackers added to new public torrents
+// while AutoAddTrackers is on.
+func (c *Client) GetKnownTrackers() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return slices.Clone(c.knownTrackers)
+}
+
+// SetKnownTrackers replaces the known trackers with list, one announce
+// URL per line, so an updated public list can be pasted in as is. It
+// applies to torrents added from now on.
+func (c *Client) SetKnownTrackers(list string) error {
+	urls, err := parseTrackerList(list)
+	if err != nil {
+		return err
+	}
+
+	data := strings.Join(urls, "\n")
+	if len(urls) > 0 {
+		data += "\n"
+	}
+	if err := os.MkdirAll(c.cfg.DataDir, 0o755); err != nil {
+		return err
+	}
+	if err := atomicfile.WriteFile(filepath.Join(c.cfg.DataDir, knownTrackersFile), []byte(data), 0o644); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.knownTrackers = urls
+	c.mu.Unlock()
+	return nil
+}
+
+// SetAutoAddTrackers turns adding the known trackers to new public
+// torrents on or off.
+func (c *Client) SetAutoAddTrackers(enabled bool) {
+	c.mu.Lock()
+	c.cfg.AutoAddTrackers = enabled
+	c.mu.Unlock()
+}
+
+// extraTrackers returns the trackers to add to a new torrent.
+func (c *Client) extraTrackers(opts *AddTorrentOpts) []string {
+	if opts.NoExtraTrackers {
+		return nil
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.cfg.AutoAddTrackers {
+		return nil
+	}
+	return slices.Clone(c.knownTrackers)
+}
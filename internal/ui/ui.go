@@ -2,52 +2,358 @@ package ui
 
 import (
 	"context"
-	"crypto/rand"
 	"crypto/sha1"
 	"encoding/hex"
+	"fmt"
 	"log/slog"
+	"path"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/prxssh/rabbit/internal/connectivity"
+	"github.com/prxssh/rabbit/internal/geo"
+	"github.com/prxssh/rabbit/internal/index"
 	"github.com/prxssh/rabbit/internal/meta"
 	"github.com/prxssh/rabbit/internal/peer"
+	"github.com/prxssh/rabbit/internal/piece"
+	"github.com/prxssh/rabbit/internal/scheduler"
+	"github.com/prxssh/rabbit/internal/storage"
 	"github.com/prxssh/rabbit/internal/torrent"
+	"github.com/prxssh/rabbit/internal/tracker"
+	"github.com/prxssh/rabbit/pkg/atomicfile"
+	"github.com/prxssh/rabbit/pkg/bitfield"
+	"github.com/prxssh/rabbit/pkg/ratelimit"
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
+// EventCheckProgress carries a storage.CheckProgress while a torrent's
+// existing data is being hash-checked.
+const EventCheckProgress = "torrent:check"
+
+// EventDeadlineMiss carries a DeadlineMissEvent when a piece being
+// streamed arrives after its deadline, so the player can rebuffer.
+const EventDeadlineMiss = "torrent:deadline-miss"
+
+type DeadlineMissEvent struct {
+	InfoHash string `json:"infoHash"`
+	scheduler.DeadlineMiss
+}
+
+const (
+	indexSaveInterval     = 5 * time.Minute
+	maxLocalSearchResults = 200
+)
+
 type Client struct {
-	log      *slog.Logger
-	ctx      context.Context
-	mu       sync.RWMutex
-	clientID [sha1.Size]byte
-	torrents map[[sha1.Size]byte]*torrent.Torrent
+	log       *slog.Logger
+	ctx       context.Context
+	cfg       *Config
+	mu        sync.RWMutex
+	clientID  [sha1.Size]byte
+	listener  *peer.Listener
+	peerCache *peer.Cache
+	bandwidth *peer.Bandwidth
+	https     *tracker.HTTPSCache
+	slots     *peer.SlotPool
+	checks    *storage.CheckQueue
+	hashes    *piece.HashMeter
+	reads     *storage.ReadScheduler
+	files     *storage.FilePool
+	index     *index.Index
+	geo       *geo.Resolver
+	torrents  map[[sha1.Size]byte]*torrent.Torrent
+	power     PowerState
+	started   atomic.Bool
+
+	// knownTrackers is the list AutoAddTrackers appends. Guarded by mu.
+	knownTrackers []string
+	// adding holds the info hashes of torrents being created, so a
+	// second add of the same one is refused before it touches the disk.
+	// Guarded by mu.
+	adding map[[sha1.Size]byte]struct{}
+
+	// connectivity is the one record of the port we advertise, shared by
+	// every torrent's trackers and peers.
+	connectivity *connectivity.Manager
 }
 
-func NewClient() (*Client, error) {
-	clientID, err := generateClientID()
+func NewClient(cfg *Config) (*Client, error) {
+	if cfg == nil {
+		cfg = WithDefaultConfig()
+	}
+
+	clientID, err := peer.SessionID()
 	if err != nil {
 		return nil, err
 	}
 
-	return &Client{
-		log:      slog.Default(),
-		ctx:      context.Background(),
-		clientID: clientID,
+	log := slog.Default()
+
+	listener, err := peer.Listen(cfg.Listen, log)
+	if err != nil {
+		return nil, err
+	}
+
+	indexPath := filepath.Join(cfg.DataDir, "index.json")
+	searchIndex, err := index.Open(indexPath)
+	if err != nil {
+		log.Warn("local index unreadable, starting fresh", "path", indexPath, "error", err)
+		searchIndex = index.New(indexPath)
+	}
+
+	knownTrackers, err := loadKnownTrackers(cfg.DataDir)
+	if err != nil {
+		log.Warn("known trackers unreadable, ignoring", "error", err)
+	}
+
+	c := &Client{
+		index:     searchIndex,
+		geo:       geo.NewResolver(cfg.GeoIP, log),
+		log:       log,
+		ctx:       context.Background(),
+		cfg:       cfg,
+		clientID:  clientID,
+		listener:  listener,
+		peerCache: peer.NewCache(cfg.PeerCacheTTL, cfg.PeerCacheSize),
+		bandwidth: &peer.Bandwidth{
+			Download:        ratelimit.NewLimiter(cfg.DownloadRateLimit),
+			Upload:          ratelimit.NewLimiter(cfg.UploadRateLimit),
+			IncludeOverhead: cfg.RateLimitIncludesOverhead,
+		},
+		https:    tracker.NewHTTPSCache(),
+		slots:    peer.NewSlotPool(cfg.GlobalUploadSlots),
+		checks:   storage.NewCheckQueue(cfg.ConcurrentChecks, cfg.CheckReadRateLimit),
+		hashes:   &piece.HashMeter{},
+		reads:    storage.NewReadScheduler(cfg.DiskReadWorkers, cfg.DiskReadsPerFile),
+		files:    storage.NewFilePool(cfg.MaxOpenFiles),
 		torrents: make(map[[sha1.Size]byte]*torrent.Torrent),
-	}, nil
+		adding:   make(map[[sha1.Size]byte]struct{}),
+	}
+	c.knownTrackers = knownTrackers
+	c.connectivity = connectivity.New(listener.Port())
+	c.checks.OnProgress = func(p storage.CheckProgress) {
+		c.emit(EventCheckProgress, p)
+	}
+
+	return c, nil
 }
 
 func (c *Client) Startup(ctx context.Context) {
 	c.ctx = ctx
+	c.started.Store(true)
+
+	go func() {
+		if err := c.listener.Run(ctx); err != nil {
+			c.log.Error("listener stopped", "error", err)
+		}
+	}()
+	go c.indexLoop(ctx)
+	go c.reads.Run(ctx)
+	go c.powerLoop(ctx)
+	go c.sleepLoop(ctx)
+	go c.networkLoop(ctx)
+	go c.autoManageLoop(ctx)
+	go c.quotaLoop(ctx)
+	if c.cfg.ProfilingAddr != "" {
+		go c.serveProfiling(ctx)
+	}
+}
+
+// emit sends an event to the frontend. It is a no-op until Wails has
+// handed us its context in Startup.
+func (c *Client) emit(name string, data any) {
+	if !c.started.Load() {
+		return
+	}
+	runtime.EventsEmit(c.ctx, name, data)
+}
+
+// indexLoop refreshes swarm snapshots of active torrents in the local index,
+// prunes stale entries and persists it.
+func (c *Client) indexLoop(ctx context.Context) {
+	ticker := time.NewTicker(indexSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := c.index.Save(); err != nil {
+				c.log.Error("save local index failed", "error", err)
+			}
+			return
+
+		case <-ticker.C:
+			c.mu.RLock()
+			for hash, t := range c.torrents {
+				stats := t.GetStats()
+				c.index.UpdateSwarm(
+					hex.EncodeToString(hash[:]),
+					stats.CurrentSeeders,
+					stats.CurrentLeechers,
+				)
+			}
+			c.mu.RUnlock()
+
+			if n := c.index.Prune(c.cfg.IndexMaxAge); n > 0 {
+				c.log.Debug("pruned local index", "removed", n)
+			}
+			if err := c.index.Save(); err != nil {
+				c.log.Error("save local index failed", "error", err)
+			}
+		}
+	}
 }
 
-func (c *Client) AddTorrent(data []byte, cfg *torrent.Config) (*torrent.Torrent, error) {
+// SearchLocal searches torrents the client has seen before, without
+// touching the network.
+func (c *Client) SearchLocal(query string) []index.Entry {
+	return c.index.Search(query, maxLocalSearchResults)
+}
+
+// ListenPort returns the TCP port incoming peers should connect to.
+func (c *Client) ListenPort() uint16 {
+	return c.connectivity.Port()
+}
+
+// AddTorrentOpts are the add dialog's choices, applied before the torrent
+// first runs.
+type AddTorrentOpts struct {
+	// Paused adds the torrent without starting it.
+	Paused bool `json:"paused"`
+	// SkipCheck trusts files already on disk instead of hashing them.
+	SkipCheck bool `json:"skipCheck"`
+	// DownloadDir overrides the config's download directory.
+	DownloadDir string `json:"downloadDir"`
+	Label       string `json:"label"`
+	// Priority ranks the torrent against the client's others.
+	Priority torrent.Priority `json:"priority"`
+	// FilePriorities is indexed like the torrent's files.
+	FilePriorities []scheduler.Priority `json:"filePriorities"`
+	// FilePreview marks files to fetch the first and last pieces of
+	// first, so they can be opened in a player early.
+	FilePreview []bool `json:"filePreview"`
+	// NoExtraTrackers leaves out the known trackers AutoAddTrackers would
+	// add.
+	NoExtraTrackers bool `json:"noExtraTrackers"`
+}
+
+// AddTorrent adds a torrent and, unless opts says otherwise, starts it.
+// opts may be nil.
+func (c *Client) AddTorrent(
+	data []byte,
+	cfg *torrent.Config,
+	opts *AddTorrentOpts,
+) (*torrent.Torrent, error) {
+	return c.addTorrent(data, cfg, opts, nil)
+}
+
+// addTorrent adds a torrent. have optionally lists pieces known to be on
+// disk already, which then skip hash checking.
+func (c *Client) addTorrent(
+	data []byte,
+	cfg *torrent.Config,
+	opts *AddTorrentOpts,
+	have bitfield.Bitfield,
+) (*torrent.Torrent, error) {
 	if cfg == nil {
 		cfg = torrent.WithDefaultConfig()
 	}
+	if opts == nil {
+		opts = &AddTorrentOpts{}
+	}
 
-	torrent, err := torrent.NewTorrent(c.clientID, data, cfg)
+	m, err := meta.ParseMetainfo(data)
 	if err != nil {
-		c.log.Error("failed to parse torrent", "error", err, "size", len(data))
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTorrent, err)
+	}
+	// NewTorrent creates and preallocates the files, so the info hash is
+	// claimed first.
+	c.mu.Lock()
+	_, exists := c.torrents[m.InfoHash]
+	_, adding := c.adding[m.InfoHash]
+	if !exists && !adding {
+		c.adding[m.InfoHash] = struct{}{}
+	}
+	c.mu.Unlock()
+	if exists || adding {
+		return nil, ErrDuplicateTorrent
+	}
+	defer func() {
+		c.mu.Lock()
+		delete(c.adding, m.InfoHash)
+		c.mu.Unlock()
+	}()
+
+	if opts.DownloadDir != "" && cfg.Storage != nil {
+		cfg.Storage.DownloadDir = opts.DownloadDir
+	}
+	if cfg.Tracker != nil {
+		cfg.Tracker.Port = c.connectivity.Port()
+		if cfg.Tracker.ExternalIP == "" {
+			cfg.Tracker.ExternalIP = c.cfg.ExternalIP
+		}
+	}
+
+	// Under a pause policy the torrent waits paused for it to lift.
+	paused, pauseReason := opts.Paused, ""
+	if !paused {
+		pauseReason = c.policyPauseReason()
+		paused = pauseReason != ""
+	}
+	saveDir := storage.WithDefaultConfig().DownloadDir
+	if cfg.Storage != nil {
+		saveDir = cfg.Storage.DownloadDir
+	}
+	if !paused && !c.quotaFits(saveDir, m.Size) {
+		paused, pauseReason = true, quotaReason
+	}
+
+	clientID := c.clientID
+	if c.cfg.PeerIDPerTorrent {
+		id, err := peer.NewID()
+		if err != nil {
+			return nil, err
+		}
+		clientID = id
+	}
+
+	torrent, err := torrent.NewTorrent(data, &torrent.Opts{
+		ClientID:    clientID,
+		Config:      cfg,
+		PeerCache:   c.peerCache,
+		Bandwidth:   c.bandwidth,
+		HTTPSCache:  c.https,
+		UploadSlots: c.slots,
+		Registry:    c.listener.Registry(),
+		Checks:      c.checks,
+		Reads:       c.reads,
+		Files:       c.files,
+		Hasher:      piece.Metered(piece.SHA1, c.hashes),
+		HavePieces:  have,
+
+		Paused:         paused,
+		PauseReason:    pauseReason,
+		SkipCheck:      opts.SkipCheck,
+		Label:          opts.Label,
+		Priority:       opts.Priority,
+		FilePriorities: opts.FilePriorities,
+		FilePreview:    opts.FilePreview,
+		ExtraTrackers:  c.extraTrackers(opts),
+		OnDeadlineMiss: func(miss scheduler.DeadlineMiss) {
+			c.emit(EventDeadlineMiss, DeadlineMissEvent{
+				InfoHash:     hex.EncodeToString(m.InfoHash[:]),
+				DeadlineMiss: miss,
+			})
+		},
+		Connectivity: c.connectivity,
+	})
+	if err != nil {
+		c.log.Error("failed to create torrent", "error", err, "size", len(data))
+		if storage.IsDiskFull(err) {
+			err = fmt.Errorf("%w: %w", ErrDiskFull, err)
+		}
 		return nil, err
 	}
 
@@ -64,99 +370,386 @@ func (c *Client) AddTorrent(data []byte, cfg *torrent.Config) (*torrent.Torrent,
 	c.torrents[torrent.Metainfo.InfoHash] = torrent
 	c.mu.Unlock()
 
-	go func() { torrent.Run(c.ctx) }()
+	c.index.Put(indexEntry(torrent.Metainfo))
+
+	if !paused {
+		go func() { torrent.Run(c.ctx) }()
+	}
 	return torrent, nil
 }
 
+// AddMagnetTorrent validates a magnet link. Fetching the metadata from
+// the swarm isn't implemented, so no torrent is added yet and the link's
+// peer hints and web seeds go unused.
 func (c *Client) AddMagnetTorrent(magnetURL string, cfg *torrent.Config) error {
 	parsedMagnetURL, err := meta.ParseMagnet(magnetURL)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrInvalidMagnet, err)
 	}
 
-	c.log.Debug("magnet url parsed successfully", "parsed magnet", parsedMagnetURL)
+	if !parsedMagnetURL.HasV1() {
+		return fmt.Errorf("%w: v2-only links are not supported", ErrInvalidMagnet)
+	}
+
+	c.log.Debug("magnet url parsed successfully",
+		"info_hash", hex.EncodeToString(parsedMagnetURL.InfoHash[:]),
+		"name", parsedMagnetURL.Name,
+		"trackers", len(parsedMagnetURL.Trackers),
+		"peers", len(parsedMagnetURL.Peers),
+		"web_seeds", len(parsedMagnetURL.WebSeeds),
+	)
 
 	return nil
 }
 
+// TorrentInfo is the metadata of a .torrent file, shown before the user
+// commits to adding it.
+type TorrentInfo struct {
+	InfoHash     string        `json:"infoHash"`
+	Name         string        `json:"name"`
+	Size         uint64        `json:"size"`
+	PieceLength  uint32        `json:"pieceLength"`
+	PieceCount   int           `json:"pieceCount"`
+	Private      bool          `json:"private"`
+	Trackers     [][]string    `json:"trackers"`
+	CreationDate time.Time     `json:"creationDate"`
+	CreatedBy    string        `json:"createdBy"`
+	Comment      string        `json:"comment"`
+	Files        []TorrentFile `json:"files"`
+	AlreadyAdded bool          `json:"alreadyAdded"`
+}
+
+type TorrentFile struct {
+	Index  int    `json:"index"`
+	Path   string `json:"path"`
+	Length uint64 `json:"length"`
+}
+
+// InspectTorrent parses a .torrent file without adding or starting it.
+func (c *Client) InspectTorrent(data []byte) (*TorrentInfo, error) {
+	m, err := meta.ParseMetainfo(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTorrent, err)
+	}
+
+	trackers := m.AnnounceList
+	if len(trackers) == 0 && m.Announce != "" {
+		trackers = [][]string{{m.Announce}}
+	}
+
+	info := &TorrentInfo{
+		InfoHash:     hex.EncodeToString(m.InfoHash[:]),
+		Name:         m.Info.Name,
+		Size:         m.Size,
+		PieceLength:  m.Info.PieceLength,
+		PieceCount:   len(m.Info.Pieces),
+		Private:      m.Info.Private,
+		Trackers:     trackers,
+		CreationDate: m.CreationDate,
+		CreatedBy:    m.CreatedBy,
+		Comment:      m.Comment,
+	}
+
+	if len(m.Info.Files) == 0 {
+		info.Files = []TorrentFile{{Index: 0, Path: m.Info.Name, Length: m.Info.Length}}
+	} else {
+		info.Files = make([]TorrentFile, len(m.Info.Files))
+		for i, f := range m.Info.Files {
+			info.Files[i] = TorrentFile{
+				Index:  i,
+				Path:   path.Join(f.Path...),
+				Length: f.Length,
+			}
+		}
+	}
+
+	c.mu.RLock()
+	_, info.AlreadyAdded = c.torrents[m.InfoHash]
+	c.mu.RUnlock()
+
+	return info, nil
+}
+
+// PlanDiskSpace reports, for a .torrent file about to be added under
+// downloadDir, how much space it still needs, how much is free there and
+// how many of its files are already on disk.
+func (c *Client) PlanDiskSpace(data []byte, downloadDir string) (*storage.SpacePlan, error) {
+	m, err := meta.ParseMetainfo(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTorrent, err)
+	}
+	if downloadDir == "" {
+		downloadDir = storage.WithDefaultConfig().DownloadDir
+	}
+
+	return storage.PlanSpace(m, downloadDir)
+}
+
+// SessionStats aggregates traffic across every torrent of the client.
+//
+// Payload counts piece data only; overhead is everything else we put on or
+// took off the wire for peers and trackers.
+type SessionStats struct {
+	PayloadDownloaded  uint64 `json:"payloadDownloaded"`
+	PayloadUploaded    uint64 `json:"payloadUploaded"`
+	OverheadDownloaded uint64 `json:"overheadDownloaded"`
+	OverheadUploaded   uint64 `json:"overheadUploaded"`
+	DownloadRate       uint64 `json:"downloadRate"`
+	UploadRate         uint64 `json:"uploadRate"`
+	DownloadRateLimit  uint64 `json:"downloadRateLimit"`
+	UploadRateLimit    uint64 `json:"uploadRateLimit"`
+}
+
+func (c *Client) GetSessionStats() *SessionStats {
+	c.mu.RLock()
+	torrents := make([]*torrent.Torrent, 0, len(c.torrents))
+	for _, t := range c.torrents {
+		torrents = append(torrents, t)
+	}
+	c.mu.RUnlock()
+
+	out := &SessionStats{
+		DownloadRateLimit: c.bandwidth.Download.Rate(),
+		UploadRateLimit:   c.bandwidth.Upload.Rate(),
+	}
+	for _, t := range torrents {
+		s := t.GetStats()
+		out.PayloadDownloaded += s.TotalDownloaded
+		out.PayloadUploaded += s.TotalUploaded
+		out.OverheadDownloaded += s.ProtocolDownload + s.BytesReceived
+		out.OverheadUploaded += s.ProtocolUpload + s.BytesSent
+		out.DownloadRate += s.SwarmMetrics.DownloadRate
+		out.UploadRate += s.SwarmMetrics.UploadRate
+	}
+
+	return out
+}
+
+// SetRateLimits changes the client-wide download and upload limits in
+// bytes/sec. 0 is unlimited. A power limit policy in force still caps
+// them.
+func (c *Client) SetRateLimits(download, upload uint64) {
+	c.mu.Lock()
+	c.cfg.DownloadRateLimit, c.cfg.UploadRateLimit = download, upload
+	c.mu.Unlock()
+
+	c.applyRateLimits()
+}
+
+// SetGlobalUploadSlots changes the client-wide unchoke slot cap, taking
+// effect at each torrent's next rechoke. 0 is unlimited.
+func (c *Client) SetGlobalUploadSlots(slots int) {
+	c.slots.SetLimit(slots)
+}
+
+// SetCheckLimits changes how many existing-data checks run at once and
+// their shared read rate in bytes/sec. 0 is unlimited for either.
+func (c *Client) SetCheckLimits(concurrent int, readRate uint64) {
+	c.checks.SetConcurrency(concurrent)
+	c.checks.SetReadRate(readRate)
+}
+
 func (c *Client) GetDefaultConfig() *torrent.Config {
 	return torrent.WithDefaultConfig()
 }
 
-func (c *Client) RemoveTorrent(infoHashHex string) error {
-	var infoHash [sha1.Size]byte
-
-	bytes, err := hex.DecodeString(infoHashHex)
-	if err != nil || len(bytes) != sha1.Size {
-		c.log.Error("invalid info hash", "hash", infoHashHex, "error", err)
+// RemoveTorrent drops the torrent from the client. With deleteData set its
+// downloaded files are deleted as well, once the torrent has shut down.
+func (c *Client) RemoveTorrent(infoHashHex string, deleteData bool) error {
+	torrent, err := c.lookupTorrent(infoHashHex)
+	if err != nil {
 		return err
 	}
-	copy(infoHash[:], bytes)
 
+	// Another call may have removed it between the lookup and here.
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	torrent, ok := c.torrents[infoHash]
-	if !ok {
-		c.log.Warn("torrent not found", "info_hash", infoHashHex)
-		return nil
+	if c.torrents[torrent.Metainfo.InfoHash] != torrent {
+		c.mu.Unlock()
+		return ErrTorrentNotFound
 	}
+	delete(c.torrents, torrent.Metainfo.InfoHash)
+	c.mu.Unlock()
 
 	c.log.Debug(
 		"removing torrent",
 		"name", torrent.Metainfo.Info.Name,
 		"info_hash", infoHashHex,
+		"delete_data", deleteData,
 	)
 
-	torrent.Stop()
-	delete(c.torrents, infoHash)
-	return nil
+	return torrent.Remove(deleteData)
 }
 
-func (c *Client) GetTorrentStats(infoHashHex string) *torrent.Stats {
-	var infoHash [sha1.Size]byte
+func (c *Client) RenameTorrentFile(infoHashHex string, index int, newPath string) error {
+	torrent, err := c.lookupTorrent(infoHashHex)
+	if err != nil {
+		return err
+	}
+	return torrent.RenameFile(index, newPath)
+}
 
-	bytes, err := hex.DecodeString(infoHashHex)
-	if err != nil || len(bytes) != sha1.Size {
-		return nil
+func (c *Client) RenameTorrentRoot(infoHashHex, newName string) error {
+	torrent, err := c.lookupTorrent(infoHashHex)
+	if err != nil {
+		return err
 	}
-	copy(infoHash[:], bytes)
+	return torrent.RenameRoot(newName)
+}
 
-	c.mu.RLock()
-	torrent, ok := c.torrents[infoHash]
-	c.mu.RUnlock()
-	if !ok {
-		return nil
+// SetFilePriorities sets the download priority of each of a torrent's
+// files; nil makes them all normal again.
+func (c *Client) SetFilePriorities(infoHashHex string, prios []scheduler.Priority) error {
+	torrent, err := c.lookupTorrent(infoHashHex)
+	if err != nil {
+		return err
 	}
+	return torrent.SetFilePriorities(prios)
+}
 
-	return torrent.GetStats()
+// SetFilePreview marks which of a torrent's files have their first and
+// last pieces downloaded first; nil clears them all.
+func (c *Client) SetFilePreview(infoHashHex string, preview []bool) error {
+	torrent, err := c.lookupTorrent(infoHashHex)
+	if err != nil {
+		return err
+	}
+	return torrent.SetFilePreview(preview)
 }
 
-func (c *Client) GetTorrentConfig(infoHashHex string) *torrent.Config {
-	var infoHash [sha1.Size]byte
+// GetPeerGeo returns the location of each connected peer of a torrent with
+// per-country and per-ASN totals. Fails with geo.ErrDisabled unless GeoIP
+// lookups are turned on.
+func (c *Client) GetPeerGeo(infoHashHex string) (*geo.Summary, error) {
+	if !c.geo.Enabled() {
+		return nil, geo.ErrDisabled
+	}
 
-	bytes, err := hex.DecodeString(infoHashHex)
-	if err != nil || len(bytes) != sha1.Size {
-		return nil
+	torrent, err := c.lookupTorrent(infoHashHex)
+	if err != nil {
+		return nil, err
 	}
-	copy(infoHash[:], bytes)
 
-	c.mu.RLock()
-	torrent, ok := c.torrents[infoHash]
-	c.mu.RUnlock()
-	if !ok {
-		return nil
+	metrics := torrent.GetStats().Peers
+	peers := make([]geo.Peer, 0, len(metrics))
+	for _, m := range metrics {
+		loc, err := c.geo.Lookup(m.Addr.Addr())
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, geo.Peer{
+			Addr:         m.Addr.String(),
+			DownloadRate: m.DownloadRate,
+			UploadRate:   m.UploadRate,
+			Location:     loc,
+		})
 	}
 
-	return torrent.GetConfig()
+	return geo.Summarize(peers), nil
 }
 
-func (c *Client) UpdateTorrentConfig(infoHashHex string, cfg *torrent.Config) error {
+func (c *Client) SetGeoIPEnabled(enabled bool) {
+	c.geo.SetEnabled(enabled)
+}
+
+// RecheckTorrent resumes a torrent stopped because its files changed on
+// disk, hash checking them before rejoining the swarm.
+func (c *Client) RecheckTorrent(infoHashHex string) error {
+	torrent, err := c.lookupTorrent(infoHashHex)
+	if err != nil {
+		return err
+	}
+	return torrent.Recheck(c.ctx)
+}
+
+// ReannounceTorrent announces a torrent to the tracker at trackerURL
+// right away, or to all its trackers when trackerURL is empty. Trackers
+// announced to too recently are skipped.
+func (c *Client) ReannounceTorrent(infoHashHex, trackerURL string) error {
+	torrent, err := c.lookupTorrent(infoHashHex)
+	if err != nil {
+		return err
+	}
+	return torrent.Reannounce(c.ctx, trackerURL)
+}
+
+// VerifyTorrent hash-checks a torrent's downloaded data and reports the
+// corrupt pieces and file ranges, without changing anything.
+func (c *Client) VerifyTorrent(infoHashHex string) (*storage.IntegrityReport, error) {
+	torrent, err := c.lookupTorrent(infoHashHex)
+	if err != nil {
+		return nil, err
+	}
+	return torrent.VerifyData(c.ctx)
+}
+
+// RedownloadPieces downloads the given pieces of a torrent again, such as
+// the corrupt ones VerifyTorrent reported.
+func (c *Client) RedownloadPieces(infoHashHex string, pieces []uint32) error {
+	torrent, err := c.lookupTorrent(infoHashHex)
+	if err != nil {
+		return err
+	}
+	return torrent.RedownloadPieces(pieces)
+}
+
+// GetTorrentPeers returns a snapshot of the torrent's connected peers.
+func (c *Client) GetTorrentPeers(infoHashHex string) ([]torrent.PeerInfo, error) {
+	torrent, err := c.lookupTorrent(infoHashHex)
+	if err != nil {
+		return nil, err
+	}
+	return torrent.GetPeers(), nil
+}
+
+// ExportTorrentFile returns the original .torrent file of a loaded torrent.
+func (c *Client) ExportTorrentFile(infoHashHex string) ([]byte, error) {
+	torrent, err := c.lookupTorrent(infoHashHex)
+	if err != nil {
+		return nil, err
+	}
+	return torrent.TorrentFile(), nil
+}
+
+// SaveTorrentFile asks the user where to save the torrent's .torrent file
+// and writes it there. It returns the chosen path, or "" if cancelled.
+func (c *Client) SaveTorrentFile(infoHashHex string) (string, error) {
+	torrent, err := c.lookupTorrent(infoHashHex)
+	if err != nil {
+		return "", err
+	}
+
+	path, err := runtime.SaveFileDialog(c.ctx, runtime.SaveDialogOptions{
+		Title:           "Save Torrent File",
+		DefaultFilename: torrent.Metainfo.Info.Name + ".torrent",
+		Filters: []runtime.FileFilter{
+			{DisplayName: "Torrent Files (*.torrent)", Pattern: "*.torrent"},
+		},
+	})
+	if err != nil || path == "" {
+		return "", err
+	}
+
+	if err := atomicfile.WriteFile(path, torrent.TorrentFile(), 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// GetMagnetLink returns the magnet URI of a loaded torrent.
+func (c *Client) GetMagnetLink(infoHashHex string) (string, error) {
+	torrent, err := c.lookupTorrent(infoHashHex)
+	if err != nil {
+		return "", err
+	}
+	return torrent.MagnetURI(), nil
+}
+
+func (c *Client) lookupTorrent(infoHashHex string) (*torrent.Torrent, error) {
 	var infoHash [sha1.Size]byte
 
 	bytes, err := hex.DecodeString(infoHashHex)
 	if err != nil || len(bytes) != sha1.Size {
-		return err
+		return nil, fmt.Errorf("%w %q", ErrInvalidInfoHash, infoHashHex)
 	}
 	copy(infoHash[:], bytes)
 
@@ -164,10 +757,33 @@ func (c *Client) UpdateTorrentConfig(infoHashHex string, cfg *torrent.Config) er
 	torrent, ok := c.torrents[infoHash]
 	c.mu.RUnlock()
 	if !ok {
-		c.log.Warn("torrent not found for config update", "info_hash", infoHashHex)
-		return nil
+		return nil, ErrTorrentNotFound
+	}
+
+	return torrent, nil
+}
+
+func (c *Client) GetTorrentStats(infoHashHex string) (*torrent.Stats, error) {
+	torrent, err := c.lookupTorrent(infoHashHex)
+	if err != nil {
+		return nil, err
 	}
+	return torrent.GetStats(), nil
+}
 
+func (c *Client) GetTorrentConfig(infoHashHex string) (*torrent.Config, error) {
+	torrent, err := c.lookupTorrent(infoHashHex)
+	if err != nil {
+		return nil, err
+	}
+	return torrent.GetConfig(), nil
+}
+
+func (c *Client) UpdateTorrentConfig(infoHashHex string, cfg *torrent.Config) error {
+	torrent, err := c.lookupTorrent(infoHashHex)
+	if err != nil {
+		return err
+	}
 	torrent.UpdateConfig(cfg)
 	return nil
 }
@@ -177,21 +793,10 @@ func (c *Client) GetPeerMessageHistory(
 	peerAddr string,
 	limit int,
 ) ([]*peer.Event, error) {
-	var infoHash [sha1.Size]byte
-
-	bytes, err := hex.DecodeString(infoHashHex)
-	if err != nil || len(bytes) != sha1.Size {
+	torrent, err := c.lookupTorrent(infoHashHex)
+	if err != nil {
 		return nil, err
 	}
-	copy(infoHash[:], bytes)
-
-	c.mu.RLock()
-	torrent, ok := c.torrents[infoHash]
-	c.mu.RUnlock()
-	if !ok {
-		return nil, nil
-	}
-
 	return torrent.GetPeerMessageHistory(peerAddr, limit)
 }
 
@@ -205,15 +810,17 @@ func (c *Client) SelectDownloadDirectory() (string, error) {
 	return path, nil
 }
 
-func generateClientID() ([sha1.Size]byte, error) {
-	var peerID [sha1.Size]byte
-
-	prefix := []byte("-RBBT-")
-	copy(peerID[:], prefix)
-
-	if _, err := rand.Read(peerID[len(prefix):]); err != nil {
-		return [sha1.Size]byte{}, err
+func indexEntry(m *meta.Metainfo) index.Entry {
+	files := make([]string, 0, len(m.Info.Files))
+	for _, f := range m.Info.Files {
+		files = append(files, path.Join(f.Path...))
 	}
 
-	return peerID, nil
+	return index.Entry{
+		InfoHash: hex.EncodeToString(m.InfoHash[:]),
+		Name:     m.Info.Name,
+		Size:     m.Size,
+		Files:    files,
+		Source:   index.SourceAdded,
+	}
 }
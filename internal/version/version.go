@@ -0,0 +1,37 @@
+// Package version holds the client identity shared by every subsystem that
+// introduces rabbit to the outside world (tracker User-Agent, peer ID).
+package version
+
+import "fmt"
+
+const (
+	// Name is the client name reported in the HTTP User-Agent.
+	Name = "rabbit"
+
+	// clientCode is the two letter Azureus-style client identifier used as
+	// the peer ID prefix.
+	clientCode = "RB"
+
+	Major = 0
+	Minor = 1
+	Patch = 0
+)
+
+// String returns the semantic version, e.g. "0.1.0".
+func String() string {
+	return fmt.Sprintf("%d.%d.%d", Major, Minor, Patch)
+}
+
+// UserAgent returns the HTTP User-Agent, e.g. "rabbit/0.1.0".
+func UserAgent() string {
+	return Name + "/" + String()
+}
+
+// PeerIDPrefix returns the Azureus-style peer ID prefix matching UserAgent,
+// e.g. "-RB0100-".
+//
+// Trackers that whitelist clients compare the two, so they must always be
+// derived from the same version.
+func PeerIDPrefix() string {
+	return fmt.Sprintf("-%s%d%d%d0-", clientCode, Major%10, Minor%10, Patch%10)
+}
@@ -19,7 +19,7 @@ var assets embed.FS
 func main() {
 	setupLogger()
 
-	client, err := ui.NewClient()
+	client, err := ui.NewClient(nil)
 	if err != nil {
 		slog.Error("failed to initialize rabbit client", "error", err.Error())
 		os.Exit(1)
@@ -34,6 +34,7 @@ func main() {
 		OnStartup:        func(ctx context.Context) { client.Startup(ctx) },
 		BackgroundColour: &options.RGBA{R: 27, G: 38, B: 54, A: 1},
 		Bind:             []any{client},
+		ErrorFormatter:   ui.FormatError,
 	})
 	if err != nil {
 		slog.Error("failed to start wails", "error", err.Error())
@@ -47,6 +48,6 @@ func setupLogger() {
 	opts.SlogOpts.AddSource = true
 
 	h := logging.NewPrettyHandler(os.Stdout, &opts)
-	l := slog.New(h)
+	l := slog.New(logging.NewDedupHandler(h, nil))
 	slog.SetDefault(l)
 }
@@ -1,6 +1,7 @@
 package availabilitybucket
 
 import (
+	"iter"
 	"math/bits"
 	"math/rand"
 	"sync"
@@ -115,6 +116,21 @@ func (b *Bucket) Move(i, delta int) {
 	b.mut.Lock()
 	defer b.mut.Unlock()
 
+	b.move(i, delta)
+}
+
+// MoveAll changes the availability of every item in items by delta under
+// a single lock, as when a peer's whole bitfield arrives or leaves.
+func (b *Bucket) MoveAll(items iter.Seq[int], delta int) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	for i := range items {
+		b.move(i, delta)
+	}
+}
+
+func (b *Bucket) move(i, delta int) {
 	oldA := int(b.avail[i])
 	newA := min(b.maxAvail, max(0, oldA+delta))
 
@@ -215,6 +215,41 @@ func TestMoveBasic(t *testing.T) {
 	checkInvariants(t, b, n)
 }
 
+func TestMoveAll(t *testing.T) {
+	n, maxAvail := 10, 3
+	b := NewBucket(n, maxAvail)
+	b.Move(2, 1)
+
+	even := func(yield func(int) bool) {
+		for i := 0; i < n; i += 2 {
+			if !yield(i) {
+				return
+			}
+		}
+	}
+
+	b.MoveAll(even, 1)
+	for i := range n {
+		want := 0
+		switch {
+		case i == 2:
+			want = 2
+		case i%2 == 0:
+			want = 1
+		}
+		if got := b.Availability(i); got != want {
+			t.Fatalf("item %d: expected avail=%d, got %d", i, want, got)
+		}
+	}
+	checkInvariants(t, b, n)
+
+	b.MoveAll(even, -1)
+	if b.Availability(2) != 1 || b.Availability(4) != 0 {
+		t.Fatalf("expected avail 1 and 0, got %d and %d", b.Availability(2), b.Availability(4))
+	}
+	checkInvariants(t, b, n)
+}
+
 // TestMoveBoundaries tests clamping at 0 and maxAvail.
 func TestMoveBoundaries(t *testing.T) {
 	n, maxAvail := 2, 3
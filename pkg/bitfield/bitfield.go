@@ -104,6 +104,24 @@ func (bf Bitfield) Equals(other Bitfield) bool {
 	return bytes.Equal(bf, other)
 }
 
+// Missing returns the indices set in bf but not in other, in ascending
+// order. Bits past the end of other count as unset.
+func (bf Bitfield) Missing(other Bitfield) []int {
+	var out []int
+	for i, b := range bf {
+		if i < len(other) {
+			b &^= other[i]
+		}
+		for b != 0 {
+			lead := bits.LeadingZeros8(b)
+			out = append(out, i*8+lead)
+			b &^= 0x80 >> lead
+		}
+	}
+
+	return out
+}
+
 // Clone returns an independent copy.
 func (bf Bitfield) Clone() Bitfield { return bf.Bytes() }
 
@@ -111,3 +111,29 @@ func TestCountAndEquals(t *testing.T) {
 		t.Fatalf("Equals should detect difference")
 	}
 }
+
+func TestMissing(t *testing.T) {
+	bf := New(20)
+	for _, i := range []int{0, 3, 7, 9, 17} {
+		bf.Set(i)
+	}
+
+	other := New(10)
+	other.Set(3)
+	other.Set(9)
+
+	got := bf.Missing(other)
+	want := []int{0, 7, 17}
+	if len(got) != len(want) {
+		t.Fatalf("Missing() = %v; want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Missing() = %v; want %v", got, want)
+		}
+	}
+
+	if got := bf.Missing(bf); len(got) != 0 {
+		t.Fatalf("Missing(self) = %v; want none", got)
+	}
+}
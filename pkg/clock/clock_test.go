@@ -0,0 +1,81 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake_Ticker(t *testing.T) {
+	start := time.Unix(1000, 0)
+	c := NewFake(start)
+	tk := c.NewTicker(time.Second)
+	defer tk.Stop()
+
+	c.Advance(500 * time.Millisecond)
+	select {
+	case <-tk.C():
+		t.Fatal("ticker fired early")
+	default:
+	}
+
+	c.Advance(time.Second)
+	select {
+	case got := <-tk.C():
+		if want := start.Add(time.Second); !got.Equal(want) {
+			t.Errorf("tick at %v, want %v", got, want)
+		}
+	default:
+		t.Fatal("ticker did not fire")
+	}
+
+	// Unreceived ticks are dropped, not queued.
+	c.Advance(5 * time.Second)
+	<-tk.C()
+	select {
+	case <-tk.C():
+		t.Error("ticker queued more than one tick")
+	default:
+	}
+
+	if got, want := c.Now(), start.Add(6500*time.Millisecond); !got.Equal(want) {
+		t.Errorf("Now() = %v, want %v", got, want)
+	}
+}
+
+func TestFake_Timer(t *testing.T) {
+	c := NewFake(time.Unix(0, 0))
+	tm := c.NewTimer(time.Second)
+
+	if !tm.Stop() {
+		t.Error("Stop() on pending timer = false")
+	}
+	c.Advance(2 * time.Second)
+	select {
+	case <-tm.C():
+		t.Fatal("stopped timer fired")
+	default:
+	}
+
+	if tm.Reset(time.Second) {
+		t.Error("Reset() on stopped timer = true")
+	}
+	c.Advance(time.Second)
+	select {
+	case <-tm.C():
+	default:
+		t.Fatal("reset timer did not fire")
+	}
+	if tm.Stop() {
+		t.Error("Stop() on fired timer = true")
+	}
+}
+
+func TestOr(t *testing.T) {
+	if Or(nil) != Real {
+		t.Error("Or(nil) is not Real")
+	}
+	f := NewFake(time.Now())
+	if Or(f) != Clock(f) {
+		t.Error("Or(f) did not return f")
+	}
+}
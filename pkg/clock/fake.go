@@ -0,0 +1,149 @@
+package clock
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Fake is a Clock that only moves when told to. Tickers and timers fire
+// as Advance passes their deadlines; like the time package, a tick is
+// dropped if the previous one hasn't been received.
+type Fake struct {
+	mut     sync.Mutex
+	now     time.Time
+	waiters []*waiter
+}
+
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	return f.now
+}
+
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// Advance moves the clock forward by d, firing everything due on the way
+// in deadline order.
+func (f *Fake) Advance(d time.Duration) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	end := f.now.Add(d)
+	for {
+		w := f.nextDue(end)
+		if w == nil {
+			break
+		}
+
+		f.now = w.deadline
+		select {
+		case w.ch <- f.now:
+		default:
+		}
+
+		if w.period > 0 {
+			w.deadline = w.deadline.Add(w.period)
+		} else {
+			f.remove(w)
+		}
+	}
+	f.now = end
+}
+
+// nextDue returns the waiter with the earliest deadline not after end.
+// Called with f.mut held.
+func (f *Fake) nextDue(end time.Time) *waiter {
+	var next *waiter
+	for _, w := range f.waiters {
+		if w.deadline.After(end) {
+			continue
+		}
+		if next == nil || w.deadline.Before(next.deadline) {
+			next = w
+		}
+	}
+	return next
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return &fakeTicker{f.add(d, d)}
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return &fakeTimer{f.add(d, 0)}
+}
+
+func (f *Fake) add(d, period time.Duration) *waiter {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	w := &waiter{
+		clock:    f,
+		ch:       make(chan time.Time, 1),
+		deadline: f.now.Add(d),
+		period:   period,
+	}
+	f.waiters = append(f.waiters, w)
+	return w
+}
+
+// remove reports whether w was pending. Called with f.mut held.
+func (f *Fake) remove(w *waiter) bool {
+	i := slices.Index(f.waiters, w)
+	if i < 0 {
+		return false
+	}
+	f.waiters = slices.Delete(f.waiters, i, i+1)
+	return true
+}
+
+type waiter struct {
+	clock    *Fake
+	ch       chan time.Time
+	deadline time.Time
+	// period is zero for timers.
+	period time.Duration
+}
+
+func (w *waiter) stop() bool {
+	w.clock.mut.Lock()
+	defer w.clock.mut.Unlock()
+
+	return w.clock.remove(w)
+}
+
+func (w *waiter) reset(d time.Duration) bool {
+	w.clock.mut.Lock()
+	defer w.clock.mut.Unlock()
+
+	pending := w.clock.remove(w)
+	w.deadline = w.clock.now.Add(d)
+	if w.period > 0 {
+		w.period = d
+	}
+	w.clock.waiters = append(w.clock.waiters, w)
+	return pending
+}
+
+type fakeTicker struct{ w *waiter }
+
+func (t *fakeTicker) C() <-chan time.Time   { return t.w.ch }
+func (t *fakeTicker) Stop()                 { t.w.stop() }
+func (t *fakeTicker) Reset(d time.Duration) { t.w.reset(d) }
+
+type fakeTimer struct{ w *waiter }
+
+func (t *fakeTimer) C() <-chan time.Time        { return t.w.ch }
+func (t *fakeTimer) Stop() bool                 { return t.w.stop() }
+func (t *fakeTimer) Reset(d time.Duration) bool { return t.w.reset(d) }
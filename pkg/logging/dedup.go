@@ -0,0 +1,224 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+type DedupOptions struct {
+	// Window is how long a message's budget lasts.
+	Window time.Duration
+	// Burst is how many similar records pass per Window. The rest are
+	// counted and reported in one summary record once the window ends.
+	Burst int
+	// Level is the lowest level deduplicated; records below it always
+	// pass.
+	Level slog.Leveler
+}
+
+func DefaultDedupOptions() DedupOptions {
+	return DedupOptions{
+		Window: time.Minute,
+		Burst:  5,
+		Level:  slog.LevelInfo,
+	}
+}
+
+// sweepInterval is how often records look for windows that ended, to
+// forget messages that stopped repeating.
+const sweepInterval = time.Second
+
+// DedupHandler drops runs of similar records, so a flapping peer or dead
+// tracker can't flood the log. Records are similar when they share a
+// level, message and logger attributes (With and WithGroup); their own
+// attributes, like the error, may differ.
+type DedupHandler struct {
+	next  slog.Handler
+	key   string
+	state *dedupState
+}
+
+type dedupState struct {
+	opts DedupOptions
+
+	mu        sync.Mutex
+	entries   map[string]*dedupEntry
+	lastSweep time.Time
+	// flush is armed while messages are suppressed, so their summary goes
+	// out when the window ends even if nothing is logged after it.
+	flush *time.Timer
+}
+
+type dedupEntry struct {
+	// handler is the one the records came through, which the summary
+	// goes out on so it keeps their logger attributes.
+	handler    slog.Handler
+	level      slog.Level
+	message    string
+	start      time.Time
+	count      int
+	suppressed int
+}
+
+func NewDedupHandler(next slog.Handler, opts *DedupOptions) *DedupHandler {
+	o := DefaultDedupOptions()
+	if opts != nil {
+		o = *opts
+	}
+	if o.Window <= 0 {
+		o.Window = time.Minute
+	}
+	if o.Burst <= 0 {
+		o.Burst = 1
+	}
+	if o.Level == nil {
+		o.Level = slog.LevelInfo
+	}
+
+	return &DedupHandler{
+		next: next,
+		state: &dedupState{
+			opts:    o,
+			entries: make(map[string]*dedupEntry),
+		},
+	}
+}
+
+func (h *DedupHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *DedupHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < h.state.opts.Level.Level() {
+		return h.next.Handle(ctx, r)
+	}
+
+	summaries, pass := h.state.admit(h, r)
+	for _, s := range summaries {
+		_ = s.handler.Handle(ctx, s.record())
+	}
+	if !pass {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *DedupHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	var key strings.Builder
+	key.WriteString(h.key)
+	for _, attr := range attrs {
+		key.WriteString(attr.String())
+		key.WriteByte(' ')
+	}
+	return &DedupHandler{next: h.next.WithAttrs(attrs), key: key.String(), state: h.state}
+}
+
+func (h *DedupHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &DedupHandler{next: h.next.WithGroup(name), key: h.key + name + ".", state: h.state}
+}
+
+// admit counts r against its window, returning whether it should be
+// logged and the summaries of windows that ended.
+func (st *dedupState) admit(h *DedupHandler, r slog.Record) ([]*dedupEntry, bool) {
+	now := r.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	key := fmt.Sprintf("%s%d %s", h.key, r.Level, r.Message)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var summaries []*dedupEntry
+	if now.Sub(st.lastSweep) >= sweepInterval {
+		st.lastSweep = now
+		summaries = st.sweep(now)
+	}
+
+	e, ok := st.entries[key]
+	if ok && now.Sub(e.start) >= st.opts.Window {
+		if e.suppressed > 0 {
+			summaries = append(summaries, e)
+		}
+		ok = false
+	}
+	if !ok {
+		e = &dedupEntry{handler: h.next, level: r.Level, message: r.Message, start: now}
+		st.entries[key] = e
+	}
+
+	e.count++
+	if e.count > st.opts.Burst {
+		e.suppressed++
+		if st.flush == nil {
+			st.flush = time.AfterFunc(e.start.Add(st.opts.Window).Sub(now), st.flushEnded)
+		}
+		return summaries, false
+	}
+	return summaries, true
+}
+
+// sweep forgets the windows that ended by now, returning those that
+// suppressed records. Called with st.mu held.
+func (st *dedupState) sweep(now time.Time) []*dedupEntry {
+	var summaries []*dedupEntry
+	for k, e := range st.entries {
+		if now.Sub(e.start) < st.opts.Window {
+			continue
+		}
+		if e.suppressed > 0 {
+			summaries = append(summaries, e)
+		}
+		delete(st.entries, k)
+	}
+	return summaries
+}
+
+// flushEnded logs the summaries of the windows that ended and rearms for
+// the next one still suppressing.
+func (st *dedupState) flushEnded() {
+	now := time.Now()
+
+	st.mu.Lock()
+	summaries := st.sweep(now)
+	st.flush = nil
+	var next time.Time
+	for _, e := range st.entries {
+		if end := e.start.Add(st.opts.Window); e.suppressed > 0 && (next.IsZero() || end.Before(next)) {
+			next = end
+		}
+	}
+	if !next.IsZero() {
+		st.flush = time.AfterFunc(next.Sub(now), st.flushEnded)
+	}
+	st.mu.Unlock()
+
+	for _, e := range summaries {
+		_ = e.handler.Handle(context.Background(), e.record())
+	}
+}
+
+func (e *dedupEntry) record() slog.Record {
+	r := slog.NewRecord(
+		time.Now(),
+		e.level,
+		fmt.Sprintf("%d similar messages suppressed", e.suppressed),
+		0,
+	)
+	r.AddAttrs(
+		slog.String("message", e.message),
+		slog.Time("since", e.start),
+	)
+	return r
+}
@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// captureHandler keeps the messages of the records it's given.
+type captureHandler struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (h *captureHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *captureHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *captureHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *captureHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.messages = append(h.messages, r.Message)
+	return nil
+}
+
+func (h *captureHandler) logged() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.messages...)
+}
+
+func newTestDedup(window time.Duration) (*slog.Logger, *captureHandler) {
+	capture := &captureHandler{}
+	opts := DedupOptions{Window: window, Burst: 2, Level: slog.LevelInfo}
+	return slog.New(NewDedupHandler(capture, &opts)), capture
+}
+
+func TestDedupHandler_SuppressesPastBurst(t *testing.T) {
+	log, capture := newTestDedup(time.Hour)
+
+	for range 5 {
+		log.Info("tracker down")
+	}
+	log.Info("peer connected")
+	for range 3 {
+		log.Debug("tracker down")
+	}
+
+	got := capture.logged()
+	want := []string{"tracker down", "tracker down", "peer connected", "tracker down", "tracker down", "tracker down"}
+	if len(got) != len(want) {
+		t.Fatalf("logged %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("logged %q, want %q", got, want)
+		}
+	}
+}
+
+func TestDedupHandler_KeepsLoggerAttrsApart(t *testing.T) {
+	log, capture := newTestDedup(time.Hour)
+
+	a, b := log.With("peer", "a"), log.With("peer", "b")
+	for range 3 {
+		a.Info("handshake failed")
+		b.Info("handshake failed")
+	}
+
+	if got := len(capture.logged()); got != 4 {
+		t.Errorf("logged %d records, want the burst of 2 for each peer", got)
+	}
+}
+
+func TestDedupHandler_SummarizesWhenWindowEnds(t *testing.T) {
+	log, capture := newTestDedup(20 * time.Millisecond)
+
+	for range 5 {
+		log.Info("tracker down")
+	}
+
+	// Nothing else is logged: the summary has to come from the timer.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got := capture.logged()
+		if len(got) == 3 {
+			if want := "3 similar messages suppressed"; got[2] != want {
+				t.Fatalf("summary = %q, want %q", got[2], want)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("logged %q, want a summary after the window", got)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// A new window has a fresh budget.
+	log.Info("tracker down")
+	if got := capture.logged(); len(got) != 4 || got[3] != "tracker down" {
+		t.Errorf("logged %q, want the message through again", got)
+	}
+}
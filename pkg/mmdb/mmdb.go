@@ -0,0 +1,401 @@
+// Package mmdb reads MaxMind DB files (GeoLite2, GeoIP2 and compatible).
+//
+// Only lookups are supported. Values decode to map[string]any, []any,
+// string, []byte, float64, float32, bool, int32 and unsigned integers,
+// which are all widened to uint64 (uint128 is returned as []byte).
+package mmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+)
+
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+var (
+	ErrInvalidDatabase = errors.New("mmdb: invalid database")
+	ErrIPv6InIPv4DB    = errors.New("mmdb: IPv6 lookup in IPv4-only database")
+)
+
+// maxMetadataSize bounds how far from the end of the file we look for the
+// metadata marker.
+const maxMetadataSize = 128 * 1024
+
+// dataSectionSeparator is the run of zero bytes between the search tree
+// and the data section.
+const dataSectionSeparator = 16
+
+type Metadata struct {
+	NodeCount    uint32
+	RecordSize   uint16
+	IPVersion    uint16
+	DatabaseType string
+	BuildEpoch   uint64
+}
+
+type Reader struct {
+	tree      []byte
+	data      []byte
+	meta      Metadata
+	nodeBytes int
+	ipv4Start uint32
+}
+
+// Open reads the whole database at path into memory.
+func Open(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return FromBytes(buf)
+}
+
+func FromBytes(buf []byte) (*Reader, error) {
+	searchFrom := max(0, len(buf)-maxMetadataSize)
+	idx := bytes.LastIndex(buf[searchFrom:], metadataMarker)
+	if idx < 0 {
+		return nil, fmt.Errorf("%w: metadata marker not found", ErrInvalidDatabase)
+	}
+	metaStart := searchFrom + idx + len(metadataMarker)
+
+	d := decoder{buf: buf[metaStart:]}
+	raw, _, err := d.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: metadata: %v", ErrInvalidDatabase, err)
+	}
+	m, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", ErrInvalidDatabase)
+	}
+
+	meta := Metadata{
+		NodeCount:    uint32(asUint(m["node_count"])),
+		RecordSize:   uint16(asUint(m["record_size"])),
+		IPVersion:    uint16(asUint(m["ip_version"])),
+		BuildEpoch:   asUint(m["build_epoch"]),
+		DatabaseType: asString(m["database_type"]),
+	}
+
+	switch meta.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%w: unsupported record size %d", ErrInvalidDatabase, meta.RecordSize)
+	}
+
+	nodeBytes := int(meta.RecordSize) / 4
+	treeSize := int(meta.NodeCount) * nodeBytes
+	if treeSize+dataSectionSeparator > searchFrom+idx {
+		return nil, fmt.Errorf("%w: search tree exceeds file", ErrInvalidDatabase)
+	}
+
+	r := &Reader{
+		tree:      buf[:treeSize],
+		data:      buf[treeSize+dataSectionSeparator : searchFrom+idx],
+		meta:      meta,
+		nodeBytes: nodeBytes,
+	}
+
+	if meta.IPVersion == 6 {
+		node := uint32(0)
+		for i := 0; i < 96 && node < meta.NodeCount; i++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+
+	return r, nil
+}
+
+func (r *Reader) Metadata() Metadata {
+	return r.meta
+}
+
+// Lookup returns the record for ip, or nil if the database has none.
+func (r *Reader) Lookup(ip netip.Addr) (any, error) {
+	ip = ip.Unmap()
+
+	var (
+		node uint32
+		bits []byte
+	)
+	switch {
+	case ip.Is4():
+		b := ip.As4()
+		bits = b[:]
+		node = r.ipv4Start
+	case r.meta.IPVersion == 4:
+		return nil, ErrIPv6InIPv4DB
+	default:
+		b := ip.As16()
+		bits = b[:]
+	}
+
+	for i := 0; i < len(bits)*8 && node < r.meta.NodeCount; i++ {
+		bit := (bits[i/8] >> (7 - uint(i%8))) & 1
+		node = r.record(node, bit)
+	}
+
+	switch {
+	case node == r.meta.NodeCount:
+		return nil, nil
+	case node < r.meta.NodeCount:
+		return nil, fmt.Errorf("%w: lookup did not reach a leaf", ErrInvalidDatabase)
+	}
+
+	offset := int(node-r.meta.NodeCount) - dataSectionSeparator
+	if offset < 0 || offset >= len(r.data) {
+		return nil, fmt.Errorf("%w: data pointer out of range", ErrInvalidDatabase)
+	}
+
+	d := decoder{buf: r.data}
+	v, _, err := d.decode(offset)
+	return v, err
+}
+
+func (r *Reader) record(node uint32, bit byte) uint32 {
+	b := r.tree[int(node)*r.nodeBytes:]
+
+	switch r.meta.RecordSize {
+	case 24:
+		if bit == 0 {
+			return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		}
+		return uint32(b[3])<<16 | uint32(b[4])<<8 | uint32(b[5])
+	case 28:
+		if bit == 0 {
+			return uint32(b[3]&0xf0)<<20 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		}
+		return uint32(b[3]&0x0f)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+	default:
+		if bit == 0 {
+			return binary.BigEndian.Uint32(b[0:4])
+		}
+		return binary.BigEndian.Uint32(b[4:8])
+	}
+}
+
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// maxDepth guards against maliciously nested data.
+const maxDepth = 64
+
+type decoder struct {
+	buf   []byte
+	depth int
+}
+
+func (d *decoder) decode(offset int) (any, int, error) {
+	d.depth++
+	defer func() { d.depth-- }()
+	if d.depth > maxDepth {
+		return nil, 0, errors.New("data nested too deeply")
+	}
+
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == typePointer {
+		ptr, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		v, _, err := d.decode(ptr)
+		return v, next, err
+	}
+
+	return d.value(typ, size, offset)
+}
+
+func (d *decoder) control(offset int) (typ, size, next int, err error) {
+	if offset >= len(d.buf) {
+		return 0, 0, 0, errors.New("unexpected end of data")
+	}
+	ctrl := d.buf[offset]
+	offset++
+
+	typ = int(ctrl >> 5)
+	if typ == typeExtended {
+		if offset >= len(d.buf) {
+			return 0, 0, 0, errors.New("unexpected end of data")
+		}
+		typ = 7 + int(d.buf[offset])
+		offset++
+	}
+
+	size = int(ctrl & 0x1f)
+	if typ == typePointer {
+		return typ, size, offset, nil
+	}
+
+	if size >= 29 {
+		n := size - 28
+		if offset+n > len(d.buf) {
+			return 0, 0, 0, errors.New("unexpected end of data")
+		}
+		ext := 0
+		for _, b := range d.buf[offset : offset+n] {
+			ext = ext<<8 | int(b)
+		}
+		offset += n
+
+		switch n {
+		case 1:
+			size = 29 + ext
+		case 2:
+			size = 285 + ext
+		default:
+			size = 65821 + ext
+		}
+	}
+
+	return typ, size, offset, nil
+}
+
+func (d *decoder) pointer(ctrlSize, offset int) (int, int, error) {
+	ss := (ctrlSize >> 3) & 0x3
+	n := ss + 1
+	if offset+n > len(d.buf) {
+		return 0, 0, errors.New("unexpected end of data")
+	}
+
+	var ptr int
+	if ss != 3 {
+		ptr = ctrlSize & 0x7
+	}
+	for _, b := range d.buf[offset : offset+n] {
+		ptr = ptr<<8 | int(b)
+	}
+
+	switch ss {
+	case 1:
+		ptr += 2048
+	case 2:
+		ptr += 526336
+	}
+
+	return ptr, offset + n, nil
+}
+
+func (d *decoder) value(typ, size, offset int) (any, int, error) {
+	switch typ {
+	case typeMap:
+		// Every entry takes at least two bytes, so a size the data can't
+		// hold is corrupt and mustn't size the allocation.
+		if size > (len(d.buf)-offset)/2 {
+			return nil, 0, errors.New("unexpected end of data")
+		}
+		m := make(map[string]any, size)
+		for i := 0; i < size; i++ {
+			k, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			v, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			offset = next
+		}
+		return m, offset, nil
+
+	case typeArray:
+		if size > len(d.buf)-offset {
+			return nil, 0, errors.New("unexpected end of data")
+		}
+		arr := make([]any, 0, size)
+		for i := 0; i < size; i++ {
+			v, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			arr = append(arr, v)
+			offset = next
+		}
+		return arr, offset, nil
+
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > len(d.buf) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+	raw := d.buf[offset : offset+size]
+	next := offset + size
+
+	switch typ {
+	case typeString:
+		return string(raw), next, nil
+	case typeBytes, typeUint128:
+		return append([]byte(nil), raw...), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float size")
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(raw)), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, errors.New("invalid integer size")
+		}
+		var v uint64
+		for _, b := range raw {
+			v = v<<8 | uint64(b)
+		}
+		return v, next, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, errors.New("invalid integer size")
+		}
+		var v uint32
+		for _, b := range raw {
+			v = v<<8 | uint32(b)
+		}
+		return int32(v), next, nil
+	}
+
+	return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+}
+
+func asUint(v any) uint64 {
+	u, _ := v.(uint64)
+	return u
+}
+
+func asString(v any) string {
+	s, _ := v.(string)
+	return s
+}
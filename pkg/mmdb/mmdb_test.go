@@ -0,0 +1,153 @@
+package mmdb
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+)
+
+// enc is a tiny MMDB data encoder, enough to build test databases.
+type enc struct{ bytes.Buffer }
+
+func (e *enc) ctrl(typ, size int) {
+	if typ <= 7 {
+		e.WriteByte(byte(typ<<5 | size))
+		return
+	}
+	e.WriteByte(byte(size))
+	e.WriteByte(byte(typ - 7))
+}
+
+func (e *enc) str(s string) {
+	e.ctrl(typeString, len(s))
+	e.WriteString(s)
+}
+
+func (e *enc) uint(typ int, v uint64, n int) {
+	e.ctrl(typ, n)
+	for i := n - 1; i >= 0; i-- {
+		e.WriteByte(byte(v >> (8 * i)))
+	}
+}
+
+func (e *enc) mapHeader(n int) { e.ctrl(typeMap, n) }
+
+// buildDB returns an IPv4 database with record size 24 and one node: the
+// lower half of the address space (0.0.0.0/1) maps to the record, the
+// upper half has no data.
+func buildDB(t *testing.T) []byte {
+	t.Helper()
+
+	var data enc
+	data.mapHeader(2)
+	data.str("country")
+	data.mapHeader(1)
+	data.str("iso_code")
+	data.str("US")
+	data.str("autonomous_system_number")
+	data.uint(typeUint32, 15169, 4)
+
+	const nodeCount = 1
+	left := nodeCount + dataSectionSeparator + 0
+	right := nodeCount
+
+	var db bytes.Buffer
+	db.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left)})
+	db.Write([]byte{byte(right >> 16), byte(right >> 8), byte(right)})
+	db.Write(make([]byte, dataSectionSeparator))
+	db.Write(data.Bytes())
+
+	var meta enc
+	meta.mapHeader(4)
+	meta.str("node_count")
+	meta.uint(typeUint32, nodeCount, 4)
+	meta.str("record_size")
+	meta.uint(typeUint16, 24, 2)
+	meta.str("ip_version")
+	meta.uint(typeUint16, 4, 2)
+	meta.str("database_type")
+	meta.str("Test-DB")
+
+	db.Write(metadataMarker)
+	db.Write(meta.Bytes())
+
+	return db.Bytes()
+}
+
+func TestReader_Lookup(t *testing.T) {
+	r, err := FromBytes(buildDB(t))
+	if err != nil {
+		t.Fatalf("FromBytes() error = %v", err)
+	}
+
+	if md := r.Metadata(); md.DatabaseType != "Test-DB" || md.NodeCount != 1 {
+		t.Errorf("Metadata() = %+v", md)
+	}
+
+	v, err := r.Lookup(netip.MustParseAddr("8.8.8.8"))
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	rec, ok := v.(map[string]any)
+	if !ok {
+		t.Fatalf("Lookup() = %T, want map", v)
+	}
+	if got := rec["country"].(map[string]any)["iso_code"]; got != "US" {
+		t.Errorf("iso_code = %v, want US", got)
+	}
+	if got := rec["autonomous_system_number"]; got != uint64(15169) {
+		t.Errorf("asn = %v, want 15169", got)
+	}
+
+	v, err = r.Lookup(netip.MustParseAddr("200.1.1.1"))
+	if err != nil || v != nil {
+		t.Errorf("Lookup(unknown) = %v, %v; want nil, nil", v, err)
+	}
+
+	if _, err := r.Lookup(netip.MustParseAddr("2001:db8::1")); err != ErrIPv6InIPv4DB {
+		t.Errorf("Lookup(ipv6) error = %v, want ErrIPv6InIPv4DB", err)
+	}
+}
+
+func TestDecoder_Pointer(t *testing.T) {
+	var e enc
+	e.str("shared") // offset 0
+	start := e.Len()
+	e.ctrl(typeArray, 2)
+	e.WriteByte(byte(typePointer << 5)) // pointer, ss=0, vvv=0
+	e.WriteByte(0)
+	e.ctrl(typeBool, 1)
+
+	d := decoder{buf: e.Bytes()}
+	v, next, err := d.decode(start)
+	if err != nil {
+		t.Fatalf("decode() error = %v", err)
+	}
+	if next != e.Len() {
+		t.Errorf("next = %d, want %d", next, e.Len())
+	}
+	arr := v.([]any)
+	if arr[0] != "shared" || arr[1] != true {
+		t.Errorf("decode() = %v", arr)
+	}
+}
+
+func TestDecoder_OversizedContainer(t *testing.T) {
+	for _, typ := range []int{typeMap, typeArray} {
+		var e enc
+		// Size 65821 + 0xffffff, followed by nothing.
+		e.ctrl(typ, 31)
+		e.Write([]byte{0xff, 0xff, 0xff})
+
+		d := decoder{buf: e.Bytes()}
+		if _, _, err := d.decode(0); err == nil {
+			t.Errorf("decode(type %d) accepted a size past the data", typ)
+		}
+	}
+}
+
+func TestFromBytes_Invalid(t *testing.T) {
+	if _, err := FromBytes([]byte("not a database")); err == nil {
+		t.Error("FromBytes() accepted garbage")
+	}
+}
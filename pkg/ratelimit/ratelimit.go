@@ -0,0 +1,142 @@
+// Package ratelimit implements a byte-oriented token bucket shared by
+// goroutines that move data, e.g. every peer connection of a client.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// minBurst keeps small rates from stalling on a single 16KiB block.
+const minBurst = 64 * 1024
+
+// Limiter hands out bytes at a fixed rate. A nil Limiter or a rate of 0 is
+// unlimited.
+type Limiter struct {
+	mut    sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	// throttled is when a caller last had to wait for tokens.
+	throttled time.Time
+	shares    map[*Share]struct{}
+}
+
+// NewLimiter returns a limiter allowing bytesPerSec on average with a burst of
+// one second worth of traffic.
+func NewLimiter(bytesPerSec uint64) *Limiter {
+	l := &Limiter{last: time.Now()}
+	l.SetRate(bytesPerSec)
+	l.tokens = l.burst
+
+	return l
+}
+
+// SetRate changes the rate; 0 removes the limit.
+func (l *Limiter) SetRate(bytesPerSec uint64) {
+	if l == nil {
+		return
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	l.refill(time.Now())
+	l.rate = float64(bytesPerSec)
+	l.burst = max(l.rate, minBurst)
+	l.tokens = min(l.tokens, l.burst)
+}
+
+// Rate returns the configured rate in bytes/sec, 0 meaning unlimited.
+func (l *Limiter) Rate() uint64 {
+	if l == nil {
+		return 0
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	return uint64(l.rate)
+}
+
+// WaitN blocks until n bytes may be transferred or ctx is done.
+//
+// Requests larger than the burst are allowed and put the bucket into debt, so
+// callers never deadlock on oversized frames.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+
+	l.mut.Lock()
+	if l.rate == 0 {
+		l.mut.Unlock()
+		return nil
+	}
+
+	wait := l.take(time.Now(), n)
+	l.mut.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	return sleep(ctx, wait, func() { l.tokens += float64(n) }, &l.mut)
+}
+
+// take spends n tokens and returns how long until the debt, if any, is paid
+// off. Called with l.mut held and a rate set.
+func (l *Limiter) take(now time.Time, n int) time.Duration {
+	l.refill(now)
+	l.tokens -= float64(n)
+
+	if l.tokens >= 0 {
+		return 0
+	}
+	l.throttled = now
+
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// sleep waits d or until ctx is done, in which case it runs undo with mut
+// held.
+func sleep(ctx context.Context, d time.Duration, undo func(), mut *sync.Mutex) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		mut.Lock()
+		undo()
+		mut.Unlock()
+
+		return ctx.Err()
+
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Saturated reports whether the limit held a caller back within the last
+// d, i.e. demand has recently been outrunning the rate.
+func (l *Limiter) Saturated(d time.Duration) bool {
+	if l == nil {
+		return false
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	return l.rate > 0 && !l.throttled.IsZero() && time.Since(l.throttled) <= d
+}
+
+func (l *Limiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+
+	if l.rate == 0 {
+		return
+	}
+	l.tokens = min(l.burst, l.tokens+elapsed*l.rate)
+}
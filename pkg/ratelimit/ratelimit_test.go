@@ -0,0 +1,147 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiter_NilAndZeroAreUnlimited(t *testing.T) {
+	var nilLimiter *Limiter
+	if err := nilLimiter.WaitN(context.Background(), 1<<30); err != nil {
+		t.Fatalf("nil limiter WaitN() error = %v", err)
+	}
+
+	l := NewLimiter(0)
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		if err := l.WaitN(context.Background(), 1<<20); err != nil {
+			t.Fatalf("WaitN() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("unlimited WaitN took %v", elapsed)
+	}
+}
+
+func TestLimiter_BurstThenWait(t *testing.T) {
+	l := NewLimiter(1 << 20) // 1MiB/s, burst 1MiB
+
+	if err := l.WaitN(context.Background(), 1<<20); err != nil {
+		t.Fatalf("burst WaitN() error = %v", err)
+	}
+
+	start := time.Now()
+	if err := l.WaitN(context.Background(), 1<<17); err != nil {
+		t.Fatalf("WaitN() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("WaitN after burst returned after %v, want >= ~125ms", elapsed)
+	}
+}
+
+func TestLimiter_WaitNCancelled(t *testing.T) {
+	l := NewLimiter(1024)
+	_ = l.WaitN(context.Background(), minBurst)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := l.WaitN(ctx, 1<<20); err == nil {
+		t.Fatal("WaitN() should fail when ctx is done")
+	}
+}
+
+func TestLimiter_SetRate(t *testing.T) {
+	l := NewLimiter(100)
+	l.SetRate(0)
+	if got := l.Rate(); got != 0 {
+		t.Fatalf("Rate() = %d, want 0", got)
+	}
+
+	if err := l.WaitN(context.Background(), 1<<30); err != nil {
+		t.Fatalf("WaitN() after SetRate(0) error = %v", err)
+	}
+}
+
+func TestLimiter_Saturated(t *testing.T) {
+	var nilLimiter *Limiter
+	if nilLimiter.Saturated(time.Minute) {
+		t.Fatal("nil limiter reports saturated")
+	}
+
+	l := NewLimiter(1 << 20)
+	if err := l.WaitN(context.Background(), 1<<20); err != nil {
+		t.Fatalf("burst WaitN() error = %v", err)
+	}
+	if l.Saturated(time.Minute) {
+		t.Fatal("saturated after a request within the burst")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = l.WaitN(ctx, 1<<20)
+	if !l.Saturated(time.Minute) {
+		t.Fatal("not saturated after a request had to wait")
+	}
+
+	l.SetRate(0)
+	if l.Saturated(time.Minute) {
+		t.Fatal("unlimited limiter reports saturated")
+	}
+}
+
+func TestShare_Weighted(t *testing.T) {
+	l := NewLimiter(1 << 20)
+	_ = l.WaitN(context.Background(), 1<<20) // spend the burst
+
+	high, low := l.NewShare(3), l.NewShare(1)
+	defer high.Release()
+	defer low.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+
+	var got [2]int
+	var wg sync.WaitGroup
+	for i, s := range []*Share{high, low} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s.WaitN(ctx, 16<<10) == nil {
+				got[i] += 16 << 10
+			}
+		}()
+	}
+	wg.Wait()
+
+	if ratio := float64(got[0]) / float64(got[1]); ratio < 1.5 {
+		t.Errorf("high/low bytes = %d/%d (%.2f), want a ratio near 3", got[0], got[1], ratio)
+	}
+	if total := got[0] + got[1]; total > 2<<20 {
+		t.Errorf("shares moved %d bytes in 1.5s at 1MiB/s", total)
+	}
+}
+
+func TestShare_AloneUsesFullRate(t *testing.T) {
+	var nilShare *Share
+	if err := nilShare.WaitN(context.Background(), 1<<30); err != nil {
+		t.Fatalf("nil share WaitN() error = %v", err)
+	}
+
+	l := NewLimiter(1 << 20)
+	s := l.NewShare(1)
+	defer s.Release()
+	l.NewShare(5) // never busy
+
+	start := time.Now()
+	for i := 0; i < 64; i++ { // the 1MiB burst
+		if err := s.WaitN(context.Background(), 16<<10); err != nil {
+			t.Fatalf("WaitN() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("lone share spending the burst took %v", elapsed)
+	}
+}
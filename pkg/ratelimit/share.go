@@ -0,0 +1,127 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// shareWindow is how recently a share must have asked for bytes to count
+// as busy.
+const shareWindow = time.Second
+
+// Share is one consumer's weighted claim on a Limiter, e.g. the peers of a
+// single torrent. While several shares are busy each is held to its weight's
+// part of the rate; a share busy alone may use all of it. A nil Share is
+// unlimited.
+type Share struct {
+	l *Limiter
+
+	// Guarded by l.mut.
+	weight int
+	// next is when the share will have been paced through every byte it
+	// has taken at its part of the rate.
+	next time.Time
+	last time.Time
+}
+
+// NewShare returns a share of l with the given weight, at least 1. It is
+// nil if l is.
+func (l *Limiter) NewShare(weight int) *Share {
+	if l == nil {
+		return nil
+	}
+
+	s := &Share{l: l, weight: max(1, weight)}
+
+	l.mut.Lock()
+	if l.shares == nil {
+		l.shares = make(map[*Share]struct{})
+	}
+	l.shares[s] = struct{}{}
+	l.mut.Unlock()
+
+	return s
+}
+
+// SetWeight changes the share's weight, at least 1.
+func (s *Share) SetWeight(weight int) {
+	if s == nil {
+		return
+	}
+
+	s.l.mut.Lock()
+	s.weight = max(1, weight)
+	s.l.mut.Unlock()
+}
+
+// Release stops the share from counting towards the split of the rate.
+func (s *Share) Release() {
+	if s == nil {
+		return
+	}
+
+	s.l.mut.Lock()
+	delete(s.l.shares, s)
+	s.l.mut.Unlock()
+}
+
+// WaitN blocks until n bytes may be transferred under both the limiter's
+// rate and the share's part of it, or ctx is done.
+func (s *Share) WaitN(ctx context.Context, n int) error {
+	if s == nil || n <= 0 {
+		return nil
+	}
+
+	l := s.l
+	l.mut.Lock()
+	if l.rate == 0 {
+		l.mut.Unlock()
+		return nil
+	}
+
+	now := time.Now()
+	s.last = now
+	wait := l.take(now, n)
+
+	var paced time.Duration
+	if busy := l.busyWeight(now); busy > s.weight {
+		rate := l.rate * float64(s.weight) / float64(busy)
+		paced = time.Duration(float64(n) / rate * float64(time.Second))
+
+		// Running ahead of the pace by up to minBurst is allowed, so a
+		// lone block never stalls.
+		s.next = maxTime(s.next, now).Add(paced)
+		lead := time.Duration(minBurst / rate * float64(time.Second))
+		wait = max(wait, s.next.Sub(now)-lead)
+	} else {
+		s.next = now
+	}
+	l.mut.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	return sleep(ctx, wait, func() {
+		l.tokens += float64(n)
+		s.next = s.next.Add(-paced)
+	}, &l.mut)
+}
+
+// busyWeight sums the weights of shares that asked for bytes within
+// shareWindow. Called with l.mut held.
+func (l *Limiter) busyWeight(now time.Time) int {
+	var total int
+	for s := range l.shares {
+		if now.Sub(s.last) <= shareWindow {
+			total += s.weight
+		}
+	}
+	return total
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -115,7 +115,7 @@ func Do(ctx context.Context, op Operation, opts ...Option) error {
 		}
 	}
 
-	return nil
+	return lastErr
 }
 
 func calculateDelay(attempt int, cfg *Config) time.Duration {
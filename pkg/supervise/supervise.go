@@ -0,0 +1,225 @@
+// Package supervise keeps a torrent's long-running loops alive. A loop
+// that fails or panics is restarted with backoff rather than tearing
+// down everything that runs beside it, and shows up as a health warning
+// until it has run cleanly for a while.
+package supervise
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prxssh/rabbit/pkg/clock"
+)
+
+// ErrPanic wraps the value a supervised function panicked with.
+var ErrPanic = errors.New("supervise: panic")
+
+type Config struct {
+	// InitialBackoff is the wait before restarting a failed loop. It
+	// doubles with every failure in a row, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Stable is how long a loop must run after its last failure to count
+	// as recovered: its backoff starts over and its warning is cleared.
+	Stable time.Duration
+}
+
+func WithDefaultConfig() *Config {
+	return &Config{
+		InitialBackoff: time.Second,
+		MaxBackoff:     5 * time.Minute,
+		Stable:         time.Minute,
+	}
+}
+
+type Opts struct {
+	Config *Config
+	Logger *slog.Logger
+	// Clock times backoffs and recovery. Defaults to the wall clock.
+	Clock clock.Clock
+}
+
+// Warning is a subsystem that failed recently.
+type Warning struct {
+	Subsystem string `json:"subsystem"`
+	Error     string `json:"error"`
+	// Failures counts the failures since the subsystem was last stable.
+	Failures int       `json:"failures"`
+	At       time.Time `json:"at"`
+	// Restarting is set while the subsystem waits out its backoff.
+	Restarting bool `json:"restarting"`
+}
+
+// Supervisor runs loops for one torrent and tracks their health. A nil
+// Supervisor runs them unsupervised.
+type Supervisor struct {
+	cfg   *Config
+	log   *slog.Logger
+	clock clock.Clock
+
+	mut      sync.Mutex
+	warnings map[string]*Warning
+	// since is when each warned subsystem last failed or came back up.
+	since map[string]time.Time
+}
+
+func New(opts *Opts) *Supervisor {
+	cfg := opts.Config
+	if cfg == nil {
+		cfg = WithDefaultConfig()
+	}
+	log := opts.Logger
+	if log == nil {
+		log = slog.Default()
+	}
+
+	return &Supervisor{
+		cfg:      cfg,
+		log:      log.With("component", "supervisor"),
+		clock:    clock.Or(opts.Clock),
+		warnings: make(map[string]*Warning),
+		since:    make(map[string]time.Time),
+	}
+}
+
+// Run calls fn until it returns nil or ctx is done, restarting it with
+// backoff whenever it returns an error or panics. Failures once ctx is
+// done are shutdown, not failures, and Run returns nil.
+func (s *Supervisor) Run(ctx context.Context, name string, fn func(context.Context) error) error {
+	if s == nil {
+		return fn(ctx)
+	}
+
+	for {
+		err := s.call(name, func() error { return fn(ctx) })
+		if err == nil || ctx.Err() != nil {
+			return nil
+		}
+
+		failures := s.failed(name, err, true)
+		delay := s.backoff(failures)
+		s.log.Warn("subsystem failed, restarting",
+			"subsystem", name,
+			"error", err,
+			"failures", failures,
+			"in", delay,
+		)
+
+		timer := s.clock.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C():
+		}
+		s.restarted(name)
+	}
+}
+
+// Recover calls fn once, turning a panic into an error wrapping ErrPanic
+// and recording it under name. It's for loops that can't simply be
+// resumed, like a peer connection whose stream is mid-message: the
+// caller's own recovery, such as redialing the peer later, takes over.
+func (s *Supervisor) Recover(name string, fn func() error) error {
+	if s == nil {
+		return fn()
+	}
+
+	err := s.call(name, fn)
+	if errors.Is(err, ErrPanic) {
+		s.failed(name, err, false)
+	}
+	return err
+}
+
+func (s *Supervisor) call(name string, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrPanic, r)
+			s.log.Error("subsystem panicked",
+				"subsystem", name,
+				"panic", r,
+				"stack", string(debug.Stack()),
+			)
+		}
+	}()
+	return fn()
+}
+
+func (s *Supervisor) backoff(failures int) time.Duration {
+	delay := s.cfg.InitialBackoff
+	for i := 1; i < failures && delay < s.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, s.cfg.MaxBackoff)
+}
+
+// failed records a failure of name and returns how many it has had in a
+// row.
+func (s *Supervisor) failed(name string, err error, restarting bool) int {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	now := s.clock.Now()
+	w, ok := s.warnings[name]
+	if !ok || s.recoveredLocked(name, now) {
+		w = &Warning{Subsystem: name}
+		s.warnings[name] = w
+	}
+	w.Error = err.Error()
+	w.Failures++
+	w.At = now
+	w.Restarting = restarting
+	s.since[name] = now
+	return w.Failures
+}
+
+func (s *Supervisor) restarted(name string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if w, ok := s.warnings[name]; ok {
+		w.Restarting = false
+		s.since[name] = s.clock.Now()
+	}
+}
+
+// recoveredLocked reports whether name has been up for Stable since it
+// last failed or restarted. Called with s.mut held.
+func (s *Supervisor) recoveredLocked(name string, now time.Time) bool {
+	w, ok := s.warnings[name]
+	return ok && !w.Restarting && now.Sub(s.since[name]) >= s.cfg.Stable
+}
+
+// Warnings returns the subsystems that failed and haven't recovered yet,
+// by name.
+func (s *Supervisor) Warnings() []Warning {
+	if s == nil {
+		return nil
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	now := s.clock.Now()
+	warnings := make([]Warning, 0, len(s.warnings))
+	for name, w := range s.warnings {
+		if s.recoveredLocked(name, now) {
+			delete(s.warnings, name)
+			delete(s.since, name)
+			continue
+		}
+		warnings = append(warnings, *w)
+	}
+	slices.SortFunc(warnings, func(a, b Warning) int {
+		return strings.Compare(a.Subsystem, b.Subsystem)
+	})
+	return warnings
+}
@@ -0,0 +1,121 @@
+package supervise
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prxssh/rabbit/pkg/clock"
+)
+
+func testSupervisor(c clock.Clock) *Supervisor {
+	return New(&Opts{
+		Config: &Config{
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     4 * time.Millisecond,
+			Stable:         time.Minute,
+		},
+		Clock: c,
+	})
+}
+
+func TestSupervisor_RunRestarts(t *testing.T) {
+	s := testSupervisor(nil)
+
+	calls := 0
+	err := s.Run(context.Background(), "loop", func(context.Context) error {
+		calls++
+		switch calls {
+		case 1:
+			return errors.New("boom")
+		case 2:
+			panic("bad")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Run() = %v, want nil", err)
+	}
+	if calls != 3 {
+		t.Errorf("calls = %d, want 3", calls)
+	}
+
+	warnings := s.Warnings()
+	if len(warnings) != 1 {
+		t.Fatalf("Warnings() = %v, want one", warnings)
+	}
+	w := warnings[0]
+	if w.Subsystem != "loop" || w.Failures != 2 || w.Restarting {
+		t.Errorf("warning = %+v", w)
+	}
+}
+
+func TestSupervisor_RunStopsWithContext(t *testing.T) {
+	s := testSupervisor(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	err := s.Run(ctx, "loop", func(context.Context) error {
+		cancel()
+		return errors.New("shutting down")
+	})
+	if err != nil {
+		t.Fatalf("Run() = %v, want nil", err)
+	}
+	if w := s.Warnings(); len(w) != 0 {
+		t.Errorf("Warnings() = %v, want none", w)
+	}
+}
+
+func TestSupervisor_Recover(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	s := testSupervisor(c)
+
+	plain := errors.New("closed")
+	if err := s.Recover("peer", func() error { return plain }); err != plain {
+		t.Errorf("Recover() = %v, want %v", err, plain)
+	}
+	if w := s.Warnings(); len(w) != 0 {
+		t.Fatalf("plain error warned: %v", w)
+	}
+
+	err := s.Recover("peer", func() error { panic("bad") })
+	if !errors.Is(err, ErrPanic) {
+		t.Fatalf("Recover() = %v, want ErrPanic", err)
+	}
+	if w := s.Warnings(); len(w) != 1 || w[0].Failures != 1 {
+		t.Fatalf("Warnings() = %v, want one failure", w)
+	}
+
+	c.Advance(time.Minute)
+	if w := s.Warnings(); len(w) != 0 {
+		t.Errorf("Warnings() after Stable = %v, want none", w)
+	}
+}
+
+func TestSupervisor_Backoff(t *testing.T) {
+	s := testSupervisor(nil)
+
+	for failures, want := range map[int]time.Duration{
+		1:  time.Millisecond,
+		2:  2 * time.Millisecond,
+		3:  4 * time.Millisecond,
+		10: 4 * time.Millisecond,
+	} {
+		if got := s.backoff(failures); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", failures, got, want)
+		}
+	}
+}
+
+func TestSupervisor_Nil(t *testing.T) {
+	var s *Supervisor
+
+	want := errors.New("boom")
+	if err := s.Run(context.Background(), "loop", func(context.Context) error { return want }); err != want {
+		t.Errorf("Run() = %v, want %v", err, want)
+	}
+	if w := s.Warnings(); w != nil {
+		t.Errorf("Warnings() = %v, want nil", w)
+	}
+}